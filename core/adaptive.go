@@ -0,0 +1,134 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	adaptiveInterval    = 5 * time.Second
+	adaptiveMaxParts    = 32
+	adaptiveMinSplitGap = 2 * MinPartSize
+)
+
+// adaptiveState tunes a multi-part download while it runs: finished parts take over half of the
+// largest remaining range, and the number of connections grows while that raises throughput and
+// shrinks again when the CDN starts throttling or erroring.
+type adaptiveState struct {
+	maxActive int
+	lastBytes int64
+	lastTime  time.Time
+	rateAtAdd float64 // throughput before the last added connection, 0 when nothing is being measured
+	errors    int
+}
+
+func newAdaptiveState(parts int) *adaptiveState {
+	return &adaptiveState{maxActive: max(parts, 1), lastTime: time.Now()}
+}
+
+// taskEnd is the current end of a task's range, it moves when the range is split
+func taskEnd(task *DownloadTask) int64 {
+	return atomic.LoadInt64(&task.rangeEnd)
+}
+
+// activeTasks counts the running parts, callers hold tasksMux
+func (fd *FileDownloader) activeTasks() int {
+	n := 0
+	for _, task := range fd.DownloadTaskList {
+		if task.active {
+			n++
+		}
+	}
+	return n
+}
+
+// splitLargest moves the second half of the largest remaining range into a new part and starts it.
+// Callers hold tasksMux, which keeps the victim's goroutine (and so the WaitGroup) alive meanwhile.
+func (fd *FileDownloader) splitLargest() bool {
+	var victim *DownloadTask
+	var largest int64
+	for _, task := range fd.DownloadTaskList {
+		if !task.active {
+			continue
+		}
+		remaining := taskEnd(task) - (task.rangeStart + atomic.LoadInt64(&task.downloadedSize)) + 1
+		if remaining > largest {
+			victim, largest = task, remaining
+		}
+	}
+	if victim == nil || largest < adaptiveMinSplitGap {
+		return false
+	}
+
+	oldEnd := taskEnd(victim)
+	newEnd := oldEnd - largest/2
+	atomic.StoreInt64(&victim.rangeEnd, newEnd)
+	task := &DownloadTask{
+		taskID:     len(fd.DownloadTaskList),
+		rangeStart: newEnd + 1,
+		rangeEnd:   oldEnd,
+		active:     true,
+	}
+	fd.DownloadTaskList = append(fd.DownloadTaskList, task)
+	fd.spawn(task)
+	return true
+}
+
+// taskFinished hands the freed connection to the largest remaining range
+func (fd *FileDownloader) taskFinished(task *DownloadTask) {
+	fd.tasksMux.Lock()
+	defer fd.tasksMux.Unlock()
+	task.active = false
+	if fd.adaptive == nil || fd.ctx.Err() != nil {
+		return
+	}
+	if fd.activeTasks() < fd.adaptive.maxActive {
+		fd.splitLargest()
+	}
+}
+
+// taskThrottled lowers the connection limit after a retryable error such as 429/503 or a reset
+func (fd *FileDownloader) taskThrottled() {
+	fd.tasksMux.Lock()
+	defer fd.tasksMux.Unlock()
+	if fd.adaptive == nil {
+		return
+	}
+	fd.adaptive.errors++
+	fd.adaptive.maxActive = max(fd.activeTasks()-1, 1)
+	fd.adaptive.rateAtAdd = 0
+}
+
+// adaptiveTick runs from the progress loop with the bytes received so far
+func (fd *FileDownloader) adaptiveTick(downloaded int64) {
+	fd.tasksMux.Lock()
+	defer fd.tasksMux.Unlock()
+	a := fd.adaptive
+	if a == nil || time.Since(a.lastTime) < adaptiveInterval {
+		return
+	}
+	rate := float64(downloaded-a.lastBytes) / time.Since(a.lastTime).Seconds()
+	a.lastBytes, a.lastTime = downloaded, time.Now()
+	errors := a.errors
+	a.errors = 0
+	if errors > 0 {
+		return
+	}
+
+	if a.rateAtAdd > 0 {
+		// the last connection did not help, give it back
+		if rate < a.rateAtAdd*1.1 {
+			a.maxActive = max(a.maxActive-1, 1)
+			a.rateAtAdd = 0
+			return
+		}
+	}
+	if a.maxActive < adaptiveMaxParts && fd.activeTasks() >= a.maxActive {
+		a.maxActive++
+		if fd.splitLargest() {
+			a.rateAtAdd = rate
+		} else {
+			a.maxActive--
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAdBlockList is shipped with the app, users can extend it with AdBlockRule
+// or replace the downloaded part from AdBlockUrl.
+const defaultAdBlockList = `# res-downloader default blocklist
+doubleclick.net
+googlesyndication.com
+googleadservices.com
+google-analytics.com
+googletagmanager.com
+adservice.google.com
+hm.baidu.com
+pos.baidu.com
+cpro.baidu.com
+cnzz.com
+umeng.com
+tanx.com
+mmstat.com
+adsmogo.com
+pangolin-sdk-toutiao.com
+e.qq.com
+gdt.qq.com
+pingma.qq.com
+`
+
+// AdBlocker blocks ad/analytics hosts. Lists accept plain domains, hosts-file
+// lines ("0.0.0.0 domain") and adblock-style "||domain^" entries; a domain also
+// blocks all of its subdomains.
+type AdBlocker struct {
+	mu      sync.RWMutex
+	domains map[string]bool
+}
+
+func initAdBlock() *AdBlocker {
+	if adBlockOnce == nil {
+		adBlockOnce = &AdBlocker{}
+		adBlockOnce.Reload()
+	}
+	return adBlockOnce
+}
+
+func (a *AdBlocker) listFile() string {
+	return filepath.Join(appOnce.UserDir, "adblock.txt")
+}
+
+// Reload rebuilds the domain set from the built-in list, the downloaded list, rule packs and the user rules
+func (a *AdBlocker) Reload() {
+	domains := make(map[string]bool)
+	parseAdBlockList(defaultAdBlockList, domains)
+	if data, err := os.ReadFile(a.listFile()); err == nil {
+		parseAdBlockList(string(data), domains)
+	}
+	parseAdBlockList(withRulePacks("adblock", globalConfig.AdBlockRule), domains)
+
+	a.mu.Lock()
+	a.domains = domains
+	a.mu.Unlock()
+}
+
+// Update downloads the remote list from AdBlockUrl and reloads
+func (a *AdBlocker) Update() (int, error) {
+	if globalConfig.AdBlockUrl == "" {
+		return 0, fmt.Errorf("blocklist url is empty")
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(globalConfig.AdBlockUrl)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(a.listFile(), data, 0644); err != nil {
+		return 0, err
+	}
+	a.Reload()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.domains), nil
+}
+
+func (a *AdBlocker) blocked(host string) bool {
+	if !globalConfig.AdBlock {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]."))
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for host != "" {
+		if a.domains[host] {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+func (a *AdBlocker) blockedResponse(r *http.Request) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(http.StatusNoContent),
+		StatusCode:    http.StatusNoContent,
+		Proto:         r.Proto,
+		ProtoMajor:    r.ProtoMajor,
+		ProtoMinor:    r.ProtoMinor,
+		Header:        make(http.Header),
+		Body:          http.NoBody,
+		ContentLength: 0,
+		Request:       r,
+	}
+}
+
+func parseAdBlockList(list string, domains map[string]bool) {
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		if strings.HasPrefix(line, "||") {
+			line = strings.TrimPrefix(line, "||")
+			if i := strings.IndexAny(line, "^/$"); i >= 0 {
+				line = line[:i]
+			}
+		} else if fields := strings.Fields(line); len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+			line = fields[1]
+		}
+		line = strings.ToLower(strings.Trim(line, "."))
+		if line == "" || line == "localhost" || strings.ContainsAny(line, " */") {
+			continue
+		}
+		domains[line] = true
+	}
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"res-downloader/core/shared"
+	"sync"
+	"time"
+)
+
+// AttemptRecord is one run of a task, a task retried twice has three
+type AttemptRecord struct {
+	Attempt   int    `json:"Attempt"`
+	Started   int64  `json:"Started"`  // unix ms
+	Duration  int64  `json:"Duration"` // ms
+	Bytes     int64  `json:"Bytes"`
+	AvgSpeed  int64  `json:"AvgSpeed"` // bytes per second
+	PeakSpeed int64  `json:"PeakSpeed"`
+	Status    string `json:"Status"`
+	Error     string `json:"Error"`
+	ErrorKind string `json:"ErrorKind"`
+}
+
+// PlatformStats sums up the attempts of one platform
+type PlatformStats struct {
+	Platform    string         `json:"Platform"`
+	Tasks       int            `json:"Tasks"`
+	Succeeded   int            `json:"Succeeded"`
+	Failed      int            `json:"Failed"`
+	Retries     int            `json:"Retries"`
+	AvgSpeed    int64          `json:"AvgSpeed"`    // of successful attempts
+	PeakSpeed   int64          `json:"PeakSpeed"`   // fastest moment seen
+	AvgDuration int64          `json:"AvgDuration"` // ms, of successful attempts
+	Errors      map[string]int `json:"Errors"`      // error kind -> count
+	TopErrors   []string       `json:"TopErrors"`
+}
+
+// liveAttempt collects the numbers of a running attempt from its progress events
+type liveAttempt struct {
+	mu      sync.Mutex
+	started time.Time
+	bytes   float64
+	speed   float64
+	peak    float64
+}
+
+func (h *History) attemptStart(id string) {
+	h.running.Store(id, &liveAttempt{started: time.Now()})
+}
+
+// observe is fed by the progress tracker at its throttled rate
+func (h *History) observe(id string, downloaded, speed float64) {
+	value, ok := h.running.Load(id)
+	if !ok {
+		return
+	}
+	attempt := value.(*liveAttempt)
+	attempt.mu.Lock()
+	attempt.bytes = downloaded
+	attempt.speed = speed
+	if speed > attempt.peak {
+		attempt.peak = speed
+	}
+	attempt.mu.Unlock()
+}
+
+// attemptEnd stores how an attempt went, err nil is a success
+func (h *History) attemptEnd(mediaInfo shared.MediaInfo, attempt int, err error) {
+	value, ok := h.running.LoadAndDelete(mediaInfo.Id)
+	if !ok {
+		return
+	}
+	live := value.(*liveAttempt)
+	live.mu.Lock()
+	duration := time.Since(live.started)
+	bytes, peak := live.bytes, live.peak
+	live.mu.Unlock()
+
+	status, message, kind := shared.DownloadStatusDone, "", ""
+	if err != nil {
+		status, message, kind = shared.DownloadStatusError, err.Error(), classifyDownloadError(err)
+		if kind == ErrorKindCancelled {
+			status = ErrorKindCancelled
+		}
+	}
+	metricsOnce.attempt(bytes, kind)
+	if h.db == nil {
+		return
+	}
+	avg := int64(0)
+	if duration > 0 {
+		avg = int64(bytes / duration.Seconds())
+	}
+	go func() {
+		_, err := h.db.Exec(`INSERT INTO attempts (task_id, platform, classify, attempt, started_at, duration_ms, bytes, avg_speed, peak_speed, status, error, error_kind)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			mediaInfo.Id, mediaInfo.Domain, mediaInfo.Classify, attempt, live.started.UnixMilli(), duration.Milliseconds(),
+			int64(bytes), avg, int64(peak), status, message, kind)
+		if err != nil {
+			globalLogger.Esg(err, "save download attempt failed")
+		}
+	}()
+}
+
+// taskLog lists the attempts of one task, oldest first
+func (h *History) taskLog(id string) ([]AttemptRecord, error) {
+	list := make([]AttemptRecord, 0)
+	if h.db == nil {
+		return list, nil
+	}
+	rows, err := h.db.Query(`SELECT attempt, started_at, duration_ms, bytes, avg_speed, peak_speed, status, error, error_kind
+		FROM attempts WHERE task_id = ? ORDER BY started_at, id`, id)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item AttemptRecord
+		if err := rows.Scan(&item.Attempt, &item.Started, &item.Duration, &item.Bytes, &item.AvgSpeed, &item.PeakSpeed,
+			&item.Status, &item.Error, &item.ErrorKind); err != nil {
+			return list, err
+		}
+		list = append(list, item)
+	}
+	return list, rows.Err()
+}
+
+// analytics groups the attempts since the given unix seconds by platform
+func (h *History) analytics(since int64) ([]PlatformStats, error) {
+	list := make([]PlatformStats, 0)
+	if h.db == nil {
+		return list, nil
+	}
+	rows, err := h.db.Query(`SELECT platform,
+			COUNT(DISTINCT task_id),
+			COUNT(DISTINCT CASE WHEN status = ? THEN task_id END),
+			COUNT(*) - COUNT(DISTINCT task_id),
+			COALESCE(AVG(CASE WHEN status = ? THEN avg_speed END), 0),
+			COALESCE(MAX(peak_speed), 0),
+			COALESCE(AVG(CASE WHEN status = ? THEN duration_ms END), 0)
+		FROM attempts WHERE started_at >= ? GROUP BY platform ORDER BY COUNT(*) DESC`,
+		shared.DownloadStatusDone, shared.DownloadStatusDone, shared.DownloadStatusDone, since*1000)
+	if err != nil {
+		return list, err
+	}
+	index := map[string]int{}
+	for rows.Next() {
+		var item PlatformStats
+		var avgSpeed, avgDuration float64
+		if err := rows.Scan(&item.Platform, &item.Tasks, &item.Succeeded, &item.Retries, &avgSpeed, &item.PeakSpeed, &avgDuration); err != nil {
+			rows.Close()
+			return list, err
+		}
+		item.Failed = item.Tasks - item.Succeeded
+		item.AvgSpeed, item.AvgDuration = int64(avgSpeed), int64(avgDuration)
+		item.Errors = map[string]int{}
+		item.TopErrors = []string{}
+		index[item.Platform] = len(list)
+		list = append(list, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return list, err
+	}
+
+	rows, err = h.db.Query(`SELECT platform, error_kind, error, COUNT(*) AS n FROM attempts
+		WHERE started_at >= ? AND error != '' GROUP BY platform, error_kind, error ORDER BY n DESC`, since*1000)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var platform, kind, message string
+		var count int
+		if err := rows.Scan(&platform, &kind, &message, &count); err != nil {
+			return list, err
+		}
+		i, ok := index[platform]
+		if !ok {
+			continue
+		}
+		list[i].Errors[kind] += count
+		if len(list[i].TopErrors) < 3 {
+			list[i].TopErrors = append(list[i].TopErrors, message)
+		}
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsAcceptGuid    = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsPingInterval  = 30 * time.Second
+	wsWriteTimeout  = 10 * time.Second
+	wsMaxClientData = 64 * 1024
+)
+
+// wsConn is the server side of an events websocket, clients only ever send control frames
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// events upgrades to a websocket and pushes every event the window gets, ?type= narrows them down.
+// Browsers can't set headers on a websocket, so the token usually comes as ?token=.
+func (a *ApiServer) events(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		a.fail(w, http.StatusBadRequest, "websocket upgrade required")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		a.fail(w, http.StatusBadRequest, "missing Sec-WebSocket-Key")
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		a.fail(w, http.StatusInternalServerError, "websocket not supported")
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		globalLogger.Esg(err, "websocket hijack failed")
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + wsAcceptGuid))
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err != nil || rw.Flush() != nil {
+		return
+	}
+
+	ws := &wsConn{conn: conn, reader: rw.Reader}
+	a.track(ws, true)
+	defer a.track(ws, false)
+	filter := newEventFilter(r.URL.Query().Get("type"))
+	ch := httpServerOnce.subscribe()
+	defer httpServerOnce.unsubscribe(ch)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		ws.readLoop()
+	}()
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if ws.write(0x9, nil) != nil {
+				return
+			}
+		case event := <-ch:
+			if !filter.wants(event) {
+				continue
+			}
+			if ws.write(0x1, []byte(event)) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (a *ApiServer) track(ws *wsConn, open bool) {
+	a.wsMu.Lock()
+	defer a.wsMu.Unlock()
+	if !open {
+		delete(a.sockets, ws)
+		return
+	}
+	if a.sockets == nil {
+		a.sockets = make(map[*wsConn]bool)
+	}
+	a.sockets[ws] = true
+}
+
+// closeSockets says goodbye to every events client, called when the api stops or moves
+func (a *ApiServer) closeSockets() {
+	a.wsMu.Lock()
+	defer a.wsMu.Unlock()
+	for ws := range a.sockets {
+		// 1001, going away
+		_ = ws.write(0x8, []byte{0x03, 0xE9})
+		_ = ws.conn.Close()
+		delete(a.sockets, ws)
+	}
+}
+
+// readLoop answers pings and returns once the client closes or the connection drops
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case 0x8:
+			_ = c.write(0x8, payload)
+			return
+		case 0x9:
+			if c.write(0xA, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxClientData {
+		return 0, nil, errors.New("websocket frame too large")
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// write sends one unmasked final frame, as a server must
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,794 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed openapi.json
+var openApiSpec []byte
+
+// extensionMediaLimit bounds the media urls one extension request may ask to resolve
+const extensionMediaLimit = 20
+
+// ApiServer is the optional token protected REST api for extensions, scripts and remote UIs.
+// It listens on its own address, apart from the proxy port the window talks to.
+type ApiServer struct {
+	mu      sync.Mutex
+	server  *http.Server
+	addr    string
+	wsMu    sync.Mutex
+	sockets map[*wsConn]bool // hijacked event sockets, the server no longer tracks them
+}
+
+func initApiServer() *ApiServer {
+	if apiServerOnce == nil {
+		apiServerOnce = &ApiServer{}
+	}
+	return apiServerOnce
+}
+
+// apply starts, stops or moves the listener to match Config.ApiEnable and Config.ApiListen
+func (a *ApiServer) apply() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	addr := strings.TrimSpace(globalConfig.ApiListen)
+	if a.server != nil && (!globalConfig.ApiEnable || addr != a.addr) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_ = a.server.Shutdown(ctx)
+		cancel()
+		a.server = nil
+		a.closeSockets()
+	}
+	if !globalConfig.ApiEnable || a.server != nil {
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		globalLogger.Esg(err, "start api server failed")
+		return
+	}
+	a.addr = addr
+	a.server = &http.Server{Handler: a.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			globalLogger.Esg(err, "api server stopped")
+		}
+	}(a.server)
+	globalLogger.Info().Msgf("api server listening on %s", addr)
+}
+
+func (a *ApiServer) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.server != nil {
+		_ = a.server.Close()
+		a.server = nil
+	}
+	a.closeSockets()
+}
+
+func (a *ApiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/resources", a.resources)
+	mux.HandleFunc("GET /v1/resources/search", a.searchResources)
+	mux.HandleFunc("GET /v1/resources/tags", a.resourceTags)
+	mux.HandleFunc("PATCH /v1/resources/{id}", a.markResource)
+	mux.HandleFunc("GET /v1/resources/{id}/text", a.resourceText)
+	mux.HandleFunc("GET /v1/resources/{id}/request", a.resourceRequest)
+	mux.HandleFunc("GET /v1/groups/{id}", a.resourceGroup)
+	mux.HandleFunc("POST /v1/groups/{id}/download", a.downloadGroup)
+	mux.HandleFunc("POST /v1/groups/{id}/image-set", a.imageSet)
+	mux.HandleFunc("GET /v1/series/{previewId}", a.series)
+	mux.HandleFunc("POST /v1/series/{previewId}/download", a.downloadSeries)
+	mux.HandleFunc("GET /v1/live-rooms", a.liveRooms)
+	mux.HandleFunc("POST /v1/live-rooms/check", a.checkLiveRooms)
+	mux.HandleFunc("POST /v1/clips", a.exportClips)
+	mux.HandleFunc("POST /v1/notes", a.exportNotes)
+	mux.HandleFunc("GET /v1/downloads", a.downloads)
+	mux.HandleFunc("POST /v1/downloads", a.addDownload)
+	mux.HandleFunc("DELETE /v1/downloads/{id}", a.cancelDownload)
+	mux.HandleFunc("POST /v1/downloads/{id}/pause", a.pauseDownload)
+	mux.HandleFunc("POST /v1/downloads/{id}/resume", a.resumeDownload)
+	mux.HandleFunc("GET /v1/sessions", a.sessions)
+	mux.HandleFunc("POST /v1/sessions", a.saveSession)
+	mux.HandleFunc("POST /v1/sessions/{name}/restore", a.restoreSession)
+	mux.HandleFunc("DELETE /v1/sessions/{name}", a.deleteSession)
+	mux.HandleFunc("POST /v1/cleanup", a.cleanup)
+	mux.HandleFunc("GET /v1/stats", a.stats)
+	mux.HandleFunc("GET /v1/vault", a.vault)
+	mux.HandleFunc("PUT /v1/vault", a.setupVault)
+	mux.HandleFunc("DELETE /v1/vault", a.disableVault)
+	mux.HandleFunc("POST /v1/vault/unlock", a.unlockVault)
+	mux.HandleFunc("PUT /v1/vault/entries/{name}", a.setVaultEntry)
+	mux.HandleFunc("DELETE /v1/vault/entries/{name}", a.deleteVaultEntry)
+	mux.HandleFunc("GET /v1/service", a.service)
+	mux.HandleFunc("PUT /v1/service/proxy", a.serviceProxy)
+	mux.HandleFunc("POST /v1/service/stop", a.stopService)
+	mux.HandleFunc("GET /v1/update", a.checkUpdate)
+	mux.HandleFunc("POST /v1/update/install", a.installUpdate)
+	mux.HandleFunc("POST /v1/update/rollback", a.rollbackUpdate)
+	mux.HandleFunc("GET /v1/settings", a.settings)
+	mux.HandleFunc("PATCH /v1/settings", a.updateSettings)
+	mux.HandleFunc("GET /v1/events", a.events)
+	mux.HandleFunc("GET /v1/openapi.json", a.openApi)
+	mux.HandleFunc("POST /v1/extension/page", a.extensionPage)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// the spec is public so generators can fetch it without a token
+		if !tokenAuthorized(r) && r.URL.Path != "/v1/openapi.json" {
+			a.fail(w, http.StatusUnauthorized, "invalid api token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// authorized accepts "Authorization: Bearer <token>", or ?token= for clients that can't set headers
+func tokenAuthorized(r *http.Request) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if given == "" {
+		given = r.URL.Query().Get("token")
+	}
+	return validApiToken(given)
+}
+
+// validApiToken tells whether given is Config.ApiToken, there is none to match while it is empty
+func validApiToken(given string) bool {
+	token := globalConfig.ApiToken
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func (a *ApiServer) reply(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		globalLogger.Err(err)
+	}
+}
+
+// fail replies {"error": ...}, an error from the message catalog adds its "code"
+func (a *ApiServer) fail(w http.ResponseWriter, status int, reason interface{}) {
+	body := map[string]string{}
+	switch v := reason.(type) {
+	case error:
+		body["error"] = v.Error()
+		if code := errorCode(v); code != "" {
+			body["code"] = code
+		}
+	default:
+		body["error"] = fmt.Sprint(v)
+	}
+	a.reply(w, status, body)
+}
+
+func (a *ApiServer) resources(w http.ResponseWriter, r *http.Request) {
+	classify := r.URL.Query().Get("type")
+	domain := r.URL.Query().Get("domain")
+	list := resourceOnce.capturedList()
+	matched := make([]shared.MediaInfo, 0, len(list))
+	for _, res := range list {
+		if classify != "" && res.Classify != classify {
+			continue
+		}
+		if domain != "" && !strings.Contains(res.Domain, domain) {
+			continue
+		}
+		matched = append(matched, res)
+	}
+	a.reply(w, http.StatusOK, matched)
+}
+
+func (a *ApiServer) searchResources(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := ResourceQuery{
+		Keyword:  query.Get("q"),
+		Title:    query.Get("title"),
+		Url:      query.Get("url"),
+		Platform: query.Get("platform"),
+		Type:     query.Get("type"),
+		Status:   query.Get("status"),
+		Tag:      query.Get("tag"),
+		Group:    query.Get("group"),
+	}
+	q.Starred, _ = strconv.ParseBool(query.Get("starred"))
+	q.MinSize, _ = strconv.ParseFloat(query.Get("min_size"), 64)
+	q.MaxSize, _ = strconv.ParseFloat(query.Get("max_size"), 64)
+	q.Start, _ = strconv.ParseInt(query.Get("start"), 10, 64)
+	q.End, _ = strconv.ParseInt(query.Get("end"), 10, 64)
+	q.Page, _ = strconv.Atoi(query.Get("page"))
+	q.PageSize, _ = strconv.Atoi(query.Get("page_size"))
+	list, total, err := resourceOnce.store.search(q)
+	if err != nil {
+		a.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.reply(w, http.StatusOK, map[string]interface{}{
+		"list":  list,
+		"total": total,
+	})
+}
+
+// markResource stars, tags or notes a captured resource, fields left out keep their value
+func (a *ApiServer) markResource(w http.ResponseWriter, r *http.Request) {
+	var data ResourceMark
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	res, err := resourceOnce.store.mark(r.PathValue("id"), data)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errorCode(err) == MsgResourceNotFound {
+			status = http.StatusNotFound
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusOK, res)
+}
+
+// resourceText is the text read off a resource by OCR, by kind
+func (a *ApiServer) resourceText(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := resourceOnce.store.get(id); !ok {
+		a.fail(w, http.StatusNotFound, messageError(MsgResourceNotFound))
+		return
+	}
+	a.reply(w, http.StatusOK, resourceOnce.store.texts(id))
+}
+
+// resourceRequest is a curl, wget or python command fetching a resource with its headers and cookies
+func (a *ApiServer) resourceRequest(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	command, err := resourceOnce.requestCommand(r.PathValue("id"), format)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errorCode(err) == MsgResourceNotFound {
+			status = http.StatusNotFound
+		}
+		a.fail(w, status, err)
+		return
+	}
+	if format == "" {
+		format = RequestCurl
+	}
+	a.reply(w, http.StatusOK, map[string]string{"format": format, "command": command})
+}
+
+func (a *ApiServer) resourceTags(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusOK, resourceOnce.store.tags())
+}
+
+func (a *ApiServer) resourceGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := resourceOnce.group(r.PathValue("id"))
+	if err != nil {
+		a.fail(w, http.StatusNotFound, err)
+		return
+	}
+	a.reply(w, http.StatusOK, group)
+}
+
+// downloadGroup queues the group as one batch, ?priority= is the priority POST /v1/downloads takes
+func (a *ApiServer) downloadGroup(w http.ResponseWriter, r *http.Request) {
+	priority, _ := strconv.Atoi(r.URL.Query().Get("priority"))
+	batch, err := resourceOnce.downloadGroup(r.PathValue("id"), priority)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch errorCode(err) {
+		case MsgGroupNotFound:
+			status = http.StatusNotFound
+		case MsgSaveDirectoryUnset, MsgNoResources:
+			status = http.StatusConflict
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusAccepted, batch)
+}
+
+func (a *ApiServer) imageSet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	priority, _ := strconv.Atoi(query.Get("priority"))
+	batch, err := resourceOnce.downloadImageSet(r.PathValue("id"), query.Get("format"), priority)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch errorCode(err) {
+		case MsgGroupNotFound:
+			status = http.StatusNotFound
+		case MsgSaveDirectoryUnset, MsgNoResources:
+			status = http.StatusConflict
+		case "":
+			status = http.StatusBadRequest
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusAccepted, batch)
+}
+
+func (a *ApiServer) series(w http.ResponseWriter, r *http.Request) {
+	series, err := resolveSeries(r.PathValue("previewId"))
+	if err != nil {
+		a.fail(w, http.StatusNotFound, err)
+		return
+	}
+	a.reply(w, http.StatusOK, series)
+}
+
+func (a *ApiServer) downloadSeries(w http.ResponseWriter, r *http.Request) {
+	priority, _ := strconv.Atoi(r.URL.Query().Get("priority"))
+	batch, err := resourceOnce.downloadSeries(r.PathValue("previewId"), priority)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		switch errorCode(err) {
+		case MsgSeriesNotFound:
+			status = http.StatusNotFound
+		case MsgSaveDirectoryUnset:
+			status = http.StatusConflict
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusAccepted, batch)
+}
+
+func (a *ApiServer) liveRooms(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusOK, liveWatchOnce.list())
+}
+
+func (a *ApiServer) checkLiveRooms(w http.ResponseWriter, r *http.Request) {
+	liveWatchOnce.poll(true)
+	a.reply(w, http.StatusOK, liveWatchOnce.list())
+}
+
+// exportClips cuts the selected transcript cues out of a downloaded video
+func (a *ApiServer) exportClips(w http.ResponseWriter, r *http.Request) {
+	var data ClipRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	files, err := exportClips(data)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errorCode(err) == MsgNoResources {
+			status = http.StatusBadRequest
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusCreated, files)
+}
+
+// exportNotes writes a Markdown document next to each given download
+func (a *ApiServer) exportNotes(w http.ResponseWriter, r *http.Request) {
+	var data NotesRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	files, err := exportNotes(data)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errorCode(err) == MsgNoResources {
+			status = http.StatusBadRequest
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusCreated, files)
+}
+
+func (a *ApiServer) downloads(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusOK, map[string]interface{}{
+		"queue":   queueOnce.list(),
+		"running": queueOnce.runningIds(),
+		"paused":  queueOnce.isPaused(),
+	})
+}
+
+// addDownload queues a captured resource by id, or whatever a url or share text resolves to
+func (a *ApiServer) addDownload(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id        string `json:"id"`
+		Url       string `json:"url"`
+		Priority  int    `json:"priority"`
+		AudioOnly bool   `json:"audioOnly"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	if globalConfig.SaveDirectory == "" {
+		a.fail(w, http.StatusConflict, messageError(MsgSaveDirectoryUnset))
+		return
+	}
+
+	var list []shared.MediaInfo
+	switch {
+	case data.Id != "":
+		if res, ok := resourceOnce.store.get(data.Id); ok {
+			list = append(list, res)
+		}
+		if list == nil {
+			a.fail(w, http.StatusNotFound, messageError(MsgResourceNotFound))
+			return
+		}
+	case data.Url != "":
+		var err error
+		if list, err = resourceOnce.resolveUrl(data.Url); err != nil {
+			a.fail(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	default:
+		a.fail(w, http.StatusBadRequest, "id or url is required")
+		return
+	}
+	for _, res := range list {
+		resourceOnce.setAudioOnly(res.Id, data.AudioOnly)
+		queueOnce.push(res, "", data.Priority)
+	}
+	a.reply(w, http.StatusAccepted, list)
+}
+
+func (a *ApiServer) cancelDownload(w http.ResponseWriter, r *http.Request) {
+	if err := resourceOnce.cancel(r.PathValue("id")); err != nil {
+		a.fail(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ApiServer) pauseDownload(w http.ResponseWriter, r *http.Request) {
+	if err := queueOnce.pause(r.PathValue("id")); err != nil {
+		a.fail(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ApiServer) resumeDownload(w http.ResponseWriter, r *http.Request) {
+	if err := queueOnce.resume(r.PathValue("id")); err != nil {
+		a.fail(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ApiServer) sessions(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusOK, resourceOnce.store.sessions())
+}
+
+// saveSession keeps the current capture under a name, a session of that name is replaced
+func (a *ApiServer) saveSession(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name    string          `json:"name"`
+		Filters json.RawMessage `json:"filters"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	summary, err := resourceOnce.saveSession(data.Name, data.Filters)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errorCode(err) == MsgSessionNameRequired {
+			status = http.StatusBadRequest
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusCreated, summary)
+}
+
+// restoreSession replaces the captured list with a saved session
+func (a *ApiServer) restoreSession(w http.ResponseWriter, r *http.Request) {
+	session, err := resourceOnce.restoreSession(r.PathValue("name"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errorCode(err) == MsgSessionNotFound {
+			status = http.StatusNotFound
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusOK, SessionSummary{Name: session.Name, Time: session.Time, Resources: len(session.Resources), Pending: len(session.Pending)})
+}
+
+func (a *ApiServer) deleteSession(w http.ResponseWriter, r *http.Request) {
+	if err := resourceOnce.store.deleteSession(r.PathValue("name")); err != nil {
+		status := http.StatusInternalServerError
+		if errorCode(err) == MsgSessionNotFound {
+			status = http.StatusNotFound
+		}
+		a.fail(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cleanup applies the retention policies now and reports what was reclaimed
+func (a *ApiServer) cleanup(w http.ResponseWriter, r *http.Request) {
+	cache, _ := strconv.ParseBool(r.URL.Query().Get("cache"))
+	a.reply(w, http.StatusOK, cleanupOnce.run(true, cache))
+}
+
+// stats answers the dashboard aggregates, as csv of the daily rows with format=csv
+func (a *ApiServer) stats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var q StatsQuery
+	q.Start, _ = strconv.ParseInt(query.Get("start"), 10, 64)
+	q.End, _ = strconv.ParseInt(query.Get("end"), 10, 64)
+	q.Top, _ = strconv.Atoi(query.Get("top"))
+	result, err := stats(q)
+	if err != nil {
+		a.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	if query.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="res-downloader-stats.csv"`)
+		_ = writeStatsCsv(w, result)
+		return
+	}
+	a.reply(w, http.StatusOK, result)
+}
+
+func (a *ApiServer) vault(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusOK, vaultOnce.status())
+}
+
+// setupVault moves the credentials into the vault, or changes its mode or passphrase
+func (a *ApiServer) setupVault(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Mode       string `json:"mode"` // keychain or passphrase
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := vaultOnce.setup(data.Mode, data.Passphrase); err != nil {
+		status := vaultStatusCode(err)
+		if code := errorCode(err); code == MsgVaultMode || code == MsgKeychainUnavailable {
+			status = http.StatusBadRequest
+		}
+		a.fail(w, status, err)
+		return
+	}
+	a.reply(w, http.StatusOK, vaultOnce.status())
+}
+
+// disableVault puts the credentials back into config.json
+func (a *ApiServer) disableVault(w http.ResponseWriter, r *http.Request) {
+	if err := vaultOnce.disable(); err != nil {
+		a.fail(w, vaultStatusCode(err), err)
+		return
+	}
+	a.reply(w, http.StatusOK, vaultOnce.status())
+}
+
+func (a *ApiServer) unlockVault(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := vaultOnce.unlock(data.Passphrase); err != nil {
+		a.fail(w, vaultStatusCode(err), err)
+		return
+	}
+	a.reply(w, http.StatusOK, vaultOnce.status())
+}
+
+// setVaultEntry changes a credential, the body is {"value": "..."}
+func (a *ApiServer) setVaultEntry(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := vaultOnce.set(r.PathValue("name"), data.Value); err != nil {
+		a.fail(w, vaultStatusCode(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ApiServer) deleteVaultEntry(w http.ResponseWriter, r *http.Request) {
+	if err := vaultOnce.set(r.PathValue("name"), ""); err != nil {
+		a.fail(w, vaultStatusCode(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func vaultStatusCode(err error) int {
+	switch errorCode(err) {
+	case MsgVaultLocked, MsgVaultDisabled:
+		return http.StatusConflict
+	case MsgVaultUnknownEntry:
+		return http.StatusNotFound
+	case MsgVaultPassphrase, MsgSettingsInvalid:
+		return http.StatusBadRequest
+	case MsgPassphraseWrong:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func (a *ApiServer) service(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusOK, serviceStatus())
+}
+
+// serviceProxy points the system proxy at the capture or restores the one set before
+func (a *ApiServer) serviceProxy(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Enable bool `json:"enable"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := setSystemProxy(data.Enable); err != nil {
+		a.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.reply(w, http.StatusOK, serviceStatus())
+}
+
+// stopService answers first, stopping shuts this server down
+func (a *ApiServer) stopService(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusAccepted, serviceStatus())
+	go stopService()
+}
+
+func (a *ApiServer) checkUpdate(w http.ResponseWriter, r *http.Request) {
+	info, err := updaterOnce.check()
+	if err != nil {
+		a.fail(w, http.StatusBadGateway, err)
+		return
+	}
+	a.reply(w, http.StatusOK, info)
+}
+
+// installUpdate answers right away, progress goes out as updateStatus events
+func (a *ApiServer) installUpdate(w http.ResponseWriter, r *http.Request) {
+	updaterOnce.installInBackground()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *ApiServer) rollbackUpdate(w http.ResponseWriter, r *http.Request) {
+	if err := updaterOnce.rollback(); err != nil {
+		status := http.StatusInternalServerError
+		if errorCode(err) == MsgUpdateNoPrevious {
+			status = http.StatusConflict
+		}
+		a.fail(w, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *ApiServer) settings(w http.ResponseWriter, r *http.Request) {
+	a.reply(w, http.StatusOK, globalConfig)
+}
+
+// updateSettings applies only the fields present in the body, the rest keep their values
+func (a *ApiServer) updateSettings(w http.ResponseWriter, r *http.Request) {
+	data, err := globalConfig.clone()
+	if err != nil {
+		a.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	globalConfig.setConfig(data)
+	a.reply(w, http.StatusOK, globalConfig)
+}
+
+// openApi serves openapi.json with the address it was requested on as the server
+func (a *ApiServer) openApi(w http.ResponseWriter, r *http.Request) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openApiSpec, &spec); err != nil {
+		a.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+	spec["servers"] = []map[string]string{{"url": "http://" + r.Host}}
+	a.reply(w, http.StatusOK, spec)
+}
+
+// extensionPage takes what a companion browser extension knows about the open tab, for browsers
+// that bypass the system proxy: the tab's cookies go into the cookie jar, then the page and any
+// media urls the extension saw are resolved and captured as if they had been sniffed.
+func (a *ApiServer) extensionPage(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Url     string `json:"url"`
+		Cookies []struct {
+			Name     string  `json:"name"`
+			Value    string  `json:"value"`
+			Domain   string  `json:"domain"`
+			Path     string  `json:"path"`
+			HostOnly bool    `json:"hostOnly"`
+			Expires  float64 `json:"expirationDate"` // unix seconds, as chrome.cookies reports them
+		} `json:"cookies"`
+		Media []string `json:"media"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&data); err != nil {
+		a.fail(w, http.StatusBadRequest, err)
+		return
+	}
+	page, err := url.Parse(data.Url)
+	if err != nil || (page.Scheme != "http" && page.Scheme != "https") {
+		a.fail(w, http.StatusBadRequest, "url must be an http(s) page")
+		return
+	}
+
+	cookies := make([]*http.Cookie, 0, len(data.Cookies))
+	for _, c := range data.Cookies {
+		cookie := &http.Cookie{Name: c.Name, Value: c.Value, Path: c.Path}
+		if !c.HostOnly {
+			cookie.Domain = c.Domain
+		}
+		if c.Expires > 0 {
+			cookie.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	if len(cookies) > 0 {
+		cookieOnce.SetCookies(page, cookies)
+	}
+
+	targets := []string{data.Url}
+	for _, media := range data.Media {
+		if len(targets) > extensionMediaLimit {
+			break
+		}
+		if u, err := url.Parse(media); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			targets = append(targets, media)
+		}
+	}
+	list := make([]shared.MediaInfo, 0)
+	var lastErr error
+	for _, target := range targets {
+		resolved, err := resourceOnce.resolveUrl(target)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		list = append(list, resolved...)
+	}
+	if len(list) == 0 && lastErr != nil {
+		a.fail(w, http.StatusUnprocessableEntity, lastErr)
+		return
+	}
+	a.reply(w, http.StatusOK, list)
+}
@@ -5,39 +5,72 @@ import (
 	"embed"
 	"fmt"
 	"github.com/vrischmann/userdir"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"res-downloader/core/shared"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type App struct {
 	ctx         context.Context
 	assets      embed.FS
-	AppName     string `json:"AppName"`
-	Version     string `json:"Version"`
-	Description string `json:"Description"`
-	Copyright   string `json:"Copyright"`
-	UserDir     string `json:"-"`
-	LockFile    string `json:"-"`
-	PublicCrt   []byte `json:"-"`
-	PrivateKey  []byte `json:"-"`
-	IsProxy     bool   `json:"IsProxy"`
-	IsReset     bool   `json:"-"`
+	AppName     string   `json:"AppName"`
+	Version     string   `json:"Version"`
+	Description string   `json:"Description"`
+	Copyright   string   `json:"Copyright"`
+	UserDir     string   `json:"-"`
+	LockFile    string   `json:"-"`
+	PublicCrt   []byte   `json:"-"`
+	PrivateKey  []byte   `json:"-"`
+	IsProxy     bool     `json:"IsProxy"`
+	IsReset     bool     `json:"-"`
+	Listeners   []string `json:"Listeners"`
+
+	domReadyOnce sync.Once
 }
 
 var (
-	appOnce        *App
-	globalConfig   *Config
-	globalLogger   *Logger
-	resourceOnce   *Resource
-	systemOnce     *SystemSetup
-	proxyOnce      *Proxy
-	httpServerOnce *HttpServer
-	ruleOnce       *RuleSet
+	appOnce             *App
+	globalConfig        *Config
+	globalLogger        *Logger
+	resourceOnce        *Resource
+	systemOnce          *SystemSetup
+	proxyOnce           *Proxy
+	httpServerOnce      *HttpServer
+	ruleOnce            *RuleSet
+	quicRuleOnce        *RuleSet
+	trafficOnce         *TrafficStats
+	scriptOnce          *ScriptEngine
+	adBlockOnce         *AdBlocker
+	bodyCacheOnce       *BodyCache
+	rangeStitchOnce     *RangeStitcher
+	dohOnce             *DohResolver
+	pinningOnce         *PinningDetector
+	danmakuOnce         *DanmakuRecorder
+	processProxyOnce    *ProcessProxy
+	previewOnce         *PreviewStore
+	speedLimitOnce      *RateLimiter
+	queueOnce           *DownloadQueue
+	batchOnce           *BatchTracker
+	historyOnce         *History
+	scheduleOnce        *Scheduler
+	cookieOnce          *CookieStore
+	apiServerOnce       *ApiServer
+	grpcServerOnce      *GrpcServer
+	externalPluginsOnce *ExternalPlugins
+	rulePacksOnce       *RulePacks
+	telegramOnce        *TelegramBot
+	metricsOnce         *Metrics
+	updaterOnce         *Updater
+	cleanupOnce         *Cleaner
+	vaultOnce           *Vault
+	liveWatchOnce       *LiveWatcher
+	politeOnce          *Politeness
 )
 
 func GetApp(assets embed.FS, wjs string) *App {
@@ -117,22 +150,92 @@ ILKEQKmPPzKs7kp/7Nz+2cT3
 		appOnce.LockFile = filepath.Join(appOnce.UserDir, "install.lock")
 		initLogger()
 		initConfig()
+		initVault()
+		globalLogger.configure()
+		initRulePacks()
+		initDoh()
+		initPinning()
 		initProxy()
 		initResource()
+		initTraffic()
 		initHttpServer()
 		initSystem()
+		recoverSystemProxy()
 		initRule()
+		initQuicRule()
+		initScript()
+		initAdBlock()
+		initBodyCache()
+		initRangeStitch()
+		initDanmaku()
+		initProcessProxy()
+		initPreview()
+		initSpeedLimit()
+		initQueue()
+		initBatch()
+		initHistory()
+		initSchedule()
+		initCookies()
+		initApiServer()
+		initGrpcServer()
+		initExternalPlugins()
+		initTelegram()
+		initMetrics()
+		initUpdater()
+		initCleanup()
+		initLiveWatch()
+		initPoliteness()
+		sweepWorkDirectory()
 	}
 	return appOnce
 }
 
 func (a *App) Startup(ctx context.Context) {
 	a.ctx = ctx
+	stopRunningService()
+	if err := a.startServices(); err != nil {
+		globalLogger.Err(err)
+		log.Fatalf("Service cannot start: %v", err)
+	}
+}
+
+// startServices brings up the proxy and api listeners, shared by the window and the cli
+func (a *App) startServices() error {
+	if err := httpServerOnce.listen(); err != nil {
+		return err
+	}
 	go httpServerOnce.run()
+	go globalConfig.watch()
+	apiServerOnce.apply()
+	grpcServerOnce.apply()
+	telegramOnce.apply()
+	metricsOnce.apply()
+	if globalConfig.ProcessProxy {
+		if err := processProxyOnce.Start(); err != nil {
+			globalLogger.Esg(err, "start process proxy failed")
+		}
+	}
+	return nil
+}
+
+// DomReady runs whenever the frontend (re)loads, startup work that emits events belongs here
+func (a *App) DomReady(ctx context.Context) {
+	a.domReadyOnce.Do(func() {
+		resourceOnce.requeueOnStartup()
+	})
 }
 
 func (a *App) OnExit() {
 	a.UnsetSystemProxy()
+	processProxyOnce.Stop()
+	apiServerOnce.stop()
+	grpcServerOnce.stop()
+	externalPluginsOnce.stop()
+	telegramOnce.stop()
+	metricsOnce.stop()
+	historyOnce.close()
+	resourceOnce.store.close()
+	cookieOnce.save()
 	globalLogger.Close()
 	if appOnce.IsReset {
 		err := a.ResetApp()
@@ -157,8 +260,10 @@ func (a *App) OpenSystemProxy() error {
 	if a.IsProxy {
 		return nil
 	}
+	previous := systemOnce.proxySnapshot()
 	err := systemOnce.setProxy()
 	if err == nil {
+		saveProxyState(previous)
 		a.IsProxy = true
 		return nil
 	}
@@ -169,8 +274,9 @@ func (a *App) UnsetSystemProxy() error {
 	if !a.IsProxy {
 		return nil
 	}
-	err := systemOnce.unsetProxy()
+	err := restoreSystemProxy(loadProxyState())
 	if err == nil {
+		removeProxyState()
 		a.IsProxy = false
 		return nil
 	}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// aria2PollFailures is how many status polls in a row may fail before the task is given up
+const aria2PollFailures = 30
+
+// Aria2Downloader hands a download to aria2 over JSON-RPC and polls it for progress
+type Aria2Downloader struct {
+	Url              string
+	FileName         string
+	Headers          map[string]string
+	Overrides        map[string]string
+	ProxyMode        string
+	progressCallback ProgressCallback
+	Finalize         FinalizeFunc
+	client           *http.Client
+	gid              string
+	gidMux           sync.Mutex
+	ctx              context.Context
+	cancelFunc       context.CancelFunc
+}
+
+type aria2Response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type aria2Status struct {
+	Status          string `json:"status"`
+	TotalLength     string `json:"totalLength"`
+	CompletedLength string `json:"completedLength"`
+	ErrorCode       string `json:"errorCode"`
+	ErrorMessage    string `json:"errorMessage"`
+}
+
+func NewAria2Downloader(url, filename string, headers map[string]string) *Aria2Downloader {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	return &Aria2Downloader{
+		Url:        url,
+		FileName:   filename,
+		Headers:    headers,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+	}
+}
+
+func (ad *Aria2Downloader) call(method string, params ...interface{}) (json.RawMessage, error) {
+	return ad.callContext(context.Background(), method, params...)
+}
+
+// callContext is call giving up when ctx ends, for the polling that must stop with the task
+func (ad *Aria2Downloader) callContext(ctx context.Context, method string, params ...interface{}) (json.RawMessage, error) {
+	if globalConfig.Aria2Secret != "" {
+		params = append([]interface{}{"token:" + globalConfig.Aria2Secret}, params...)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "res-downloader",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, globalConfig.Aria2Rpc, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := ad.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("aria2 rpc failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result aria2Response
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("aria2 rpc response: %w", err)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("aria2 error %d: %s", result.Error.Code, result.Error.Message)
+	}
+	return result.Result, nil
+}
+
+func (ad *Aria2Downloader) Start() error {
+	fd := &FileDownloader{Headers: ad.Headers, Overrides: ad.Overrides}
+	if _, ok := ad.Headers["User-Agent"]; !ok {
+		ad.Headers["User-Agent"] = defaultUserAgent()
+	}
+	request, _ := http.NewRequest("GET", ad.Url, nil)
+	fd.setHeaders(request)
+	var headers []string
+	for key, values := range request.Header {
+		for _, value := range values {
+			headers = append(headers, key+": "+value)
+		}
+	}
+
+	ad.FileName = targetFileName(ad.FileName)
+	options := map[string]interface{}{
+		"dir":    filepath.Dir(ad.FileName),
+		"out":    filepath.Base(ad.FileName) + partSuffix,
+		"header": headers,
+		"split":  strconv.Itoa(max(globalConfig.TaskNumber, 1)),
+		// picks up the .aria2 control file of a paused download
+		"continue": "true",
+	}
+	proxyURL, err := downloadProxy(ad.ProxyMode, ad.Url)
+	if err != nil {
+		return err
+	}
+	if proxyURL != nil {
+		options["all-proxy"] = proxyURL.String()
+	}
+	if limit := speedLimitOnce.Rate(); limit > 0 {
+		options["max-download-limit"] = strconv.FormatInt(limit, 10)
+	}
+	result, err := ad.call("aria2.addUri", []string{ad.Url}, options)
+	if err != nil {
+		return err
+	}
+	var gid string
+	if err := json.Unmarshal(result, &gid); err != nil {
+		return err
+	}
+	ad.gidMux.Lock()
+	ad.gid = gid
+	ad.gidMux.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ad.ctx.Done():
+			return messageError(MsgDownloadCancelled)
+		case <-ticker.C:
+		}
+		var status aria2Status
+		result, err := ad.callContext(ad.ctx, "aria2.tellStatus", gid, []string{"status", "totalLength", "completedLength", "errorCode", "errorMessage"})
+		if err == nil {
+			err = json.Unmarshal(result, &status)
+		}
+		if err != nil {
+			if ad.ctx.Err() != nil {
+				return messageError(MsgDownloadCancelled)
+			}
+			// aria2 went away or forgot the task, nothing will ever finish it
+			if failures++; failures >= aria2PollFailures {
+				_, _ = ad.call("aria2.remove", gid)
+				return fmt.Errorf("aria2 tellStatus failed: %w", err)
+			}
+			globalLogger.Warn().Msgf("aria2 tellStatus failed: %v", err)
+			continue
+		}
+		failures = 0
+		total, _ := strconv.ParseFloat(status.TotalLength, 64)
+		completed, _ := strconv.ParseFloat(status.CompletedLength, 64)
+		if ad.progressCallback != nil && total > 0 {
+			ad.progressCallback(completed, total, 0, completed/total*100)
+		}
+
+		switch status.Status {
+		case "complete":
+			return commitPart(ad.FileName+partSuffix, ad.FileName, ad.Finalize)
+		case "error":
+			return fmt.Errorf("aria2 download failed (%s): %s", status.ErrorCode, status.ErrorMessage)
+		case "removed":
+			return messageError(MsgDownloadCancelled)
+		}
+	}
+}
+
+// Pause removes the task from aria2 but leaves its file and control file for the next start
+func (ad *Aria2Downloader) Pause() {
+	ad.Cancel()
+}
+
+func (ad *Aria2Downloader) Cancel() {
+	ad.cancelFunc()
+	ad.gidMux.Lock()
+	gid := ad.gid
+	ad.gidMux.Unlock()
+	if gid != "" {
+		if _, err := ad.call("aria2.remove", gid); err != nil {
+			globalLogger.Esg(err, "aria2 remove failed")
+		}
+	}
+}
+
+func (ad *Aria2Downloader) SetSpeedLimit(rate int64) {
+	ad.gidMux.Lock()
+	gid := ad.gid
+	ad.gidMux.Unlock()
+	if gid == "" {
+		return
+	}
+	if _, err := ad.call("aria2.changeOption", gid, map[string]string{"max-download-limit": strconv.FormatInt(rate, 10)}); err != nil {
+		globalLogger.Esg(err, "aria2 change speed limit failed")
+	}
+}
+
+// aria2Version checks the rpc settings
+func aria2Version() (string, error) {
+	result, err := (&Aria2Downloader{client: &http.Client{Timeout: 10 * time.Second}}).call("aria2.getVersion")
+	if err != nil {
+		return "", err
+	}
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(result, &version); err != nil {
+		return "", err
+	}
+	if version.Version == "" {
+		return "", errors.New("unexpected aria2 response")
+	}
+	return version.Version, nil
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"res-downloader/core/shared"
+)
+
+// Downloads are written to "<name>.part" and only renamed to their final name once complete,
+// so an interrupted download never looks like a finished file in the save directory.
+const partSuffix = ".part"
+
+// FinalizeFunc runs on the complete part file right before it is committed, e.g. to decrypt it
+type FinalizeFunc func(partName string) error
+
+// targetFileName picks the final name, an existing file is only replaced with the overwrite policy
+func targetFileName(fileName string) string {
+	if duplicatePolicy() == DuplicateOverwrite {
+		return fileName
+	}
+	return shared.GetUniqueFileName(fileName)
+}
+
+// commitPart runs finalize on the part file and moves it to fileName
+func commitPart(partName, fileName string, finalize FinalizeFunc) error {
+	if finalize != nil {
+		if err := finalize(partName); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(partName, fileName); err != nil {
+		return fmt.Errorf("rename part file failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+)
+
+// audioSuffix is what an audio-only download is saved as, AAC copied out of mp4 and ts plays as .m4a
+const audioSuffix = ".m4a"
+
+// setAudioOnly marks a download to keep only the sound of a video
+func (r *Resource) setAudioOnly(id string, on bool) {
+	if !on {
+		r.audioModes.Delete(id)
+		return
+	}
+	r.audioModes.Store(id, true)
+}
+
+func (r *Resource) audioOnly(mediaInfo shared.MediaInfo) bool {
+	if mediaInfo.Classify != "video" && !isHlsPlaylist(mediaInfo) {
+		return false
+	}
+	_, ok := r.audioModes.Load(mediaInfo.Id)
+	return ok
+}
+
+// findFfmpeg resolves the ffmpeg executable, name empty means the one on PATH
+func findFfmpeg(name string) (string, error) {
+	if name == "" {
+		name = "ffmpeg"
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg not found: %w", err)
+	}
+	return path, nil
+}
+
+// extractAudio copies the audio stream of a downloaded video into a .m4a next to it and removes
+// the video. ffmpeg writes to the work directory, the .m4a only shows up once complete.
+func extractAudio(source string) (string, error) {
+	ffmpeg, err := findFfmpeg("")
+	if err != nil {
+		return "", err
+	}
+	target := strings.TrimSuffix(source, filepath.Ext(source)) + audioSuffix
+	if target == source {
+		return source, nil
+	}
+	if _, err := os.Stat(target); err == nil && duplicatePolicy() != DuplicateOverwrite {
+		target = freeFileName(target)
+	}
+	work, err := workFile(audioSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(work)
+	output, err := backgroundCommand(ffmpeg, "-y", "-hide_banner", "-loglevel", "error", "-i", source, "-vn", "-c:a", "copy", work).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if err := moveWorkFile(work, target); err != nil {
+		return "", err
+	}
+	_ = os.Remove(source)
+	return target, nil
+}
+
+// hlsAudioRendition is the audio playlist a master playlist lists separately, the default one
+// first. Empty when the audio is muxed into the video streams.
+func hlsAudioRendition(base *url.URL, body []byte) string {
+	var first string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+		attrs := hlsAttributes(line)
+		if attrs["TYPE"] != "AUDIO" || attrs["URI"] == "" {
+			continue
+		}
+		u, err := base.Parse(attrs["URI"])
+		if err != nil {
+			continue
+		}
+		if attrs["DEFAULT"] == "YES" {
+			return u.String()
+		}
+		if first == "" {
+			first = u.String()
+		}
+	}
+	return first
+}
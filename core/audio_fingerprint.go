@@ -0,0 +1,239 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the sound is decoded to mono at this rate, the bands compared all lie below its half
+	fingerprintRate   = 5512
+	fingerprintFrame  = 2048
+	fingerprintHop    = 512
+	fingerprintLowHz  = 300.0
+	fingerprintHighHz = 2000.0
+	// fingerprintSeconds is how much of the start is compared, a re-upload rarely differs only later
+	fingerprintSeconds = 120
+	// fingerprintMinFrames is the least overlap two fingerprints are judged on, about 9 seconds
+	fingerprintMinFrames = 100
+	// fingerprintMaxShift lets one copy start up to about 15 seconds before or after the other,
+	// a trimmed intro or an added watermark bumper
+	fingerprintMaxShift = 160
+	// fingerprintBitError is the share of differing bits below which two sounds are the same,
+	// unrelated audio differs in about half of them
+	fingerprintBitError = 0.35
+)
+
+// fingerprintExts are the downloads that carry sound worth comparing
+var fingerprintExts = map[string]bool{
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true, ".flv": true, ".ts": true,
+	".m4a": true, ".mp3": true, ".aac": true, ".ogg": true, ".opus": true, ".wav": true, ".flac": true,
+}
+
+func audioIndexFile() string {
+	return filepath.Join(appOnce.UserDir, "audio.json")
+}
+
+func fingerprintable(savePath string) bool {
+	return fingerprintExts[strings.ToLower(filepath.Ext(savePath))]
+}
+
+// similarAudio indexes the audio fingerprint of a video or audio download and returns an earlier
+// one that sounds the same, e.g. a clip re-posted on another platform with a different encoding.
+// It only runs with Config.AudioFingerprint and ffmpeg at hand. The caller holds contentIndexMux.
+func similarAudio(savePath string) string {
+	if !globalConfig.AudioFingerprint || !fingerprintable(savePath) {
+		return ""
+	}
+	fingerprint, ok := audioFingerprint(savePath)
+	if !ok {
+		return ""
+	}
+
+	index := map[string]string{} // path -> base64 of the little endian fingerprint
+	if data, err := os.ReadFile(audioIndexFile()); err == nil {
+		_ = json.Unmarshal(data, &index)
+	}
+	similar := ""
+	for path, value := range index {
+		if path == savePath {
+			continue
+		}
+		other, err := decodeFingerprint(value)
+		if err != nil || !fingerprintMatch(fingerprint, other) {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			delete(index, path)
+			continue
+		}
+		similar = path
+		break
+	}
+	index[savePath] = encodeFingerprint(fingerprint)
+	if data, err := json.Marshal(index); err == nil {
+		if err := os.WriteFile(audioIndexFile(), data, 0644); err != nil {
+			globalLogger.Esg(err, "save audio index failed")
+		}
+	}
+	return similar
+}
+
+// audioFingerprint decodes the start of the sound and fingerprints it. Files without sound, too
+// short ones and silence give no fingerprint.
+func audioFingerprint(fileName string) ([]uint32, bool) {
+	ffmpeg, err := findFfmpeg("")
+	if err != nil {
+		return nil, false
+	}
+	pcm, err := backgroundCommand(ffmpeg, "-hide_banner", "-loglevel", "error", "-i", fileName,
+		"-t", strconv.Itoa(fingerprintSeconds), "-vn", "-ac", "1", "-ar", strconv.Itoa(fingerprintRate),
+		"-f", "s16le", "-").Output()
+	if err != nil {
+		globalLogger.Warn().Msgf("decode audio of %s failed: %v", fileName, err)
+		return nil, false
+	}
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+	return fingerprintSamples(samples)
+}
+
+// fingerprintSamples is a Philips style fingerprint: every hop the spectrum between 300 and
+// 2000 Hz is split into 33 bands on a log scale, and each of the 32 bits tells whether the energy
+// difference of two neighbouring bands grew since the previous frame. Loudness, encoding and
+// small equalisation changes leave most bits alone.
+func fingerprintSamples(samples []float64) ([]uint32, bool) {
+	frames := (len(samples)-fingerprintFrame)/fingerprintHop + 1
+	if frames < fingerprintMinFrames+1 {
+		return nil, false
+	}
+
+	// band edges as fft bins
+	var edges [34]int
+	for i := range edges {
+		hz := fingerprintLowHz * math.Pow(fingerprintHighHz/fingerprintLowHz, float64(i)/33)
+		edges[i] = int(hz * fingerprintFrame / fingerprintRate)
+	}
+	window := make([]float64, fingerprintFrame)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/fingerprintFrame)
+	}
+
+	fingerprint := make([]uint32, 0, frames-1)
+	buffer := make([]complex128, fingerprintFrame)
+	var previous [33]float64
+	loud := 0
+	for n := 0; n < frames; n++ {
+		frame := samples[n*fingerprintHop : n*fingerprintHop+fingerprintFrame]
+		total := 0.0
+		for i, sample := range frame {
+			buffer[i] = complex(sample*window[i], 0)
+			total += math.Abs(sample)
+		}
+		// below about -60 dB the bits are noise
+		if total/fingerprintFrame > 32 {
+			loud++
+		}
+		fft(buffer)
+
+		var energy [33]float64
+		for band := range energy {
+			for bin := edges[band]; bin < max(edges[band+1], edges[band]+1); bin++ {
+				energy[band] += real(buffer[bin])*real(buffer[bin]) + imag(buffer[bin])*imag(buffer[bin])
+			}
+		}
+		if n > 0 {
+			var word uint32
+			for m := 0; m < 32; m++ {
+				word <<= 1
+				if energy[m]-energy[m+1]-(previous[m]-previous[m+1]) > 0 {
+					word |= 1
+				}
+			}
+			fingerprint = append(fingerprint, word)
+		}
+		previous = energy
+	}
+	if loud < fingerprintMinFrames {
+		return nil, false
+	}
+	return fingerprint, true
+}
+
+// fingerprintMatch slides one fingerprint along the other and reports whether at some offset
+// few enough bits differ
+func fingerprintMatch(a, b []uint32) bool {
+	for shift := -fingerprintMaxShift; shift <= fingerprintMaxShift; shift++ {
+		// a[i] lines up with b[i+shift]
+		start := max(0, -shift)
+		end := min(len(a), len(b)-shift)
+		if end-start < fingerprintMinFrames {
+			continue
+		}
+		limit := int(fingerprintBitError * float64((end-start)*32))
+		diff := 0
+		for i := start; i < end && diff <= limit; i++ {
+			diff += bits.OnesCount32(a[i] ^ b[i+shift])
+		}
+		if diff <= limit {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeFingerprint(fingerprint []uint32) string {
+	data := make([]byte, len(fingerprint)*4)
+	for i, word := range fingerprint {
+		binary.LittleEndian.PutUint32(data[i*4:], word)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func decodeFingerprint(value string) ([]uint32, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := make([]uint32, len(data)/4)
+	for i := range fingerprint {
+		fingerprint[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+	return fingerprint, nil
+}
+
+// fft transforms in place, the length must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"res-downloader/core/shared"
+	"strings"
+)
+
+// AutomationRule acts on captured resources that pass its filter, Domain doubles as the platform.
+// A resource whose size is not known yet never passes MinSize.
+type AutomationRule struct {
+	Name     string  `json:"Name"`
+	Enable   bool    `json:"Enable"`
+	MinSize  float64 `json:"MinSize"` // bytes, 0 for no bound
+	MaxSize  float64 `json:"MaxSize"`
+	Download bool    `json:"Download"`
+	Upload   bool    `json:"Upload"` // copy what the rule downloaded to WebDAV, even with WebdavEnable off
+	Notify   bool    `json:"Notify"` // message the Telegram chat and tell the window
+	resourceFilter
+}
+
+type automationActions struct {
+	rules    []string
+	download bool
+	notify   bool
+}
+
+func (a AutomationRule) matches(res shared.MediaInfo) bool {
+	if a.MinSize > 0 && res.Size < a.MinSize {
+		return false
+	}
+	if a.MaxSize > 0 && res.Size > a.MaxSize {
+		return false
+	}
+	return a.resourceFilter.matches(res)
+}
+
+// automationFor collects the actions of every matching rule. It runs before the window hears of
+// the resource, so the upload mark travels with it into downloads the window starts.
+func automationFor(res *shared.MediaInfo) automationActions {
+	var actions automationActions
+	for _, rule := range globalConfig.AutomationRules {
+		if !rule.Enable {
+			continue
+		}
+		if err := rule.compile(); err != nil {
+			globalLogger.Esg(err, "automation rule %s has an invalid filter", rule.Name)
+			continue
+		}
+		if !rule.matches(*res) {
+			continue
+		}
+		actions.rules = append(actions.rules, rule.Name)
+		actions.download = actions.download || rule.Download
+		actions.notify = actions.notify || rule.Notify
+		if rule.Download && rule.Upload {
+			otherData := map[string]string{"automation_upload": "1"}
+			for k, v := range res.OtherData {
+				otherData[k] = v
+			}
+			res.OtherData = otherData
+		}
+	}
+	return actions
+}
+
+func (a automationActions) run(res shared.MediaInfo) {
+	if len(a.rules) == 0 {
+		return
+	}
+	if a.notify {
+		title := res.Description
+		if title == "" {
+			title = res.Url
+		}
+		telegramOnce.message("Captured by " + strings.Join(a.rules, ", ") + "\n" + title)
+		httpServerOnce.send("automationMatched", map[string]interface{}{
+			"Rules":    a.rules,
+			"Resource": res,
+		})
+	}
+	if !a.download {
+		return
+	}
+	if globalConfig.SaveDirectory == "" {
+		globalLogger.Warn().Msgf("automation skipped %s, save directory is not set", res.Url)
+		return
+	}
+	// the window derives the decryption stream from DecodeKey, so encrypted videos are handed to it
+	if res.DecodeKey != "" {
+		httpServerOnce.send("automationDownload", res)
+		return
+	}
+	queueOnce.push(res, "", 0)
+}
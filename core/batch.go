@@ -0,0 +1,189 @@
+package core
+
+import (
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// DownloadBatch groups resources queued together so their progress can be reported as one
+type DownloadBatch struct {
+	Id       string `json:"Id"`
+	Total    int    `json:"Total"`
+	Done     int    `json:"Done"`
+	Failed   int    `json:"Failed"`
+	Running  int    `json:"Running"`
+	Progress int    `json:"Progress"` // percent over all items
+	Created  int64  `json:"Created"`
+	items    map[string]*batchItem
+	finish   func()    // run once when every item is done or failed
+	finished time.Time // when every item was done or failed, zero before
+}
+
+const (
+	// batchKeep is how long a finished batch can still be looked up
+	batchKeep = 10 * time.Minute
+	// batchMaxAge drops batches whose items never all finish, e.g. removed from the queue
+	batchMaxAge = 24 * time.Hour
+)
+
+type batchItem struct {
+	status  string
+	percent int
+}
+
+type BatchTracker struct {
+	mu      sync.Mutex
+	batches map[string]*DownloadBatch
+	itemOf  map[string]string // media id -> batch id
+}
+
+func initBatch() *BatchTracker {
+	if batchOnce == nil {
+		batchOnce = &BatchTracker{
+			batches: make(map[string]*DownloadBatch),
+			itemOf:  make(map[string]string),
+		}
+	}
+	return batchOnce
+}
+
+// start queues every item with the same priority and returns the batch
+func (b *BatchTracker) start(items []QueueItem, priority int) DownloadBatch {
+	return b.startThen(items, priority, nil)
+}
+
+// startThen is start with finish run once every item is done or failed, right away when there are no items
+func (b *BatchTracker) startThen(items []QueueItem, priority int, finish func()) DownloadBatch {
+	id, err := gonanoid.New()
+	if err != nil {
+		id = shared.Md5(strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+	batch := &DownloadBatch{
+		Id:      id,
+		Created: time.Now().Unix(),
+		items:   make(map[string]*batchItem),
+		finish:  finish,
+	}
+
+	b.mu.Lock()
+	b.prune(time.Now())
+	for _, item := range items {
+		batch.items[item.MediaInfo.Id] = &batchItem{status: shared.DownloadStatusReady}
+		b.itemOf[item.MediaInfo.Id] = id
+	}
+	batch.Total = len(batch.items)
+	if batch.Total == 0 {
+		batch.finished = time.Now()
+	}
+	b.batches[id] = batch
+	snapshot := batch.snapshot()
+	b.mu.Unlock()
+
+	if len(items) == 0 && finish != nil {
+		go finish()
+	}
+	for _, item := range items {
+		queueOnce.push(item.MediaInfo, item.DecodeStr, priority)
+	}
+	return snapshot
+}
+
+// update is fed by every download progress event
+func (b *BatchTracker) update(mediaId, status, message string) {
+	b.mu.Lock()
+	batchId, ok := b.itemOf[mediaId]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	batch := b.batches[batchId]
+	item := batch.items[mediaId]
+	item.status = status
+	switch status {
+	case shared.DownloadStatusDone:
+		item.percent = 100
+	case shared.DownloadStatusRunning:
+		if percent, err := strconv.Atoi(strings.TrimSuffix(message, "%")); err == nil {
+			item.percent = percent
+		}
+	}
+	snapshot := batch.snapshot()
+	finish := batch.finish
+	if snapshot.Done+snapshot.Failed < snapshot.Total {
+		finish = nil
+		batch.finished = time.Time{}
+	} else {
+		batch.finish = nil
+		if batch.finished.IsZero() {
+			batch.finished = time.Now()
+		}
+	}
+	b.mu.Unlock()
+
+	httpServerOnce.send("batchProgress", snapshot)
+	if finish != nil {
+		go finish()
+	}
+}
+
+// prune drops the batches finished longer than batchKeep ago and the ones older than batchMaxAge.
+// Callers hold mu.
+func (b *BatchTracker) prune(now time.Time) {
+	for id, batch := range b.batches {
+		if (batch.finished.IsZero() || now.Sub(batch.finished) < batchKeep) && now.Unix()-batch.Created < int64(batchMaxAge/time.Second) {
+			continue
+		}
+		for mediaId := range batch.items {
+			if b.itemOf[mediaId] == id {
+				delete(b.itemOf, mediaId)
+			}
+		}
+		delete(b.batches, id)
+	}
+}
+
+func (b *BatchTracker) get(id string) (DownloadBatch, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.batches[id]
+	if !ok {
+		return DownloadBatch{}, false
+	}
+	return batch.snapshot(), true
+}
+
+func (b *BatchTracker) list() []DownloadBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(time.Now())
+	list := make([]DownloadBatch, 0, len(b.batches))
+	for _, batch := range b.batches {
+		list = append(list, batch.snapshot())
+	}
+	return list
+}
+
+func (d *DownloadBatch) snapshot() DownloadBatch {
+	s := DownloadBatch{Id: d.Id, Total: d.Total, Created: d.Created}
+	sum := 0
+	for _, item := range d.items {
+		switch item.status {
+		case shared.DownloadStatusDone:
+			s.Done++
+		case shared.DownloadStatusError:
+			s.Failed++
+		case shared.DownloadStatusRunning:
+			s.Running++
+		}
+		sum += item.percent
+	}
+	if d.Total > 0 {
+		s.Progress = sum / d.Total
+	}
+	return s
+}
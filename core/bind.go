@@ -1,6 +1,8 @@
 package core
 
 import (
+	"encoding/json"
+
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
@@ -19,6 +21,118 @@ func (b *Bind) AppInfo() *ResponseData {
 	return httpServerOnce.buildResp(1, "ok", appOnce)
 }
 
+// SetConfig applies the settings page, window only settings included. Keys missing from data keep
+// their values, the api token only changes through ResetApiToken.
+func (b *Bind) SetConfig(data map[string]interface{}) *ResponseData {
+	delete(data, "ApiToken")
+	config, err := globalConfig.clone()
+	if err == nil {
+		var raw []byte
+		if raw, err = json.Marshal(data); err == nil {
+			err = json.Unmarshal(raw, &config)
+		}
+	}
+	if err != nil {
+		return httpServerOnce.buildResp(0, err.Error(), nil)
+	}
+	globalConfig.setConfig(config)
+	return httpServerOnce.buildResp(1, "ok", globalConfig)
+}
+
+// UpdateInstall installs the latest release, the window follows the updateStatus events
+func (b *Bind) UpdateInstall() *ResponseData {
+	updaterOnce.installInBackground()
+	return httpServerOnce.buildResp(1, "ok", nil)
+}
+
+// ResetApiToken makes up a new api token, the window is the only place it is shown
+func (b *Bind) ResetApiToken() *ResponseData {
+	token, err := globalConfig.resetApiToken()
+	if err != nil {
+		return httpServerOnce.buildResp(0, err.Error(), nil)
+	}
+	return httpServerOnce.buildResp(1, "ok", map[string]string{"ApiToken": token})
+}
+
+// VaultSetup moves the credentials into the vault, or changes its mode or passphrase
+func (b *Bind) VaultSetup(mode, passphrase string) *ResponseData {
+	return b.vaultResult(vaultOnce.setup(mode, passphrase))
+}
+
+// VaultSet changes one credential in the vault, an empty value removes it
+func (b *Bind) VaultSet(name, value string) *ResponseData {
+	return b.vaultResult(vaultOnce.set(name, value))
+}
+
+// VaultDisable puts the credentials back into config.json
+func (b *Bind) VaultDisable() *ResponseData {
+	return b.vaultResult(vaultOnce.disable())
+}
+
+func (b *Bind) vaultResult(err error) *ResponseData {
+	if err != nil {
+		return httpServerOnce.buildResp(0, err.Error(), nil)
+	}
+	return httpServerOnce.buildResp(1, "ok", vaultOnce.status())
+}
+
+// Resources lists the captures with their headers, which the open /api only shows holders of the token
+func (b *Bind) Resources() *ResponseData {
+	return httpServerOnce.buildResp(1, "ok", map[string]interface{}{"list": resourceOnce.capturedList()})
+}
+
+// CopyRequest is a command fetching a resource with its headers and cookies, for the user's own
+// shell. It isn't on the open /api, where any page could read the cookies of a capture.
+func (b *Bind) CopyRequest(id, format string) *ResponseData {
+	command, err := resourceOnce.requestCommand(id, format)
+	if err != nil {
+		return httpServerOnce.buildResp(0, err.Error(), nil)
+	}
+	return httpServerOnce.buildResp(1, "ok", map[string]string{"command": command})
+}
+
+// SettingsExport writes all settings and the cookie jar where the user picks. Like CopyRequest it
+// isn't on the open /api, the bundle carries every credential.
+func (b *Bind) SettingsExport(passphrase string) *ResponseData {
+	file, err := runtime.SaveFileDialog(appOnce.ctx, runtime.SaveDialogOptions{
+		DefaultFilename: "res-downloader-settings.json",
+		Title:           "Export settings",
+	})
+	if err == nil && file == "" {
+		err = messageError(MsgNoFileSelected)
+	}
+	if err == nil {
+		err = exportSettings(file, passphrase)
+	}
+	if err != nil {
+		return httpServerOnce.buildResp(0, err.Error(), nil)
+	}
+	return httpServerOnce.buildResp(1, "ok", map[string]string{"file": file})
+}
+
+// SettingsImport applies a bundle the user picks
+func (b *Bind) SettingsImport(passphrase string) *ResponseData {
+	file, err := runtime.OpenFileDialog(appOnce.ctx, runtime.OpenDialogOptions{
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "Settings (*.json)",
+				Pattern:     "*.json",
+			},
+		},
+		Title: "Import settings",
+	})
+	if err == nil && file == "" {
+		err = messageError(MsgNoFileSelected)
+	}
+	if err == nil {
+		err = importSettings(file, passphrase)
+	}
+	if err != nil {
+		return httpServerOnce.buildResp(0, err.Error(), nil)
+	}
+	return httpServerOnce.buildResp(1, "ok", globalConfig)
+}
+
 func (b *Bind) ResetApp() {
 	appOnce.IsReset = true
 	runtime.Quit(appOnce.ctx)
@@ -0,0 +1,351 @@
+package core
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"sync"
+)
+
+// BodyCache keeps captured response bodies. Recently used bodies stay in memory up
+// to CacheMemoryMB, older ones are spilled to disk, and once everything together
+// exceeds CacheTotalMB the least recently used bodies are dropped.
+type BodyCache struct {
+	mu        sync.Mutex
+	dir       string
+	entries   map[string]*cacheEntry
+	lru       *list.List
+	memBytes  int64
+	diskBytes int64
+	hits      int64
+	misses    int64
+	spills    int64
+	evictions int64
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+	data []byte
+	file string
+	elem *list.Element
+}
+
+// cacheStreamMemory is how much of a teed body is held in memory before it continues in a file
+const cacheStreamMemory = 4 * 1024 * 1024
+
+// cacheTeeBody copies a media response into the cache while it streams to the client,
+// the body is only kept when the client read it to the end
+type cacheTeeBody struct {
+	io.ReadCloser
+	cache *BodyCache
+	key   string
+	buf   []byte
+	file  *os.File
+	size  int64
+	done  bool
+}
+
+type CacheStats struct {
+	Entries     int   `json:"Entries"`
+	MemoryBytes int64 `json:"MemoryBytes"`
+	DiskBytes   int64 `json:"DiskBytes"`
+	MemoryLimit int64 `json:"MemoryLimit"`
+	TotalLimit  int64 `json:"TotalLimit"`
+	Hits        int64 `json:"Hits"`
+	Misses      int64 `json:"Misses"`
+	Spills      int64 `json:"Spills"`
+	Evictions   int64 `json:"Evictions"`
+}
+
+func initBodyCache() *BodyCache {
+	if bodyCacheOnce == nil {
+		bodyCacheOnce = &BodyCache{
+			dir:     filepath.Join(appOnce.UserDir, "cache"),
+			entries: make(map[string]*cacheEntry),
+			lru:     list.New(),
+		}
+		// bodies spilled by a previous session are unreachable, start clean
+		_ = os.RemoveAll(bodyCacheOnce.dir)
+	}
+	return bodyCacheOnce
+}
+
+func (c *BodyCache) memoryLimit() int64 {
+	return int64(globalConfig.CacheMemoryMB) * 1024 * 1024
+}
+
+func (c *BodyCache) totalLimit() int64 {
+	return int64(globalConfig.CacheTotalMB) * 1024 * 1024
+}
+
+func (c *BodyCache) Put(key string, data []byte) {
+	size := int64(len(data))
+	if size == 0 || size > c.totalLimit() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	entry := &cacheEntry{key: key, size: size, data: data}
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	c.memBytes += size
+	c.shrink()
+}
+
+// tee caches whole media responses when Config.CacheMedia is on, the body stays a stream
+func (c *BodyCache) tee(resp *http.Response) {
+	if !globalConfig.CacheMedia || resp.StatusCode != http.StatusOK || resp.Body == nil || resp.Request.Method != http.MethodGet {
+		return
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return
+	}
+	if resp.ContentLength > c.totalLimit() {
+		return
+	}
+	classify, _ := globalConfig.typeSuffix(resp.Header.Get("Content-Type"))
+	if classify != "video" && classify != "audio" {
+		return
+	}
+	resp.Body = &cacheTeeBody{ReadCloser: resp.Body, cache: c, key: shared.Md5(resp.Request.URL.String())}
+}
+
+func (b *cacheTeeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.done {
+		b.write(p[:n])
+	}
+	if err == io.EOF && !b.done {
+		b.done = true
+		b.commit()
+	}
+	return n, err
+}
+
+func (b *cacheTeeBody) Close() error {
+	if !b.done {
+		b.done = true
+		b.discard()
+	}
+	return b.ReadCloser.Close()
+}
+
+func (b *cacheTeeBody) write(p []byte) {
+	b.size += int64(len(p))
+	if b.size > b.cache.totalLimit() {
+		b.done = true
+		b.discard()
+		return
+	}
+	if b.file == nil && b.size <= cacheStreamMemory {
+		b.buf = append(b.buf, p...)
+		return
+	}
+	if b.file == nil {
+		if err := shared.CreateDirIfNotExist(b.cache.dir); err != nil {
+			b.done = true
+			b.discard()
+			return
+		}
+		file, err := os.CreateTemp(b.cache.dir, "stream-*")
+		if err != nil {
+			globalLogger.Esg(err, "create cache stream file failed")
+			b.done = true
+			b.discard()
+			return
+		}
+		b.file = file
+		p = append(b.buf, p...)
+		b.buf = nil
+	}
+	if _, err := b.file.Write(p); err != nil {
+		globalLogger.Esg(err, "write cache stream file failed")
+		b.done = true
+		b.discard()
+	}
+}
+
+func (b *cacheTeeBody) commit() {
+	if b.file == nil {
+		b.cache.Put(b.key, b.buf)
+		b.buf = nil
+		return
+	}
+	name := b.file.Name()
+	if err := b.file.Close(); err != nil {
+		_ = os.Remove(name)
+		return
+	}
+	b.file = nil
+	b.cache.putFile(b.key, name, b.size)
+}
+
+func (b *cacheTeeBody) discard() {
+	b.buf = nil
+	if b.file != nil {
+		name := b.file.Name()
+		_ = b.file.Close()
+		_ = os.Remove(name)
+		b.file = nil
+	}
+}
+
+// putFile adopts a body already streamed to disk, it never passes through memory
+func (c *BodyCache) putFile(key, name string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	file := filepath.Join(c.dir, shared.Md5(key))
+	if err := os.Rename(name, file); err != nil {
+		_ = os.Remove(name)
+		return
+	}
+	entry := &cacheEntry{key: key, size: size, file: file}
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	c.diskBytes += size
+	c.shrink()
+}
+
+// Open streams a cached body, a spilled one is read from disk rather than loaded into memory
+func (c *BodyCache) Open(key string) (io.ReadCloser, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(entry.elem)
+	c.hits++
+	if entry.data != nil {
+		return io.NopCloser(bytes.NewReader(entry.data)), true
+	}
+	file, err := os.Open(entry.file)
+	if err != nil {
+		c.remove(key)
+		return nil, false
+	}
+	return file, true
+}
+
+// export writes a cached body to savePath, so a resource already played through the proxy is not fetched again
+func (c *BodyCache) export(key, savePath string, finalize FinalizeFunc) (string, bool, error) {
+	src, ok := c.Open(key)
+	if !ok {
+		return savePath, false, nil
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(savePath), os.ModePerm); err != nil {
+		return savePath, true, fmt.Errorf("create directory failed: %w", err)
+	}
+	savePath = targetFileName(savePath)
+	partName := savePath + partSuffix
+	dst, err := os.Create(partName)
+	if err != nil {
+		return savePath, true, err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partName)
+		return savePath, true, err
+	}
+	return savePath, true, commitPart(partName, savePath, finalize)
+}
+
+func (c *BodyCache) Delete(key string) {
+	c.mu.Lock()
+	c.remove(key)
+	c.mu.Unlock()
+}
+
+func (c *BodyCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*cacheEntry)
+	c.lru.Init()
+	c.memBytes, c.diskBytes = 0, 0
+	_ = os.RemoveAll(c.dir)
+}
+
+func (c *BodyCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:     len(c.entries),
+		MemoryBytes: c.memBytes,
+		DiskBytes:   c.diskBytes,
+		MemoryLimit: c.memoryLimit(),
+		TotalLimit:  c.totalLimit(),
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Spills:      c.spills,
+		Evictions:   c.evictions,
+	}
+}
+
+func (c *BodyCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.lru.Remove(entry.elem)
+	delete(c.entries, key)
+	if entry.data != nil {
+		c.memBytes -= entry.size
+	} else {
+		c.diskBytes -= entry.size
+		_ = os.Remove(entry.file)
+	}
+}
+
+// shrink spills from the cold end until memory fits, then evicts until the total fits
+func (c *BodyCache) shrink() {
+	for elem := c.lru.Back(); elem != nil && c.memBytes > c.memoryLimit(); elem = elem.Prev() {
+		entry := elem.Value.(*cacheEntry)
+		if entry.data == nil {
+			continue
+		}
+		if err := c.spill(entry); err != nil {
+			globalLogger.Esg(err, "spill cache entry failed")
+			break
+		}
+	}
+
+	for c.memBytes+c.diskBytes > c.totalLimit() && c.lru.Len() > 0 {
+		entry := c.lru.Back().Value.(*cacheEntry)
+		c.remove(entry.key)
+		c.evictions++
+	}
+}
+
+func (c *BodyCache) spill(entry *cacheEntry) error {
+	if err := shared.CreateDirIfNotExist(c.dir); err != nil {
+		return err
+	}
+	file := filepath.Join(c.dir, shared.Md5(entry.key))
+	if err := os.WriteFile(file, entry.data, 0644); err != nil {
+		return err
+	}
+	entry.file = file
+	entry.data = nil
+	c.memBytes -= entry.size
+	c.diskBytes += entry.size
+	c.spills++
+	return nil
+}
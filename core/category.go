@@ -0,0 +1,89 @@
+package core
+
+import (
+	"bufio"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+)
+
+// categoryPlatforms names the dir of each platform in platformNames
+var categoryPlatforms = map[string]string{
+	"wechat":      "视频号",
+	"xiaohongshu": "小红书",
+	"douyin":      "抖音",
+	"kuaishou":    "快手",
+	"bilibili":    "哔哩哔哩",
+	"weibo":       "微博",
+}
+
+var categoryTypes = map[string]string{
+	"video":   "视频",
+	"image":   "图片",
+	"audio":   "音频",
+	"m3u8":    "直播流",
+	"live":    "直播流",
+	"stream":  "直播流",
+	"doc":     "文档",
+	"pdf":     "文档",
+	"ppt":     "文档",
+	"xls":     "文档",
+	"font":    "字体",
+	"torrent": "种子",
+}
+
+// categoryDir returns the sub directory for a resource when Config.CategoryDirs is on.
+// Config.CategoryRules lines are "platform:type=dir" where either side of ':' may be '*',
+// the most specific rule wins; without a rule it falls back to "<platform>/<type>".
+func categoryDir(mediaInfo shared.MediaInfo) string {
+	if !globalConfig.CategoryDirs {
+		return ""
+	}
+	rules := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(globalConfig.CategoryRules))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, dir, ok := strings.Cut(line, "="); ok {
+			rules[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(dir)
+		}
+	}
+
+	platform := strings.ToLower(mediaInfo.Domain)
+	classify := strings.ToLower(mediaInfo.Classify)
+	for _, key := range []string{platform + ":" + classify, platform + ":*", "*:" + classify, "*:*"} {
+		if dir, ok := rules[key]; ok {
+			return cleanCategoryDir(dir)
+		}
+	}
+
+	platformName, ok := categoryPlatforms[platformNames[platform]]
+	if !ok {
+		platformName = "其他"
+	}
+	typeName, ok := categoryTypes[classify]
+	if !ok {
+		typeName = "其他"
+	}
+	return filepath.Join(platformName, typeName)
+}
+
+// cleanCategoryDir keeps a user supplied dir inside the save directory
+func cleanCategoryDir(dir string) string {
+	var parts []string
+	for _, part := range strings.FieldsFunc(dir, func(r rune) bool { return r == '/' || r == '\\' }) {
+		part = sanitizeFileName(part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return filepath.Join(parts...)
+}
+
+// saveDirectory is where a resource is written, the save directory plus its category
+func saveDirectory(mediaInfo shared.MediaInfo) string {
+	return filepath.Join(globalConfig.SaveDirectory, categoryDir(mediaInfo))
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is how often the retention policies are applied in the background
+const cleanupInterval = 6 * time.Hour
+
+// CleanupReport is what one run of the cleaner removed
+type CleanupReport struct {
+	Resources     int   `json:"Resources"`     // captured entries past Config.RetentionDays
+	PartialFiles  int   `json:"PartialFiles"`  // abandoned .part files past Config.PartRetentionDays
+	PartialBytes  int64 `json:"PartialBytes"`  // their size
+	CacheBytes    int64 `json:"CacheBytes"`    // cached response bodies, only when asked to
+	DatabaseBytes int64 `json:"DatabaseBytes"` // resources.db shrunk by the vacuum of a manual run
+	Reclaimed     int64 `json:"Reclaimed"`     // bytes freed in all
+}
+
+// Cleaner applies the retention policies: captured entries older than Config.RetentionDays go,
+// except the starred, tagged or noted ones, and .part files no pending download will resume are
+// removed once untouched for Config.PartRetentionDays. The body cache caps itself at CacheTotalMB.
+type Cleaner struct {
+	mu      sync.Mutex
+	lastRun time.Time
+	last    CleanupReport
+}
+
+func initCleanup() *Cleaner {
+	if cleanupOnce == nil {
+		cleanupOnce = &Cleaner{}
+		go func() {
+			cleanupOnce.run(false, false)
+			for range time.Tick(cleanupInterval) {
+				cleanupOnce.run(false, false)
+			}
+		}()
+	}
+	return cleanupOnce
+}
+
+// run applies the policies once. A manual run also drops the body cache when cache is set and
+// compacts the database, the background one leaves both alone.
+func (c *Cleaner) run(manual, cache bool) CleanupReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var report CleanupReport
+	if days := globalConfig.RetentionDays; days > 0 {
+		before := time.Now().AddDate(0, 0, -days)
+		report.Resources = resourceOnce.store.expire(before)
+	}
+	if days := globalConfig.PartRetentionDays; days > 0 {
+		report.PartialFiles, report.PartialBytes = c.removePartials(time.Now().AddDate(0, 0, -days))
+	}
+	if manual {
+		if cache {
+			stats := bodyCacheOnce.Stats()
+			report.CacheBytes = stats.MemoryBytes + stats.DiskBytes
+			bodyCacheOnce.Clear()
+		}
+		report.DatabaseBytes = resourceOnce.store.vacuum()
+	}
+	report.Reclaimed = report.PartialBytes + report.CacheBytes + report.DatabaseBytes
+
+	c.lastRun, c.last = time.Now(), report
+	if report.Resources > 0 || report.PartialFiles > 0 || report.Reclaimed > 0 {
+		globalLogger.Info().Msgf("cleanup removed %d resources and %d partial files, %d bytes reclaimed",
+			report.Resources, report.PartialFiles, report.Reclaimed)
+	}
+	return report
+}
+
+// removePartials deletes the .part files below the save directory that were last written before
+// the cutoff and that no pending download points at, with the resume state kept next to them.
+// Only the ones of a download in the history are taken, a browser or another downloader saving
+// to the same folder has its own.
+func (c *Cleaner) removePartials(before time.Time) (int, int64) {
+	root := globalConfig.SaveDirectory
+	if root == "" {
+		return 0, 0
+	}
+	// running and paused downloads are in the journal too
+	keep := map[string]bool{}
+	journalMux.Lock()
+	for _, item := range loadJournal() {
+		if item.MediaInfo.SavePath != "" {
+			keep[filepath.Clean(item.MediaInfo.SavePath)+partSuffix] = true
+		}
+	}
+	journalMux.Unlock()
+
+	count, size := 0, int64(0)
+	_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, partSuffix) || keep[filepath.Clean(path)] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(before) || !historyOnce.downloaded(strings.TrimSuffix(path, partSuffix)) {
+			return nil
+		}
+		if os.Remove(path) != nil {
+			return nil
+		}
+		count++
+		size += info.Size()
+		if state, err := os.Stat(path + ".json"); err == nil && os.Remove(path+".json") == nil {
+			size += state.Size()
+		}
+		return nil
+	})
+	return count, size
+}
+
+// status is the outcome of the latest run, zero before the first one finished
+func (c *Cleaner) status() (time.Time, CleanupReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastRun, c.last
+}
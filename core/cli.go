@@ -0,0 +1,300 @@
+package core
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"res-downloader/core/shared"
+	"syscall"
+	"time"
+)
+
+const cliUsage = `usage: res-downloader <command> [flags]
+
+commands:
+  capture   run the proxy without the window, print captured resources as JSON lines
+  service   run the capture in the background until stopped over the api, what launch at login starts
+  list      print the resources a running capture has seen, as JSON
+  download  queue resources of a running capture by id or by filter
+  run       run the pipeline of a YAML job file, e.g. record a live stream, remux it and upload it
+
+run "res-downloader <command> -h" for the flags of a command.
+encrypted WeChat Channels videos still need the window, their key is derived in the frontend.
+`
+
+var cliCommands = map[string]bool{"capture": true, "service": true, "list": true, "download": true, "run": true, "help": true}
+
+// IsCliCommand tells main whether to run headless, every other argument starts the window
+func IsCliCommand(arg string) bool {
+	return cliCommands[arg]
+}
+
+// RunCli runs one headless command and returns the process exit code
+func RunCli(assets embed.FS, wjs string, args []string) int {
+	var err error
+	switch args[0] {
+	case "capture":
+		err = cliCapture(assets, wjs, args[1:])
+	case "service":
+		err = cliService(assets, wjs, args[1:])
+	case "list":
+		err = cliList(args[1:])
+	case "download":
+		err = cliDownload(args[1:])
+	case "run":
+		err = cliRun(assets, wjs, args[1:])
+	default:
+		fmt.Print(cliUsage)
+		return 0
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+func bindFilter(fs *flag.FlagSet, f *resourceFilter) {
+	fs.StringVar(&f.Classify, "type", "", "only resources of this type, e.g. video, audio, m3u8")
+	fs.StringVar(&f.Domain, "domain", "", "only resources whose domain contains this")
+	fs.StringVar(&f.Match, "match", "", "only resources whose url or description matches this regular expression")
+}
+
+func cliCapture(assets embed.FS, wjs string, args []string) error {
+	fs := flag.NewFlagSet("capture", flag.ContinueOnError)
+	systemProxy := fs.Bool("system-proxy", false, "point the system proxy at the capture while it runs")
+	save := fs.String("save", "", "download directory for this run, the saved setting is left alone")
+	auto := fs.Bool("download", false, "queue every captured resource that matches the filters")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 runs until interrupted")
+	var filter resourceFilter
+	bindFilter(fs, &filter)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := filter.compile(); err != nil {
+		return err
+	}
+
+	// stdout carries only resources, the status lines of the services go to stderr
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	app := GetApp(assets, wjs)
+	if *save != "" {
+		globalConfig.SaveDirectory = *save
+	}
+	events := httpServerOnce.subscribe()
+	defer httpServerOnce.unsubscribe(events)
+	if err := app.startServices(); err != nil {
+		return err
+	}
+	defer app.OnExit()
+	if *systemProxy {
+		if !app.isInstall() {
+			fmt.Fprintln(os.Stderr, "the certificate is not installed yet, https resources will not be captured")
+		}
+		if err := app.OpenSystemProxy(); err != nil {
+			return err
+		}
+	}
+	resourceOnce.requeueOnStartup()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	var timeout <-chan time.Time
+	if *duration > 0 {
+		timeout = time.After(*duration)
+	}
+
+	encoder := json.NewEncoder(out)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-timeout:
+			return nil
+		case event := <-events:
+			var message struct {
+				Type string           `json:"type"`
+				Data shared.MediaInfo `json:"data"`
+			}
+			if json.Unmarshal([]byte(event), &message) != nil || message.Type != "newResources" {
+				continue
+			}
+			if !filter.matches(message.Data) {
+				continue
+			}
+			_ = encoder.Encode(message.Data)
+			if *auto {
+				queueOnce.push(message.Data, "", 0)
+			}
+		}
+	}
+}
+
+func cliList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8899", "address of the running capture")
+	var filter resourceFilter
+	bindFilter(fs, &filter)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := filter.compile(); err != nil {
+		return err
+	}
+	list, err := cliResources(*addr)
+	if err != nil {
+		return err
+	}
+	matched := make([]shared.MediaInfo, 0, len(list))
+	for _, res := range list {
+		if filter.matches(res) {
+			matched = append(matched, res)
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(matched)
+}
+
+func cliDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8899", "address of the running capture")
+	priority := fs.Int("priority", 0, "queue priority, higher runs first")
+	var filter resourceFilter
+	bindFilter(fs, &filter)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := filter.compile(); err != nil {
+		return err
+	}
+	ids := map[string]bool{}
+	for _, id := range fs.Args() {
+		ids[id] = true
+	}
+	if len(ids) == 0 && filter.empty() {
+		return errors.New("give resource ids or a filter")
+	}
+
+	list, err := cliResources(*addr)
+	if err != nil {
+		return err
+	}
+	queued := 0
+	for _, res := range list {
+		if len(ids) > 0 && !ids[res.Id] {
+			continue
+		}
+		if !filter.matches(res) {
+			continue
+		}
+		err := cliCall(*addr, "/api/download", struct {
+			shared.MediaInfo
+			Priority int `json:"priority"`
+		}{res, *priority}, nil)
+		if err != nil {
+			return fmt.Errorf("queue %s: %w", res.Id, err)
+		}
+		fmt.Println(res.Id)
+		queued++
+	}
+	if queued == 0 {
+		return errors.New("no resource matched")
+	}
+	return nil
+}
+
+func cliRun(assets embed.FS, wjs string, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: res-downloader run <job.yaml>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	job, err := loadJob(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	os.Stdout = os.Stderr
+	app := GetApp(assets, wjs)
+	defer app.OnExit()
+	runner := &jobRunner{job: job}
+
+	// the first interrupt ends the recording and lets the remaining steps run, the second quits
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	result := make(chan error, 1)
+	go func() {
+		result <- runner.run()
+	}()
+	for {
+		select {
+		case err := <-result:
+			return err
+		case <-stop:
+			if !runner.stop() {
+				return errors.New("interrupted")
+			}
+			runner.log("stopping the recording")
+		}
+	}
+}
+
+func cliResources(addr string) ([]shared.MediaInfo, error) {
+	var data struct {
+		List []shared.MediaInfo `json:"list"`
+	}
+	if err := cliCall(addr, "/api/resources", struct{}{}, &data); err != nil {
+		return nil, err
+	}
+	return data.List, nil
+}
+
+// cliCall posts to the api of a running instance, which only answers on its own 127.0.0.1 host
+func cliCall(addr, path string, body interface{}, result interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: nil}, Timeout: 30 * time.Second}
+	resp, err := client.Post("http://"+addr+path, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("is a capture running at %s? %w", addr, err)
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	if response.Code == 0 {
+		return errors.New(response.Message)
+	}
+	if result != nil && len(response.Data) > 0 {
+		return json.Unmarshal(response.Data, result)
+	}
+	return nil
+}
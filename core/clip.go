@@ -0,0 +1,206 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// clipMergeGap joins selected cues closer than this into one clip, in seconds
+	clipMergeGap = 1.0
+	clipMaxPad   = 10.0
+)
+
+// transcriptTime matches "00:01:02,345 --> 00:01:04,000" of SRT and "01:02.345 --> 01:04.000" of VTT
+var transcriptTime = regexp.MustCompile(`((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+
+// transcriptTag is the inline markup of a cue, <v Speaker>, <i>, <font ...>
+var transcriptTag = regexp.MustCompile(`<[^>]*>`)
+
+// Cue is one utterance of a transcript
+type Cue struct {
+	Index int     `json:"Index"` // 1 based, in file order
+	Start float64 `json:"Start"` // seconds
+	End   float64 `json:"End"`
+	Text  string  `json:"Text"`
+}
+
+// ClipRequest selects cues of a transcript to cut out of a downloaded video
+type ClipRequest struct {
+	Video      string  `json:"video"`
+	Transcript string  `json:"transcript"`
+	Cues       []int   `json:"cues"`    // Cue.Index of the selected utterances
+	Merge      bool    `json:"merge"`   // neighbouring cues become one clip
+	Padding    float64 `json:"padding"` // seconds added before and after each clip
+}
+
+// transcriptFor is the .srt or .vtt saved next to a video, empty when there is none
+func transcriptFor(video string) string {
+	base := strings.TrimSuffix(video, filepath.Ext(video))
+	for _, ext := range []string{".srt", ".vtt"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+func loadTranscript(fileName string) ([]Cue, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	cues := parseTranscript(string(data))
+	if len(cues) == 0 {
+		return nil, errors.New("no timed lines found, expected an srt or vtt transcript")
+	}
+	return cues, nil
+}
+
+// parseTranscript reads the cues of an SRT or WebVTT file, cue numbers and styling lines are skipped
+func parseTranscript(text string) []Cue {
+	var cues []Cue
+	var current *Cue
+	flush := func() {
+		if current != nil && current.End > current.Start {
+			current.Text = strings.TrimSpace(current.Text)
+			current.Index = len(cues) + 1
+			cues = append(cues, *current)
+		}
+		current = nil
+	}
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(text, "\ufeff")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if match := transcriptTime.FindStringSubmatch(line); match != nil {
+			flush()
+			current = &Cue{Start: transcriptSeconds(match[1]), End: transcriptSeconds(match[2])}
+			continue
+		}
+		if line == "" {
+			flush()
+			continue
+		}
+		if current != nil {
+			if current.Text != "" {
+				current.Text += " "
+			}
+			current.Text += transcriptTag.ReplaceAllString(line, "")
+		}
+	}
+	flush()
+	return cues
+}
+
+func transcriptSeconds(value string) float64 {
+	parts := strings.Split(strings.ReplaceAll(value, ",", "."), ":")
+	seconds := 0.0
+	for _, part := range parts {
+		n, _ := strconv.ParseFloat(part, 64)
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+// clipRanges turns the selected cues into time ranges, padded and merged as asked
+func clipRanges(cues []Cue, req ClipRequest) [][2]float64 {
+	selected := map[int]bool{}
+	for _, index := range req.Cues {
+		selected[index] = true
+	}
+	var picked []Cue
+	for _, cue := range cues {
+		if selected[cue.Index] {
+			picked = append(picked, cue)
+		}
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Start < picked[j].Start })
+
+	padding := min(max(req.Padding, 0), clipMaxPad)
+	var ranges [][2]float64
+	for _, cue := range picked {
+		start, end := max(cue.Start-padding, 0), cue.End+padding
+		if last := len(ranges) - 1; req.Merge && last >= 0 && start-ranges[last][1] <= clipMergeGap {
+			ranges[last][1] = max(ranges[last][1], end)
+			continue
+		}
+		ranges = append(ranges, [2]float64{start, end})
+	}
+	return ranges
+}
+
+// exportClips cuts the selected utterances out of the video without re-encoding. The cut starts
+// at the keyframe at or before each range, so a clip may begin slightly early.
+func exportClips(req ClipRequest) ([]string, error) {
+	if req.Video == "" || !shared.FileExist(req.Video) {
+		return nil, errors.New("video not found: " + req.Video)
+	}
+	if req.Transcript == "" {
+		req.Transcript = transcriptFor(req.Video)
+	}
+	if req.Transcript == "" {
+		return nil, errors.New("no transcript next to the video, choose one")
+	}
+	cues, err := loadTranscript(req.Transcript)
+	if err != nil {
+		return nil, err
+	}
+	ranges := clipRanges(cues, req)
+	if len(ranges) == 0 {
+		return nil, messageError(MsgNoResources)
+	}
+	ffmpeg, err := findFfmpeg("")
+	if err != nil {
+		return nil, err
+	}
+
+	ext := filepath.Ext(req.Video)
+	base := strings.TrimSuffix(req.Video, ext)
+	var files []string
+	for i, r := range ranges {
+		target := fmt.Sprintf("%s_clip%d_%s%s", base, i+1, clipStamp(r[0]), ext)
+		if _, err := os.Stat(target); err == nil && duplicatePolicy() != DuplicateOverwrite {
+			target = freeFileName(target)
+		}
+		if err := cutClip(ffmpeg, req.Video, r, target); err != nil {
+			return files, err
+		}
+		files = append(files, target)
+	}
+	return files, nil
+}
+
+// cutClip copies the range of video into the work directory and moves it to target once cut
+func cutClip(ffmpeg, video string, r [2]float64, target string) error {
+	work, err := workFile(filepath.Ext(target))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(work)
+	// -ss before -i seeks by keyframe, which is what a stream copy can cut at
+	output, err := backgroundCommand(ffmpeg, "-y", "-hide_banner", "-loglevel", "error",
+		"-ss", strconv.FormatFloat(r[0], 'f', 3, 64), "-i", video,
+		"-t", strconv.FormatFloat(r[1]-r[0], 'f', 3, 64),
+		"-c", "copy", "-avoid_negative_ts", "make_zero", work).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return moveWorkFile(work, target)
+}
+
+// clipStamp names a clip by where it starts, e.g. 01m23s
+func clipStamp(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%dh%02dm%02ds", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%02dm%02ds", s/60, s%60)
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// commentLimit is how many comments are kept with a resource, plugins send the most liked first
+	commentLimit = 20
+	// commentWait is how long comments wait for their media, a page opened without playing it never sends it
+	commentWait = 10 * time.Minute
+)
+
+// pendingComments are comments that came before their media
+type pendingComments struct {
+	comments shared.MediaComments
+	added    time.Time
+}
+
+// attachComments keeps the description and comments with their resource. They often come
+// before the media is captured, then capture picks them up.
+func (r *Resource) attachComments(comments shared.MediaComments) {
+	if len(comments.Comments) > commentLimit {
+		comments.Comments = comments.Comments[:commentLimit]
+	}
+	if res, ok := r.store.annotate(comments.UrlSign, commentValues(comments)); ok {
+		httpServerOnce.send("resourceComments", map[string]interface{}{
+			"Id":          res.Id,
+			"Description": comments.Description,
+			"Comments":    comments.Comments,
+		})
+		return
+	}
+	r.comments.Range(func(key, value interface{}) bool {
+		if time.Since(value.(pendingComments).added) > commentWait {
+			r.comments.Delete(key)
+		}
+		return true
+	})
+	r.comments.Store(comments.UrlSign, pendingComments{comments: comments, added: time.Now()})
+}
+
+// takeComments adds the comments that came before res to it
+func (r *Resource) takeComments(res *shared.MediaInfo) {
+	value, ok := r.comments.LoadAndDelete(res.UrlSign)
+	if !ok {
+		return
+	}
+	if res.OtherData == nil {
+		res.OtherData = map[string]string{}
+	}
+	for key, v := range commentValues(value.(pendingComments).comments) {
+		res.OtherData[key] = v
+	}
+}
+
+// commentValues are the OtherData keys comments are kept under
+func commentValues(comments shared.MediaComments) map[string]string {
+	values := map[string]string{}
+	if description := strings.TrimSpace(comments.Description); description != "" {
+		values["description"] = description
+	}
+	if len(comments.Comments) > 0 {
+		data, _ := json.Marshal(comments.Comments)
+		values["comments"] = string(data)
+	}
+	return values
+}
+
+func commentsOf(res shared.MediaInfo) []shared.Comment {
+	var list []shared.Comment
+	if raw := res.OtherData["comments"]; raw != "" {
+		_ = json.Unmarshal([]byte(raw), &list)
+	}
+	return list
+}
+
+// writeCommentsText writes the description and the comments of res as plain text, nothing when it has neither
+func writeCommentsText(fileName string, res shared.MediaInfo) (bool, error) {
+	description := res.OtherData["description"]
+	if description == "" {
+		description = res.Description
+	}
+	comments := commentsOf(res)
+	if description == "" && len(comments) == 0 {
+		return false, nil
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(description))
+	b.WriteString("\n")
+	if len(comments) > 0 {
+		b.WriteString("\n----\n\n")
+	}
+	for _, comment := range comments {
+		line := comment.Author + ": " + strings.TrimSpace(comment.Content)
+		var extra []string
+		if comment.Likes > 0 {
+			extra = append(extra, "♥ "+strconv.FormatInt(comment.Likes, 10))
+		}
+		if comment.Time > 0 {
+			extra = append(extra, time.Unix(comment.Time, 0).Format("2006-01-02 15:04"))
+		}
+		if len(extra) > 0 {
+			line += "  (" + strings.Join(extra, ", ") + ")"
+		}
+		b.WriteString(line + "\n")
+	}
+	return true, os.WriteFile(fileName, []byte(b.String()), 0644)
+}
@@ -2,12 +2,15 @@ package core
 
 import (
 	"encoding/json"
+	gonanoid "github.com/matoous/go-nanoid/v2"
 	"os"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 type MimeInfo struct {
@@ -17,31 +20,141 @@ type MimeInfo struct {
 
 // Config struct
 type Config struct {
-	storage       *Storage
-	Theme         string              `json:"Theme"`
-	Locale        string              `json:"Locale"`
-	Host          string              `json:"Host"`
-	Port          string              `json:"Port"`
-	Quality       int                 `json:"Quality"`
-	SaveDirectory string              `json:"SaveDirectory"`
-	FilenameLen   int                 `json:"FilenameLen"`
-	FilenameTime  bool                `json:"FilenameTime"`
-	UpstreamProxy string              `json:"UpstreamProxy"`
-	OpenProxy     bool                `json:"OpenProxy"`
-	DownloadProxy bool                `json:"DownloadProxy"`
-	AutoProxy     bool                `json:"AutoProxy"`
-	WxAction      bool                `json:"WxAction"`
-	TaskNumber    int                 `json:"TaskNumber"`
-	DownNumber    int                 `json:"DownNumber"`
-	UserAgent     string              `json:"UserAgent"`
-	UseHeaders    string              `json:"UseHeaders"`
-	InsertTail    bool                `json:"InsertTail"`
-	MimeMap       map[string]MimeInfo `json:"MimeMap"`
-	Rule          string              `json:"Rule"`
+	storage            *Storage
+	Theme              string              `json:"Theme"`
+	Locale             string              `json:"Locale"`
+	Host               string              `json:"Host"`
+	Port               string              `json:"Port"`
+	Quality            int                 `json:"Quality"`
+	SaveDirectory      string              `json:"SaveDirectory"`
+	FilenameLen        int                 `json:"FilenameLen"`
+	FilenameTime       bool                `json:"FilenameTime"`
+	UpstreamProxy      string              `json:"UpstreamProxy"`
+	OpenProxy          bool                `json:"OpenProxy"`
+	DownloadProxy      bool                `json:"DownloadProxy"`
+	AutoProxy          bool                `json:"AutoProxy"`
+	WxAction           bool                `json:"WxAction"`
+	TaskNumber         int                 `json:"TaskNumber"`
+	DownNumber         int                 `json:"DownNumber"`
+	UserAgent          string              `json:"UserAgent"`
+	UseHeaders         string              `json:"UseHeaders"`
+	InsertTail         bool                `json:"InsertTail"`
+	MimeMap            map[string]MimeInfo `json:"MimeMap"`
+	Rule               string              `json:"Rule"`
+	QuicDowngrade      bool                `json:"QuicDowngrade"`
+	QuicRule           string              `json:"QuicRule"`
+	Listeners          string              `json:"Listeners"`
+	Script             string              `json:"Script"`
+	SegmentMerge       bool                `json:"SegmentMerge"`
+	AdBlock            bool                `json:"AdBlock"`
+	AdBlockRule        string              `json:"AdBlockRule"`
+	AdBlockUrl         string              `json:"AdBlockUrl"`
+	CacheMemoryMB      int                 `json:"CacheMemoryMB"`
+	CacheTotalMB       int                 `json:"CacheTotalMB"`
+	RangeStitch        bool                `json:"RangeStitch"`
+	DnsOverHttps       string              `json:"DnsOverHttps"`
+	DanmakuCapture     bool                `json:"DanmakuCapture"`
+	ProcessProxy       bool                `json:"ProcessProxy"`
+	ProcessNames       string              `json:"ProcessNames"`
+	TlsFingerprint     string              `json:"TlsFingerprint"`
+	PoolMaxIdle        int                 `json:"PoolMaxIdle"`
+	PoolMaxPerHost     int                 `json:"PoolMaxPerHost"`
+	PoolIdleTime       int                 `json:"PoolIdleTime"`
+	ResponsePreview    bool                `json:"ResponsePreview"`
+	PlatformDomains    string              `json:"PlatformDomains"`
+	SpeedLimit         int                 `json:"SpeedLimit"`
+	RetryCount         int                 `json:"RetryCount"`
+	FilenameTemplate   string              `json:"FilenameTemplate"`
+	VerifyDownload     bool                `json:"VerifyDownload"`
+	VerifyRedownload   bool                `json:"VerifyRedownload"`
+	MinFreeSpace       int                 `json:"MinFreeSpace"`
+	Aria2Enable        bool                `json:"Aria2Enable"`
+	Aria2Rpc           string              `json:"Aria2Rpc"`
+	Aria2Secret        string              `json:"Aria2Secret"`
+	ProxyList          string              `json:"ProxyList"`
+	CategoryDirs       bool                `json:"CategoryDirs"`
+	CategoryRules      string              `json:"CategoryRules"`
+	PostCommand        string              `json:"PostCommand"`
+	PostCommandTimeout int                 `json:"PostCommandTimeout"`
+	WebdavEnable       bool                `json:"WebdavEnable"`
+	WebdavUrl          string              `json:"WebdavUrl"`
+	WebdavUser         string              `json:"WebdavUser"`
+	WebdavPassword     string              `json:"WebdavPassword"`
+	DeleteAfterUpload  bool                `json:"DeleteAfterUpload"`
+	S3Enable           bool                `json:"S3Enable"`
+	S3Endpoint         string              `json:"S3Endpoint"`
+	S3Region           string              `json:"S3Region"`
+	S3Bucket           string              `json:"S3Bucket"`
+	S3AccessKey        string              `json:"S3AccessKey"`
+	S3SecretKey        string              `json:"S3SecretKey"`
+	S3Prefix           string              `json:"S3Prefix"`
+	S3PathStyle        bool                `json:"S3PathStyle"`
+	DuplicatePolicy    string              `json:"DuplicatePolicy"`
+	ScheduleEnable     bool                `json:"ScheduleEnable"`
+	DownloadSchedule   string              `json:"DownloadSchedule"`
+	AutoRequeue        bool                `json:"AutoRequeue"`
+	RequeueLimit       int                 `json:"RequeueLimit"`
+	AdaptiveChunks     bool                `json:"AdaptiveChunks"`
+	Preallocate        bool                `json:"Preallocate"`
+	CookieJar          bool                `json:"CookieJar"`
+	DownloadIdentity   string              `json:"DownloadIdentity"`
+	RecordMaxMinutes   int                 `json:"RecordMaxMinutes"`
+	RecordMaxSizeMB    int                 `json:"RecordMaxSizeMB"`
+	RecordConcat       bool                `json:"RecordConcat"`
+	BandwidthProfiles  string              `json:"BandwidthProfiles"`
+	TorrentClient      string              `json:"TorrentClient"`
+	TorrentUrl         string              `json:"TorrentUrl"`
+	TorrentUser        string              `json:"TorrentUser"`
+	TorrentPassword    string              `json:"TorrentPassword"`
+	VariantPreference  string              `json:"VariantPreference"`
+	CacheMedia         bool                `json:"CacheMedia"`
+	ApiEnable          bool                `json:"ApiEnable"`
+	ApiListen          string              `json:"ApiListen"`
+	ApiToken           string              `json:"ApiToken"`
+	GrpcEnable         bool                `json:"GrpcEnable"`
+	GrpcListen         string              `json:"GrpcListen"` // the gRPC control api, it takes ApiToken too
+	ExternalPlugins    bool                `json:"ExternalPlugins"`
+	RulePackUrls       string              `json:"RulePackUrls"`
+	RulePackKey        string              `json:"RulePackKey"`
+	RulePackHours      int                 `json:"RulePackHours"`
+	Webhooks           []Webhook           `json:"Webhooks"`
+	TelegramEnable     bool                `json:"TelegramEnable"`
+	TelegramToken      string              `json:"TelegramToken"`
+	TelegramChatId     string              `json:"TelegramChatId"` // the only chat the bot notifies and obeys
+	TelegramProxy      string              `json:"TelegramProxy"`  // proxy mode as for downloads
+	AutomationRules    []AutomationRule    `json:"AutomationRules"`
+	MetricsEnable      bool                `json:"MetricsEnable"`
+	MetricsListen      string              `json:"MetricsListen"`
+	LogJson            bool                `json:"LogJson"`
+	LogLevel           string              `json:"LogLevel"`
+	LogLevels          string              `json:"LogLevels"`  // "module=level" per line, e.g. proxy=warn
+	LogMaxSize         int                 `json:"LogMaxSize"` // MB before app.log is rotated
+	LogMaxFiles        int                 `json:"LogMaxFiles"`
+	Profiles           []ConfigProfile     `json:"Profiles"`
+	ActiveProfile      string              `json:"ActiveProfile"`
+	UpdateChannel      string              `json:"UpdateChannel"`
+	UpdateRepo         string              `json:"UpdateRepo"`        // owner/name on GitHub
+	CrashDumps         bool                `json:"CrashDumps"`        // write a panic's stack and recent log lines to crashes/ before exiting
+	RetentionDays      int                 `json:"RetentionDays"`     // captured entries older than this are removed, 0 keeps them
+	PartRetentionDays  int                 `json:"PartRetentionDays"` // abandoned .part files untouched this long are removed, 0 keeps them
+	SaveCover          bool                `json:"SaveCover"`         // save the cover of a downloaded video next to it
+	SidecarFormat      string              `json:"SidecarFormat"`     // metadata file next to a downloaded video: json, nfo or empty for none
+	SaveComments       bool                `json:"SaveComments"`      // write the description and captured comments to a .txt next to it
+	ContentDuplicate   string              `json:"ContentDuplicate"`  // keep, delete or hardlink a download seen before, empty or off doesn't look
+	LiveRooms          string              `json:"LiveRooms"`         // rooms to record when they go live, one url per line optionally followed by a name
+	LivePollSeconds    int                 `json:"LivePollSeconds"`   // how often each watched room is checked
+	AudioFingerprint   bool                `json:"AudioFingerprint"`  // also compare the sound of videos and audio to find re-uploads, needs ffmpeg
+	OcrCommand         string              `json:"OcrCommand"`        // prints the text of the image in RD_IMAGE, e.g. tesseract "$RD_IMAGE" - -l eng+chi_sim, empty turns OCR off
+	OcrFrameSeconds    int                 `json:"OcrFrameSeconds"`   // a video is read one frame every this many seconds
+	DomainLimits       string              `json:"DomainLimits"`      // per platform pacing, one "<domain> <requests a minute> [downloads at once]" per line, * for the others
+	LaunchAtLogin      bool                `json:"LaunchAtLogin"`     // run "res-downloader service" without the window when the user logs in
+	WorkDirectory      string              `json:"WorkDirectory"`     // where ffmpeg and OCR write intermediate files, empty is the work folder in the user directory
 }
 
 var (
 	mimeMux sync.RWMutex
+	// setMux serializes setConfig, the window, the api and the file watcher all call it
+	setMux sync.Mutex
 )
 
 func initConfig() *Config {
@@ -50,26 +163,134 @@ func initConfig() *Config {
 	}
 
 	defaultConfig := &Config{
-		Theme:         "lightTheme",
-		Locale:        "zh",
-		Host:          "127.0.0.1",
-		Port:          "8899",
-		Quality:       0,
-		SaveDirectory: getDefaultDownloadDir(),
-		FilenameLen:   0,
-		FilenameTime:  true,
-		UpstreamProxy: "",
-		OpenProxy:     false,
-		DownloadProxy: false,
-		AutoProxy:     false,
-		WxAction:      true,
-		TaskNumber:    runtime.NumCPU() * 2,
-		DownNumber:    3,
-		UserAgent:     "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
-		UseHeaders:    "default",
-		InsertTail:    true,
-		MimeMap:       getDefaultMimeMap(),
-		Rule:          "*",
+		Theme:              "lightTheme",
+		Locale:             "zh",
+		Host:               "127.0.0.1",
+		Port:               "8899",
+		Quality:            0,
+		SaveDirectory:      getDefaultDownloadDir(),
+		FilenameLen:        0,
+		FilenameTime:       true,
+		UpstreamProxy:      "",
+		OpenProxy:          false,
+		DownloadProxy:      false,
+		AutoProxy:          false,
+		WxAction:           true,
+		TaskNumber:         runtime.NumCPU() * 2,
+		DownNumber:         3,
+		UserAgent:          "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+		UseHeaders:         "default",
+		InsertTail:         true,
+		MimeMap:            getDefaultMimeMap(),
+		Rule:               "*",
+		QuicDowngrade:      false,
+		QuicRule:           "*",
+		Listeners:          "",
+		Script:             "",
+		SegmentMerge:       false,
+		AdBlock:            false,
+		AdBlockRule:        "",
+		AdBlockUrl:         "",
+		CacheMemoryMB:      128,
+		CacheTotalMB:       1024,
+		RangeStitch:        false,
+		DnsOverHttps:       "",
+		DanmakuCapture:     false,
+		ProcessProxy:       false,
+		ProcessNames:       "WeChat.exe\nWeChatAppEx.exe",
+		TlsFingerprint:     "",
+		PoolMaxIdle:        200,
+		PoolMaxPerHost:     16,
+		PoolIdleTime:       90,
+		ResponsePreview:    true,
+		PlatformDomains:    "",
+		SpeedLimit:         0,
+		RetryCount:         3,
+		FilenameTemplate:   "",
+		VerifyDownload:     true,
+		VerifyRedownload:   true,
+		MinFreeSpace:       500,
+		Aria2Enable:        false,
+		Aria2Rpc:           "http://127.0.0.1:6800/jsonrpc",
+		Aria2Secret:        "",
+		ProxyList:          "",
+		CategoryDirs:       false,
+		CategoryRules:      "",
+		PostCommand:        "",
+		PostCommandTimeout: 300,
+		WebdavEnable:       false,
+		WebdavUrl:          "",
+		WebdavUser:         "",
+		WebdavPassword:     "",
+		DeleteAfterUpload:  false,
+		S3Enable:           false,
+		S3Endpoint:         "",
+		S3Region:           "",
+		S3Bucket:           "",
+		S3AccessKey:        "",
+		S3SecretKey:        "",
+		S3Prefix:           "{platform}/{date}",
+		S3PathStyle:        false,
+		DuplicatePolicy:    "rename",
+		ScheduleEnable:     false,
+		DownloadSchedule:   "",
+		AutoRequeue:        true,
+		RequeueLimit:       3,
+		AdaptiveChunks:     true,
+		Preallocate:        true,
+		CookieJar:          false,
+		DownloadIdentity:   "",
+		RecordMaxMinutes:   0,
+		RecordMaxSizeMB:    0,
+		RecordConcat:       false,
+		BandwidthProfiles:  "",
+		TorrentClient:      "",
+		TorrentUrl:         "",
+		TorrentUser:        "",
+		TorrentPassword:    "",
+		VariantPreference:  "highest",
+		CacheMedia:         false,
+		ApiEnable:          false,
+		ApiListen:          "127.0.0.1:8900",
+		ApiToken:           "",
+		GrpcEnable:         false,
+		GrpcListen:         "127.0.0.1:8901",
+		ExternalPlugins:    false,
+		RulePackUrls:       "",
+		RulePackKey:        "",
+		RulePackHours:      24,
+		Webhooks:           []Webhook{},
+		TelegramEnable:     false,
+		TelegramToken:      "",
+		TelegramChatId:     "",
+		TelegramProxy:      "",
+		AutomationRules:    []AutomationRule{},
+		MetricsEnable:      false,
+		MetricsListen:      "127.0.0.1:9108",
+		LogJson:            false,
+		LogLevel:           "debug",
+		LogLevels:          "",
+		LogMaxSize:         10,
+		LogMaxFiles:        5,
+		Profiles:           []ConfigProfile{},
+		ActiveProfile:      "",
+		UpdateChannel:      UpdateChannelStable,
+		UpdateRepo:         "putyy/res-downloader",
+		CrashDumps:         false,
+		RetentionDays:      0,
+		PartRetentionDays:  0,
+		SaveCover:          false,
+		SidecarFormat:      "",
+		SaveComments:       false,
+		ContentDuplicate:   "",
+		LiveRooms:          "",
+		LivePollSeconds:    120,
+		AudioFingerprint:   false,
+		OcrCommand:         "",
+		OcrFrameSeconds:    10,
+		DomainLimits:       "",
+		LaunchAtLogin:      false,
+		WorkDirectory:      "",
 	}
 
 	rawDefaults, err := json.Marshal(defaultConfig)
@@ -165,6 +386,7 @@ func getDefaultMimeMap() map[string]MimeInfo {
 		"application/x-mpeg":            {Type: "m3u8", Suffix: ".m3u8"},
 		"audio/x-mpegurl":               {Type: "m3u8", Suffix: ".m3u8"},
 		"application/pdf":               {Type: "pdf", Suffix: ".pdf"},
+		"application/x-bittorrent":      {Type: "torrent", Suffix: ".torrent"},
 		"application/vnd.ms-powerpoint": {Type: "ppt", Suffix: ".ppt"},
 		"application/vnd.openxmlformats-officedocument.presentationml.presentation": {Type: "ppt", Suffix: ".pptx"},
 		"application/vnd.ms-excel": {Type: "xls", Suffix: ".xls"},
@@ -207,9 +429,36 @@ func getDefaultDownloadDir() string {
 }
 
 func (c *Config) setConfig(config Config) {
+	setMux.Lock()
+	defer setMux.Unlock()
 	oldProxy := c.UpstreamProxy
 	openProxy := c.OpenProxy
+	oldDoh := c.DnsOverHttps
+	oldFingerprint := c.TlsFingerprint
+	oldPlatformDomains := c.PlatformDomains
+	oldSpeedLimit := c.SpeedLimit
+	oldScheduleEnable := c.ScheduleEnable
+	oldSchedule := c.DownloadSchedule
+	oldLiveRooms := c.LiveRooms
+	oldProfiles := c.BandwidthProfiles
+	oldDownNumber := c.DownNumber
+	oldDomainLimits := c.DomainLimits
+	oldLaunchAtLogin := c.LaunchAtLogin
+	oldPool := [3]int{c.PoolMaxIdle, c.PoolMaxPerHost, c.PoolIdleTime}
 	oldRule := c.Rule
+	oldQuicRule := c.QuicRule
+	oldScript := c.Script
+	oldAdBlockRule := c.AdBlockRule
+	oldApiEnable := c.ApiEnable
+	oldApiListen := c.ApiListen
+	oldGrpcEnable := c.GrpcEnable
+	oldGrpcListen := c.GrpcListen
+	oldMetricsEnable := c.MetricsEnable
+	oldMetricsListen := c.MetricsListen
+	oldRulePacks := c.RulePackUrls + "\n" + c.RulePackKey
+	oldTelegramEnable := c.TelegramEnable
+	oldTelegram := [2]string{c.TelegramToken, c.TelegramProxy}
+	oldProcessProxy := c.ProcessProxy
 	c.Host = config.Host
 	c.Port = config.Port
 	c.Theme = config.Theme
@@ -229,27 +478,323 @@ func (c *Config) setConfig(config Config) {
 	c.UseHeaders = config.UseHeaders
 	c.InsertTail = config.InsertTail
 	c.Rule = config.Rule
-	if oldProxy != c.UpstreamProxy || openProxy != c.OpenProxy {
+	c.QuicDowngrade = config.QuicDowngrade
+	c.QuicRule = config.QuicRule
+	c.Listeners = config.Listeners
+	c.Script = config.Script
+	c.SegmentMerge = config.SegmentMerge
+	c.AdBlock = config.AdBlock
+	c.AdBlockRule = config.AdBlockRule
+	c.AdBlockUrl = config.AdBlockUrl
+	c.CacheMemoryMB = config.CacheMemoryMB
+	c.CacheTotalMB = config.CacheTotalMB
+	c.RangeStitch = config.RangeStitch
+	c.DnsOverHttps = config.DnsOverHttps
+	c.DanmakuCapture = config.DanmakuCapture
+	c.ProcessProxy = config.ProcessProxy
+	c.ProcessNames = config.ProcessNames
+	c.TlsFingerprint = config.TlsFingerprint
+	c.PoolMaxIdle = config.PoolMaxIdle
+	c.PoolMaxPerHost = config.PoolMaxPerHost
+	c.PoolIdleTime = config.PoolIdleTime
+	c.ResponsePreview = config.ResponsePreview
+	c.PlatformDomains = config.PlatformDomains
+	c.SpeedLimit = config.SpeedLimit
+	c.RetryCount = config.RetryCount
+	c.FilenameTemplate = config.FilenameTemplate
+	c.VerifyDownload = config.VerifyDownload
+	c.VerifyRedownload = config.VerifyRedownload
+	c.MinFreeSpace = config.MinFreeSpace
+	c.Aria2Enable = config.Aria2Enable
+	c.Aria2Rpc = config.Aria2Rpc
+	c.Aria2Secret = config.Aria2Secret
+	c.ProxyList = config.ProxyList
+	c.CategoryDirs = config.CategoryDirs
+	c.CategoryRules = config.CategoryRules
+	c.PostCommand = config.PostCommand
+	c.PostCommandTimeout = config.PostCommandTimeout
+	c.WebdavEnable = config.WebdavEnable
+	c.WebdavUrl = config.WebdavUrl
+	c.WebdavUser = config.WebdavUser
+	c.WebdavPassword = config.WebdavPassword
+	c.DeleteAfterUpload = config.DeleteAfterUpload
+	c.S3Enable = config.S3Enable
+	c.S3Endpoint = config.S3Endpoint
+	c.S3Region = config.S3Region
+	c.S3Bucket = config.S3Bucket
+	c.S3AccessKey = config.S3AccessKey
+	c.S3SecretKey = config.S3SecretKey
+	c.S3Prefix = config.S3Prefix
+	c.S3PathStyle = config.S3PathStyle
+	c.DuplicatePolicy = config.DuplicatePolicy
+	c.ScheduleEnable = config.ScheduleEnable
+	c.DownloadSchedule = config.DownloadSchedule
+	c.AutoRequeue = config.AutoRequeue
+	c.RequeueLimit = config.RequeueLimit
+	c.AdaptiveChunks = config.AdaptiveChunks
+	c.Preallocate = config.Preallocate
+	c.CookieJar = config.CookieJar
+	c.DownloadIdentity = config.DownloadIdentity
+	c.RecordMaxMinutes = config.RecordMaxMinutes
+	c.RecordMaxSizeMB = config.RecordMaxSizeMB
+	c.RecordConcat = config.RecordConcat
+	c.BandwidthProfiles = config.BandwidthProfiles
+	c.TorrentClient = config.TorrentClient
+	c.TorrentUrl = config.TorrentUrl
+	c.TorrentUser = config.TorrentUser
+	c.TorrentPassword = config.TorrentPassword
+	c.VariantPreference = config.VariantPreference
+	c.CacheMedia = config.CacheMedia
+	c.ApiEnable = config.ApiEnable
+	c.ApiListen = config.ApiListen
+	c.ApiToken = config.ApiToken
+	c.GrpcEnable = config.GrpcEnable
+	c.GrpcListen = config.GrpcListen
+	c.ExternalPlugins = config.ExternalPlugins
+	c.RulePackUrls = config.RulePackUrls
+	c.RulePackKey = config.RulePackKey
+	c.RulePackHours = config.RulePackHours
+	c.Webhooks = config.Webhooks
+	c.TelegramEnable = config.TelegramEnable
+	c.TelegramToken = config.TelegramToken
+	c.TelegramChatId = config.TelegramChatId
+	c.TelegramProxy = config.TelegramProxy
+	c.AutomationRules = config.AutomationRules
+	c.MetricsEnable = config.MetricsEnable
+	c.MetricsListen = config.MetricsListen
+	c.LogJson = config.LogJson
+	c.LogLevel = config.LogLevel
+	c.LogLevels = config.LogLevels
+	c.LogMaxSize = config.LogMaxSize
+	c.LogMaxFiles = config.LogMaxFiles
+	c.Profiles = config.Profiles
+	c.ActiveProfile = config.ActiveProfile
+	c.UpdateChannel = config.UpdateChannel
+	c.UpdateRepo = config.UpdateRepo
+	c.CrashDumps = config.CrashDumps
+	c.RetentionDays = config.RetentionDays
+	c.PartRetentionDays = config.PartRetentionDays
+	c.SaveCover = config.SaveCover
+	c.SidecarFormat = config.SidecarFormat
+	c.SaveComments = config.SaveComments
+	c.ContentDuplicate = config.ContentDuplicate
+	c.LiveRooms = config.LiveRooms
+	c.LivePollSeconds = config.LivePollSeconds
+	c.AudioFingerprint = config.AudioFingerprint
+	c.OcrCommand = config.OcrCommand
+	c.OcrFrameSeconds = config.OcrFrameSeconds
+	c.DomainLimits = config.DomainLimits
+	c.LaunchAtLogin = config.LaunchAtLogin
+	c.WorkDirectory = config.WorkDirectory
+	globalLogger.configure()
+	// the api is never open without a token, one is made up the first time it is enabled
+	if (c.ApiEnable || c.GrpcEnable) && c.ApiToken == "" {
+		if token, err := gonanoid.New(32); err == nil {
+			c.ApiToken = token
+		}
+	}
+	if oldProxy != c.UpstreamProxy || openProxy != c.OpenProxy || oldDoh != c.DnsOverHttps || oldFingerprint != c.TlsFingerprint ||
+		oldPool != [3]int{c.PoolMaxIdle, c.PoolMaxPerHost, c.PoolIdleTime} {
+		dohOnce.clear()
 		proxyOnce.setTransport()
 	}
 
+	if oldDomainLimits != c.DomainLimits {
+		politeOnce.setLimits(c.DomainLimits)
+	}
+	// a cap raised or removed may let waiting downloads start
+	if c.DownNumber > oldDownNumber || oldDomainLimits != c.DomainLimits {
+		queueOnce.schedule()
+	}
+
+	if oldSpeedLimit != c.SpeedLimit || oldScheduleEnable != c.ScheduleEnable || oldSchedule != c.DownloadSchedule || oldProfiles != c.BandwidthProfiles {
+		scheduleOnce.apply()
+	}
+
+	if oldLaunchAtLogin != c.LaunchAtLogin {
+		if err := setLaunchAtLogin(c.LaunchAtLogin); err != nil {
+			globalLogger.Esg(err, "set launch at login failed")
+		}
+	}
+
+	if oldLiveRooms != c.LiveRooms {
+		// rooms just added are checked right away
+		go liveWatchOnce.poll(false)
+	}
+
+	if oldApiEnable != c.ApiEnable || oldApiListen != c.ApiListen {
+		// a PATCH over the api itself may move it, don't wait on that request
+		go apiServerOnce.apply()
+	}
+	if oldGrpcEnable != c.GrpcEnable || oldGrpcListen != c.GrpcListen {
+		go grpcServerOnce.apply()
+	}
+
+	if oldRulePacks != c.RulePackUrls+"\n"+c.RulePackKey {
+		go func() {
+			rulePacksOnce.prune()
+			rulePacksOnce.update(nil)
+		}()
+	}
+
+	if oldProcessProxy != c.ProcessProxy {
+		if c.ProcessProxy {
+			if err := processProxyOnce.Start(); err != nil {
+				globalLogger.Esg(err, "start process proxy failed")
+			}
+		} else {
+			processProxyOnce.Stop()
+		}
+	}
+
+	if oldMetricsEnable != c.MetricsEnable || oldMetricsListen != c.MetricsListen {
+		go metricsOnce.apply()
+	}
+
+	if oldTelegramEnable != c.TelegramEnable || oldTelegram != [2]string{c.TelegramToken, c.TelegramProxy} {
+		telegramOnce.apply()
+	}
+
+	if oldPlatformDomains != c.PlatformDomains {
+		loadPluginPatterns(pluginList, withRulePacks("platforms", c.PlatformDomains))
+	}
+
 	if oldRule != c.Rule {
-		err := ruleOnce.Load(c.Rule)
+		err := ruleOnce.Load(withRulePacks("mitm", c.Rule))
 		if err != nil {
 			globalLogger.Esg(err, "set rule failed")
 		}
 	}
 
+	if oldQuicRule != c.QuicRule {
+		err := quicRuleOnce.Load(withRulePacks("quic", c.QuicRule))
+		if err != nil {
+			globalLogger.Esg(err, "set quic rule failed")
+		}
+	}
+
+	if oldScript != c.Script {
+		err := scriptOnce.Load(c.Script)
+		if err != nil {
+			globalLogger.Esg(err, "set script failed")
+		}
+	}
+
+	if oldAdBlockRule != c.AdBlockRule {
+		adBlockOnce.Reload()
+	}
+
 	mimeMux.Lock()
 	c.MimeMap = config.MimeMap
 	mimeMux.Unlock()
 
-	jsonData, err := json.Marshal(c)
+	jsonData, err := vaultOnce.strip(c)
 	if err == nil {
 		_ = globalConfig.storage.Store(jsonData)
 	}
 }
 
+// windowOnlyKeys can't be changed over the legacy /api, which any web page reaches through
+// 127.0.0.1. The window sets them through its binding, scripts through the token api.
+var windowOnlyKeys = []string{
+	"UpdateRepo",
+	// registers the binary to start with the system
+	"LaunchAtLogin",
+	// commands run as the user
+	"PostCommand", "OcrCommand", "ExternalPlugins",
+}
+
+// windowOnlyChange is the first window only setting config changes, empty when it changes none
+func (c *Config) windowOnlyChange(config Config) string {
+	for _, key := range windowOnlyKeys {
+		if !reflect.DeepEqual(c.getConfig(key), config.getConfig(key)) {
+			return key
+		}
+	}
+	return ""
+}
+
+// secretKeys never leave through the legacy /api: get-config blanks them and set-config keeps
+// the values they have. The window shows them, the token api is for whoever already holds one.
+var secretKeys = append([]string{"ApiToken"}, vaultKeys...)
+
+// publicConfig is the config as the legacy /api may show it, secretKeys blanked
+func (c *Config) publicConfig() (Config, error) {
+	config, err := c.clone()
+	if err != nil {
+		return config, err
+	}
+	fields := reflect.ValueOf(&config).Elem()
+	for _, key := range secretKeys {
+		field := fields.FieldByName(key)
+		field.Set(reflect.Zero(field.Type()))
+	}
+	return config, nil
+}
+
+// keepSecrets puts the current values of secretKeys into config
+func (c *Config) keepSecrets(config *Config) {
+	c.keepFields(config, secretKeys)
+}
+
+// keepFields puts the current values of the fields named keys into config
+func (c *Config) keepFields(config *Config, keys []string) {
+	current := reflect.ValueOf(c).Elem()
+	fields := reflect.ValueOf(config).Elem()
+	for _, key := range keys {
+		fields.FieldByName(key).Set(current.FieldByName(key))
+	}
+}
+
+// resetApiToken replaces the api token, clients holding the old one are turned away
+func (c *Config) resetApiToken() (string, error) {
+	token, err := gonanoid.New(32)
+	if err != nil {
+		return "", err
+	}
+	config, err := c.clone()
+	if err != nil {
+		return "", err
+	}
+	config.ApiToken = token
+	c.setConfig(config)
+	return token, nil
+}
+
+// watch applies edits made to config.json by hand or by another tool. The listening
+// ports are bound at startup, a change to them waits for the next start.
+func (c *Config) watch() {
+	for {
+		time.Sleep(2 * time.Second)
+		data, changed := c.storage.Changed()
+		if !changed {
+			continue
+		}
+		config, err := c.clone()
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			globalLogger.Esg(err, "reload config failed")
+			continue
+		}
+		// config.json holds the credentials blank while the vault keeps them, taken as they are
+		// strip would clear the vault
+		if vaultOnce.enabled() {
+			c.keepFields(&config, vaultKeys)
+		}
+		if config.Host != c.Host || config.Port != c.Port || config.Listeners != c.Listeners {
+			globalLogger.Warn().Msg("listen address changes in config.json apply after a restart")
+		}
+		globalLogger.Info().Msg("config.json changed, reloading")
+		c.setConfig(config)
+		// the event also reaches /api/events, the window reads the credentials through its binding
+		if public, err := c.publicConfig(); err == nil {
+			httpServerOnce.send("configReloaded", public)
+		}
+	}
+}
+
 func (c *Config) getConfig(key string) interface{} {
 	switch key {
 	case "Host":
@@ -294,6 +839,222 @@ func (c *Config) getConfig(key string) interface{} {
 		return c.MimeMap
 	case "Rule":
 		return c.Rule
+	case "QuicDowngrade":
+		return c.QuicDowngrade
+	case "QuicRule":
+		return c.QuicRule
+	case "Listeners":
+		return c.Listeners
+	case "Script":
+		return c.Script
+	case "SegmentMerge":
+		return c.SegmentMerge
+	case "AdBlock":
+		return c.AdBlock
+	case "AdBlockRule":
+		return c.AdBlockRule
+	case "AdBlockUrl":
+		return c.AdBlockUrl
+	case "CacheMemoryMB":
+		return c.CacheMemoryMB
+	case "CacheTotalMB":
+		return c.CacheTotalMB
+	case "RangeStitch":
+		return c.RangeStitch
+	case "DnsOverHttps":
+		return c.DnsOverHttps
+	case "DanmakuCapture":
+		return c.DanmakuCapture
+	case "ProcessProxy":
+		return c.ProcessProxy
+	case "ProcessNames":
+		return c.ProcessNames
+	case "TlsFingerprint":
+		return c.TlsFingerprint
+	case "PoolMaxIdle":
+		return c.PoolMaxIdle
+	case "PoolMaxPerHost":
+		return c.PoolMaxPerHost
+	case "PoolIdleTime":
+		return c.PoolIdleTime
+	case "ResponsePreview":
+		return c.ResponsePreview
+	case "PlatformDomains":
+		return c.PlatformDomains
+	case "SpeedLimit":
+		return c.SpeedLimit
+	case "RetryCount":
+		return c.RetryCount
+	case "FilenameTemplate":
+		return c.FilenameTemplate
+	case "VerifyDownload":
+		return c.VerifyDownload
+	case "VerifyRedownload":
+		return c.VerifyRedownload
+	case "MinFreeSpace":
+		return c.MinFreeSpace
+	case "Aria2Enable":
+		return c.Aria2Enable
+	case "Aria2Rpc":
+		return c.Aria2Rpc
+	case "Aria2Secret":
+		return c.Aria2Secret
+	case "ProxyList":
+		return c.ProxyList
+	case "CategoryDirs":
+		return c.CategoryDirs
+	case "CategoryRules":
+		return c.CategoryRules
+	case "PostCommand":
+		return c.PostCommand
+	case "PostCommandTimeout":
+		return c.PostCommandTimeout
+	case "WebdavEnable":
+		return c.WebdavEnable
+	case "WebdavUrl":
+		return c.WebdavUrl
+	case "WebdavUser":
+		return c.WebdavUser
+	case "WebdavPassword":
+		return c.WebdavPassword
+	case "DeleteAfterUpload":
+		return c.DeleteAfterUpload
+	case "S3Enable":
+		return c.S3Enable
+	case "S3Endpoint":
+		return c.S3Endpoint
+	case "S3Region":
+		return c.S3Region
+	case "S3Bucket":
+		return c.S3Bucket
+	case "S3AccessKey":
+		return c.S3AccessKey
+	case "S3SecretKey":
+		return c.S3SecretKey
+	case "S3Prefix":
+		return c.S3Prefix
+	case "S3PathStyle":
+		return c.S3PathStyle
+	case "DuplicatePolicy":
+		return c.DuplicatePolicy
+	case "ScheduleEnable":
+		return c.ScheduleEnable
+	case "DownloadSchedule":
+		return c.DownloadSchedule
+	case "AutoRequeue":
+		return c.AutoRequeue
+	case "RequeueLimit":
+		return c.RequeueLimit
+	case "AdaptiveChunks":
+		return c.AdaptiveChunks
+	case "Preallocate":
+		return c.Preallocate
+	case "CookieJar":
+		return c.CookieJar
+	case "DownloadIdentity":
+		return c.DownloadIdentity
+	case "RecordMaxMinutes":
+		return c.RecordMaxMinutes
+	case "RecordMaxSizeMB":
+		return c.RecordMaxSizeMB
+	case "RecordConcat":
+		return c.RecordConcat
+	case "BandwidthProfiles":
+		return c.BandwidthProfiles
+	case "TorrentClient":
+		return c.TorrentClient
+	case "TorrentUrl":
+		return c.TorrentUrl
+	case "TorrentUser":
+		return c.TorrentUser
+	case "TorrentPassword":
+		return c.TorrentPassword
+	case "VariantPreference":
+		return c.VariantPreference
+	case "CacheMedia":
+		return c.CacheMedia
+	case "ApiEnable":
+		return c.ApiEnable
+	case "ApiListen":
+		return c.ApiListen
+	case "ApiToken":
+		return c.ApiToken
+	case "GrpcEnable":
+		return c.GrpcEnable
+	case "GrpcListen":
+		return c.GrpcListen
+	case "ExternalPlugins":
+		return c.ExternalPlugins
+	case "RulePackUrls":
+		return c.RulePackUrls
+	case "RulePackKey":
+		return c.RulePackKey
+	case "RulePackHours":
+		return c.RulePackHours
+	case "Webhooks":
+		return c.Webhooks
+	case "TelegramEnable":
+		return c.TelegramEnable
+	case "TelegramToken":
+		return c.TelegramToken
+	case "TelegramChatId":
+		return c.TelegramChatId
+	case "TelegramProxy":
+		return c.TelegramProxy
+	case "AutomationRules":
+		return c.AutomationRules
+	case "MetricsEnable":
+		return c.MetricsEnable
+	case "MetricsListen":
+		return c.MetricsListen
+	case "LogJson":
+		return c.LogJson
+	case "LogLevel":
+		return c.LogLevel
+	case "LogLevels":
+		return c.LogLevels
+	case "LogMaxSize":
+		return c.LogMaxSize
+	case "LogMaxFiles":
+		return c.LogMaxFiles
+	case "Profiles":
+		return c.Profiles
+	case "ActiveProfile":
+		return c.ActiveProfile
+	case "UpdateChannel":
+		return c.UpdateChannel
+	case "UpdateRepo":
+		return c.UpdateRepo
+	case "CrashDumps":
+		return c.CrashDumps
+	case "RetentionDays":
+		return c.RetentionDays
+	case "PartRetentionDays":
+		return c.PartRetentionDays
+	case "SaveCover":
+		return c.SaveCover
+	case "SidecarFormat":
+		return c.SidecarFormat
+	case "SaveComments":
+		return c.SaveComments
+	case "ContentDuplicate":
+		return c.ContentDuplicate
+	case "LiveRooms":
+		return c.LiveRooms
+	case "LivePollSeconds":
+		return c.LivePollSeconds
+	case "AudioFingerprint":
+		return c.AudioFingerprint
+	case "OcrCommand":
+		return c.OcrCommand
+	case "OcrFrameSeconds":
+		return c.OcrFrameSeconds
+	case "DomainLimits":
+		return c.DomainLimits
+	case "LaunchAtLogin":
+		return c.LaunchAtLogin
+	case "WorkDirectory":
+		return c.WorkDirectory
 	default:
 		return nil
 	}
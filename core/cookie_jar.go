@@ -0,0 +1,277 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cookieVaultPrefix marks a jar sealed by the vault, without it the jar is under the built-in key
+const cookieVaultPrefix = "vault:"
+
+// cookieObfuscationKey is compiled into every build, so a jar under it is only obfuscated: anyone
+// with the binary can read it. Only a vault sealed jar is actually protected.
+const cookieObfuscationKey = "resc0k1e5a9d3f7e2b8c4a6d1e0f9b37"
+
+type jarCookie struct {
+	Name     string `json:"Name"`
+	Value    string `json:"Value"`
+	Domain   string `json:"Domain"`
+	Path     string `json:"Path"`
+	HostOnly bool   `json:"HostOnly"`
+	Expires  int64  `json:"Expires"` // unix seconds, 0 for a session cookie
+}
+
+// CookieStore keeps one cookie jar per platform (top level domain), filled from captured traffic
+// and saved in UserDir so member-only downloads keep working after a restart. The file is sealed
+// by the vault when it is set up and otherwise just obfuscated, see cookieObfuscationKey.
+// It implements http.CookieJar for the resolver client and is applied to download requests.
+type CookieStore struct {
+	mu      sync.Mutex
+	jars    map[string]map[string]*jarCookie // platform -> domain|path|name -> cookie
+	pending bool
+	cipher  *AESCipher
+}
+
+func initCookies() *CookieStore {
+	if cookieOnce == nil {
+		cookieOnce = &CookieStore{
+			jars:   make(map[string]map[string]*jarCookie),
+			cipher: NewAESCipher(cookieObfuscationKey),
+		}
+		cookieOnce.load()
+	}
+	return cookieOnce
+}
+
+func (c *CookieStore) file() string {
+	return filepath.Join(appOnce.UserDir, "cookies.dat")
+}
+
+func (c *CookieStore) load() {
+	data, err := os.ReadFile(c.file())
+	if err != nil {
+		return
+	}
+	var plain []byte
+	if sealed, ok := bytes.CutPrefix(data, []byte(cookieVaultPrefix)); ok {
+		// a locked vault reads the jar once it is unlocked
+		if !vaultOnce.enabled() {
+			return
+		}
+		plain, err = vaultOnce.unseal(sealed)
+	} else {
+		var text string
+		text, err = c.cipher.Decrypt(string(data))
+		plain = []byte(text)
+	}
+	if err != nil {
+		if errorCode(err) != MsgVaultLocked {
+			globalLogger.Esg(err, "decrypt cookie jar failed")
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := json.Unmarshal(plain, &c.jars); err != nil {
+		globalLogger.Esg(err, "parse cookie jar failed")
+	}
+}
+
+// save writes the jars now, expired cookies are dropped
+func (c *CookieStore) save() {
+	c.mu.Lock()
+	c.pending = false
+	now := time.Now().Unix()
+	for _, jar := range c.jars {
+		for key, cookie := range jar {
+			if cookie.Expires > 0 && cookie.Expires < now {
+				delete(jar, key)
+			}
+		}
+	}
+	data, err := json.Marshal(c.jars)
+	c.mu.Unlock()
+	if err != nil {
+		return
+	}
+	var encrypted []byte
+	if vaultOnce.enabled() {
+		// while the vault is locked the jar on disk stays as it is
+		sealed, err := vaultOnce.seal(data)
+		if err != nil {
+			return
+		}
+		encrypted = append([]byte(cookieVaultPrefix), sealed...)
+	} else {
+		text, err := c.cipher.Encrypt(string(data))
+		if err != nil {
+			globalLogger.Esg(err, "encrypt cookie jar failed")
+			return
+		}
+		encrypted = []byte(text)
+	}
+	if err := os.WriteFile(c.file(), encrypted, 0600); err != nil {
+		globalLogger.Esg(err, "save cookie jar failed")
+	}
+}
+
+// scheduleSave batches the many small updates of captured traffic, callers hold mu
+func (c *CookieStore) scheduleSave() {
+	if c.pending {
+		return
+	}
+	c.pending = true
+	time.AfterFunc(10*time.Second, c.save)
+}
+
+// tap stores the cookies a browser sent and the ones the server set
+func (c *CookieStore) tap(resp *http.Response) {
+	if !globalConfig.CookieJar {
+		return
+	}
+	host := strings.ToLower(resp.Request.URL.Hostname())
+	platform := shared.GetTopLevelDomain(host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := false
+	for _, cookie := range resp.Request.Cookies() {
+		// the browser doesn't say which domain a cookie belongs to, the platform is the safe guess
+		changed = c.put(platform, &jarCookie{Name: cookie.Name, Value: cookie.Value, Domain: platform, Path: "/"}) || changed
+	}
+	for _, cookie := range resp.Cookies() {
+		changed = c.setCookie(host, cookie) || changed
+	}
+	if changed {
+		c.scheduleSave()
+	}
+}
+
+// setCookie applies one Set-Cookie, callers hold mu
+func (c *CookieStore) setCookie(host string, cookie *http.Cookie) bool {
+	stored := &jarCookie{Name: cookie.Name, Value: cookie.Value, Domain: strings.TrimPrefix(strings.ToLower(cookie.Domain), "."), Path: cookie.Path}
+	if stored.Domain == "" {
+		stored.Domain, stored.HostOnly = host, true
+	}
+	if stored.Path == "" || !strings.HasPrefix(stored.Path, "/") {
+		stored.Path = "/"
+	}
+	switch {
+	case cookie.MaxAge < 0:
+		stored.Expires = 1
+	case cookie.MaxAge > 0:
+		stored.Expires = time.Now().Unix() + int64(cookie.MaxAge)
+	case !cookie.Expires.IsZero():
+		stored.Expires = cookie.Expires.Unix()
+	}
+	platform := shared.GetTopLevelDomain(stored.Domain)
+	if stored.Expires > 0 && stored.Expires < time.Now().Unix() {
+		jar := c.jars[platform]
+		key := stored.Domain + "|" + stored.Path + "|" + stored.Name
+		if _, ok := jar[key]; ok {
+			delete(jar, key)
+			return true
+		}
+		return false
+	}
+	return c.put(platform, stored)
+}
+
+func (c *CookieStore) put(platform string, cookie *jarCookie) bool {
+	jar, ok := c.jars[platform]
+	if !ok {
+		jar = make(map[string]*jarCookie)
+		c.jars[platform] = jar
+	}
+	key := cookie.Domain + "|" + cookie.Path + "|" + cookie.Name
+	if old, ok := jar[key]; ok && *old == *cookie {
+		return false
+	}
+	jar[key] = cookie
+	return true
+}
+
+// SetCookies implements http.CookieJar
+func (c *CookieStore) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if !globalConfig.CookieJar {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := false
+	for _, cookie := range cookies {
+		changed = c.setCookie(strings.ToLower(u.Hostname()), cookie) || changed
+	}
+	if changed {
+		c.scheduleSave()
+	}
+}
+
+// Cookies implements http.CookieJar
+func (c *CookieStore) Cookies(u *url.URL) []*http.Cookie {
+	if !globalConfig.CookieJar {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := time.Now().Unix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var cookies []*http.Cookie
+	for _, cookie := range c.jars[shared.GetTopLevelDomain(host)] {
+		if cookie.Expires > 0 && cookie.Expires < now || !strings.HasPrefix(path, cookie.Path) {
+			continue
+		}
+		if host != cookie.Domain && (cookie.HostOnly || !strings.HasSuffix(host, "."+cookie.Domain)) {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return cookies
+}
+
+// apply adds jar cookies the request doesn't carry yet, captured Cookie headers win
+func (c *CookieStore) apply(request *http.Request) {
+	existing := map[string]bool{}
+	for _, cookie := range request.Cookies() {
+		existing[cookie.Name] = true
+	}
+	for _, cookie := range c.Cookies(request.URL) {
+		if !existing[cookie.Name] {
+			request.AddCookie(cookie)
+		}
+	}
+}
+
+// platforms lists the jars with their cookie count
+func (c *CookieStore) platforms() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make(map[string]int, len(c.jars))
+	for platform, jar := range c.jars {
+		list[platform] = len(jar)
+	}
+	return list
+}
+
+// clear empties one platform's jar, or all jars when platform is empty
+func (c *CookieStore) clear(platform string) {
+	c.mu.Lock()
+	if platform == "" {
+		c.jars = make(map[string]map[string]*jarCookie)
+	} else {
+		delete(c.jars, platform)
+	}
+	c.mu.Unlock()
+	c.save()
+}
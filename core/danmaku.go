@@ -0,0 +1,392 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// danmakuHosts are the live chat endpoints we know how to read
+var danmakuHosts = []string{
+	"chat.bilibili.com",
+	"live.bilibili.com",
+	"huya.com",
+	"douyu.com",
+	"kuaishou.com",
+}
+
+type DanmakuItem struct {
+	Time int64  `json:"Time"` // unix ms
+	User string `json:"User"`
+	Text string `json:"Text"`
+}
+
+type DanmakuSession struct {
+	Id      string `json:"Id"`
+	Host    string `json:"Host"`
+	Url     string `json:"Url"`
+	Start   int64  `json:"Start"`
+	Count   int    `json:"Count"`
+	items   []DanmakuItem
+	itemMux sync.Mutex
+}
+
+type DanmakuRecorder struct {
+	mu       sync.Mutex
+	sessions map[string]*DanmakuSession
+}
+
+func initDanmaku() *DanmakuRecorder {
+	if danmakuOnce == nil {
+		danmakuOnce = &DanmakuRecorder{
+			sessions: make(map[string]*DanmakuSession),
+		}
+	}
+	return danmakuOnce
+}
+
+func (d *DanmakuRecorder) match(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range danmakuHosts {
+		if strings.HasSuffix(strings.Split(host, ":")[0], h) {
+			return true
+		}
+	}
+	return false
+}
+
+// tap wraps a 101 websocket response so server frames are parsed as they pass through
+func (d *DanmakuRecorder) tap(resp *http.Response) {
+	if !globalConfig.DanmakuCapture || resp.StatusCode != http.StatusSwitchingProtocols || !d.match(resp.Request.Host) {
+		return
+	}
+	rw, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return
+	}
+	id, err := gonanoid.New()
+	if err != nil {
+		id = shared.Md5(resp.Request.URL.String() + time.Now().String())
+	}
+	session := &DanmakuSession{
+		Id:    id,
+		Host:  resp.Request.Host,
+		Url:   resp.Request.URL.String(),
+		Start: time.Now().UnixMilli(),
+	}
+	d.mu.Lock()
+	d.sessions[id] = session
+	d.mu.Unlock()
+
+	resp.Body = &wsTapBody{ReadWriteCloser: rw, parser: &wsFrameParser{session: session, deflate: strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")}}
+	httpServerOnce.send("danmakuSession", session)
+}
+
+func (d *DanmakuRecorder) list() []DanmakuSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]DanmakuSession, 0, len(d.sessions))
+	for _, s := range d.sessions {
+		s.itemMux.Lock()
+		list = append(list, DanmakuSession{Id: s.Id, Host: s.Host, Url: s.Url, Start: s.Start, Count: len(s.items)})
+		s.itemMux.Unlock()
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start > list[j].Start })
+	return list
+}
+
+// export writes the session as bilibili-style xml or ass, start (unix ms) aligns it to a recording
+func (d *DanmakuRecorder) export(id, format string, start int64) (string, error) {
+	d.mu.Lock()
+	session, ok := d.sessions[id]
+	d.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("danmaku session not found")
+	}
+	if start <= 0 {
+		start = session.Start
+	}
+	session.itemMux.Lock()
+	items := append([]DanmakuItem(nil), session.items...)
+	session.itemMux.Unlock()
+
+	var content string
+	switch format {
+	case "ass":
+		content = buildDanmakuAss(items, start)
+	default:
+		format = "xml"
+		content = buildDanmakuXml(items, start)
+	}
+	fileName := filepath.Join(globalConfig.SaveDirectory, "danmaku-"+shared.GetCurrentDateTimeFormatted()+"."+format)
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
+
+func (d *DanmakuRecorder) clear() {
+	d.mu.Lock()
+	d.sessions = make(map[string]*DanmakuSession)
+	d.mu.Unlock()
+}
+
+func (s *DanmakuSession) add(items []DanmakuItem) {
+	if len(items) == 0 {
+		return
+	}
+	s.itemMux.Lock()
+	s.items = append(s.items, items...)
+	s.itemMux.Unlock()
+}
+
+type wsTapBody struct {
+	io.ReadWriteCloser
+	parser *wsFrameParser
+}
+
+func (b *wsTapBody) Read(p []byte) (int, error) {
+	n, err := b.ReadWriteCloser.Read(p)
+	if n > 0 {
+		b.parser.feed(p[:n])
+	}
+	return n, err
+}
+
+// wsFrameParser decodes unmasked server frames, including permessage-deflate with context takeover
+type wsFrameParser struct {
+	session *DanmakuSession
+	deflate bool
+	broken  bool
+	buf     []byte
+	message []byte
+	opcode  byte
+	rsv1    bool
+	window  []byte
+}
+
+const wsMaxFrame = 16 * 1024 * 1024
+
+func (p *wsFrameParser) feed(data []byte) {
+	if p.broken {
+		return
+	}
+	p.buf = append(p.buf, data...)
+	for {
+		if len(p.buf) < 2 {
+			return
+		}
+		fin := p.buf[0]&0x80 != 0
+		rsv1 := p.buf[0]&0x40 != 0
+		opcode := p.buf[0] & 0x0F
+		masked := p.buf[1]&0x80 != 0
+		length := uint64(p.buf[1] & 0x7F)
+		offset := 2
+		switch length {
+		case 126:
+			if len(p.buf) < 4 {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(p.buf[2:4]))
+			offset = 4
+		case 127:
+			if len(p.buf) < 10 {
+				return
+			}
+			length = binary.BigEndian.Uint64(p.buf[2:10])
+			offset = 10
+		}
+		if length > wsMaxFrame {
+			p.broken = true
+			return
+		}
+		if masked {
+			offset += 4
+		}
+		if uint64(len(p.buf)) < uint64(offset)+length {
+			return
+		}
+		payload := append([]byte(nil), p.buf[offset:uint64(offset)+length]...)
+		if masked {
+			key := p.buf[offset-4 : offset]
+			for i := range payload {
+				payload[i] ^= key[i%4]
+			}
+		}
+		p.buf = p.buf[uint64(offset)+length:]
+
+		if opcode >= 0x8 {
+			continue // control frames
+		}
+		if opcode != 0 {
+			p.opcode = opcode
+			p.rsv1 = rsv1
+			p.message = p.message[:0]
+		}
+		p.message = append(p.message, payload...)
+		if fin {
+			p.handle(p.opcode, p.message)
+			p.message = nil
+		}
+	}
+}
+
+func (p *wsFrameParser) handle(opcode byte, message []byte) {
+	if p.deflate && p.rsv1 {
+		reader := flate.NewReaderDict(io.MultiReader(bytes.NewReader(message), bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff})), p.window)
+		inflated, err := io.ReadAll(reader)
+		if err != nil && len(inflated) == 0 {
+			return
+		}
+		p.window = append(p.window, inflated...)
+		if len(p.window) > 32*1024 {
+			p.window = p.window[len(p.window)-32*1024:]
+		}
+		message = inflated
+	}
+
+	now := time.Now().UnixMilli()
+	var items []DanmakuItem
+	if opcode == 0x2 {
+		items = decodeBilibiliPackets(message, now)
+	} else {
+		items = decodeJsonDanmaku(message, now)
+	}
+	p.session.add(items)
+}
+
+// decodeBilibiliPackets reads the bilibili live packet format: 16 byte header, op 5 = command json
+func decodeBilibiliPackets(data []byte, now int64) []DanmakuItem {
+	var items []DanmakuItem
+	for len(data) >= 16 {
+		packetLen := binary.BigEndian.Uint32(data[0:4])
+		headerLen := binary.BigEndian.Uint16(data[4:6])
+		ver := binary.BigEndian.Uint16(data[6:8])
+		op := binary.BigEndian.Uint32(data[8:12])
+		if packetLen < uint32(headerLen) || int(packetLen) > len(data) {
+			break
+		}
+		body := data[headerLen:packetLen]
+		data = data[packetLen:]
+		if op != 5 {
+			continue
+		}
+		switch ver {
+		case 2:
+			reader, err := zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				continue
+			}
+			inflated, _ := io.ReadAll(reader)
+			_ = reader.Close()
+			items = append(items, decodeBilibiliPackets(inflated, now)...)
+		case 0, 1:
+			var msg struct {
+				Cmd  string            `json:"cmd"`
+				Info []json.RawMessage `json:"info"`
+			}
+			if json.Unmarshal(body, &msg) != nil || !strings.HasPrefix(msg.Cmd, "DANMU_MSG") || len(msg.Info) < 3 {
+				continue
+			}
+			var text string
+			var user []interface{}
+			_ = json.Unmarshal(msg.Info[1], &text)
+			_ = json.Unmarshal(msg.Info[2], &user)
+			item := DanmakuItem{Time: now, Text: text}
+			if len(user) > 1 {
+				item.User = fmt.Sprint(user[1])
+			}
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// decodeJsonDanmaku handles platforms that push plain json chat messages
+func decodeJsonDanmaku(data []byte, now int64) []DanmakuItem {
+	var msg map[string]interface{}
+	if json.Unmarshal(data, &msg) != nil {
+		return nil
+	}
+	if inner, ok := msg["data"].(map[string]interface{}); ok {
+		msg = inner
+	}
+	text := firstString(msg, "content", "text", "msg", "txt")
+	if text == "" {
+		return nil
+	}
+	return []DanmakuItem{{Time: now, User: firstString(msg, "nickname", "uname", "nick", "user"), Text: text}}
+}
+
+func firstString(m map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := m[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func buildDanmakuXml(items []DanmakuItem, start int64) string {
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<i>\n")
+	for _, item := range items {
+		if item.Time < start {
+			continue
+		}
+		seconds := float64(item.Time-start) / 1000
+		b.WriteString(fmt.Sprintf("  <d p=\"%.3f,1,25,16777215,%d,0,%s,0\">%s</d>\n", seconds, item.Time/1000, html.EscapeString(item.User), html.EscapeString(item.Text)))
+	}
+	b.WriteString("</i>\n")
+	return b.String()
+}
+
+// buildDanmakuAss renders right-to-left scrolling lines on a 1920x1080 canvas, 8s per line
+func buildDanmakuAss(items []DanmakuItem, start int64) string {
+	const width, rows, duration = 1920, 12, int64(8000)
+	var b strings.Builder
+	b.WriteString("[Script Info]\nScriptType: v4.00+\nPlayResX: 1920\nPlayResY: 1080\n\n")
+	b.WriteString("[V4+ Styles]\nFormat: Name, Fontname, Fontsize, PrimaryColour, OutlineColour, BorderStyle, Outline, Shadow, Alignment\n")
+	b.WriteString("Style: Danmaku,Microsoft YaHei,48,&H00FFFFFF,&H00000000,1,2,0,7\n\n")
+	b.WriteString("[Events]\nFormat: Layer, Start, End, Style, Text\n")
+
+	rowFree := make([]int64, rows)
+	for _, item := range items {
+		if item.Time < start {
+			continue
+		}
+		begin := item.Time - start
+		row := 0
+		for i := range rowFree {
+			if rowFree[i] <= begin {
+				row = i
+				break
+			}
+		}
+		rowFree[row] = begin + duration/3
+		y := 60 + row*80
+		text := strings.NewReplacer("{", "(", "}", ")", "\n", " ").Replace(item.Text)
+		b.WriteString(fmt.Sprintf("Dialogue: 0,%s,%s,Danmaku,{\\move(%d,%d,%d,%d)}%s\n",
+			assTime(begin), assTime(begin+duration), width, y, -len([]rune(text))*48, y, text))
+	}
+	return b.String()
+}
+
+func assTime(ms int64) string {
+	return fmt.Sprintf("%d:%02d:%02d.%02d", ms/3600000, ms/60000%60, ms/1000%60, ms/10%100)
+}
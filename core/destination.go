@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+)
+
+// Destination is a remote place completed downloads are copied to
+type Destination interface {
+	Name() string
+	// Upload copies mediaInfo.SavePath to remotePath ('/' separated, relative to the destination root)
+	// and returns only after the remote copy was verified
+	Upload(ctx context.Context, mediaInfo shared.MediaInfo, remotePath string) error
+}
+
+func enabledDestinations() []Destination {
+	var list []Destination
+	if globalConfig.WebdavEnable && globalConfig.WebdavUrl != "" {
+		list = append(list, newWebdavDestination())
+	}
+	if globalConfig.S3Enable && globalConfig.S3Bucket != "" {
+		if d, err := newS3Destination(); err == nil {
+			list = append(list, d)
+		} else {
+			globalLogger.Esg(err, "s3 destination disabled")
+		}
+	}
+	return list
+}
+
+// remotePathFor keeps the layout below the save directory, e.g. 视频号/视频/name.mp4
+func remotePathFor(localPath string) string {
+	rel, err := filepath.Rel(globalConfig.SaveDirectory, localPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(localPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// uploadDestinations pushes a finished file everywhere configured, the local copy is
+// removed only when asked to and every upload was verified
+func uploadDestinations(mediaInfo shared.MediaInfo) {
+	destinations := enabledDestinations()
+	// an automation rule may ask for WebDAV on its own downloads
+	if mediaInfo.OtherData["automation_upload"] == "1" && !globalConfig.WebdavEnable && globalConfig.WebdavUrl != "" {
+		destinations = append(destinations, newWebdavDestination())
+	}
+	if len(destinations) == 0 {
+		return
+	}
+	remotePath := remotePathFor(mediaInfo.SavePath)
+	allOk := true
+	for _, destination := range destinations {
+		uploadEventsEmit(mediaInfo, destination.Name(), shared.DownloadStatusRunning, "uploading")
+		if err := destination.Upload(context.Background(), mediaInfo, remotePath); err != nil {
+			allOk = false
+			globalLogger.Esg(err, destination.Name()+" upload failed")
+			uploadEventsEmit(mediaInfo, destination.Name(), shared.DownloadStatusError, err.Error())
+			continue
+		}
+		uploadEventsEmit(mediaInfo, destination.Name(), shared.DownloadStatusDone, remotePath)
+	}
+	if allOk && globalConfig.DeleteAfterUpload {
+		if err := os.Remove(mediaInfo.SavePath); err != nil {
+			globalLogger.Esg(err, "remove uploaded file failed")
+		}
+	}
+}
+
+func uploadEventsEmit(mediaInfo shared.MediaInfo, destination, status, message string) {
+	httpServerOnce.send("uploadProgress", map[string]interface{}{
+		"Id":          mediaInfo.Id,
+		"Destination": destination,
+		"Status":      status,
+		"Message":     message,
+	})
+}
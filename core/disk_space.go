@@ -0,0 +1,51 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DiskSpaceError stops a download before the disk fills up, the .part file is kept for resuming
+type DiskSpaceError struct {
+	Path string
+	Free int64
+	Need int64
+}
+
+func (e *DiskSpaceError) Error() string {
+	return localize(MsgDiskSpace, e.Path, e.Free>>20, e.Need>>20)
+}
+
+func (e *DiskSpaceError) MessageCode() string {
+	return MsgDiskSpace
+}
+
+func diskReserve() int64 {
+	return int64(globalConfig.MinFreeSpace) << 20
+}
+
+// checkDiskSpace returns a DiskSpaceError when writing need more bytes into dir would cross the reserve
+func checkDiskSpace(dir string, need int64) error {
+	for dir != "" {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	free, err := diskFree(dir)
+	if err != nil {
+		// unknown, don't block the download
+		return nil
+	}
+	if need < 0 {
+		need = 0
+	}
+	if free < need+diskReserve() {
+		return &DiskSpaceError{Path: dir, Free: free, Need: need + diskReserve()}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DohResolver resolves hostnames through a DNS-over-HTTPS server (RFC 8484), used
+// by the proxy's outbound connections when Config.DnsOverHttps is set.
+type DohResolver struct {
+	mu     sync.Mutex
+	cache  map[string]dohCacheEntry
+	client *http.Client
+	dialer *net.Dialer
+}
+
+type dohCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+const dohMinTTL = 30 * time.Second
+
+func initDoh() *DohResolver {
+	if dohOnce == nil {
+		dohOnce = &DohResolver{
+			cache: make(map[string]dohCacheEntry),
+			// the DoH server itself is resolved by the system resolver
+			client: &http.Client{Timeout: 10 * time.Second},
+			dialer: &net.Dialer{Timeout: 60 * time.Second},
+		}
+	}
+	return dohOnce
+}
+
+func (d *DohResolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil || globalConfig.DnsOverHttps == "" {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		globalLogger.Module("proxy").Warn().Msgf("doh lookup %s failed, fallback to system dns: %v", host, err)
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (d *DohResolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	d.mu.Lock()
+	if entry, ok := d.cache[host]; ok && time.Now().Before(entry.expires) {
+		d.mu.Unlock()
+		return entry.ips, nil
+	}
+	d.mu.Unlock()
+
+	var ips []net.IP
+	var ttl uint32
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		found, t, err := d.query(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) > 0 {
+			ips, ttl = found, t
+			break
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+
+	expires := time.Duration(ttl) * time.Second
+	if expires < dohMinTTL {
+		expires = dohMinTTL
+	}
+	d.mu.Lock()
+	d.cache[host] = dohCacheEntry{ips: ips, expires: time.Now().Add(expires)}
+	d.mu.Unlock()
+	return ips, nil
+}
+
+func (d *DohResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]net.IP, uint32, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", globalConfig.DnsOverHttps, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+
+	resp, err := d.client.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("doh server status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(body); err != nil {
+		return nil, 0, fmt.Errorf("unpack doh answer failed: %w", err)
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("doh answer rcode: %s", answer.RCode)
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for _, rr := range answer.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		default:
+			continue
+		}
+		if ttl == 0 || rr.Header.TTL < ttl {
+			ttl = rr.Header.TTL
+		}
+	}
+	return ips, ttl, nil
+}
+
+func (d *DohResolver) clear() {
+	d.mu.Lock()
+	d.cache = make(map[string]dohCacheEntry)
+	d.mu.Unlock()
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"sync"
+)
+
+// PendingDownload is a started but unfinished download, kept on disk so it can be resumed after a restart
+type PendingDownload struct {
+	MediaInfo shared.MediaInfo `json:"MediaInfo"`
+	DecodeStr string           `json:"DecodeStr"`
+	Requeued  int              `json:"Requeued"` // automatic requeues at startup, see requeueOnStartup
+}
+
+var journalMux sync.Mutex
+
+func journalFile() string {
+	return filepath.Join(appOnce.UserDir, "downloads.json")
+}
+
+func loadJournal() map[string]PendingDownload {
+	pending := map[string]PendingDownload{}
+	data, err := os.ReadFile(journalFile())
+	if err != nil {
+		return pending
+	}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		globalLogger.Esg(err, "parse download journal failed")
+	}
+	return pending
+}
+
+func saveJournal(pending map[string]PendingDownload) {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(journalFile(), data, 0644); err != nil {
+		globalLogger.Esg(err, "save download journal failed")
+	}
+}
+
+func (r *Resource) journalAdd(mediaInfo shared.MediaInfo, decodeStr string) {
+	journalMux.Lock()
+	defer journalMux.Unlock()
+	pending := loadJournal()
+	requeued := 0
+	if item, ok := pending[mediaInfo.Id]; ok && item.MediaInfo.Url == mediaInfo.Url {
+		requeued = item.Requeued
+	}
+	pending[mediaInfo.Id] = PendingDownload{MediaInfo: mediaInfo, DecodeStr: decodeStr, Requeued: requeued}
+	saveJournal(pending)
+}
+
+func (r *Resource) journalRemove(id string) {
+	journalMux.Lock()
+	defer journalMux.Unlock()
+	pending := loadJournal()
+	if _, ok := pending[id]; !ok {
+		return
+	}
+	delete(pending, id)
+	saveJournal(pending)
+}
+
+// journalSavePath returns the path a pending download of the same url was writing to, so its .part file is reused
+func (r *Resource) journalSavePath(mediaInfo shared.MediaInfo) string {
+	journalMux.Lock()
+	defer journalMux.Unlock()
+	if item, ok := loadJournal()[mediaInfo.Id]; ok && item.MediaInfo.Url == mediaInfo.Url {
+		return item.MediaInfo.SavePath
+	}
+	return ""
+}
+
+func (r *Resource) pendingDownloads() []PendingDownload {
+	journalMux.Lock()
+	defer journalMux.Unlock()
+	list := make([]PendingDownload, 0)
+	for id, item := range loadJournal() {
+		if _, running := r.tasks.Load(id); !running {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// resumeDownloads restarts every pending download that isn't already running
+func (r *Resource) resumeDownloads() int {
+	list := r.pendingDownloads()
+	for _, item := range list {
+		httpServerOnce.send("newResources", item.MediaInfo)
+		queueOnce.push(item.MediaInfo, item.DecodeStr, 0)
+	}
+	return len(list)
+}
+
+// requeueOnStartup queues the downloads the last run left unfinished, whether it crashed or they failed.
+// Each task is requeued at most Config.RequeueLimit times, after that it stays in the pending list.
+func (r *Resource) requeueOnStartup() int {
+	if !globalConfig.AutoRequeue {
+		return 0
+	}
+	journalMux.Lock()
+	pending := loadJournal()
+	var list []PendingDownload
+	for id, item := range pending {
+		if item.Requeued >= globalConfig.RequeueLimit {
+			continue
+		}
+		item.Requeued++
+		pending[id] = item
+		list = append(list, item)
+	}
+	if len(list) > 0 {
+		saveJournal(pending)
+	}
+	journalMux.Unlock()
+
+	for _, item := range list {
+		httpServerOnce.send("newResources", item.MediaInfo)
+		queueOnce.push(item.MediaInfo, item.DecodeStr, 0)
+	}
+	if len(list) > 0 {
+		globalLogger.Info().Msgf("requeued %d unfinished downloads", len(list))
+	}
+	return len(list)
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bufio"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Proxy modes a download task can choose
+const (
+	DownloadProxyDefault = ""       // follow Config.DownloadProxy / UpstreamProxy
+	DownloadProxyDirect  = "direct" // no proxy
+	DownloadProxyEnv     = "env"    // HTTP(S)_PROXY / NO_PROXY environment, not the OS proxy settings
+)
+
+// downloadProxy resolves a task's proxy mode, anything else is a name from Config.ProxyList or a proxy url.
+// A mode that is neither fails instead of quietly going direct.
+// The capture proxy itself is never returned so downloads don't loop back into it.
+func downloadProxy(mode, rawUrl string) (*url.URL, error) {
+	var proxyURL *url.URL
+	switch mode {
+	case DownloadProxyDefault:
+		if globalConfig.DownloadProxy && globalConfig.UpstreamProxy != "" {
+			proxyURL, _ = url.Parse(globalConfig.UpstreamProxy)
+		}
+	case DownloadProxyDirect:
+		return nil, nil
+	case DownloadProxyEnv:
+		if request, err := http.NewRequest("GET", rawUrl, nil); err == nil {
+			proxyURL, _ = http.ProxyFromEnvironment(request)
+		}
+	default:
+		value := mode
+		if named, ok := namedProxies()[mode]; ok {
+			value = named
+		}
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, messageError(MsgProxyUnknown, mode)
+		}
+		proxyURL = parsed
+	}
+	if proxyURL == nil || proxyURL.Host == "" || proxyURL.Port() == globalConfig.Port && isLocalHost(proxyURL.Hostname()) {
+		return nil, nil
+	}
+	return proxyURL, nil
+}
+
+// namedProxies parses Config.ProxyList, one "name=url" per line
+func namedProxies() map[string]string {
+	proxies := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(globalConfig.ProxyList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, value, ok := strings.Cut(line, "="); ok {
+			proxies[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return proxies
+}
+
+func isLocalHost(host string) bool {
+	return host == "127.0.0.1" || host == "localhost" || host == "::1" || host == "0.0.0.0" || host == ""
+}
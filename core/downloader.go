@@ -2,15 +2,18 @@ package core
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"res-downloader/core/shared"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,6 +36,7 @@ type DownloadTask struct {
 	rangeEnd       int64
 	downloadedSize int64
 	isCompleted    bool
+	active         bool
 	err            error
 }
 
@@ -40,6 +44,7 @@ type FileDownloader struct {
 	Url              string
 	Referer          string
 	ProxyUrl         *url.URL
+	ProxyMode        string
 	FileName         string
 	File             *os.File
 	totalTasks       int
@@ -47,8 +52,19 @@ type FileDownloader struct {
 	IsMultiPart      bool
 	RetryOnError     bool
 	Headers          map[string]string
+	Overrides        map[string]string // per task headers, applied last, an empty value removes the header
 	DownloadTaskList []*DownloadTask
+	tasksMux         sync.Mutex
+	adaptive         *adaptiveState
+	spawn            func(task *DownloadTask)
 	progressCallback ProgressCallback
+	client           *http.Client
+	partName         string
+	Finalize         FinalizeFunc
+	Checksums        Checksums
+	Sha256           string
+	limiter          *RateLimiter
+	stopErr          atomic.Pointer[DiskSpaceError]
 	ctx              context.Context
 	cancelFunc       context.CancelFunc
 }
@@ -64,6 +80,7 @@ func NewFileDownloader(url, filename string, totalTasks int, headers map[string]
 		TotalSize:        0,
 		Headers:          headers,
 		DownloadTaskList: make([]*DownloadTask, 0),
+		limiter:          NewRateLimiter(0),
 		ctx:              ctx,
 		cancelFunc:       cancelFunc,
 	}
@@ -76,12 +93,38 @@ func (fd *FileDownloader) buildClient() *http.Client {
 	}
 	if fd.ProxyUrl != nil {
 		transport.Proxy = http.ProxyURL(fd.ProxyUrl)
+	} else {
+		dial := (&net.Dialer{Timeout: 60 * time.Second}).DialContext
+		profile := currentIdentity()
+		if profile == nil || globalConfig.TlsFingerprint != "" {
+			transport.DialTLSContext = fingerprintDialTLS(dial)
+		} else {
+			transport.DialTLSContext = fingerprintDialTLSAs(profile.tls, dial)
+		}
+		// headers can only be reordered on connections we write ourselves, Go's TLS would hide them
+		if profile != nil && transport.DialTLSContext != nil {
+			transport.DialTLSContext = profile.dial(transport.DialTLSContext)
+			transport.DialContext = profile.dial(dial)
+		}
 	}
 	return &http.Client{
 		Transport: transport,
 	}
 }
 
+// SetSpeedLimit caps this task in bytes per second on top of the global limit, 0 removes the cap
+func (fd *FileDownloader) SetSpeedLimit(rate int64) {
+	fd.limiter.SetRate(rate)
+}
+
+// httpClient shares one client across all parts so connections to the CDN are reused
+func (fd *FileDownloader) httpClient() *http.Client {
+	if fd.client == nil {
+		fd.client = fd.buildClient()
+	}
+	return fd.client
+}
+
 var forbiddenDownloadHeaders = map[string]struct{}{
 	"accept-encoding":   {},
 	"content-length":    {},
@@ -116,11 +159,22 @@ func (fd *FileDownloader) setHeaders(request *http.Request) {
 			request.Header.Set(key, value)
 			continue
 		}
-		
+
 		if strings.Contains(globalConfig.UseHeaders, key) {
 			request.Header.Set(key, value)
 		}
 	}
+	if profile := currentIdentity(); profile != nil {
+		profile.apply(request)
+	}
+	cookieOnce.apply(request)
+	for key, value := range fd.Overrides {
+		if value == "" {
+			request.Header.Del(key)
+		} else {
+			request.Header.Set(key, value)
+		}
+	}
 }
 
 func (fd *FileDownloader) init() error {
@@ -132,11 +186,8 @@ func (fd *FileDownloader) init() error {
 		fd.Referer = parsedURL.Scheme + "://" + parsedURL.Host + "/"
 	}
 
-	if globalConfig.DownloadProxy && globalConfig.UpstreamProxy != "" && !strings.Contains(globalConfig.UpstreamProxy, globalConfig.Port) {
-		proxyURL, err := url.Parse(globalConfig.UpstreamProxy)
-		if err == nil {
-			fd.ProxyUrl = proxyURL
-		}
+	if fd.ProxyUrl, err = downloadProxy(fd.ProxyMode, fd.Url); err != nil {
+		return err
 	}
 
 	request, err := http.NewRequest("HEAD", fd.Url, nil)
@@ -145,7 +196,7 @@ func (fd *FileDownloader) init() error {
 	}
 
 	if _, ok := fd.Headers["User-Agent"]; !ok {
-		fd.Headers["User-Agent"] = globalConfig.UserAgent
+		fd.Headers["User-Agent"] = defaultUserAgent()
 	}
 	if _, ok := fd.Headers["Referer"]; !ok {
 		fd.Headers["Referer"] = fd.Referer
@@ -155,26 +206,38 @@ func (fd *FileDownloader) init() error {
 
 	var resp *http.Response
 	for retries := 0; retries < MaxRetries; retries++ {
-		resp, err = fd.buildClient().Do(request)
+		resp, err = fd.httpClient().Do(request)
 		if err == nil {
 			break
 		}
 		if retries < MaxRetries-1 {
 			time.Sleep(RetryDelay)
-			globalLogger.Warn().Msgf("HEAD request failed, retrying (%d/%d): %v", retries+1, MaxRetries, err)
+			globalLogger.Module("downloader").Warn().Msgf("HEAD request failed, retrying (%d/%d): %v", retries+1, MaxRetries, err)
 		}
 	}
 
-	if err != nil {
-		return fmt.Errorf("HEAD request failed after %d retries: %w", MaxRetries, err)
+	fd.TotalSize = -1
+	fd.IsMultiPart = false
+	acceptRanges := false
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusBadRequest && resp.ContentLength > 0 {
+			fd.TotalSize = resp.ContentLength
+			fd.Checksums = parseChecksums(resp.Header)
+			acceptRanges = strings.Contains(strings.ToLower(resp.Header.Get("Accept-Ranges")), "bytes")
+		}
+	} else {
+		globalLogger.Module("downloader").Warn().Msgf("HEAD request failed after %d retries, probing with GET: %v", MaxRetries, err)
 	}
-	defer resp.Body.Close()
 
-	fd.TotalSize = resp.ContentLength
-	if fd.TotalSize <= 0 {
-		fd.IsMultiPart = false
-		fd.TotalSize = -1
-	} else if resp.Header.Get("Accept-Ranges") == "bytes" && fd.TotalSize > MinPartSize {
+	// many CDNs refuse HEAD or omit Accept-Ranges, ask for the first byte instead
+	if !acceptRanges {
+		if total, ok := fd.probeRange(); ok {
+			fd.TotalSize = total
+			acceptRanges = true
+		}
+	}
+	if acceptRanges && fd.TotalSize > MinPartSize {
 		fd.IsMultiPart = true
 	}
 
@@ -183,21 +246,102 @@ func (fd *FileDownloader) init() error {
 		return fmt.Errorf("create directory failed: %w", err)
 	}
 
-	fd.FileName = shared.GetUniqueFileName(fd.FileName)
+	fd.FileName = targetFileName(fd.FileName)
+	// data goes to a .part file next to the target, renamed once complete
+	fd.partName = fd.FileName + partSuffix
 
-	fd.File, err = os.OpenFile(fd.FileName, os.O_RDWR|os.O_CREATE, 0644)
+	fd.File, err = os.OpenFile(fd.partName, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return fmt.Errorf("file open failed: %w", err)
 	}
 	if fd.TotalSize > 0 {
-		if err := fd.File.Truncate(fd.TotalSize); err != nil {
-			fd.File.Close()
-			return fmt.Errorf("file truncate failed: %w", err)
+		if info, err := fd.File.Stat(); err == nil && info.Size() != fd.TotalSize {
+			if err := allocateFile(fd.File, fd.TotalSize); err != nil {
+				fd.File.Close()
+				var diskErr *DiskSpaceError
+				if errors.As(err, &diskErr) {
+					return diskErr
+				}
+				return fmt.Errorf("file truncate failed: %w", err)
+			}
 		}
 	}
 	return nil
 }
 
+// downloadState is written beside the .part file so a restarted download skips finished bytes
+type downloadState struct {
+	Url       string     `json:"Url"`
+	TotalSize int64      `json:"TotalSize"`
+	Parts     [][3]int64 `json:"Parts"` // rangeStart, rangeEnd, downloaded
+}
+
+func (fd *FileDownloader) stateName() string {
+	return fd.partName + ".json"
+}
+
+func (fd *FileDownloader) saveState() {
+	if !fd.IsMultiPart || fd.partName == "" {
+		return
+	}
+	state := downloadState{Url: fd.Url, TotalSize: fd.TotalSize}
+	fd.tasksMux.Lock()
+	for _, task := range fd.DownloadTaskList {
+		state.Parts = append(state.Parts, [3]int64{task.rangeStart, taskEnd(task), atomic.LoadInt64(&task.downloadedSize)})
+	}
+	fd.tasksMux.Unlock()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(fd.stateName(), data, 0644)
+}
+
+// loadState restores per-part progress when the saved state matches this url and size
+func (fd *FileDownloader) loadState() {
+	data, err := os.ReadFile(fd.stateName())
+	if err != nil {
+		return
+	}
+	var state downloadState
+	if json.Unmarshal(data, &state) != nil || state.TotalSize != fd.TotalSize {
+		return
+	}
+	fd.DownloadTaskList = make([]*DownloadTask, 0, len(state.Parts))
+	for i, part := range state.Parts {
+		fd.DownloadTaskList = append(fd.DownloadTaskList, &DownloadTask{
+			taskID:         i,
+			rangeStart:     part[0],
+			rangeEnd:       part[1],
+			downloadedSize: part[2],
+		})
+	}
+	fd.totalTasks = len(fd.DownloadTaskList)
+}
+
+// probeRange requests bytes=0-0, a 206 with Content-Range proves range support and gives the size
+func (fd *FileDownloader) probeRange() (int64, bool) {
+	ctx, cancel := context.WithTimeout(fd.ctx, 30*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "GET", fd.Url, nil)
+	if err != nil {
+		return 0, false
+	}
+	fd.setHeaders(request)
+	request.Header.Set("Range", "bytes=0-0")
+
+	resp, err := fd.httpClient().Do(request)
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, false
+	}
+	_, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	return total, ok
+}
+
 func (fd *FileDownloader) createDownloadTasks() {
 	if fd.IsMultiPart {
 		if fd.totalTasks <= 0 {
@@ -224,6 +368,7 @@ func (fd *FileDownloader) createDownloadTasks() {
 				rangeEnd:   end,
 			})
 		}
+		fd.loadState()
 	} else {
 		fd.totalTasks = 1
 		rangeEnd := int64(-1)
@@ -243,23 +388,62 @@ func (fd *FileDownloader) startDownload() error {
 	progressChan := make(chan ProgressChan, len(fd.DownloadTaskList))
 	errorChan := make(chan error, len(fd.DownloadTaskList))
 
-	for _, task := range fd.DownloadTaskList {
+	fd.spawn = func(task *DownloadTask) {
 		wg.Add(1)
 		go fd.startDownloadTask(wg, progressChan, errorChan, task)
 	}
+	fd.adaptive = nil
+	if globalConfig.AdaptiveChunks && fd.IsMultiPart && fd.TotalSize > 0 {
+		fd.adaptive = newAdaptiveState(len(fd.DownloadTaskList))
+	}
+	fd.tasksMux.Lock()
+	for _, task := range fd.DownloadTaskList {
+		task.active = true
+		fd.spawn(task)
+	}
+	fd.tasksMux.Unlock()
 
 	go func() {
-		taskProgress := make([]int64, len(fd.DownloadTaskList))
+		taskProgress := make(map[int]int64)
 		totalDownloaded := int64(0)
+		fd.tasksMux.Lock()
+		for i, task := range fd.DownloadTaskList {
+			taskProgress[i] = atomic.LoadInt64(&task.downloadedSize)
+			totalDownloaded += taskProgress[i]
+		}
+		fd.tasksMux.Unlock()
+		lastSave := time.Now()
+		lastDiskCheck := time.Now()
 
 		for progress := range progressChan {
 			taskProgress[progress.taskID] += progress.bytes
 			totalDownloaded += progress.bytes
+			if time.Since(lastSave) > time.Second {
+				fd.saveState()
+				lastSave = time.Now()
+			}
+			// stop before the disk is full, the part file stays for resuming
+			if time.Since(lastDiskCheck) > 5*time.Second {
+				lastDiskCheck = time.Now()
+				remaining := int64(0)
+				if fd.TotalSize > 0 {
+					remaining = fd.TotalSize - totalDownloaded
+				}
+				if err := checkDiskSpace(filepath.Dir(fd.FileName), remaining); err != nil && fd.stopErr.Load() == nil {
+					fd.stopErr.Store(err.(*DiskSpaceError))
+					fd.cancelFunc()
+				}
+			}
+
+			fd.adaptiveTick(totalDownloaded)
 
 			if fd.progressCallback != nil {
 				taskPercentage := float64(0)
-				if task := fd.DownloadTaskList[progress.taskID]; task != nil {
-					taskSize := task.rangeEnd - task.rangeStart + 1
+				fd.tasksMux.Lock()
+				task := fd.DownloadTaskList[progress.taskID]
+				fd.tasksMux.Unlock()
+				if task != nil {
+					taskSize := taskEnd(task) - task.rangeStart + 1
 					if taskSize > 0 {
 						taskPercentage = float64(taskProgress[progress.taskID]) / float64(taskSize) * 100
 					}
@@ -281,6 +465,13 @@ func (fd *FileDownloader) startDownload() error {
 	}
 
 	if len(errArr) > 0 {
+		fd.saveState()
+		if stopErr := fd.stopErr.Load(); stopErr != nil {
+			return stopErr
+		}
+		if fd.ctx.Err() != nil {
+			return messageError(MsgDownloadCancelled)
+		}
 		if !fd.RetryOnError && fd.IsMultiPart {
 			// 降级
 			fd.RetryOnError = true
@@ -290,7 +481,7 @@ func (fd *FileDownloader) startDownload() error {
 			fd.createDownloadTasks()
 			return fd.startDownload()
 		}
-		return fmt.Errorf("download failed with %d errors: %v", len(errArr), errArr[0])
+		return fmt.Errorf("download failed with %d errors: %w", len(errArr), errArr[0])
 	}
 
 	if err := fd.verifyDownload(); err != nil {
@@ -307,34 +498,40 @@ func (fd *FileDownloader) startDownloadTask(wg *sync.WaitGroup, progressChan cha
 		err := fd.doDownloadTask(progressChan, task)
 		if err == nil {
 			task.isCompleted = true
+			fd.taskFinished(task)
 			return
 		}
 
-		if strings.Contains(err.Error(), "cancelled") {
+		switch classifyDownloadError(err) {
+		case ErrorKindCancelled:
 			errorChan <- err
 			return
+		case ErrorKindFatal:
+			errorChan <- fmt.Errorf("task %d failed: %w", task.taskID, err)
+			return
 		}
+		fd.taskThrottled()
 
 		task.err = err
-		globalLogger.Warn().Msgf("Task %d failed (attempt %d/%d): %v", task.taskID, retries+1, MaxRetries, err)
+		globalLogger.Module("downloader").Warn().Msgf("Task %d failed (attempt %d/%d): %v", task.taskID, retries+1, MaxRetries, err)
 
 		if retries < MaxRetries-1 {
 			select {
 			case <-fd.ctx.Done():
 				errorChan <- fmt.Errorf("task %d cancelled during retry", task.taskID)
 				return
-			case <-time.After(RetryDelay):
+			case <-time.After(retryDelay(retries)):
 			}
 		}
 	}
 
-	errorChan <- fmt.Errorf("task %d failed after %d attempts: %v", task.taskID, MaxRetries, task.err)
+	errorChan <- fmt.Errorf("task %d failed after %d attempts: %w", task.taskID, MaxRetries, task.err)
 }
 
 func (fd *FileDownloader) doDownloadTask(progressChan chan ProgressChan, task *DownloadTask) error {
 	select {
 	case <-fd.ctx.Done():
-		return fmt.Errorf("download cancelled")
+		return messageError(MsgDownloadCancelled)
 	default:
 	}
 
@@ -344,46 +541,61 @@ func (fd *FileDownloader) doDownloadTask(progressChan chan ProgressChan, task *D
 	}
 	fd.setHeaders(request)
 
+	if !fd.IsMultiPart {
+		// a plain GET always starts from the beginning
+		atomic.StoreInt64(&task.downloadedSize, 0)
+	}
+
 	if fd.IsMultiPart {
+		if task.rangeStart+task.downloadedSize > taskEnd(task) {
+			return nil
+		}
 		rangeStart := task.rangeStart + task.downloadedSize
-		rangeHeader := fmt.Sprintf("bytes=%d-%d", rangeStart, task.rangeEnd)
+		rangeHeader := fmt.Sprintf("bytes=%d-%d", rangeStart, taskEnd(task))
 		request.Header.Set("Range", rangeHeader)
 	}
 
-	client := fd.buildClient()
-	resp, err := client.Do(request)
+	resp, err := fd.httpClient().Do(request)
 	if err != nil {
 		return fmt.Errorf("send request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if fd.IsMultiPart && resp.StatusCode != http.StatusPartialContent {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return &StatusError{Code: resp.StatusCode}
+		}
 		return fmt.Errorf("server does not support range requests, status: %d", resp.StatusCode)
 	} else if !fd.IsMultiPart && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return &StatusError{Code: resp.StatusCode}
 	}
 
+	body := newLimitedReader(fd.ctx, resp.Body, fd.limiter, speedLimitOnce)
 	buf := make([]byte, 32*1024)
 	for {
 		select {
 		case <-fd.ctx.Done():
-			return fmt.Errorf("download cancelled")
+			return messageError(MsgDownloadCancelled)
 		default:
 		}
 
-		n, err := resp.Body.Read(buf)
+		n, err := body.Read(buf)
 		if n > 0 {
 			writeSize := int64(n)
 			offset := task.rangeStart + task.downloadedSize
+			// the range may have been split while this response was streaming
+			if end := taskEnd(task); fd.TotalSize > 0 && offset+writeSize-1 > end {
+				writeSize = max(end-offset+1, 0)
+			}
 			_, writeErr := fd.File.WriteAt(buf[:writeSize], offset)
 			if writeErr != nil {
 				return fmt.Errorf("write file failed at offset %d: %w", offset, writeErr)
 			}
 
-			task.downloadedSize += writeSize
+			atomic.AddInt64(&task.downloadedSize, writeSize)
 			progressChan <- ProgressChan{taskID: task.taskID, bytes: writeSize}
 
-			if fd.TotalSize > 0 && task.rangeStart+task.downloadedSize-1 >= task.rangeEnd {
+			if fd.TotalSize > 0 && task.rangeStart+task.downloadedSize-1 >= taskEnd(task) {
 				return nil
 			}
 		}
@@ -420,15 +632,54 @@ func (fd *FileDownloader) Start() error {
 	}
 	fd.createDownloadTasks()
 
+	if fd.TotalSize > 0 {
+		need := fd.TotalSize
+		for _, task := range fd.DownloadTaskList {
+			need -= task.downloadedSize
+		}
+		if err := checkDiskSpace(filepath.Dir(fd.FileName), need); err != nil {
+			fd.File.Close()
+			return err
+		}
+	}
+
 	err := fd.startDownload()
 
 	if fd.File != nil {
 		fd.File.Close()
 	}
+	if err == nil {
+		_ = os.Remove(fd.stateName())
+		if globalConfig.VerifyDownload {
+			// the part file is preallocated, so count what was actually written
+			written := int64(0)
+			for _, task := range fd.DownloadTaskList {
+				written += atomic.LoadInt64(&task.downloadedSize)
+			}
+			if fd.TotalSize > 0 && written != fd.TotalSize {
+				err = &IntegrityError{Reason: fmt.Sprintf("received %d bytes, expected %d", written, fd.TotalSize)}
+			} else {
+				fd.Sha256, err = verifyFile(fd.partName, fd.Checksums)
+			}
+			if err != nil {
+				// a corrupted file can't be resumed, start over next time
+				_ = os.Remove(fd.partName)
+				return err
+			}
+		}
+		err = commitPart(fd.partName, fd.FileName, fd.Finalize)
+	}
 
 	return err
 }
 
+// Pause stops the download but keeps the part file and its state, a new downloader for the same path resumes it
+func (fd *FileDownloader) Pause() {
+	if fd.cancelFunc != nil {
+		fd.cancelFunc()
+	}
+}
+
 func (fd *FileDownloader) Cancel() {
 	if fd.cancelFunc != nil {
 		fd.cancelFunc()
@@ -438,7 +689,8 @@ func (fd *FileDownloader) Cancel() {
 		fd.File.Close()
 	}
 
-	if fd.FileName != "" {
-		_ = os.Remove(fd.FileName)
+	if fd.partName != "" {
+		_ = os.Remove(fd.partName)
+		_ = os.Remove(fd.stateName())
 	}
 }
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	DuplicateOverwrite = "overwrite"
+	DuplicateSkip      = "skip"
+	DuplicateRename    = "rename"
+	DuplicateHardlink  = "hardlink"
+)
+
+// what to do with a download whose content was downloaded before, see contentPolicy
+const (
+	ContentOff      = "off"
+	ContentKeep     = "keep"
+	ContentDelete   = "delete"
+	ContentHardlink = "hardlink"
+)
+
+var contentIndexMux sync.Mutex
+
+func contentIndexFile() string {
+	return filepath.Join(appOnce.UserDir, "contents.json")
+}
+
+// duplicatePolicy returns Config.DuplicatePolicy, unknown values fall back to rename so nothing is lost
+func duplicatePolicy() string {
+	switch globalConfig.DuplicatePolicy {
+	case DuplicateOverwrite, DuplicateSkip, DuplicateHardlink:
+		return globalConfig.DuplicatePolicy
+	}
+	return DuplicateRename
+}
+
+// resolveExistingName applies the policy when savePath is already taken. It returns the path to
+// download to, or with a remark the path the task ends at when the download should not run at all.
+// The hardlink policy links the existing file under a free name, and downloads to that name
+// instead when linking fails, e.g. across volumes.
+func resolveExistingName(savePath string) (string, string) {
+	if _, err := os.Stat(savePath); err != nil {
+		return savePath, ""
+	}
+	switch duplicatePolicy() {
+	case DuplicateOverwrite:
+		return savePath, ""
+	case DuplicateSkip:
+		return savePath, "skipped: file already exists"
+	case DuplicateHardlink:
+		linkPath := freeFileName(savePath)
+		if err := os.Link(savePath, linkPath); err != nil {
+			globalLogger.Warn().Msgf("hardlink %s failed: %v", savePath, err)
+			return linkPath, ""
+		}
+		return linkPath, "hardlinked to " + savePath
+	}
+	return freeFileName(savePath), ""
+}
+
+// freeFileName returns "name (n).ext" for the first n that is not taken
+func freeFileName(savePath string) string {
+	ext := filepath.Ext(savePath)
+	base := strings.TrimSuffix(savePath, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			if _, err := os.Stat(candidate + partSuffix); os.IsNotExist(err) {
+				return candidate
+			}
+		}
+	}
+}
+
+// DuplicateContent is a finished download whose content was downloaded before
+type DuplicateContent struct {
+	Id      string `json:"Id"`
+	Path    string `json:"Path"`
+	Of      string `json:"Of"`      // the earlier file
+	Similar bool   `json:"Similar"` // an image that looks or a video that sounds the same, not the same bytes
+	Action  string `json:"Action"`  // keep, delete or hardlink
+}
+
+// contentPolicy returns Config.ContentDuplicate, off unless it is set: hashing every download is
+// a cost only those who want it pay
+func contentPolicy() string {
+	switch globalConfig.ContentDuplicate {
+	case ContentKeep, ContentDelete, ContentHardlink:
+		return globalConfig.ContentDuplicate
+	}
+	return ContentOff
+}
+
+// dedupeContent looks for an earlier download with identical content. With the delete policy the
+// new file is removed and the existing path returned, with hardlink the new file becomes a link
+// to it, with keep it is only flagged. Images that merely look the same and, with
+// Config.AudioFingerprint, videos and audio that sound the same are always only flagged.
+// It returns nil when the content is new. sum is the sha256 of the file when already known, it is
+// returned for the history, hashed here if the policy needed it.
+func dedupeContent(savePath, sum string) (string, string, *DuplicateContent) {
+	policy := contentPolicy()
+	if policy == ContentOff {
+		return savePath, sum, nil
+	}
+	if sum == "" {
+		var err error
+		if sum, err = fileSha256(savePath); err != nil {
+			return savePath, "", nil
+		}
+	}
+
+	contentIndexMux.Lock()
+	defer contentIndexMux.Unlock()
+	index := map[string]string{}
+	if data, err := os.ReadFile(contentIndexFile()); err == nil {
+		_ = json.Unmarshal(data, &index)
+	}
+
+	existing, ok := index[sum]
+	if !ok || existing == savePath || !sameContent(existing, savePath, sum) {
+		index[sum] = savePath
+		saveContentIndex(index)
+		if similar := similarImage(savePath); similar != "" {
+			return savePath, sum, &DuplicateContent{Path: savePath, Of: similar, Similar: true, Action: ContentKeep}
+		}
+		if similar := similarAudio(savePath); similar != "" {
+			return savePath, sum, &DuplicateContent{Path: savePath, Of: similar, Similar: true, Action: ContentKeep}
+		}
+		return savePath, sum, nil
+	}
+
+	duplicate := &DuplicateContent{Path: savePath, Of: existing, Action: ContentKeep}
+	switch policy {
+	case ContentDelete:
+		if err := os.Remove(savePath); err != nil {
+			return savePath, sum, duplicate
+		}
+		duplicate.Path, duplicate.Action = existing, ContentDelete
+		return existing, sum, duplicate
+	case ContentHardlink:
+		tmp := savePath + ".link"
+		if err := os.Link(existing, tmp); err != nil {
+			globalLogger.Warn().Msgf("hardlink %s failed: %v", existing, err)
+			return savePath, sum, duplicate
+		}
+		if err := os.Rename(tmp, savePath); err != nil {
+			_ = os.Remove(tmp)
+			return savePath, sum, duplicate
+		}
+		duplicate.Action = ContentHardlink
+	}
+	return savePath, sum, duplicate
+}
+
+// message is what the download reports for it
+func (d *DuplicateContent) message() string {
+	switch {
+	case d.Similar && fingerprintable(d.Path):
+		return "complete, sounds the same as " + d.Of
+	case d.Similar:
+		return "complete, looks the same as " + d.Of
+	case d.Action == ContentDelete:
+		return "skipped: same content as " + d.Of
+	case d.Action == ContentHardlink:
+		return "hardlinked to " + d.Of
+	}
+	return "complete, same content as " + d.Of
+}
+
+// sameContent guards against a stale index entry whose file was replaced since, sum is the sha256 of b
+func sameContent(a, b, sum string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA != nil || errB != nil || infoA.Size() != infoB.Size() {
+		return false
+	}
+	sumA, err := fileSha256(a)
+	return err == nil && sumA == sum
+}
+
+func saveContentIndex(index map[string]string) {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(contentIndexFile(), data, 0644); err != nil {
+		globalLogger.Esg(err, "save content index failed")
+	}
+}
+
+func fileSha256(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,530 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// External plugins live in UserDir/plugins/<name>/ with a plugin.json manifest. Each one is a separate
+// process that reads one JSON request per line on stdin and answers one JSON line per request on stdout:
+//
+//	-> {"id":1,"method":"response","params":{"url":"...","status":200,"contentType":"...","headers":{},"body":"<base64>"}}
+//	<- {"id":1,"result":{"resources":[{"url":"...","classify":"video","suffix":".mp4","description":"..."}]}}
+//
+// Methods are "response" (a proxied response on one of its domains), "resolve" (a share page) and
+// "decrypt" (a finished part file, "params":{"file":"...","resource":{...}}). See docs/plugins.md.
+const (
+	pluginManifestName  = "plugin.json"
+	pluginCallTimeout   = 10 * time.Second
+	pluginDecryptTimout = 10 * time.Minute
+	pluginDefaultBody   = 2 * 1024 * 1024
+	pluginMaxBody       = 16 * 1024 * 1024
+	pluginMaxPending    = 64 * 1024 * 1024 // bodies waiting for all plugins at once, more are skipped
+	pluginMaxResources  = 100
+	pluginMaxFailures   = 3
+	pluginBackoff       = time.Minute
+)
+
+type pluginManifest struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"` // program and arguments, a relative program is looked up in the plugin directory
+	Domains []string `json:"domains"` // top-level domains or patterns as in Config.PlatformDomains
+	Hooks   []string `json:"hooks"`   // response, resolve, decrypt
+	MaxBody int64    `json:"maxBody"` // largest response body handed over, bytes
+}
+
+// pluginResource is what a plugin reports, core turns it into a MediaInfo
+type pluginResource struct {
+	Url         string            `json:"url"`
+	Classify    string            `json:"classify"`
+	Suffix      string            `json:"suffix"`
+	Description string            `json:"description"`
+	Cover       string            `json:"cover"`
+	Size        float64           `json:"size"`
+	Headers     map[string]string `json:"headers"`
+	OtherData   map[string]string `json:"otherData"`
+	Decrypt     bool              `json:"decrypt"` // the file needs this plugin's decrypt once downloaded
+}
+
+// pluginPending is the size of the bodies handed to plugins that haven't answered yet
+var pluginPending atomic.Int64
+
+type pluginReply struct {
+	Id     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// PluginStatus is shown in the settings page
+type PluginStatus struct {
+	Name     string   `json:"Name"`
+	Domains  []string `json:"Domains"`
+	Hooks    []string `json:"Hooks"`
+	Running  bool     `json:"Running"`
+	Failures int      `json:"Failures"`
+	LastErr  string   `json:"LastErr"`
+}
+
+// ExternalPlugin adapts a plugin process to shared.Plugin and shared.Resolver.
+// Calls are serialized, a plugin that hangs or crashes is killed and restarted on the next call,
+// and one that keeps failing is left alone for a while.
+type ExternalPlugin struct {
+	manifest pluginManifest
+	dir      string
+	bridge   *shared.Bridge
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	lines    chan []byte
+	nextId   int
+	failures int
+	lastErr  string
+	retryAt  time.Time
+}
+
+type ExternalPlugins struct {
+	plugins []*ExternalPlugin
+}
+
+func initExternalPlugins() *ExternalPlugins {
+	if externalPluginsOnce == nil {
+		externalPluginsOnce = &ExternalPlugins{}
+		if globalConfig.ExternalPlugins {
+			externalPluginsOnce.load(filepath.Join(appOnce.UserDir, "plugins"))
+		}
+	}
+	return externalPluginsOnce
+}
+
+// load reads the manifests and registers the plugins, built-in plugins keep their domains.
+// It only runs at startup, before the proxy serves, so the registry needs no lock.
+func (e *ExternalPlugins) load(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, pluginManifestName))
+		if err != nil {
+			continue
+		}
+		var manifest pluginManifest
+		if err := json.Unmarshal(data, &manifest); err != nil || len(manifest.Command) == 0 {
+			globalLogger.Warn().Msgf("invalid plugin manifest in %s", dir)
+			continue
+		}
+		if manifest.Name == "" {
+			manifest.Name = entry.Name()
+		}
+		if manifest.MaxBody <= 0 {
+			manifest.MaxBody = pluginDefaultBody
+		}
+		if manifest.MaxBody > pluginMaxBody {
+			manifest.MaxBody = pluginMaxBody
+		}
+		p := &ExternalPlugin{manifest: manifest, dir: dir}
+		p.SetBridge(proxyBridge)
+		for _, domain := range p.Domains() {
+			if isDomainPattern(domain) {
+				continue
+			}
+			if _, taken := pluginRegistry[domain]; taken {
+				globalLogger.Warn().Msgf("plugin %s: domain %s is already handled", manifest.Name, domain)
+				continue
+			}
+			pluginRegistry[domain] = p
+		}
+		pluginList = append(pluginList, p)
+		e.plugins = append(e.plugins, p)
+		globalLogger.Info().Msgf("loaded plugin %s for %v", manifest.Name, manifest.Domains)
+	}
+	if len(e.plugins) > 0 {
+		loadPluginPatterns(pluginList, withRulePacks("platforms", globalConfig.PlatformDomains))
+	}
+}
+
+func (e *ExternalPlugins) get(name string) *ExternalPlugin {
+	for _, p := range e.plugins {
+		if p.manifest.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (e *ExternalPlugins) status() []PluginStatus {
+	list := make([]PluginStatus, 0, len(e.plugins))
+	for _, p := range e.plugins {
+		p.mu.Lock()
+		list = append(list, PluginStatus{
+			Name:     p.manifest.Name,
+			Domains:  p.manifest.Domains,
+			Hooks:    p.manifest.Hooks,
+			Running:  p.cmd != nil,
+			Failures: p.failures,
+			LastErr:  p.lastErr,
+		})
+		p.mu.Unlock()
+	}
+	return list
+}
+
+func (e *ExternalPlugins) stop() {
+	for _, p := range e.plugins {
+		p.mu.Lock()
+		p.kill()
+		p.mu.Unlock()
+	}
+}
+
+func (p *ExternalPlugin) SetBridge(bridge *shared.Bridge) {
+	p.bridge = bridge
+}
+
+func (p *ExternalPlugin) Domains() []string {
+	return p.manifest.Domains
+}
+
+func (p *ExternalPlugin) hooks(name string) bool {
+	for _, hook := range p.manifest.Hooks {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ExternalPlugin) OnRequest(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	return nil, nil
+}
+
+// OnResponse hands small bodies to the plugin in the background, the client gets the response unchanged.
+// Returning nil lets the default plugin still capture plain media on the same domains.
+func (p *ExternalPlugin) OnResponse(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	if !p.hooks("response") || resp.Body == nil || resp.ContentLength > p.manifest.MaxBody || streamingResponse(resp) {
+		return nil
+	}
+	if pluginPending.Load()+p.manifest.MaxBody > pluginMaxPending {
+		return nil
+	}
+	if classify, _ := p.bridge.TypeSuffix(resp.Header.Get("Content-Type")); classify != "" && classify != "html" && classify != "json" {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.manifest.MaxBody+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	if err != nil || int64(len(data)) > p.manifest.MaxBody {
+		return nil
+	}
+	if data = decodeContent(resp.Header.Get("Content-Encoding"), data, p.manifest.MaxBody); data == nil {
+		return nil
+	}
+
+	params := map[string]interface{}{
+		"url":         resp.Request.URL.String(),
+		"status":      resp.StatusCode,
+		"contentType": resp.Header.Get("Content-Type"),
+		"headers":     flattenHeader(resp.Header),
+		"body":        base64.StdEncoding.EncodeToString(data),
+	}
+	requestHeaders := resp.Request.Header.Clone()
+	pageDomain := shared.GetTopLevelDomain(resp.Request.URL.String())
+	size := int64(len(data))
+	pluginPending.Add(size)
+	go func() {
+		list, err := p.extract("response", params, pageDomain, requestHeaders)
+		pluginPending.Add(-size)
+		if err != nil {
+			globalLogger.Warn().Msgf("plugin %s: %v", p.manifest.Name, err)
+			return
+		}
+		for _, res := range list {
+			if p.bridge.MediaIsMarked(res.UrlSign) {
+				continue
+			}
+			p.bridge.MarkMedia(res.UrlSign)
+			p.bridge.Send("newResources", res)
+		}
+	}()
+	return nil
+}
+
+func (p *ExternalPlugin) Resolve(pageUrl string, body []byte) ([]shared.MediaInfo, error) {
+	if !p.hooks("resolve") {
+		return nil, nil
+	}
+	return p.extract("resolve", map[string]interface{}{
+		"url":  pageUrl,
+		"body": base64.StdEncoding.EncodeToString(body),
+	}, shared.GetTopLevelDomain(pageUrl), nil)
+}
+
+// decrypt lets the plugin rewrite a finished part file in place
+func (p *ExternalPlugin) decrypt(file string, mediaInfo shared.MediaInfo) error {
+	_, err := p.call("decrypt", map[string]interface{}{
+		"file":     file,
+		"resource": mediaInfo,
+	}, pluginDecryptTimout)
+	return err
+}
+
+func (p *ExternalPlugin) extract(method string, params map[string]interface{}, domain string, headers http.Header) ([]shared.MediaInfo, error) {
+	raw, err := p.call(method, params, pluginCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Resources []pluginResource `json:"resources"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return nil, fmt.Errorf("invalid result: %w", err)
+		}
+	}
+	if len(result.Resources) > pluginMaxResources {
+		result.Resources = result.Resources[:pluginMaxResources]
+	}
+
+	list := make([]shared.MediaInfo, 0, len(result.Resources))
+	for _, item := range result.Resources {
+		res, ok := p.mediaInfo(item, domain, headers)
+		if ok {
+			list = append(list, res)
+		}
+	}
+	return list, nil
+}
+
+// mediaInfo only accepts http(s) urls, a plugin can't point downloads at local files
+func (p *ExternalPlugin) mediaInfo(item pluginResource, domain string, headers http.Header) (shared.MediaInfo, bool) {
+	u, err := url.Parse(strings.TrimSpace(item.Url))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return shared.MediaInfo{}, false
+	}
+	rawUrl := u.String()
+	classify := item.Classify
+	if classify == "" {
+		classify = "video"
+	}
+	suffix := item.Suffix
+	if suffix == "" {
+		suffix = filepath.Ext(u.Path)
+	}
+	urlSign := shared.Md5(rawUrl)
+	id, err := gonanoid.New()
+	if err != nil {
+		id = urlSign
+	}
+	res := shared.MediaInfo{
+		Id:          id,
+		Url:         rawUrl,
+		UrlSign:     urlSign,
+		CoverUrl:    item.Cover,
+		Size:        item.Size,
+		Domain:      domain,
+		Classify:    classify,
+		Suffix:      suffix,
+		Status:      shared.DownloadStatusReady,
+		Description: item.Description,
+		OtherData:   map[string]string{},
+	}
+	for key, value := range item.OtherData {
+		res.OtherData[key] = value
+	}
+	if headers == nil {
+		headers = http.Header{}
+	}
+	for key, value := range item.Headers {
+		headers.Set(key, value)
+	}
+	if len(headers) > 0 {
+		if data, err := json.Marshal(headers); err == nil {
+			res.OtherData["headers"] = string(data)
+		}
+	}
+	res.OtherData["plugin"] = p.manifest.Name
+	if item.Decrypt && p.hooks("decrypt") {
+		res.OtherData["plugin_decrypt"] = "1"
+	}
+	return res, true
+}
+
+func (p *ExternalPlugin) call(method string, params interface{}, timeout time.Duration) (json.RawMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Now().Before(p.retryAt) {
+		return nil, errors.New("plugin is backing off after repeated failures")
+	}
+	result, err := p.roundTrip(method, params, timeout)
+	if err != nil {
+		p.failures++
+		p.lastErr = err.Error()
+		if p.failures >= pluginMaxFailures {
+			p.retryAt = time.Now().Add(pluginBackoff)
+			p.failures = 0
+		}
+		return nil, err
+	}
+	p.failures = 0
+	return result, nil
+}
+
+func (p *ExternalPlugin) roundTrip(method string, params interface{}, timeout time.Duration) (json.RawMessage, error) {
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return nil, err
+		}
+	}
+	p.nextId++
+	id := p.nextId
+	request, err := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+		p.kill()
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				p.kill()
+				return nil, errors.New("plugin exited")
+			}
+			var reply pluginReply
+			if json.Unmarshal(line, &reply) != nil || reply.Id != id {
+				continue // stray output or a reply to a call that already timed out
+			}
+			if reply.Error != "" {
+				return nil, errors.New(reply.Error)
+			}
+			return reply.Result, nil
+		case <-timer.C:
+			p.kill()
+			return nil, fmt.Errorf("%s timed out after %s", method, timeout)
+		}
+	}
+}
+
+// start runs the plugin in its own directory with a minimal environment, its HOME and temp dir
+// point into the plugin directory so it keeps its state there rather than in the user's profile.
+// That only keeps our environment out of it, it is no isolation: the plugin runs with the user's
+// rights and can still read or change anything they can.
+func (p *ExternalPlugin) start() error {
+	program := p.manifest.Command[0]
+	if !filepath.IsAbs(program) && strings.ContainsAny(program, `/\`) {
+		program = filepath.Join(p.dir, program)
+	}
+	dataDir := filepath.Join(p.dir, "data")
+	if err := shared.CreateDirIfNotExist(dataDir); err != nil {
+		return err
+	}
+
+	cmd := backgroundCommand(program, p.manifest.Command[1:]...)
+	cmd.Dir = p.dir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dataDir,
+		"USERPROFILE=" + dataDir,
+		"TMPDIR=" + dataDir,
+		"TEMP=" + dataDir,
+		"TMP=" + dataDir,
+		"RES_DOWNLOADER_VERSION=" + p.bridge.GetVersion(),
+	}
+	if runtime.GOOS == "windows" {
+		cmd.Env = append(cmd.Env, "SYSTEMROOT="+os.Getenv("SYSTEMROOT"))
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	lines := make(chan []byte, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), pluginMaxBody)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+	}()
+	go func(name string) {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			globalLogger.Info().Msgf("plugin %s: %s", name, scanner.Text())
+		}
+	}(p.manifest.Name)
+
+	p.cmd, p.stdin, p.lines = cmd, stdin, lines
+	return nil
+}
+
+func (p *ExternalPlugin) kill() {
+	if p.cmd == nil {
+		return
+	}
+	_ = p.stdin.Close()
+	if p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+	}
+	go func(cmd *exec.Cmd, lines chan []byte) {
+		for range lines {
+		}
+		_ = cmd.Wait()
+	}(p.cmd, p.lines)
+	p.cmd, p.stdin, p.lines = nil, nil, nil
+}
+
+// pluginDecrypt runs the decrypt hook of the plugin that reported the resource, if it asked for one
+func pluginDecrypt(partName string, mediaInfo shared.MediaInfo) error {
+	if mediaInfo.OtherData["plugin_decrypt"] == "" {
+		return nil
+	}
+	p := externalPluginsOnce.get(mediaInfo.OtherData["plugin"])
+	if p == nil {
+		return fmt.Errorf("plugin %s is not loaded", mediaInfo.OtherData["plugin"])
+	}
+	return p.decrypt(partName, mediaInfo)
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	filenameVarRegex     = regexp.MustCompile(`\{(\w+)\}`)
+	filenameIllegalRegex = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+	filenameSpaceRegex   = regexp.MustCompile(`\s+`)
+)
+
+// platformNames turns the captured domain into a short folder friendly name, it is the one
+// domain to platform table: category dirs and sidecars read it too
+var platformNames = map[string]string{
+	"qq.com":          "wechat",
+	"douyin.com":      "douyin",
+	"douyinvod.com":   "douyin",
+	"kuaishou.com":    "kuaishou",
+	"bilibili.com":    "bilibili",
+	"xhscdn.com":      "xiaohongshu",
+	"xiaohongshu.com": "xiaohongshu",
+	"weibo.com":       "weibo",
+	"sinaimg.cn":      "weibo",
+}
+
+// templateSavePath renders Config.FilenameTemplate, e.g. "{platform}/{author}-{title}-{date}.{ext}",
+// {published} is the publish date and {likes} the like count the api responses gave, {series} and
+// {episode} name and number the episodes of a catalog queued with downloadSeries.
+// Every variable is sanitized on its own so only the template itself can create sub directories.
+func templateSavePath(mediaInfo shared.MediaInfo, template string) string {
+	savePath := filepath.Join(append([]string{saveDirectory(mediaInfo)}, templateParts(mediaInfo, template)...)...)
+	if mediaInfo.Suffix != "" && !strings.HasSuffix(savePath, mediaInfo.Suffix) {
+		savePath += mediaInfo.Suffix
+	}
+	return savePath
+}
+
+// templateParts renders template into its path elements, empty and ".." elements are dropped
+func templateParts(mediaInfo shared.MediaInfo, template string) []string {
+	now := time.Now()
+	ext := strings.TrimPrefix(mediaInfo.Suffix, ".")
+	titleLen := globalConfig.FilenameLen
+	if titleLen <= 0 {
+		titleLen = 10
+	}
+
+	title := mediaInfo.Description
+	if title == "" {
+		title = shared.GetFileNameFromURL(mediaInfo.Url)
+		title = strings.TrimSuffix(title, filepath.Ext(title))
+	}
+	platform := mediaInfo.Domain
+	if name, ok := platformNames[platform]; ok {
+		platform = name
+	}
+	host := ""
+	if u, err := url.Parse(mediaInfo.Url); err == nil {
+		host = u.Hostname()
+	}
+
+	published := ""
+	if unix, _ := strconv.ParseInt(mediaInfo.OtherData["publish_time"], 10, 64); unix > 0 {
+		published = time.Unix(unix, 0).Format("20060102")
+	}
+
+	values := map[string]string{
+		"platform":  platform,
+		"domain":    mediaInfo.Domain,
+		"host":      host,
+		"author":    mediaInfo.OtherData["author"],
+		"title":     truncateRunes(title, titleLen),
+		"classify":  mediaInfo.Classify,
+		"id":        mediaInfo.Id,
+		"hash":      shared.Md5(mediaInfo.Url)[:8],
+		"date":      now.Format("20060102"),
+		"time":      now.Format("150405"),
+		"published": published,
+		"likes":     mediaInfo.OtherData["likes"],
+		"series":    mediaInfo.OtherData["series"],
+		"episode":   mediaInfo.OtherData["episode"],
+		"ext":       ext,
+	}
+
+	rendered := filenameVarRegex.ReplaceAllStringFunc(template, func(v string) string {
+		value, ok := values[strings.ToLower(v[1:len(v)-1])]
+		if !ok {
+			return v
+		}
+		return sanitizeFileName(value)
+	})
+
+	var parts []string
+	for _, part := range strings.FieldsFunc(rendered, func(r rune) bool { return r == '/' || r == '\\' }) {
+		part = strings.Trim(part, " .-_")
+		if part == "" || part == ".." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		parts = []string{shared.Md5(mediaInfo.Url)}
+	}
+	return parts
+}
+
+func sanitizeFileName(name string) string {
+	name = filenameIllegalRegex.ReplaceAllString(name, "_")
+	name = filenameSpaceRegex.ReplaceAllString(name, " ")
+	return strings.TrimSpace(name)
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
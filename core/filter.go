@@ -0,0 +1,43 @@
+package core
+
+import (
+	"regexp"
+	"res-downloader/core/shared"
+	"strings"
+)
+
+// resourceFilter selects resources by type, domain and a regular expression on url or description,
+// empty fields match everything. It runs on captures, whether a resource is starred is a store query,
+// see ResourceQuery.
+type resourceFilter struct {
+	Classify string `json:"Type" yaml:"type"`
+	Domain   string `json:"Domain" yaml:"domain"`
+	Match    string `json:"Match" yaml:"match"`
+	match    *regexp.Regexp
+}
+
+func (f *resourceFilter) compile() (err error) {
+	f.match = nil
+	if f.Match != "" {
+		f.match, err = regexp.Compile(f.Match)
+	}
+	return err
+}
+
+func (f *resourceFilter) empty() bool {
+	return f.Classify == "" && f.Domain == "" && f.Match == ""
+}
+
+// matches needs compile to have run when Match is set
+func (f *resourceFilter) matches(res shared.MediaInfo) bool {
+	if f.Classify != "" && !strings.EqualFold(res.Classify, f.Classify) {
+		return false
+	}
+	if f.Domain != "" && !strings.Contains(res.Domain, f.Domain) {
+		return false
+	}
+	if f.match != nil && !f.match.MatchString(res.Url) && !f.match.MatchString(res.Description) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// tlsFingerprints maps Config.TlsFingerprint to the browser ClientHello that is mimicked,
+// an empty value keeps Go's own TLS stack
+var tlsFingerprints = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"ios":     utls.HelloIOS_Auto,
+	"random":  utls.HelloRandomizedALPN,
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// fingerprintDialTLS returns a DialTLSContext for http.Transport, nil when mimicry is off.
+// It is only used for direct connections, requests through an upstream proxy keep Go's TLS.
+func fingerprintDialTLS(dial dialFunc) dialFunc {
+	return fingerprintDialTLSAs(globalConfig.TlsFingerprint, dial)
+}
+
+func fingerprintDialTLSAs(name string, dial dialFunc) dialFunc {
+	helloID, ok := tlsFingerprints[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		spec, err := utls.UTLSIdToSpec(helloID)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		// http.Transport can't speak h2 over a non crypto/tls conn, so only offer http/1.1
+		for _, ext := range spec.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = []string{"http/1.1"}
+			}
+		}
+
+		uconn := utls.UClient(conn, &utls.Config{ServerName: host}, utls.HelloCustom)
+		if err := uconn.ApplyPreset(&spec); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("apply tls fingerprint failed: %w", err)
+		}
+		if err := uconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return uconn, nil
+	}
+}
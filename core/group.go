@@ -0,0 +1,188 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const (
+	// groupIdle is how long a page keeps its group open, later captures with the same Referer start a new one
+	groupIdle = 10 * time.Second
+	// groupMaxAge is how long after it opened a page group takes captures at all, so a page that never
+	// stops producing them doesn't swallow the rest of the session
+	groupMaxAge = 2 * time.Minute
+	// groupKeep is how long share link and api groups are remembered after their last capture
+	groupKeep = 30 * time.Minute
+	// groupUrlLimit bounds the remembered url -> group pairs covers are matched against
+	groupUrlLimit = 2000
+)
+
+// ResourceGroup is the resources one page, api call or share link produced, in capture order
+type ResourceGroup struct {
+	Id        string             `json:"Id"`
+	Page      string             `json:"Page"`
+	Resources []shared.MediaInfo `json:"Resources"`
+}
+
+type openGroup struct {
+	id     string
+	opened time.Time
+	last   time.Time
+}
+
+// takes reports whether the group of key still takes a capture made at now
+func (o *openGroup) takes(key string, now time.Time) bool {
+	if !strings.HasPrefix(key, "page:") {
+		return true
+	}
+	return now.Sub(o.last) < groupIdle && now.Sub(o.opened) < groupMaxAge
+}
+
+// Grouper correlates new captures into groups. What produced a resource decides, in this order:
+// the share link it was resolved from, the api response that lists it, the video it is the cover
+// of, and the page in its Referer while that page keeps producing captures.
+type Grouper struct {
+	mu     sync.Mutex
+	pages  map[string]*openGroup // correlation key -> group still taking captures
+	urls   map[string]string     // media or cover url -> group id
+	pruned time.Time
+}
+
+func newGrouper() *Grouper {
+	return &Grouper{
+		pages: make(map[string]*openGroup),
+		urls:  make(map[string]string),
+	}
+}
+
+// assign sets OtherData group and group_page of res, a resource nothing correlates stays ungrouped
+func (g *Grouper) assign(res *shared.MediaInfo) {
+	key, page := groupSource(*res)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	g.prune(now)
+	id := g.urls[res.Url]
+	if id == "" && res.CoverUrl != "" {
+		id = g.urls[res.CoverUrl]
+	}
+	if id == "" && key != "" {
+		if open, ok := g.pages[key]; ok && open.takes(key, now) {
+			id = open.id
+		}
+	}
+	if id == "" {
+		if key == "" {
+			return
+		}
+		var err error
+		if id, err = gonanoid.New(); err != nil {
+			id = shared.Md5(key + now.String())
+		}
+	}
+	if key != "" {
+		if open, ok := g.pages[key]; ok && open.id == id {
+			open.last = now
+		} else {
+			g.pages[key] = &openGroup{id: id, opened: now, last: now}
+		}
+	}
+	if len(g.urls) > groupUrlLimit {
+		g.urls = make(map[string]string)
+	}
+	g.urls[res.Url] = id
+	if res.CoverUrl != "" {
+		g.urls[res.CoverUrl] = id
+	}
+
+	if res.OtherData == nil {
+		res.OtherData = map[string]string{}
+	}
+	res.OtherData["group"] = id
+	if page != "" {
+		res.OtherData["group_page"] = page
+	}
+}
+
+// prune drops the page groups that closed and the share link and api groups idle past groupKeep,
+// at most once per groupIdle. Callers hold mu.
+func (g *Grouper) prune(now time.Time) {
+	if now.Sub(g.pruned) < groupIdle {
+		return
+	}
+	g.pruned = now
+	for key, open := range g.pages {
+		if !open.takes(key, now) || now.Sub(open.last) > groupKeep {
+			delete(g.pages, key)
+		}
+	}
+}
+
+func (g *Grouper) clear() {
+	g.mu.Lock()
+	g.pages = make(map[string]*openGroup)
+	g.urls = make(map[string]string)
+	g.mu.Unlock()
+}
+
+// groupSource is the correlation key of res and the page shown for its group
+func groupSource(res shared.MediaInfo) (string, string) {
+	if shareUrl := res.OtherData["share_url"]; shareUrl != "" {
+		return "share:" + shareUrl, shareUrl
+	}
+	if id := res.OtherData["preview_id"]; id != "" {
+		preview, _ := previewOnce.get(id)
+		return "api:" + id, preview.Url
+	}
+	var header http.Header
+	if raw := res.OtherData["headers"]; raw == "" || json.Unmarshal([]byte(raw), &header) != nil {
+		return "", ""
+	}
+	referer := header.Get("Referer")
+	u, err := url.Parse(referer)
+	// an origin-only Referer, what most sites send cross-origin, names the site rather than the page
+	if err != nil || u.Host == "" || (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		return "", ""
+	}
+	u.Fragment = ""
+	return "page:" + u.String(), u.String()
+}
+
+// group returns the stored resources of a group, oldest first
+func (r *Resource) group(id string) (ResourceGroup, error) {
+	list := r.store.grouped(id)
+	if len(list) == 0 {
+		return ResourceGroup{}, messageError(MsgGroupNotFound)
+	}
+	return ResourceGroup{Id: id, Page: list[0].OtherData["group_page"], Resources: list}, nil
+}
+
+// downloadGroup queues every resource of a group that is not downloaded yet as one batch
+func (r *Resource) downloadGroup(id string, priority int) (DownloadBatch, error) {
+	if globalConfig.SaveDirectory == "" {
+		return DownloadBatch{}, messageError(MsgSaveDirectoryUnset)
+	}
+	group, err := r.group(id)
+	if err != nil {
+		return DownloadBatch{}, err
+	}
+	items := make([]QueueItem, 0, len(group.Resources))
+	for _, res := range group.Resources {
+		if res.Status == shared.DownloadStatusDone || res.Status == shared.DownloadStatusRunning {
+			continue
+		}
+		items = append(items, QueueItem{MediaInfo: res})
+	}
+	if len(items) == 0 {
+		return DownloadBatch{}, messageError(MsgNoResources)
+	}
+	return batchOnce.start(items, priority), nil
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"res-downloader/core/shared"
+	controlv1 "res-downloader/proto/res_downloader/v1"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcServer is the optional gRPC control api of proto/res_downloader/v1/control.proto, the typed
+// counterpart of ApiServer. It listens on its own address and takes the same token, sent as
+// "authorization: Bearer <token>" metadata.
+type GrpcServer struct {
+	controlv1.UnimplementedControlServer
+	mu     sync.Mutex
+	server *grpc.Server
+	addr   string
+}
+
+func initGrpcServer() *GrpcServer {
+	if grpcServerOnce == nil {
+		grpcServerOnce = &GrpcServer{}
+	}
+	return grpcServerOnce
+}
+
+// apply starts, stops or moves the listener to match Config.GrpcEnable and Config.GrpcListen
+func (g *GrpcServer) apply() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	addr := strings.TrimSpace(globalConfig.GrpcListen)
+	if g.server != nil && (!globalConfig.GrpcEnable || addr != g.addr) {
+		// Stop rather than GracefulStop, the watch streams never end on their own
+		g.server.Stop()
+		g.server = nil
+	}
+	if !globalConfig.GrpcEnable || g.server != nil {
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		globalLogger.Esg(err, "start grpc server failed")
+		return
+	}
+	g.addr = addr
+	g.server = grpc.NewServer(grpc.UnaryInterceptor(g.unaryAuth), grpc.StreamInterceptor(g.streamAuth))
+	controlv1.RegisterControlServer(g.server, g)
+	go func(server *grpc.Server) {
+		if err := server.Serve(listener); err != nil {
+			globalLogger.Esg(err, "grpc server stopped")
+		}
+	}(g.server)
+	globalLogger.Info().Msgf("grpc server listening on %s", addr)
+}
+
+func (g *GrpcServer) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.server != nil {
+		g.server.Stop()
+		g.server = nil
+	}
+}
+
+func (g *GrpcServer) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	given := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		given = strings.TrimPrefix(values[0], "Bearer ")
+	}
+	if !validApiToken(given) {
+		return status.Error(codes.Unauthenticated, "invalid api token")
+	}
+	return nil
+}
+
+func (g *GrpcServer) unaryAuth(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := g.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (g *GrpcServer) streamAuth(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := g.authorize(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// grpcError turns an error into a status, with the code ApiServer answers the same error with
+func grpcError(err error, fallback codes.Code) error {
+	switch errorCode(err) {
+	case MsgResourceNotFound, MsgTaskNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case MsgSaveDirectoryUnset, MsgTaskNotPaused, MsgTaskNotQueued:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(fallback, err.Error())
+}
+
+func (g *GrpcServer) GetCaptureState(context.Context, *controlv1.Empty) (*controlv1.CaptureState, error) {
+	return &controlv1.CaptureState{SystemProxy: appOnce.IsProxy, Listeners: appOnce.Listeners}, nil
+}
+
+func (g *GrpcServer) SetSystemProxy(ctx context.Context, req *controlv1.SetSystemProxyRequest) (*controlv1.CaptureState, error) {
+	if err := setSystemProxy(req.GetEnabled()); err != nil {
+		return nil, grpcError(err, codes.Internal)
+	}
+	return g.GetCaptureState(ctx, nil)
+}
+
+func (g *GrpcServer) ClearResources(context.Context, *controlv1.Empty) (*controlv1.Empty, error) {
+	resourceOnce.clear()
+	return &controlv1.Empty{}, nil
+}
+
+func (g *GrpcServer) ListResources(_ context.Context, req *controlv1.ListResourcesRequest) (*controlv1.ListResourcesResponse, error) {
+	filter, err := grpcFilter(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &controlv1.ListResourcesResponse{}
+	for _, res := range resourceOnce.capturedList() {
+		if filter.matches(res) {
+			resp.Resources = append(resp.Resources, grpcMedia(res))
+		}
+	}
+	return resp, nil
+}
+
+// WatchResources streams the resources captured from now on that match, until the client leaves
+func (g *GrpcServer) WatchResources(req *controlv1.ListResourcesRequest, stream controlv1.Control_WatchResourcesServer) error {
+	filter, err := grpcFilter(req)
+	if err != nil {
+		return err
+	}
+	return g.watch(stream.Context(), func(kind string, data json.RawMessage) error {
+		var res shared.MediaInfo
+		if kind != "newResources" || json.Unmarshal(data, &res) != nil || !filter.matches(res) {
+			return nil
+		}
+		return stream.Send(grpcMedia(res))
+	})
+}
+
+func (g *GrpcServer) ListDownloads(context.Context, *controlv1.Empty) (*controlv1.Downloads, error) {
+	return grpcDownloads(), nil
+}
+
+// EnqueueDownload queues a captured resource by id, or whatever a url or share text resolves to
+func (g *GrpcServer) EnqueueDownload(_ context.Context, req *controlv1.EnqueueRequest) (*controlv1.ListResourcesResponse, error) {
+	if globalConfig.SaveDirectory == "" {
+		return nil, grpcError(messageError(MsgSaveDirectoryUnset), codes.FailedPrecondition)
+	}
+	var list []shared.MediaInfo
+	switch {
+	case req.GetId() != "":
+		res, ok := resourceOnce.store.get(req.GetId())
+		if !ok {
+			return nil, grpcError(messageError(MsgResourceNotFound), codes.NotFound)
+		}
+		list = append(list, res)
+	case req.GetUrl() != "":
+		var err error
+		if list, err = resourceOnce.resolveUrl(req.GetUrl()); err != nil {
+			return nil, grpcError(err, codes.InvalidArgument)
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "id or url is required")
+	}
+	resp := &controlv1.ListResourcesResponse{}
+	for _, res := range list {
+		resourceOnce.setAudioOnly(res.Id, req.GetAudioOnly())
+		queueOnce.push(res, "", int(req.GetPriority()))
+		resp.Resources = append(resp.Resources, grpcMedia(res))
+	}
+	return resp, nil
+}
+
+func (g *GrpcServer) CancelDownload(_ context.Context, req *controlv1.TaskRequest) (*controlv1.Empty, error) {
+	if err := resourceOnce.cancel(req.GetId()); err != nil {
+		return nil, grpcError(err, codes.NotFound)
+	}
+	return &controlv1.Empty{}, nil
+}
+
+func (g *GrpcServer) PauseDownload(_ context.Context, req *controlv1.TaskRequest) (*controlv1.Empty, error) {
+	if err := queueOnce.pause(req.GetId()); err != nil {
+		return nil, grpcError(err, codes.FailedPrecondition)
+	}
+	return &controlv1.Empty{}, nil
+}
+
+func (g *GrpcServer) ResumeDownload(_ context.Context, req *controlv1.TaskRequest) (*controlv1.Empty, error) {
+	if err := queueOnce.resume(req.GetId()); err != nil {
+		return nil, grpcError(err, codes.FailedPrecondition)
+	}
+	return &controlv1.Empty{}, nil
+}
+
+func (g *GrpcServer) SetQueuePaused(_ context.Context, req *controlv1.SetQueuePausedRequest) (*controlv1.Downloads, error) {
+	queueOnce.setPaused(req.GetPaused())
+	return grpcDownloads(), nil
+}
+
+// WatchProgress streams the speed and size of running tasks and every change of a task's status
+func (g *GrpcServer) WatchProgress(_ *controlv1.Empty, stream controlv1.Control_WatchProgressServer) error {
+	return g.watch(stream.Context(), func(kind string, data json.RawMessage) error {
+		switch kind {
+		case "downloadStats":
+			var stats ProgressStats
+			if json.Unmarshal(data, &stats) != nil {
+				return nil
+			}
+			return stream.Send(&controlv1.Progress{
+				Id:         stats.Id,
+				Unit:       stats.Unit,
+				Downloaded: stats.Downloaded,
+				Total:      stats.Total,
+				Speed:      stats.Speed,
+				Eta:        stats.Eta,
+				Status:     shared.DownloadStatusRunning,
+			})
+		case "downloadProgress":
+			var progress struct {
+				Id     string `json:"Id"`
+				Status string `json:"Status"`
+			}
+			if json.Unmarshal(data, &progress) != nil || progress.Id == "" {
+				return nil
+			}
+			return stream.Send(&controlv1.Progress{Id: progress.Id, Status: progress.Status, Eta: -1})
+		}
+		return nil
+	})
+}
+
+// watch hands the events the window gets to send until the client leaves or send fails. Like the
+// websocket clients, a stream that can't keep up misses events.
+func (g *GrpcServer) watch(ctx context.Context, send func(kind string, data json.RawMessage) error) error {
+	ch := httpServerOnce.subscribe()
+	defer httpServerOnce.unsubscribe(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-ch:
+			var message struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			if json.Unmarshal([]byte(event), &message) != nil {
+				continue
+			}
+			if err := send(message.Type, message.Data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func grpcFilter(req *controlv1.ListResourcesRequest) (*resourceFilter, error) {
+	filter := &resourceFilter{Classify: req.GetType(), Domain: req.GetDomain(), Match: req.GetMatch()}
+	if err := filter.compile(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return filter, nil
+}
+
+func grpcDownloads() *controlv1.Downloads {
+	downloads := &controlv1.Downloads{Running: queueOnce.runningIds(), Paused: queueOnce.isPaused()}
+	for _, item := range queueOnce.list() {
+		downloads.Queue = append(downloads.Queue, &controlv1.QueueItem{
+			MediaInfo: grpcMedia(item.MediaInfo),
+			Priority:  int32(item.Priority),
+			Paused:    item.Paused,
+		})
+	}
+	return downloads
+}
+
+func grpcMedia(res shared.MediaInfo) *controlv1.MediaInfo {
+	media := &controlv1.MediaInfo{
+		Id:          res.Id,
+		Url:         res.Url,
+		UrlSign:     res.UrlSign,
+		CoverUrl:    res.CoverUrl,
+		Size:        res.Size,
+		Domain:      res.Domain,
+		Classify:    res.Classify,
+		Suffix:      res.Suffix,
+		SavePath:    res.SavePath,
+		Status:      res.Status,
+		DecodeKey:   res.DecodeKey,
+		Description: res.Description,
+		ContentType: res.ContentType,
+		OtherData:   res.OtherData,
+	}
+	for _, variant := range res.Variants {
+		media.Variants = append(media.Variants, &controlv1.Variant{
+			Url:       variant.Url,
+			Label:     variant.Label,
+			Height:    int32(variant.Height),
+			Bandwidth: variant.Bandwidth,
+		})
+	}
+	return media
+}
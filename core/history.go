@@ -0,0 +1,229 @@
+package core
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type HistoryRecord struct {
+	Id       int64  `json:"Id"`
+	TaskId   string `json:"TaskId"`
+	Url      string `json:"Url"`
+	Title    string `json:"Title"`
+	Platform string `json:"Platform"`
+	Classify string `json:"Classify"`
+	SavePath string `json:"SavePath"`
+	Size     int64  `json:"Size"`
+	Status   string `json:"Status"`
+	Message  string `json:"Message"`
+	Checksum string `json:"Checksum"`
+	Time     int64  `json:"Time"`
+}
+
+type HistoryQuery struct {
+	Platform string `json:"platform"`
+	Status   string `json:"status"`
+	Keyword  string `json:"keyword"`
+	Start    int64  `json:"start"` // unix seconds, inclusive
+	End      int64  `json:"end"`   // unix seconds, exclusive
+	Page     int    `json:"page"`
+	PageSize int    `json:"pageSize"`
+}
+
+// History persists finished and failed downloads in UserDir/history.db
+type History struct {
+	db      *sql.DB
+	running sync.Map // task id -> *liveAttempt
+}
+
+func initHistory() *History {
+	if historyOnce == nil {
+		historyOnce = &History{}
+		db, err := sql.Open("sqlite", filepath.Join(appOnce.UserDir, "history.db"))
+		if err == nil {
+			// a single connection serializes writers, sqlite would answer SQLITE_BUSY otherwise
+			db.SetMaxOpenConns(1)
+			_, err = db.Exec(`CREATE TABLE IF NOT EXISTS downloads (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				task_id TEXT NOT NULL,
+				url TEXT NOT NULL,
+				title TEXT NOT NULL DEFAULT '',
+				platform TEXT NOT NULL DEFAULT '',
+				classify TEXT NOT NULL DEFAULT '',
+				save_path TEXT NOT NULL DEFAULT '',
+				size INTEGER NOT NULL DEFAULT 0,
+				status TEXT NOT NULL,
+				message TEXT NOT NULL DEFAULT '',
+				checksum TEXT NOT NULL DEFAULT '',
+				created_at INTEGER NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS idx_downloads_platform ON downloads(platform);
+			CREATE INDEX IF NOT EXISTS idx_downloads_created ON downloads(created_at);
+			CREATE TABLE IF NOT EXISTS attempts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				task_id TEXT NOT NULL,
+				platform TEXT NOT NULL DEFAULT '',
+				classify TEXT NOT NULL DEFAULT '',
+				attempt INTEGER NOT NULL,
+				started_at INTEGER NOT NULL,
+				duration_ms INTEGER NOT NULL,
+				bytes INTEGER NOT NULL DEFAULT 0,
+				avg_speed INTEGER NOT NULL DEFAULT 0,
+				peak_speed INTEGER NOT NULL DEFAULT 0,
+				status TEXT NOT NULL,
+				error TEXT NOT NULL DEFAULT '',
+				error_kind TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX IF NOT EXISTS idx_attempts_task ON attempts(task_id);
+			CREATE INDEX IF NOT EXISTS idx_attempts_started ON attempts(started_at);`)
+		}
+		if err != nil {
+			globalLogger.Esg(err, "open download history failed")
+			return historyOnce
+		}
+		historyOnce.db = db
+	}
+	return historyOnce
+}
+
+// record stores the outcome of a task, size and checksum are taken from the file in the background
+func (h *History) record(mediaInfo shared.MediaInfo, status, message string) {
+	h.recordChecksum(mediaInfo, status, message, "")
+}
+
+// recordChecksum is record with the sha256 of a finished download when it is already known
+func (h *History) recordChecksum(mediaInfo shared.MediaInfo, status, message, checksum string) {
+	// every task ends here, whichever way it went
+	metricsOnce.download(status)
+	if status == shared.DownloadStatusDone {
+		fireWebhooks(WebhookDone, mediaInfo, message)
+		telegramOnce.notify(mediaInfo, status, message)
+	} else if status == shared.DownloadStatusError {
+		fireWebhooks(WebhookError, mediaInfo, message)
+		telegramOnce.notify(mediaInfo, status, message)
+	}
+	if h.db == nil {
+		return
+	}
+	go func() {
+		var size int64
+		if status == shared.DownloadStatusDone {
+			if info, err := os.Stat(mediaInfo.SavePath); err == nil {
+				size = info.Size()
+				if checksum == "" {
+					checksum, _ = fileSha256(mediaInfo.SavePath)
+				}
+			}
+		}
+		_, err := h.db.Exec(`INSERT INTO downloads (task_id, url, title, platform, classify, save_path, size, status, message, checksum, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			mediaInfo.Id, mediaInfo.Url, mediaInfo.Description, mediaInfo.Domain, mediaInfo.Classify,
+			mediaInfo.SavePath, size, status, message, checksum, time.Now().Unix())
+		if err != nil {
+			globalLogger.Esg(err, "save download history failed")
+		}
+	}()
+}
+
+func (h *History) query(q HistoryQuery) ([]HistoryRecord, int, error) {
+	list := make([]HistoryRecord, 0)
+	if h.db == nil {
+		return list, 0, nil
+	}
+
+	var where []string
+	var args []interface{}
+	if q.Platform != "" {
+		where = append(where, "platform = ?")
+		args = append(args, q.Platform)
+	}
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, q.Status)
+	}
+	if q.Start > 0 {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Start)
+	}
+	if q.End > 0 {
+		where = append(where, "created_at < ?")
+		args = append(args, q.End)
+	}
+	if q.Keyword != "" {
+		where = append(where, "(title LIKE ? OR url LIKE ? OR save_path LIKE ?)")
+		like := "%" + q.Keyword + "%"
+		args = append(args, like, like, like)
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM downloads"+clause, args...).Scan(&total); err != nil {
+		return list, 0, err
+	}
+
+	if q.PageSize <= 0 || q.PageSize > 500 {
+		q.PageSize = 50
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	rows, err := h.db.Query(`SELECT id, task_id, url, title, platform, classify, save_path, size, status, message, checksum, created_at
+		FROM downloads`+clause+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, q.PageSize, (q.Page-1)*q.PageSize)...)
+	if err != nil {
+		return list, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item HistoryRecord
+		if err := rows.Scan(&item.Id, &item.TaskId, &item.Url, &item.Title, &item.Platform, &item.Classify,
+			&item.SavePath, &item.Size, &item.Status, &item.Message, &item.Checksum, &item.Time); err != nil {
+			return list, 0, err
+		}
+		list = append(list, item)
+	}
+	return list, total, rows.Err()
+}
+
+func (h *History) remove(ids []int64) error {
+	if h.db == nil {
+		return nil
+	}
+	if len(ids) == 0 {
+		_, err := h.db.Exec("DELETE FROM downloads; DELETE FROM attempts")
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	_, err := h.db.Exec("DELETE FROM downloads WHERE id IN ("+placeholders+")", args...)
+	return err
+}
+
+// downloaded tells whether a task of this app ever saved to savePath, whichever way it ended
+func (h *History) downloaded(savePath string) bool {
+	if h.db == nil {
+		return false
+	}
+	var found int
+	err := h.db.QueryRow("SELECT 1 FROM downloads WHERE save_path = ? LIMIT 1", savePath).Scan(&found)
+	return err == nil
+}
+
+func (h *History) close() {
+	if h.db != nil {
+		_ = h.db.Close()
+	}
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+)
+
+const hlsPlaylistLimit = 4 << 20
+
+// segmentCrypt is the AES-128 key and IV of one segment
+type segmentCrypt struct {
+	Key []byte
+	IV  []byte
+}
+
+func isHlsPlaylist(mediaInfo shared.MediaInfo) bool {
+	return mediaInfo.Classify == "m3u8" || strings.EqualFold(mediaInfo.Suffix, ".m3u8")
+}
+
+// loadPlaylist resolves a playlist into ordered segment urls, a master playlist is followed to the
+// chosen variant. crypts is nil when no segment is encrypted.
+func (sd *SegmentDownloader) loadPlaylist(client *http.Client, fd *FileDownloader, playlistUrl string) ([]string, []*segmentCrypt, error) {
+	for depth := 0; depth < 3; depth++ {
+		body, err := sd.get(client, fd, playlistUrl, hlsPlaylistLimit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load playlist failed: %w", err)
+		}
+		if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("#EXTM3U")) {
+			return nil, nil, errors.New("not a m3u8 playlist")
+		}
+		base, err := url.Parse(playlistUrl)
+		if err != nil {
+			return nil, nil, err
+		}
+		if variants := hlsVariants(base, body); len(variants) > 0 {
+			playlistUrl = sd.chooseVariant(variants)
+			// without a separate audio playlist the chosen stream is downloaded and the audio extracted after
+			if audio := hlsAudioRendition(base, body); sd.AudioOnly && audio != "" {
+				playlistUrl = audio
+				sd.audioRendition = true
+			}
+			continue
+		}
+		return sd.parseMediaPlaylist(client, fd, base, body)
+	}
+	return nil, nil, errors.New("too many nested playlists")
+}
+
+// chooseVariant takes the stream picked in the list, otherwise the one Config.VariantPreference asks for
+func (sd *SegmentDownloader) chooseVariant(variants []shared.Variant) string {
+	for _, variant := range variants {
+		if variant.Url == sd.Variant {
+			return variant.Url
+		}
+	}
+	return pickVariant(variants, globalConfig.VariantPreference).Url
+}
+
+func (sd *SegmentDownloader) parseMediaPlaylist(client *http.Client, fd *FileDownloader, base *url.URL, body []byte) ([]string, []*segmentCrypt, error) {
+	var urls []string
+	var crypts []*segmentCrypt
+	encrypted := false
+	keys := map[string][]byte{}
+	var key []byte
+	var iv []byte
+	sequence := int64(0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			// fMP4 init section goes first, it is never encrypted with the segment key here
+			if u, err := base.Parse(hlsAttributes(line)["URI"]); err == nil {
+				urls = append(urls, u.String())
+				crypts = append(crypts, nil)
+			}
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := hlsAttributes(line)
+			switch attrs["METHOD"] {
+			case "NONE":
+				key, iv = nil, nil
+				continue
+			case "AES-128":
+			default:
+				return nil, nil, fmt.Errorf("unsupported hls encryption %s", attrs["METHOD"])
+			}
+			keyUrl, err := base.Parse(attrs["URI"])
+			if err != nil {
+				return nil, nil, err
+			}
+			if cached, ok := keys[keyUrl.String()]; ok {
+				key = cached
+			} else {
+				key, err = sd.get(client, fd, keyUrl.String(), 64)
+				if err != nil {
+					return nil, nil, fmt.Errorf("load hls key failed: %w", err)
+				}
+				if len(key) != 16 {
+					return nil, nil, fmt.Errorf("invalid hls key length %d", len(key))
+				}
+				keys[keyUrl.String()] = key
+			}
+			iv = nil
+			if value := attrs["IV"]; value != "" {
+				iv, err = hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X"))
+				if err != nil || len(iv) != 16 {
+					return nil, nil, fmt.Errorf("invalid hls iv %s", value)
+				}
+			}
+			encrypted = true
+		case line != "" && !strings.HasPrefix(line, "#"):
+			u, err := base.Parse(line)
+			if err != nil {
+				return nil, nil, err
+			}
+			urls = append(urls, u.String())
+			var crypt *segmentCrypt
+			if key != nil {
+				segmentIV := iv
+				if segmentIV == nil {
+					// without an explicit IV the media sequence number is used
+					segmentIV = make([]byte, 16)
+					binary.BigEndian.PutUint64(segmentIV[8:], uint64(sequence))
+				}
+				crypt = &segmentCrypt{Key: key, IV: segmentIV}
+			}
+			crypts = append(crypts, crypt)
+			sequence++
+		}
+	}
+	if len(urls) == 0 {
+		return nil, nil, errors.New("playlist has no segments")
+	}
+	if !encrypted {
+		crypts = nil
+	}
+	return urls, crypts, nil
+}
+
+// get fetches a small resource (playlist, key) with the task's headers and proxy
+func (sd *SegmentDownloader) get(client *http.Client, fd *FileDownloader, rawUrl string, limit int64) ([]byte, error) {
+	request, err := http.NewRequestWithContext(sd.ctx, "GET", rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	fd.setHeaders(request)
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// hlsAttributes parses an attribute list like METHOD=AES-128,URI="key.bin",IV=0x...
+func hlsAttributes(line string) map[string]string {
+	attrs := map[string]string{}
+	_, list, _ := strings.Cut(line, ":")
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.ToUpper(strings.TrimSpace(name))] = value
+		list = rest
+	}
+	return attrs
+}
+
+func decryptSegment(data []byte, crypt *segmentCrypt) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted segment is not block aligned")
+	}
+	block, err := aes.NewCipher(crypt.Key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, crypt.IV).CryptBlocks(out, data)
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(out) {
+		return nil, errors.New("invalid segment padding")
+	}
+	return out[:len(out)-padding], nil
+}
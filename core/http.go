@@ -5,14 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"res-downloader/core/shared"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
@@ -20,12 +22,20 @@ import (
 type respData map[string]interface{}
 
 type ResponseData struct {
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
+	Code        int         `json:"code"`
+	Message     string      `json:"message"`
+	MessageCode string      `json:"messageCode,omitempty"` // catalog code of the message, see message.go
+	Data        interface{} `json:"data"`
 }
 
-type HttpServer struct{}
+type HttpServer struct {
+	listeners   []net.Listener
+	subsMux     sync.Mutex
+	subscribers map[chan string]struct{} // /api/events clients, each gets every event sent to the UI
+}
+
+// portFallbackAttempts is how many following ports are tried when the configured one is taken
+const portFallbackAttempts = 10
 
 func initHttpServer() *HttpServer {
 	if httpServerOnce == nil {
@@ -34,23 +44,91 @@ func initHttpServer() *HttpServer {
 	return httpServerOnce
 }
 
-func (h *HttpServer) run() {
-	listener, err := net.Listen("tcp", globalConfig.Host+":"+globalConfig.Port)
+// listen binds the primary address (falling back to the next free port when the
+// configured one is in use) and every extra address from Config.Listeners.
+func (h *HttpServer) listen() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(globalConfig.Host, globalConfig.Port))
 	if err != nil {
-		globalLogger.Err(err)
-		log.Fatalf("Service cannot start: %v", err)
+		port, convErr := strconv.Atoi(globalConfig.Port)
+		if convErr != nil {
+			return err
+		}
+		for i := 1; i <= portFallbackAttempts && listener == nil; i++ {
+			candidate := strconv.Itoa(port + i)
+			if l, e := net.Listen("tcp", net.JoinHostPort(globalConfig.Host, candidate)); e == nil {
+				globalLogger.Warn().Msgf("port %s is in use, falling back to %s", globalConfig.Port, candidate)
+				globalConfig.Port = candidate
+				listener = l
+			}
+		}
+		if listener == nil {
+			return err
+		}
+	}
+	h.listeners = []net.Listener{listener}
+
+	seen := map[string]bool{listener.Addr().String(): true}
+	for _, addr := range parseListenAddrs(globalConfig.Listeners) {
+		if seen[addr] {
+			continue
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			globalLogger.Esg(err, "listen %s failed, skipped", addr)
+			continue
+		}
+		seen[l.Addr().String()] = true
+		h.listeners = append(h.listeners, l)
+	}
+
+	appOnce.Listeners = make([]string, 0, len(h.listeners))
+	for _, l := range h.listeners {
+		appOnce.Listeners = append(appOnce.Listeners, l.Addr().String())
+	}
+	return nil
+}
+
+// parseListenAddrs accepts "host:port" or a bare port per line/comma, a bare port binds all interfaces
+func parseListenAddrs(raw string) []string {
+	var addrs []string
+	for _, item := range strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' '
+	}) {
+		if _, err := strconv.Atoi(item); err == nil {
+			item = ":" + item
+		}
+		if _, _, err := net.SplitHostPort(item); err != nil {
+			globalLogger.Warn().Msgf("invalid listen address: %s", item)
+			continue
+		}
+		addrs = append(addrs, item)
 	}
-	fmt.Println("Service started, listening http://" + globalConfig.Host + ":" + globalConfig.Port)
-	if err1 := http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return addrs
+}
+
+func (h *HttpServer) run() {
+	defer CaptureCrash()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Host == "127.0.0.1:"+globalConfig.Port && HandleApi(w, r) {
 
 		} else {
 			proxyOnce.Proxy.ServeHTTP(w, r) // 代理
 		}
-	})); err1 != nil {
-		globalLogger.Err(err1)
-		fmt.Printf("Service startup exception: %v", err1)
+	})
+
+	wg := sync.WaitGroup{}
+	for _, listener := range h.listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			fmt.Println("Service started, listening http://" + listener.Addr().String())
+			if err := http.Serve(listener, handler); err != nil {
+				globalLogger.Err(err)
+				fmt.Printf("Service startup exception: %v", err)
+			}
+		}(listener)
 	}
+	wg.Wait()
 }
 
 func (h *HttpServer) downCert(w http.ResponseWriter, r *http.Request) {
@@ -117,7 +195,63 @@ func (h *HttpServer) send(t string, data interface{}) {
 		fmt.Println("Error converting map to JSON:", err)
 		return
 	}
-	runtime.EventsEmit(appOnce.ctx, "event", string(jsonData))
+	// the window isn't up before Startup, and wails exits on a nil context
+	if appOnce.ctx != nil {
+		runtime.EventsEmit(appOnce.ctx, "event", string(jsonData))
+	}
+	h.broadcast(string(jsonData))
+}
+
+// broadcast hands an event to the /api/events clients, a client that can't keep up misses it
+func (h *HttpServer) broadcast(event string) {
+	h.subsMux.Lock()
+	defer h.subsMux.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (h *HttpServer) subscribe() chan string {
+	ch := make(chan string, 64)
+	h.subsMux.Lock()
+	if h.subscribers == nil {
+		h.subscribers = make(map[chan string]struct{})
+	}
+	h.subscribers[ch] = struct{}{}
+	h.subsMux.Unlock()
+	return ch
+}
+
+func (h *HttpServer) unsubscribe(ch chan string) {
+	h.subsMux.Lock()
+	delete(h.subscribers, ch)
+	h.subsMux.Unlock()
+}
+
+// eventFilter keeps the event types a subscriber asked for, an empty filter keeps everything
+type eventFilter map[string]bool
+
+func newEventFilter(types string) eventFilter {
+	filter := eventFilter{}
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			filter[t] = true
+		}
+	}
+	return filter
+}
+
+func (f eventFilter) wants(event string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	var head struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal([]byte(event), &head) == nil && f[head.Type]
 }
 
 func (h *HttpServer) writeJson(w http.ResponseWriter, data *ResponseData) {
@@ -129,17 +263,27 @@ func (h *HttpServer) writeJson(w http.ResponseWriter, data *ResponseData) {
 	}
 }
 
+// error replies with a failure, the first argument is the message or an error whose code goes along
 func (h *HttpServer) error(w http.ResponseWriter, args ...interface{}) {
 	message := "ok"
+	messageCode := ""
 	var data interface{}
 
 	if len(args) > 0 {
-		message = args[0].(string)
+		switch v := args[0].(type) {
+		case error:
+			message = v.Error()
+			messageCode = errorCode(v)
+		case string:
+			message = v
+		}
 	}
 	if len(args) > 1 {
 		data = args[1]
 	}
-	h.writeJson(w, h.buildResp(0, message, data))
+	resp := h.buildResp(0, message, data)
+	resp.MessageCode = messageCode
+	h.writeJson(w, resp)
 }
 
 func (h *HttpServer) success(w http.ResponseWriter, args ...interface{}) {
@@ -165,12 +309,17 @@ func (h *HttpServer) buildResp(code int, message string, data interface{}) *Resp
 }
 
 func (h *HttpServer) openDirectoryDialog(w http.ResponseWriter, r *http.Request) {
+	// headless runs have no window to ask in
+	if appOnce.ctx == nil {
+		h.error(w, messageError(MsgNoFileSelected))
+		return
+	}
 	folder, err := runtime.OpenDirectoryDialog(appOnce.ctx, runtime.OpenDialogOptions{
 		DefaultDirectory: "",
 		Title:            "Select a folder",
 	})
 	if err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	h.success(w, respData{
@@ -179,6 +328,11 @@ func (h *HttpServer) openDirectoryDialog(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *HttpServer) openFileDialog(w http.ResponseWriter, r *http.Request) {
+	// headless runs have no window to ask in
+	if appOnce.ctx == nil {
+		h.error(w, messageError(MsgNoFileSelected))
+		return
+	}
 	filePath, err := runtime.OpenFileDialog(appOnce.ctx, runtime.OpenDialogOptions{
 		Filters: []runtime.FileFilter{
 			{
@@ -189,7 +343,7 @@ func (h *HttpServer) openFileDialog(w http.ResponseWriter, r *http.Request) {
 		Title: "Select a file",
 	})
 	if err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	h.success(w, respData{
@@ -209,7 +363,7 @@ func (h *HttpServer) openFolder(w http.ResponseWriter, r *http.Request) {
 	err = shared.OpenFolder(data.FilePath)
 	if err != nil {
 		globalLogger.Err(err)
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	h.success(w)
@@ -244,7 +398,7 @@ func (h *HttpServer) setSystemPassword(w http.ResponseWriter, r *http.Request) {
 	}
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	systemOnce.SetPassword(data.Password, data.IsCache)
@@ -254,7 +408,7 @@ func (h *HttpServer) setSystemPassword(w http.ResponseWriter, r *http.Request) {
 func (h *HttpServer) openSystemProxy(w http.ResponseWriter, r *http.Request) {
 	err := appOnce.OpenSystemProxy()
 	if err != nil {
-		h.error(w, err.Error(), respData{
+		h.error(w, err, respData{
 			"value": appOnce.IsProxy,
 		})
 		return
@@ -267,7 +421,7 @@ func (h *HttpServer) openSystemProxy(w http.ResponseWriter, r *http.Request) {
 func (h *HttpServer) unsetSystemProxy(w http.ResponseWriter, r *http.Request) {
 	err := appOnce.UnsetSystemProxy()
 	if err != nil {
-		h.error(w, err.Error(), respData{
+		h.error(w, err, respData{
 			"value": appOnce.IsProxy,
 		})
 		return
@@ -288,13 +442,23 @@ func (h *HttpServer) appInfo(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HttpServer) getConfig(w http.ResponseWriter, r *http.Request) {
-	h.success(w, globalConfig)
+	config, err := globalConfig.publicConfig()
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, config)
 }
 
 func (h *HttpServer) setConfig(w http.ResponseWriter, r *http.Request) {
 	var data Config
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
+		return
+	}
+	globalConfig.keepSecrets(&data)
+	if key := globalConfig.windowOnlyChange(data); key != "" {
+		h.error(w, messageError(MsgWindowOnly, key))
 		return
 	}
 	globalConfig.setConfig(data)
@@ -338,13 +502,30 @@ func (h *HttpServer) delete(w http.ResponseWriter, r *http.Request) {
 func (h *HttpServer) download(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		shared.MediaInfo
-		DecodeStr string `json:"decodeStr"`
+		DecodeStr string            `json:"decodeStr"`
+		Priority  int               `json:"priority"`
+		Headers   map[string]string `json:"headers"`
+		Proxy     *string           `json:"proxy"`
+		AudioOnly *bool             `json:"audioOnly"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
+		return
+	}
+	if data.Proxy != nil {
+		resourceOnce.setProxyMode(data.Id, *data.Proxy)
+	}
+	if data.AudioOnly != nil {
+		resourceOnce.setAudioOnly(data.Id, *data.AudioOnly)
+	}
+	if globalConfig.SaveDirectory == "" {
+		h.error(w, messageError(MsgSaveDirectoryUnset))
 		return
 	}
-	resourceOnce.download(data.MediaInfo, data.DecodeStr)
+	if data.Headers != nil {
+		resourceOnce.setHeaderOverrides(data.Id, data.Headers)
+	}
+	queueOnce.push(data.MediaInfo, data.DecodeStr, data.Priority)
 	h.success(w)
 }
 
@@ -354,13 +535,13 @@ func (h *HttpServer) cancel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 
 	err := resourceOnce.cancel(data.Id)
 	if err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	h.success(w)
@@ -373,12 +554,12 @@ func (h *HttpServer) wxFileDecode(w http.ResponseWriter, r *http.Request) {
 		DecodeStr string `json:"decodeStr"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	savePath, err := resourceOnce.wxFileDecode(data.MediaInfo, data.Filename, data.DecodeStr)
 	if err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	h.success(w, respData{
@@ -391,13 +572,13 @@ func (h *HttpServer) batchExport(w http.ResponseWriter, r *http.Request) {
 		Content string `json:"content"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 	fileName := filepath.Join(globalConfig.SaveDirectory, "res-downloader-"+shared.GetCurrentDateTimeFormatted()+".txt")
 	err := os.WriteFile(fileName, []byte(data.Content), 0644)
 	if err != nil {
-		h.error(w, err.Error())
+		h.error(w, err)
 		return
 	}
 
@@ -406,3 +587,1022 @@ func (h *HttpServer) batchExport(w http.ResponseWriter, r *http.Request) {
 		"file_name": fileName,
 	})
 }
+
+func (h *HttpServer) trafficStats(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": trafficOnce.list(),
+	})
+}
+
+func (h *HttpServer) trafficClear(w http.ResponseWriter, r *http.Request) {
+	trafficOnce.clear()
+	h.success(w)
+}
+
+func (h *HttpServer) adBlockUpdate(w http.ResponseWriter, r *http.Request) {
+	count, err := adBlockOnce.Update()
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"count": count,
+	})
+}
+
+func (h *HttpServer) cacheStats(w http.ResponseWriter, r *http.Request) {
+	h.success(w, bodyCacheOnce.Stats())
+}
+
+func (h *HttpServer) cacheClear(w http.ResponseWriter, r *http.Request) {
+	bodyCacheOnce.Clear()
+	h.success(w)
+}
+
+// cleanup applies the retention policies now, cache also drops the cached response bodies
+func (h *HttpServer) cleanup(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Cache bool `json:"cache"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			h.error(w, err)
+			return
+		}
+	}
+	h.success(w, cleanupOnce.run(true, data.Cache))
+}
+
+func (h *HttpServer) rangeStatus(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Sign string `json:"sign"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	status, ok := rangeStitchOnce.status(data.Sign)
+	if !ok {
+		h.error(w, "no ranges captured")
+		return
+	}
+	h.success(w, status)
+}
+
+func (h *HttpServer) pinning(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": pinningOnce.list(),
+	})
+}
+
+func (h *HttpServer) pinningClear(w http.ResponseWriter, r *http.Request) {
+	pinningOnce.clear()
+	h.success(w)
+}
+
+func (h *HttpServer) danmakuSessions(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": danmakuOnce.list(),
+	})
+}
+
+func (h *HttpServer) danmakuExport(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id     string `json:"id"`
+		Format string `json:"format"`
+		Start  int64  `json:"start"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	fileName, err := danmakuOnce.export(data.Id, data.Format, data.Start)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"file_name": fileName,
+	})
+}
+
+func (h *HttpServer) processProxyStart(w http.ResponseWriter, r *http.Request) {
+	if err := processProxyOnce.Start(); err != nil {
+		h.error(w, err, processProxyOnce.Status())
+		return
+	}
+	h.success(w, processProxyOnce.Status())
+}
+
+func (h *HttpServer) processProxyStop(w http.ResponseWriter, r *http.Request) {
+	processProxyOnce.Stop()
+	h.success(w, processProxyOnce.Status())
+}
+
+func (h *HttpServer) processProxyStatus(w http.ResponseWriter, r *http.Request) {
+	h.success(w, processProxyOnce.Status())
+}
+
+func (h *HttpServer) responsePreviews(w http.ResponseWriter, r *http.Request) {
+	h.success(w, previewOnce.list(r.URL.Query().Get("host")))
+}
+
+func (h *HttpServer) responsePreview(w http.ResponseWriter, r *http.Request) {
+	item, ok := previewOnce.get(r.URL.Query().Get("id"))
+	if !ok {
+		h.error(w, messageError(MsgPreviewNotFound))
+		return
+	}
+	h.success(w, item)
+}
+
+func (h *HttpServer) pendingDownloads(w http.ResponseWriter, r *http.Request) {
+	h.success(w, resourceOnce.pendingDownloads())
+}
+
+func (h *HttpServer) resumeDownloads(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"count": resourceOnce.resumeDownloads(),
+	})
+}
+
+func (h *HttpServer) speedLimit(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id    string `json:"id"`
+		Limit int64  `json:"limit"` // KB/s, 0 = unlimited
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := resourceOnce.setSpeedLimit(data.Id, data.Limit*1024); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w)
+}
+
+func (h *HttpServer) queueList(w http.ResponseWriter, r *http.Request) {
+	h.success(w, queueOnce.list())
+}
+
+func (h *HttpServer) queueMove(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id       string `json:"id"`
+		Position int    `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := queueOnce.move(data.Id, data.Position); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, queueOnce.list())
+}
+
+func (h *HttpServer) queuePriority(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id       string `json:"id"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := queueOnce.setPriority(data.Id, data.Priority); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, queueOnce.list())
+}
+
+func (h *HttpServer) queuePause(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Paused bool `json:"paused"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	queueOnce.setPaused(data.Paused)
+	h.success(w, respData{
+		"paused": queueOnce.isPaused(),
+	})
+}
+
+func (h *HttpServer) batchDownload(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Items []struct {
+			shared.MediaInfo
+			DecodeStr string `json:"decodeStr"`
+		} `json:"items"`
+		Priority int `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if globalConfig.SaveDirectory == "" {
+		h.error(w, messageError(MsgSaveDirectoryUnset))
+		return
+	}
+	if len(data.Items) == 0 {
+		h.error(w, messageError(MsgNoResources))
+		return
+	}
+	items := make([]QueueItem, 0, len(data.Items))
+	for _, item := range data.Items {
+		items = append(items, QueueItem{MediaInfo: item.MediaInfo, DecodeStr: item.DecodeStr})
+	}
+	h.success(w, batchOnce.start(items, data.Priority))
+}
+
+func (h *HttpServer) batchStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.success(w, batchOnce.list())
+		return
+	}
+	batch, ok := batchOnce.get(id)
+	if !ok {
+		h.error(w, messageError(MsgBatchNotFound))
+		return
+	}
+	h.success(w, batch)
+}
+
+func (h *HttpServer) aria2Check(w http.ResponseWriter, r *http.Request) {
+	version, err := aria2Version()
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"version": version,
+	})
+}
+
+// taskHeaders returns the captured headers and overrides of a task, save=true replaces the overrides first
+func (h *HttpServer) taskHeaders(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		shared.MediaInfo
+		Headers   map[string]string `json:"headers"`
+		Cookie    string            `json:"cookie"`
+		UserAgent string            `json:"userAgent"`
+		Save      bool              `json:"save"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+
+	if data.Save {
+		overrides := map[string]string{}
+		for key, value := range data.Headers {
+			overrides[http.CanonicalHeaderKey(key)] = value
+		}
+		if data.Cookie != "" {
+			overrides["Cookie"] = data.Cookie
+		}
+		if data.UserAgent != "" {
+			overrides["User-Agent"] = data.UserAgent
+		}
+		resourceOnce.setHeaderOverrides(data.Id, overrides)
+	}
+
+	captured, _ := resourceOnce.parseHeaders(data.MediaInfo)
+	h.success(w, respData{
+		"captured":  captured,
+		"overrides": resourceOnce.headerOverrides(data.Id),
+	})
+}
+
+func (h *HttpServer) taskProxy(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id    string `json:"id"`
+		Proxy string `json:"proxy"` // "", direct, env, a name from ProxyList or a proxy url
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	resourceOnce.setProxyMode(data.Id, data.Proxy)
+	names := make([]string, 0)
+	for name := range namedProxies() {
+		names = append(names, name)
+	}
+	h.success(w, respData{
+		"proxy":   resourceOnce.proxyMode(data.Id),
+		"proxies": names,
+	})
+}
+
+func (h *HttpServer) webdavCheck(w http.ResponseWriter, r *http.Request) {
+	if err := webdavCheck(); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w)
+}
+
+func (h *HttpServer) history(w http.ResponseWriter, r *http.Request) {
+	var data HistoryQuery
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	list, total, err := historyOnce.query(data)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"list":  list,
+		"total": total,
+	})
+}
+
+func (h *HttpServer) historyDelete(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Ids []int64 `json:"ids"` // empty clears everything
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := historyOnce.remove(data.Ids); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w)
+}
+
+func (h *HttpServer) listExport(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Format    string             `json:"format"` // json or csv
+		Resources []shared.MediaInfo `json:"resources"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	fileName, err := resourceOnce.exportList(data.Resources, data.Format)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	_ = shared.OpenFolder(fileName)
+	h.success(w, respData{
+		"file_name": fileName,
+	})
+}
+
+func (h *HttpServer) listImport(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		FileName string `json:"fileName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	resources, pending, err := resourceOnce.importList(data.FileName)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"resources": resources,
+		"pending":   pending,
+	})
+}
+
+func (h *HttpServer) addUrl(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Url string `json:"url"` // a url or pasted share text
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	list, err := resourceOnce.resolveUrl(data.Url)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"list": list,
+	})
+}
+
+func (h *HttpServer) scheduleStatus(w http.ResponseWriter, r *http.Request) {
+	h.success(w, scheduleOnce.status())
+}
+
+func (h *HttpServer) pause(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := queueOnce.pause(data.Id); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w)
+}
+
+func (h *HttpServer) resume(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := queueOnce.resume(data.Id); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w)
+}
+
+func (h *HttpServer) cookies(w http.ResponseWriter, r *http.Request) {
+	h.success(w, cookieOnce.platforms())
+}
+
+func (h *HttpServer) cookiesClear(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Platform string `json:"platform"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	cookieOnce.clear(data.Platform)
+	h.success(w)
+}
+
+// events streams the UI events as server-sent events to holders of the api token,
+// ?type=downloadStats,downloadProgress limits them
+func (h *HttpServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.error(w, "streaming not supported")
+		return
+	}
+	filter := newEventFilter(r.URL.Query().Get("type"))
+
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if !filter.wants(event) {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *HttpServer) analytics(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Since int64 `json:"since"` // unix seconds, 0 for everything
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	list, err := historyOnce.analytics(data.Since)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"list": list,
+	})
+}
+
+func (h *HttpServer) stats(w http.ResponseWriter, r *http.Request) {
+	var data StatsQuery
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	result, err := stats(data)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, result)
+}
+
+// statsExport writes the daily aggregates to a csv file, asking where when file is empty
+// statsExport writes the statistics as csv, see diagnostics for where
+func (h *HttpServer) statsExport(w http.ResponseWriter, r *http.Request) {
+	var data StatsQuery
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	result, err := stats(data)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	name, err := exportTarget("res-downloader-stats-"+time.Now().Format("20060102")+".csv", "Export statistics")
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	defer file.Close()
+	if err := writeStatsCsv(file, result); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"file": name,
+	})
+}
+
+func (h *HttpServer) taskLog(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	list, err := historyOnce.taskLog(data.Id)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"list": list,
+	})
+}
+
+func (h *HttpServer) variants(w http.ResponseWriter, r *http.Request) {
+	var mediaInfo shared.MediaInfo
+	if err := json.NewDecoder(r.Body).Decode(&mediaInfo); err != nil {
+		h.error(w, err)
+		return
+	}
+	list, err := resourceOnce.listVariants(mediaInfo)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"list": list,
+	})
+}
+
+// resources lists the captures for holders of the api token, see tokenRoutes
+func (h *HttpServer) resources(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": resourceOnce.capturedList(),
+	})
+}
+
+func (h *HttpServer) plugins(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": externalPluginsOnce.status(),
+	})
+}
+
+func (h *HttpServer) rulePacks(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": rulePacksOnce.list(),
+	})
+}
+
+func (h *HttpServer) rulePacksUpdate(w http.ResponseWriter, r *http.Request) {
+	rulePacksOnce.update(nil)
+	h.success(w, respData{
+		"list": rulePacksOnce.list(),
+	})
+}
+
+// logs serves the recent log lines, ?limit=, ?level= for a minimum level and ?module= to narrow them
+func (h *HttpServer) logs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > logRecentLimit {
+		limit = 200
+	}
+	h.success(w, respData{
+		"list": globalLogger.recentLines(limit, query.Get("level"), query.Get("module")),
+	})
+}
+
+func (h *HttpServer) profiles(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list":   globalConfig.Profiles,
+		"active": globalConfig.ActiveProfile,
+	})
+}
+
+func (h *HttpServer) profileSave(w http.ResponseWriter, r *http.Request) {
+	h.profileAction(w, r, globalConfig.saveProfile)
+}
+
+func (h *HttpServer) profileSwitch(w http.ResponseWriter, r *http.Request) {
+	h.profileAction(w, r, globalConfig.switchProfile)
+}
+
+func (h *HttpServer) profileDelete(w http.ResponseWriter, r *http.Request) {
+	h.profileAction(w, r, globalConfig.deleteProfile)
+}
+
+func (h *HttpServer) profileAction(w http.ResponseWriter, r *http.Request, action func(name string) error) {
+	var data struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := action(data.Name); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.profiles(w, r)
+}
+
+func (h *HttpServer) updateCheck(w http.ResponseWriter, r *http.Request) {
+	info, err := updaterOnce.check()
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, info)
+}
+
+// diagnostics writes the bug report zip to file, asking where when it is empty
+// diagnostics saves the bundle where the user picks, or in the user directory without a window.
+// Any page reaches this route, so it never writes to a path the request names.
+func (h *HttpServer) diagnostics(w http.ResponseWriter, r *http.Request) {
+	file, err := exportTarget("res-downloader-diagnostics-"+time.Now().Format("20060102-150405")+".zip", "Save diagnostics")
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := writeDiagnostics(file); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"file": file,
+	})
+}
+
+// exportTarget asks where to save an export named name, headless runs put it in the user directory
+func exportTarget(name, title string) (string, error) {
+	if appOnce.ctx == nil {
+		return filepath.Join(appOnce.UserDir, name), nil
+	}
+	file, err := runtime.SaveFileDialog(appOnce.ctx, runtime.SaveDialogOptions{
+		DefaultFilename: name,
+		Title:           title,
+	})
+	if err == nil && file == "" {
+		err = messageError(MsgNoFileSelected)
+	}
+	return file, err
+}
+
+func (h *HttpServer) resourceSearch(w http.ResponseWriter, r *http.Request) {
+	var data ResourceQuery
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	list, total, err := resourceOnce.store.search(data)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"list":  list,
+		"total": total,
+	})
+}
+
+func (h *HttpServer) resourceMark(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id string `json:"id"`
+		ResourceMark
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	res, err := resourceOnce.store.mark(data.Id, data.ResourceMark)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"resource": res,
+	})
+}
+
+// resourceText is the text read off a resource, by kind
+func (h *HttpServer) resourceText(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"text": resourceOnce.store.texts(data.Id),
+	})
+}
+
+func (h *HttpServer) resourceTags(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": resourceOnce.store.tags(),
+	})
+}
+
+func (h *HttpServer) resourceGroup(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	group, err := resourceOnce.group(data.Id)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, group)
+}
+
+// groupDownload is the "download all" of a resource group
+func (h *HttpServer) groupDownload(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id       string `json:"id"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	batch, err := resourceOnce.downloadGroup(data.Id, data.Priority)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, batch)
+}
+
+// imageSet downloads the images of a group and saves them as a numbered folder, zip or cbz
+func (h *HttpServer) imageSet(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Id       string `json:"id"`
+		Format   string `json:"format"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	batch, err := resourceOnce.downloadImageSet(data.Id, data.Format, data.Priority)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, batch)
+}
+
+// series lists the episodes of the catalog a captured api response belongs to
+func (h *HttpServer) series(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		PreviewId string `json:"previewId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	series, err := resolveSeries(data.PreviewId)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, series)
+}
+
+func (h *HttpServer) seriesDownload(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		PreviewId string `json:"previewId"`
+		Priority  int    `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	batch, err := resourceOnce.downloadSeries(data.PreviewId, data.Priority)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, batch)
+}
+
+func (h *HttpServer) liveRooms(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": liveWatchOnce.list(),
+	})
+}
+
+// liveCheck checks every watched room now instead of waiting for its turn
+func (h *HttpServer) liveCheck(w http.ResponseWriter, r *http.Request) {
+	liveWatchOnce.poll(true)
+	h.success(w, respData{
+		"list": liveWatchOnce.list(),
+	})
+}
+
+// transcript reads the cues of a transcript: the given file, a file dialog when choose is set,
+// otherwise the one next to the video
+func (h *HttpServer) transcript(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Video  string `json:"video"`
+		File   string `json:"file"`
+		Choose bool   `json:"choose"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if data.File == "" && !data.Choose {
+		data.File = transcriptFor(data.Video)
+	}
+	if data.File == "" && data.Choose && appOnce.ctx != nil {
+		var err error
+		data.File, err = runtime.OpenFileDialog(appOnce.ctx, runtime.OpenDialogOptions{
+			DefaultDirectory: filepath.Dir(data.Video),
+			Filters: []runtime.FileFilter{
+				{
+					DisplayName: "Transcripts (*.srt;*.vtt)",
+					Pattern:     "*.srt;*.vtt",
+				},
+			},
+			Title: "Select a transcript",
+		})
+		if err != nil {
+			h.error(w, err)
+			return
+		}
+	}
+	if data.File == "" {
+		h.error(w, messageError(MsgNoFileSelected))
+		return
+	}
+	cues, err := loadTranscript(data.File)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"file": data.File,
+		"cues": cues,
+	})
+}
+
+func (h *HttpServer) clipExport(w http.ResponseWriter, r *http.Request) {
+	var data ClipRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	files, err := exportClips(data)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"files": files,
+	})
+}
+
+func (h *HttpServer) notesExport(w http.ResponseWriter, r *http.Request) {
+	var data NotesRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	files, err := exportNotes(data)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"files": files,
+	})
+}
+
+func (h *HttpServer) sessions(w http.ResponseWriter, r *http.Request) {
+	h.success(w, respData{
+		"list": resourceOnce.store.sessions(),
+	})
+}
+
+// sessionSave keeps the current capture under a name, filters are the window's list filters
+func (h *HttpServer) sessionSave(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name    string          `json:"name"`
+		Filters json.RawMessage `json:"filters"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if _, err := resourceOnce.saveSession(data.Name, data.Filters); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.sessions(w, r)
+}
+
+// sessionRestore replaces the list with a saved session, the window loads /api/resources again
+func (h *HttpServer) sessionRestore(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	session, err := resourceOnce.restoreSession(data.Name)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.success(w, respData{
+		"resources": len(session.Resources),
+		"pending":   len(session.Pending),
+		"types":     session.Types,
+		"filters":   session.Filters,
+	})
+}
+
+func (h *HttpServer) sessionDelete(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := resourceOnce.store.deleteSession(data.Name); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.sessions(w, r)
+}
+
+func (h *HttpServer) vault(w http.ResponseWriter, r *http.Request) {
+	h.success(w, vaultOnce.status())
+}
+
+func (h *HttpServer) vaultUnlock(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.error(w, err)
+		return
+	}
+	if err := vaultOnce.unlock(data.Passphrase); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.vault(w, r)
+}
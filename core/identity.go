@@ -0,0 +1,158 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// identityProfile is how a browser fetches media: the headers it adds and the order it sends them in.
+// Some CDNs serve a different file, or nothing, to a client that doesn't look like one.
+type identityProfile struct {
+	tls     string            // tlsFingerprints key used when Config.TlsFingerprint is empty
+	headers map[string]string // filled in when the captured request didn't carry them
+	order   []string          // header names as the browser spells and orders them
+}
+
+// identityProfiles maps Config.DownloadIdentity to a profile, an empty value keeps Go's defaults
+var identityProfiles = map[string]*identityProfile{
+	"chrome": {
+		tls: "chrome",
+		headers: map[string]string{
+			"sec-ch-ua":          `"Google Chrome";v="129", "Not=A?Brand";v="8", "Chromium";v="129"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"macOS"`,
+			"User-Agent":         "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+			"Accept":             "*/*",
+			"Sec-Fetch-Site":     "cross-site",
+			"Sec-Fetch-Mode":     "no-cors",
+			"Sec-Fetch-Dest":     "video",
+			"Accept-Encoding":    "identity;q=1, *;q=0",
+			"Accept-Language":    "zh-CN,zh;q=0.9,en;q=0.8",
+		},
+		order: []string{"Host", "Connection", "sec-ch-ua-platform", "User-Agent", "sec-ch-ua", "sec-ch-ua-mobile", "Accept",
+			"Origin", "Sec-Fetch-Site", "Sec-Fetch-Mode", "Sec-Fetch-Dest", "Referer", "Accept-Encoding", "Accept-Language", "Cookie", "Range"},
+	},
+	"safari": {
+		tls: "safari",
+		headers: map[string]string{
+			"User-Agent":      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+			"Accept":          "*/*",
+			"Sec-Fetch-Site":  "cross-site",
+			"Sec-Fetch-Mode":  "no-cors",
+			"Sec-Fetch-Dest":  "video",
+			"Accept-Encoding": "identity",
+			"Accept-Language": "zh-CN,zh-Hans;q=0.9",
+		},
+		order: []string{"Host", "Accept", "Sec-Fetch-Site", "Cookie", "Accept-Encoding", "Sec-Fetch-Mode", "Range", "Origin",
+			"User-Agent", "Accept-Language", "Referer", "Sec-Fetch-Dest", "Connection"},
+	},
+}
+
+func currentIdentity() *identityProfile {
+	return identityProfiles[strings.ToLower(globalConfig.DownloadIdentity)]
+}
+
+// defaultUserAgent is sent when the captured request had none
+func defaultUserAgent() string {
+	if profile := currentIdentity(); profile != nil {
+		return profile.headers["User-Agent"]
+	}
+	return globalConfig.UserAgent
+}
+
+// apply adds the profile headers the request doesn't carry yet, captured values win
+func (p *identityProfile) apply(request *http.Request) {
+	for key, value := range p.headers {
+		if request.Header.Get(key) == "" {
+			request.Header.Set(key, value)
+		}
+	}
+}
+
+// dial wraps connections so request headers leave in the profile's order and spelling,
+// net/http itself always writes them sorted and canonicalized
+func (p *identityProfile) dial(dial dialFunc) dialFunc {
+	rank := make(map[string]int, len(p.order))
+	for i, name := range p.order {
+		rank[strings.ToLower(name)] = i
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &orderedConn{Conn: conn, profile: p, rank: rank}, nil
+	}
+}
+
+// orderedConn holds back each HTTP/1.1 request head until it is complete and rewrites it,
+// request bodies announced by Content-Length pass through untouched
+type orderedConn struct {
+	net.Conn
+	profile *identityProfile
+	rank    map[string]int
+	buf     []byte
+	body    int64
+}
+
+func (c *orderedConn) Write(b []byte) (int, error) {
+	c.buf = append(c.buf, b...)
+	for len(c.buf) > 0 {
+		if c.body > 0 {
+			n := int64(len(c.buf))
+			if n > c.body {
+				n = c.body
+			}
+			if _, err := c.Conn.Write(c.buf[:n]); err != nil {
+				return 0, err
+			}
+			c.body -= n
+			c.buf = c.buf[n:]
+			continue
+		}
+		end := bytes.Index(c.buf, []byte("\r\n\r\n"))
+		if end < 0 {
+			break
+		}
+		head := c.reorder(c.buf[:end])
+		if _, err := c.Conn.Write(append(head, "\r\n\r\n"...)); err != nil {
+			return 0, err
+		}
+		c.buf = c.buf[end+4:]
+	}
+	if len(c.buf) == 0 {
+		c.buf = nil
+	}
+	return len(b), nil
+}
+
+func (c *orderedConn) reorder(head []byte) []byte {
+	lines := bytes.Split(head, []byte("\r\n"))
+	fields := lines[1:]
+	position := func(line []byte) int {
+		name, _, _ := bytes.Cut(line, []byte(":"))
+		if i, ok := c.rank[strings.ToLower(string(name))]; ok {
+			return i
+		}
+		return len(c.rank)
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return position(fields[i]) < position(fields[j])
+	})
+	for i, line := range fields {
+		name, value, _ := bytes.Cut(line, []byte(":"))
+		lower := strings.ToLower(string(name))
+		if lower == "content-length" {
+			c.body, _ = strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+		}
+		if r, ok := c.rank[lower]; ok {
+			fields[i] = append([]byte(c.profile.order[r]+":"), value...)
+		}
+	}
+	return bytes.Join(lines, []byte("\r\n"))
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// imageHashDistance is how many of the 64 bits two hashes may differ in and still look the same
+	imageHashDistance = 4
+	// imageHashMaxPixels skips decoding images too large to hash cheaply
+	imageHashMaxPixels = 40 * 1000 * 1000
+)
+
+func imageIndexFile() string {
+	return filepath.Join(appOnce.UserDir, "images.json")
+}
+
+// similarImage indexes the perceptual hash of an image download and returns an earlier one that
+// looks the same, e.g. the same picture served re-encoded or resized. The caller holds contentIndexMux.
+func similarImage(savePath string) string {
+	switch strings.ToLower(filepath.Ext(savePath)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+	default:
+		return ""
+	}
+	hash, ok := imageHash(savePath)
+	// flat images all hash alike, they are not told apart
+	if !ok || hash == 0 || hash == ^uint64(0) {
+		return ""
+	}
+
+	index := map[string]string{} // path -> hex hash
+	if data, err := os.ReadFile(imageIndexFile()); err == nil {
+		_ = json.Unmarshal(data, &index)
+	}
+	similar := ""
+	for path, value := range index {
+		// images moved or deleted since are dropped, the index only grows with what is on disk
+		if _, err := os.Stat(path); err != nil {
+			delete(index, path)
+			continue
+		}
+		other, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			delete(index, path)
+			continue
+		}
+		if similar == "" && path != savePath && bits.OnesCount64(hash^other) <= imageHashDistance {
+			similar = path
+		}
+	}
+	index[savePath] = strconv.FormatUint(hash, 16)
+	if data, err := json.Marshal(index); err == nil {
+		if err := os.WriteFile(imageIndexFile(), data, 0644); err != nil {
+			globalLogger.Esg(err, "save image index failed")
+		}
+	}
+	return similar
+}
+
+// imageHash is the difference hash of an image: shrunk to 9x8 in grey, each bit tells whether
+// a pixel is brighter than its right neighbour. Scaling and recompression barely change it.
+func imageHash(fileName string) (uint64, bool) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil || config.Width*config.Height > imageHashMaxPixels || config.Width < 9 || config.Height < 8 {
+		return 0, false
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return 0, false
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, false
+	}
+
+	// average the grey of each cell of a 9x8 grid
+	bounds := img.Bounds()
+	var grey [8][9]float64
+	for y := 0; y < 8; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/8
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/8
+		for x := 0; x < 9; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/9
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/9
+			sum, count := 0.0, 0
+			// sampling every few pixels is plenty for an average
+			stepY, stepX := max((y1-y0)/16, 1), max((x1-x0)/16, 1)
+			for py := y0; py < y1; py += stepY {
+				for px := x0; px < x1; px += stepX {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			if count > 0 {
+				grey[y][x] = sum / float64(count)
+			}
+		}
+	}
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grey[y][x] > grey[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, true
+}
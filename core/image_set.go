@@ -0,0 +1,187 @@
+package core
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+)
+
+const (
+	ImageSetFolder = "folder" // the images moved into a folder named after the set
+	ImageSetZip    = "zip"
+	ImageSetCbz    = "cbz" // a zip comic readers open, pages in name order
+)
+
+// ImageSet is sent as "imageSet" once the images of a group are downloaded and packaged
+type ImageSet struct {
+	Group   string `json:"Group"`
+	Path    string `json:"Path"`    // the folder or the archive
+	Images  int    `json:"Images"`  // images packaged
+	Missing int    `json:"Missing"` // images whose download failed
+	Error   string `json:"Error"`
+}
+
+// downloadImageSet queues the images of a group as one batch, then saves them in capture order as
+// 001.jpg, 002.jpg ... in a folder, or packs them into a zip or cbz and removes the single files.
+func (r *Resource) downloadImageSet(id, format string, priority int) (DownloadBatch, error) {
+	if globalConfig.SaveDirectory == "" {
+		return DownloadBatch{}, messageError(MsgSaveDirectoryUnset)
+	}
+	switch format {
+	case "":
+		format = ImageSetFolder
+	case ImageSetFolder, ImageSetZip, ImageSetCbz:
+	default:
+		return DownloadBatch{}, fmt.Errorf("unknown image set format: %s", format)
+	}
+	group, err := r.group(id)
+	if err != nil {
+		return DownloadBatch{}, err
+	}
+	var items []QueueItem
+	images := 0
+	for _, res := range group.Resources {
+		if res.Classify != "image" {
+			continue
+		}
+		images++
+		if res.Status == shared.DownloadStatusDone && shared.FileExist(res.SavePath) {
+			continue
+		}
+		items = append(items, QueueItem{MediaInfo: res})
+	}
+	if images == 0 {
+		return DownloadBatch{}, messageError(MsgNoResources)
+	}
+	return batchOnce.startThen(items, priority, func() {
+		set := r.packImageSet(id, format)
+		if set.Error != "" {
+			globalLogger.Error().Msgf("package image set %s failed: %s", id, set.Error)
+		}
+		httpServerOnce.send("imageSet", set)
+	}), nil
+}
+
+// packImageSet gathers the downloaded images of a group in capture order
+func (r *Resource) packImageSet(id, format string) ImageSet {
+	set := ImageSet{Group: id}
+	group, err := r.group(id)
+	if err != nil {
+		set.Error = err.Error()
+		return set
+	}
+	var files, ids []string
+	name := ""
+	for _, res := range group.Resources {
+		if res.Classify != "image" {
+			continue
+		}
+		if res.Status != shared.DownloadStatusDone || !shared.FileExist(res.SavePath) {
+			set.Missing++
+			continue
+		}
+		files = append(files, res.SavePath)
+		ids = append(ids, res.Id)
+		if name == "" {
+			name = imageSetName(res)
+		}
+	}
+	if len(files) == 0 {
+		set.Error = messageError(MsgNoResources).Error()
+		return set
+	}
+	if name == "" {
+		name = "images_" + id
+	}
+	target := filepath.Join(filepath.Dir(files[0]), name)
+	if format != ImageSetFolder {
+		target += "." + format
+	}
+	if _, err := os.Stat(target); err == nil {
+		target = freeFileName(target)
+	}
+
+	if format == ImageSetFolder {
+		err = moveImageSet(target, files)
+	} else {
+		err = zipImageSet(target, files)
+	}
+	if err != nil {
+		set.Error = err.Error()
+		return set
+	}
+	if format == ImageSetFolder {
+		for i, id := range ids {
+			r.store.setStatus(id, shared.DownloadStatusDone, filepath.Join(target, imageSetEntry(i, len(files), files[i])))
+		}
+	}
+	set.Path = target
+	set.Images = len(files)
+	return set
+}
+
+// imageSetName is the title of the note or gallery, as far as the capture knows it
+func imageSetName(res shared.MediaInfo) string {
+	for _, name := range []string{res.OtherData["title"], res.Description, res.OtherData["author"]} {
+		if name = sanitizeFileName(name); name != "" {
+			fileLen := globalConfig.FilenameLen
+			if fileLen <= 0 {
+				fileLen = 10
+			}
+			return truncateRunes(name, fileLen)
+		}
+	}
+	return ""
+}
+
+// imageSetEntry numbers a page, wide enough that name order is page order
+func imageSetEntry(index, total int, path string) string {
+	width := len(fmt.Sprint(total))
+	if width < 3 {
+		width = 3
+	}
+	return fmt.Sprintf("%0*d%s", width, index+1, strings.ToLower(filepath.Ext(path)))
+}
+
+func moveImageSet(dir string, files []string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for i, path := range files {
+		if err := os.Rename(path, filepath.Join(dir, imageSetEntry(i, len(files), path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func zipImageSet(path string, files []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	archive := zip.NewWriter(file)
+	for i, name := range files {
+		if err = zipFile(archive, imageSetEntry(i, len(files), name), name); err != nil {
+			break
+		}
+	}
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	// the archive replaces the single files
+	for _, name := range files {
+		_ = os.Remove(name)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// IntegrityError means the file on disk doesn't match what the server announced
+type IntegrityError struct {
+	Reason string
+}
+
+func (e *IntegrityError) Error() string {
+	return "integrity check failed: " + e.Reason
+}
+
+// Checksums announced by the server, hex encoded
+type Checksums struct {
+	Md5    string
+	Sha256 string
+}
+
+// parseChecksums reads Content-MD5, x-goog-hash and x-amz-checksum-sha256 style headers
+func parseChecksums(header http.Header) Checksums {
+	var sums Checksums
+	decode := func(value string) string {
+		value = strings.TrimSpace(value)
+		if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+			return hex.EncodeToString(data)
+		}
+		if _, err := hex.DecodeString(value); err == nil {
+			return strings.ToLower(value)
+		}
+		return ""
+	}
+	if v := header.Get("Content-MD5"); v != "" {
+		sums.Md5 = decode(v)
+	}
+	for _, v := range header.Values("X-Goog-Hash") {
+		for _, item := range strings.Split(v, ",") {
+			// the value is base64 and may itself end with '=', so only strip the key
+			if item = strings.TrimSpace(item); strings.HasPrefix(item, "md5=") {
+				sums.Md5 = decode(strings.TrimPrefix(item, "md5="))
+			}
+		}
+	}
+	if v := header.Get("X-Amz-Checksum-Sha256"); v != "" {
+		sums.Sha256 = decode(v)
+	}
+	return sums
+}
+
+// verifyFile checks the announced checksums, it always returns the sha256 of the file
+func verifyFile(fileName string, sums Checksums) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sha := sha256.New()
+	md := md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha, md), file); err != nil {
+		return "", err
+	}
+	shaHex := hex.EncodeToString(sha.Sum(nil))
+
+	if sums.Sha256 != "" && sums.Sha256 != shaHex {
+		return shaHex, &IntegrityError{Reason: "sha256 mismatch"}
+	}
+	if sums.Md5 != "" && sums.Md5 != hex.EncodeToString(md.Sum(nil)) {
+		return shaHex, &IntegrityError{Reason: "md5 mismatch"}
+	}
+	return shaHex, nil
+}
@@ -0,0 +1,361 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Job is a pipeline read from a YAML file and run headless by "res-downloader run", e.g.
+//
+//	name: evening-live
+//	source: https://live.example.com/room/123
+//	type: live
+//	save: /data/recordings
+//	output: "{platform}/{date}/{title}-{time}.{ext}"
+//	steps:
+//	  - record: 2h
+//	  - remux: mp4
+//	  - upload: webdav
+//	    path: "live/{date}/{title}.{ext}"
+//	  - command: echo "$RD_PATH"
+//
+// Output and upload paths take the variables of Config.FilenameTemplate.
+type Job struct {
+	Name           string            `yaml:"name"`
+	Source         string            `yaml:"source"` // media url, share link or page, resolved like a pasted link
+	resourceFilter `yaml:",inline"`  // picks the resource when the source resolves to several
+	Headers        map[string]string `yaml:"headers"`
+	Save           string            `yaml:"save"`   // download directory for this run, the saved setting is left alone
+	Output         string            `yaml:"output"` // file name template for this run
+	Steps          []JobStep         `yaml:"steps"`
+}
+
+// JobStep is one action, exactly one of the action keys is set
+type JobStep struct {
+	Record   string `yaml:"record"`   // how long to record a live stream, e.g. 2h, or true until it ends
+	Download bool   `yaml:"download"` // fetch the resource as the queue would
+	Remux    string `yaml:"remux"`    // container to rewrap into with ffmpeg, e.g. mp4
+	Upload   string `yaml:"upload"`   // webdav or s3
+	Command  string `yaml:"command"`  // shell command, the file is passed as RD_* env vars
+
+	Path   string `yaml:"path"`   // upload: remote path template, default the layout below the save directory
+	Keep   bool   `yaml:"keep"`   // remux: keep the original file
+	Ffmpeg string `yaml:"ffmpeg"` // remux: ffmpeg executable, default the one on PATH
+
+	// speech recognition is named so a job using it fails before anything runs, this build has none
+	Asr interface{} `yaml:"asr"`
+	Srt interface{} `yaml:"srt"`
+}
+
+func (s JobStep) action() string {
+	var actions []string
+	if s.Record != "" {
+		actions = append(actions, "record")
+	}
+	if s.Download {
+		actions = append(actions, "download")
+	}
+	if s.Remux != "" {
+		actions = append(actions, "remux")
+	}
+	if s.Upload != "" {
+		actions = append(actions, "upload")
+	}
+	if s.Command != "" {
+		actions = append(actions, "command")
+	}
+	if len(actions) != 1 {
+		return ""
+	}
+	return actions[0]
+}
+
+// loadJob reads and checks a job file before anything runs
+func loadJob(path string) (*Job, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	var job Job
+	if err := decoder.Decode(&job); err != nil {
+		return nil, fmt.Errorf("read job %s: %w", path, err)
+	}
+	if job.Name == "" {
+		job.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if strings.TrimSpace(job.Source) == "" {
+		return nil, errors.New("job has no source")
+	}
+	if err := job.compile(); err != nil {
+		return nil, err
+	}
+	if len(job.Steps) == 0 || job.Steps[0].action() != "record" && job.Steps[0].action() != "download" {
+		return nil, errors.New("a job starts with a record or download step")
+	}
+	for i, step := range job.Steps {
+		if step.Asr != nil || step.Srt != nil {
+			return nil, fmt.Errorf("step %d: speech recognition and subtitles are not supported", i+1)
+		}
+		switch step.action() {
+		case "":
+			return nil, fmt.Errorf("step %d: give exactly one of record, download, remux, upload or command", i+1)
+		case "record":
+			if i > 0 {
+				return nil, fmt.Errorf("step %d: only the first step fetches", i+1)
+			}
+			if step.Record != "true" {
+				if _, err := time.ParseDuration(step.Record); err != nil {
+					return nil, fmt.Errorf("step %d: %w", i+1, err)
+				}
+			}
+		case "download":
+			if i > 0 {
+				return nil, fmt.Errorf("step %d: only the first step fetches", i+1)
+			}
+		case "upload":
+			if step.Upload != "webdav" && step.Upload != "s3" {
+				return nil, fmt.Errorf("step %d: unknown destination %s", i+1, step.Upload)
+			}
+		}
+	}
+	return &job, nil
+}
+
+// jobRunner carries the file through the steps of one job
+type jobRunner struct {
+	job       *Job
+	mediaInfo shared.MediaInfo
+	mu        sync.Mutex
+	cancel    func() // ends the fetch early, a recording is kept
+}
+
+func (j *jobRunner) log(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "[%s] %s\n", j.job.Name, fmt.Sprintf(format, args...))
+}
+
+func (j *jobRunner) run() error {
+	if j.job.Save != "" {
+		globalConfig.SaveDirectory = j.job.Save
+	}
+	if j.job.Output != "" {
+		globalConfig.FilenameTemplate = j.job.Output
+	}
+	// the job's own steps take the place of the configured post command and uploads
+	globalConfig.PostCommand = ""
+	globalConfig.WebdavEnable = false
+	globalConfig.S3Enable = false
+	list, err := resourceOnce.resolveUrl(j.job.Source)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, res := range list {
+		if j.job.matches(res) {
+			j.mediaInfo, found = res, true
+			break
+		}
+	}
+	if !found {
+		return errors.New("the source has no matching resource")
+	}
+	if len(j.job.Headers) > 0 {
+		resourceOnce.setHeaderOverrides(j.mediaInfo.Id, j.job.Headers)
+	}
+
+	for i, step := range j.job.Steps {
+		action := step.action()
+		j.log("step %d: %s", i+1, action)
+		switch action {
+		case "record", "download":
+			err = j.fetch(step)
+		case "remux":
+			err = j.remux(step)
+		case "upload":
+			err = j.upload(step)
+		case "command":
+			err = j.command(step)
+		}
+		if err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, action, err)
+		}
+	}
+	j.log("done: %s", j.mediaInfo.SavePath)
+	return nil
+}
+
+// stop ends a running fetch, what was recorded so far goes through the remaining steps
+func (j *jobRunner) stop() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel == nil {
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+func (j *jobRunner) fetch(step JobStep) error {
+	if step.Record != "" && !isLiveFlv(j.mediaInfo) {
+		return fmt.Errorf("%s is not a live stream, use a download step", j.mediaInfo.Url)
+	}
+	id := j.mediaInfo.Id
+	j.mu.Lock()
+	j.cancel = func() {
+		if task, ok := resourceOnce.tasks.Load(id); ok {
+			task.(Downloader).Cancel()
+		}
+	}
+	j.mu.Unlock()
+	defer func() {
+		j.mu.Lock()
+		j.cancel = nil
+		j.mu.Unlock()
+	}()
+	if duration, err := time.ParseDuration(step.Record); err == nil && duration > 0 {
+		timer := time.AfterFunc(duration, func() { j.stop() })
+		defer timer.Stop()
+	}
+
+	// the file name is only known once the download settled it, it comes with the done event
+	events := httpServerOnce.subscribe()
+	defer httpServerOnce.unsubscribe(events)
+	savePath := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		path := ""
+		take := func(event string) {
+			var message struct {
+				Type string `json:"type"`
+				Data struct {
+					Id       string
+					Status   string
+					SavePath string
+				} `json:"data"`
+			}
+			if json.Unmarshal([]byte(event), &message) == nil && message.Type == "downloadProgress" &&
+				message.Data.Id == id && message.Data.Status == shared.DownloadStatusDone {
+				path = message.Data.SavePath
+			}
+		}
+		for {
+			select {
+			case event := <-events:
+				take(event)
+			case <-done:
+				for {
+					select {
+					case event := <-events:
+						take(event)
+					default:
+						savePath <- path
+						return
+					}
+				}
+			}
+		}
+	}()
+	err := resourceOnce.download(j.mediaInfo, "")
+	resourceOnce.tasks.Delete(id)
+	close(done)
+	path := <-savePath
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.New("download did not complete")
+	}
+	j.mediaInfo.SavePath = path
+	j.mediaInfo.Suffix = filepath.Ext(path)
+	if info, err := os.Stat(path); err == nil {
+		j.mediaInfo.Size = float64(info.Size())
+	}
+	return nil
+}
+
+// remux rewraps the streams into another container without re-encoding
+func (j *jobRunner) remux(step JobStep) error {
+	ffmpeg, err := findFfmpeg(step.Ffmpeg)
+	if err != nil {
+		return err
+	}
+	suffix := "." + strings.TrimPrefix(strings.ToLower(step.Remux), ".")
+	source := j.mediaInfo.SavePath
+	if strings.EqualFold(filepath.Ext(source), suffix) {
+		return nil
+	}
+	target := strings.TrimSuffix(source, filepath.Ext(source)) + suffix
+	work, err := workFile(suffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(work)
+	output, err := backgroundCommand(ffmpeg, "-y", "-hide_banner", "-loglevel", "error", "-i", source, "-c", "copy", work).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if err := moveWorkFile(work, target); err != nil {
+		return err
+	}
+	if !step.Keep {
+		_ = os.Remove(source)
+	}
+	j.mediaInfo.SavePath = target
+	j.mediaInfo.Suffix = suffix
+	return nil
+}
+
+func (j *jobRunner) upload(step JobStep) error {
+	var destination Destination
+	switch step.Upload {
+	case "webdav":
+		if globalConfig.WebdavUrl == "" {
+			return errors.New("webdav is not configured")
+		}
+		destination = newWebdavDestination()
+	case "s3":
+		d, err := newS3Destination()
+		if err != nil {
+			return err
+		}
+		destination = d
+	}
+	remotePath := remotePathFor(j.mediaInfo.SavePath)
+	if step.Path != "" {
+		remotePath = strings.Join(templateParts(j.mediaInfo, step.Path), "/")
+		if !strings.HasSuffix(remotePath, j.mediaInfo.Suffix) {
+			remotePath += j.mediaInfo.Suffix
+		}
+	}
+	if err := destination.Upload(context.Background(), j.mediaInfo, remotePath); err != nil {
+		return err
+	}
+	j.log("uploaded to %s: %s", destination.Name(), remotePath)
+	return nil
+}
+
+func (j *jobRunner) command(step JobStep) error {
+	timeout := time.Duration(globalConfig.PostCommandTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, step.Command)
+	cmd.Env = append(os.Environ(), mediaEnv(j.mediaInfo)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListExport is a saved sniffing session: the captured resources plus tasks that were not finished yet
+type ListExport struct {
+	Time      int64              `json:"Time"`
+	Resources []shared.MediaInfo `json:"Resources"`
+	Pending   []PendingDownload  `json:"Pending"`
+}
+
+var listCsvHeader = []string{"Kind", "Id", "Url", "UrlSign", "CoverUrl", "Size", "Domain", "Classify", "Suffix",
+	"SavePath", "Status", "DecodeKey", "Description", "ContentType", "OtherData", "DecodeStr"}
+
+// listCsvMeta follows listCsvHeader for reading in a spreadsheet, import takes them from OtherData
+var listCsvMeta = []string{"Title", "Author", "Published", "Likes"}
+
+// exportList writes the resources given by the frontend and all pending tasks into SaveDirectory
+func (r *Resource) exportList(resources []shared.MediaInfo, format string) (string, error) {
+	if globalConfig.SaveDirectory == "" {
+		return "", errors.New("save directory is not set")
+	}
+	export := ListExport{
+		Time:      time.Now().Unix(),
+		Resources: resources,
+		Pending:   r.unfinished(),
+	}
+	if export.Resources == nil {
+		export.Resources = []shared.MediaInfo{}
+	}
+
+	fileName := filepath.Join(globalConfig.SaveDirectory, "res-downloader-list-"+shared.GetCurrentDateTimeFormatted())
+	if format == "csv" {
+		fileName += ".csv"
+		return fileName, writeListCsv(fileName, export)
+	}
+	fileName += ".json"
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fileName, os.WriteFile(fileName, data, 0644)
+}
+
+// unfinished is every task that has not completed: the pending downloads and what waits in the queue
+func (r *Resource) unfinished() []PendingDownload {
+	list := r.pendingDownloads()
+	for _, item := range queueOnce.list() {
+		list = append(list, PendingDownload{MediaInfo: item.MediaInfo, DecodeStr: item.DecodeStr})
+	}
+	return list
+}
+
+func writeListCsv(fileName string, export ListExport) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// BOM so Excel detects utf-8
+	if _, err := file.WriteString("\xEF\xBB\xBF"); err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	_ = w.Write(append(append([]string{}, listCsvHeader...), listCsvMeta...))
+	row := func(kind string, m shared.MediaInfo, decodeStr string) []string {
+		otherData, _ := json.Marshal(m.OtherData)
+		published := ""
+		if unix, _ := strconv.ParseInt(m.OtherData["publish_time"], 10, 64); unix > 0 {
+			published = time.Unix(unix, 0).Format("2006-01-02 15:04:05")
+		}
+		return []string{kind, m.Id, m.Url, m.UrlSign, m.CoverUrl, strconv.FormatFloat(m.Size, 'f', -1, 64), m.Domain,
+			m.Classify, m.Suffix, m.SavePath, m.Status, m.DecodeKey, m.Description, m.ContentType, string(otherData), decodeStr,
+			m.OtherData["title"], m.OtherData["author"], published, m.OtherData["likes"]}
+	}
+	for _, m := range export.Resources {
+		_ = w.Write(row("resource", m, ""))
+	}
+	for _, item := range export.Pending {
+		_ = w.Write(row("pending", item.MediaInfo, item.DecodeStr))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func readListCsv(data []byte) (ListExport, error) {
+	var export ListExport
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\xEF\xBB\xBF"))).ReadAll()
+	if err != nil {
+		return export, err
+	}
+	if len(records) == 0 || len(records[0]) < len(listCsvHeader) {
+		return export, errors.New("not a res-downloader list")
+	}
+	for _, rec := range records[1:] {
+		if len(rec) < len(listCsvHeader) {
+			continue
+		}
+		size, _ := strconv.ParseFloat(rec[5], 64)
+		m := shared.MediaInfo{
+			Id: rec[1], Url: rec[2], UrlSign: rec[3], CoverUrl: rec[4], Size: size, Domain: rec[6], Classify: rec[7],
+			Suffix: rec[8], SavePath: rec[9], Status: rec[10], DecodeKey: rec[11], Description: rec[12], ContentType: rec[13],
+		}
+		_ = json.Unmarshal([]byte(rec[14]), &m.OtherData)
+		if rec[0] == "pending" {
+			export.Pending = append(export.Pending, PendingDownload{MediaInfo: m, DecodeStr: rec[15]})
+		} else {
+			export.Resources = append(export.Resources, m)
+		}
+	}
+	return export, nil
+}
+
+// importList restores a saved list: resources are sent to the frontend again and
+// pending tasks go into the download journal, from where /api/resume-downloads picks them up
+func (r *Resource) importList(fileName string) (int, int, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return 0, 0, err
+	}
+	var export ListExport
+	if strings.EqualFold(filepath.Ext(fileName), ".csv") {
+		export, err = readListCsv(data)
+	} else {
+		err = json.Unmarshal(data, &export)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	resources := 0
+	for _, m := range export.Resources {
+		if m.Url == "" || (m.UrlSign != "" && r.mediaIsMarked(m.UrlSign)) {
+			continue
+		}
+		if m.UrlSign != "" {
+			r.markMedia(m.UrlSign)
+		}
+		httpServerOnce.send("newResources", m)
+		resources++
+	}
+	for _, item := range export.Pending {
+		if item.MediaInfo.Url == "" {
+			continue
+		}
+		if _, running := r.tasks.Load(item.MediaInfo.Id); running {
+			continue
+		}
+		r.journalAdd(item.MediaInfo, item.DecodeStr)
+	}
+	return resources, len(export.Pending), nil
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"bufio"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// liveWatchTick is how often due rooms are looked for, each room waits Config.LivePollSeconds
+	liveWatchTick = 15 * time.Second
+	// liveRecordPriority puts a recording ahead of ordinary queued downloads
+	liveRecordPriority = 100
+)
+
+// LiveRoom is a watched room and what its last check found
+type LiveRoom struct {
+	Url       string `json:"Url"`
+	Name      string `json:"Name"`
+	Live      bool   `json:"Live"`
+	Recording string `json:"Recording"` // id of the running recording, empty when none
+	Checked   int64  `json:"Checked"`   // unix seconds of the last check, 0 before the first
+	Error     string `json:"Error"`
+}
+
+// LiveWatcher polls the rooms of Config.LiveRooms and queues a recording when one goes live. The
+// recording ends by itself with the stream, the room is polled again once it is no longer queued.
+type LiveWatcher struct {
+	mu      sync.Mutex
+	polling sync.Mutex           // one poll at a time, a room is never checked twice at once
+	rooms   map[string]*LiveRoom // room url -> state
+}
+
+func initLiveWatch() *LiveWatcher {
+	if liveWatchOnce == nil {
+		liveWatchOnce = &LiveWatcher{rooms: map[string]*LiveRoom{}}
+		go func() {
+			for range time.Tick(liveWatchTick) {
+				liveWatchOnce.poll(false)
+			}
+		}()
+	}
+	return liveWatchOnce
+}
+
+// parseLiveRooms reads one room per line: the url, optionally followed by a name
+func parseLiveRooms(text string) []LiveRoom {
+	var rooms []LiveRoom
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rawUrl, name, _ := strings.Cut(line, " ")
+		if !strings.HasPrefix(rawUrl, "http://") && !strings.HasPrefix(rawUrl, "https://") {
+			globalLogger.Warn().Msgf("invalid live room: %s", line)
+			continue
+		}
+		rooms = append(rooms, LiveRoom{Url: rawUrl, Name: strings.TrimSpace(name)})
+	}
+	return rooms
+}
+
+// poll checks the rooms that are due, all of them when force is set
+func (l *LiveWatcher) poll(force bool) {
+	l.polling.Lock()
+	defer l.polling.Unlock()
+	interval := time.Duration(globalConfig.LivePollSeconds) * time.Second
+	if interval < liveWatchTick {
+		interval = liveWatchTick
+	}
+	configured := parseLiveRooms(globalConfig.LiveRooms)
+
+	l.mu.Lock()
+	// rooms taken out of the config are forgotten, a recording they started runs on
+	kept := make(map[string]*LiveRoom, len(configured))
+	var due []*LiveRoom
+	for _, room := range configured {
+		state, ok := l.rooms[room.Url]
+		if !ok {
+			state = &LiveRoom{Url: room.Url}
+		}
+		state.Name = room.Name
+		kept[room.Url] = state
+		if state.Recording != "" && l.busy(state.Recording) {
+			continue
+		}
+		if force || time.Since(time.Unix(state.Checked, 0)) >= interval {
+			due = append(due, state)
+		}
+	}
+	l.rooms = kept
+	l.mu.Unlock()
+
+	for _, room := range due {
+		l.check(room)
+	}
+}
+
+// busy reports a recording still queued or running
+func (l *LiveWatcher) busy(id string) bool {
+	if slices.Contains(queueOnce.runningIds(), id) {
+		return true
+	}
+	return slices.ContainsFunc(queueOnce.list(), func(item QueueItem) bool { return item.MediaInfo.Id == id })
+}
+
+// check resolves the room page, a live stream on it means the streamer is on air
+func (l *LiveWatcher) check(room *LiveRoom) {
+	list, err := resolveShare(room.Url)
+
+	l.mu.Lock()
+	wasLive := room.Live
+	room.Checked = time.Now().Unix()
+	room.Error = ""
+	room.Live = false
+	if err != nil {
+		// an offline room often has no stream to find, only other errors are kept
+		if !strings.HasPrefix(err.Error(), "no media found") {
+			room.Error = err.Error()
+		}
+		list = nil
+	}
+	for _, res := range list {
+		// a page's <video> pointing at an flv is a stream as well
+		if res.Classify != "live" && !strings.EqualFold(res.Suffix, ".flv") {
+			continue
+		}
+		res.Classify = "live"
+		room.Live = true
+		if globalConfig.SaveDirectory == "" {
+			room.Error = messageError(MsgSaveDirectoryUnset).Error()
+			break
+		}
+		res.OtherData["share_url"] = room.Url
+		res.OtherData["live_room"] = room.Url
+		if room.Name != "" {
+			res.Description = room.Name + "_" + time.Now().Format("20060102_1504")
+		}
+		room.Recording = res.Id
+		if !resourceOnce.mediaIsMarked(res.UrlSign) {
+			resourceOnce.markMedia(res.UrlSign)
+			resourceOnce.capture(res)
+		}
+		queueOnce.push(res, "", liveRecordPriority)
+		globalLogger.Info().Msgf("live room %s went live, recording %s", room.Url, res.Id)
+		break
+	}
+	if !room.Live {
+		room.Recording = ""
+	}
+	snapshot := *room
+	l.mu.Unlock()
+
+	if snapshot.Live != wasLive || snapshot.Error != "" {
+		httpServerOnce.send("liveRoom", snapshot)
+	}
+}
+
+// list is the watched rooms in config order
+func (l *LiveWatcher) list() []LiveRoom {
+	configured := parseLiveRooms(globalConfig.LiveRooms)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]LiveRoom, 0, len(configured))
+	for _, room := range configured {
+		if state, ok := l.rooms[room.Url]; ok {
+			room = *state
+		}
+		list = append(list, room)
+	}
+	return list
+}
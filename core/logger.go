@@ -1,17 +1,56 @@
 package core
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/rs/zerolog"
 	"io"
 	"os"
 	"path/filepath"
 	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// logRecentLimit is how many lines are kept in memory for the troubleshooting panel
+const logRecentLimit = 1000
+
 type Logger struct {
 	zerolog.Logger
-	logFile *os.File
+	sink    *logSink
+	mu      sync.Mutex
+	modules map[string]*zerolog.Logger
+}
+
+// logSink is where every logger writes, it filters by level, keeps the recent lines
+// and writes them to the console or the rotated file as text or JSON
+type logSink struct {
+	mu       sync.Mutex
+	out      io.Writer
+	file     *rotateFile
+	console  zerolog.ConsoleWriter
+	json     bool
+	level    zerolog.Level
+	modules  map[string]zerolog.Level
+	recent   [][]byte
+	position int
+}
+
+// levelWriter tags the writes of one module so the sink can apply its level
+type levelWriter struct {
+	sink   *logSink
+	module string
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w levelWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	return len(p), w.sink.write(w.module, level, p)
 }
 
 func initLogger() *Logger {
@@ -22,47 +61,208 @@ func initLogger() *Logger {
 }
 
 func (l *Logger) Close() {
-	_ = l.logFile.Close()
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	if l.sink.file != nil {
+		_ = l.sink.file.Close()
+	}
 }
 
 func (l *Logger) Err(err error) {
-	l.Error().Stack().Err(err)
+	l.Error().Stack().Err(err).Send()
 }
 
 func (l *Logger) Esg(err error, format string, v ...interface{}) {
 	l.Error().Stack().Err(err).Msgf(fmt.Sprintf(format, v...))
 }
 
+// Module is the logger of a subsystem such as proxy or downloader, its level can be set apart in Config.LogLevels
+func (l *Logger) Module(name string) *zerolog.Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if logger, ok := l.modules[name]; ok {
+		return logger
+	}
+	logger := zerolog.New(levelWriter{sink: l.sink, module: name}).With().Timestamp().Str("module", name).Logger()
+	l.modules[name] = &logger
+	return &logger
+}
+
 // NewLogger create a new logger
 func NewLogger(logFile bool, logPath string) *Logger {
-	var out io.Writer
+	sink := &logSink{
+		out:     os.Stdout,
+		level:   zerolog.TraceLevel,
+		modules: map[string]zerolog.Level{},
+	}
 	if logFile {
 		// log to file
 		logDir := filepath.Dir(logPath)
 		if err := shared.CreateDirIfNotExist(logDir); err != nil {
 			panic(err)
 		}
-		var (
-			logfile *os.File
-			err     error
-		)
-		logfile, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, err := openRotateFile(logPath)
 		if err != nil {
 			panic(err)
 		}
-		out = logfile
+		sink.file = file
+		sink.out = file
+	}
+	sink.console = zerolog.ConsoleWriter{
+		NoColor:    true,
+		Out:        sink.out,
+		TimeFormat: "2006-01-02 15:04:05",
+	}
+
+	return &Logger{
+		Logger:  zerolog.New(levelWriter{sink: sink}).With().Timestamp().Logger(),
+		sink:    sink,
+		modules: map[string]*zerolog.Logger{},
+	}
+}
+
+// configure applies Config.LogJson, LogLevel, LogLevels and the rotation limits
+func (l *Logger) configure() {
+	s := l.sink
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.json = globalConfig.LogJson
+	s.level = parseLogLevel(globalConfig.LogLevel, zerolog.DebugLevel)
+	s.modules = map[string]zerolog.Level{}
+	scanner := bufio.NewScanner(strings.NewReader(globalConfig.LogLevels))
+	for scanner.Scan() {
+		name, level, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && !strings.HasPrefix(name, "#") {
+			s.modules[strings.TrimSpace(name)] = parseLogLevel(level, s.level)
+		}
+	}
+	if s.file != nil {
+		s.file.maxSize = int64(globalConfig.LogMaxSize) * 1024 * 1024
+		s.file.maxFiles = globalConfig.LogMaxFiles
+	}
+}
+
+func parseLogLevel(value string, fallback zerolog.Level) zerolog.Level {
+	level, err := zerolog.ParseLevel(strings.ToLower(strings.TrimSpace(value)))
+	if err != nil || value == "" {
+		return fallback
+	}
+	return level
+}
+
+func (s *logSink) write(module string, level zerolog.Level, p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	threshold := s.level
+	if moduleLevel, ok := s.modules[module]; ok {
+		threshold = moduleLevel
+	}
+	if level < threshold {
+		return nil
+	}
+
+	line := append([]byte{}, bytes.TrimSpace(p)...)
+	if len(s.recent) < logRecentLimit {
+		s.recent = append(s.recent, line)
 	} else {
-		out = os.Stdout
+		s.recent[s.position] = line
+		s.position = (s.position + 1) % logRecentLimit
 	}
 
-	logger := &Logger{}
-	if logFile {
-		logger.logFile = out.(*os.File)
+	if s.json {
+		_, err := s.out.Write(p)
+		return err
 	}
-	logger.Logger = zerolog.New(zerolog.ConsoleWriter{
-		NoColor:    true,
-		Out:        out,
-		TimeFormat: "2006-01-02 15:04:05",
-	}).With().Timestamp().Logger()
-	return logger
+	_, err := s.console.Write(p)
+	return err
+}
+
+// recentLines returns up to limit of the latest lines at or above level, optionally of one module, oldest first
+func (l *Logger) recentLines(limit int, level, module string) []json.RawMessage {
+	s := l.sink
+	threshold := parseLogLevel(level, zerolog.TraceLevel)
+	s.mu.Lock()
+	lines := make([][]byte, 0, len(s.recent))
+	lines = append(lines, s.recent[s.position:]...)
+	lines = append(lines, s.recent[:s.position]...)
+	s.mu.Unlock()
+
+	list := make([]json.RawMessage, 0)
+	for i := len(lines) - 1; i >= 0 && len(list) < limit; i-- {
+		var entry struct {
+			Level  string `json:"level"`
+			Module string `json:"module"`
+		}
+		if json.Unmarshal(lines[i], &entry) != nil {
+			continue
+		}
+		if module != "" && entry.Module != module {
+			continue
+		}
+		if parseLogLevel(entry.Level, zerolog.NoLevel) < threshold {
+			continue
+		}
+		list = append(list, json.RawMessage(lines[i]))
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// rotateFile renames app.log to app.log.1, app.log.1 to app.log.2 and so on once it grows past maxSize
+type rotateFile struct {
+	path     string
+	file     *os.File
+	size     int64
+	maxSize  int64 // 0 never rotates
+	maxFiles int
+}
+
+func openRotateFile(path string) (*rotateFile, error) {
+	r := &rotateFile{path: path}
+	return r, r.open()
+}
+
+func (r *rotateFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file, r.size = file, 0
+	if info, err := file.Stat(); err == nil {
+		r.size = info.Size()
+	}
+	return nil
+}
+
+// Write is called with the sink locked
+func (r *rotateFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		r.rotate()
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotateFile) rotate() {
+	_ = r.file.Close()
+	keep := r.maxFiles
+	if keep < 1 {
+		keep = 1
+	}
+	_ = os.Remove(r.path + "." + strconv.Itoa(keep))
+	for i := keep - 1; i >= 1; i-- {
+		_ = os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+	}
+	_ = os.Rename(r.path, r.path+".1")
+	if err := r.open(); err != nil {
+		// keep logging somewhere rather than lose the lines
+		r.file = os.Stderr
+	}
+}
+
+func (r *rotateFile) Close() error {
+	return r.file.Close()
 }
@@ -0,0 +1,65 @@
+//go:build darwin
+
+package core
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+)
+
+const loginAgentLabel = "com.res-downloader.service"
+
+func loginAgentFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", loginAgentLabel+".plist"), nil
+}
+
+// setLaunchAtLogin writes a launch agent starting the service when the user logs in, launchd
+// reads it at the next login
+func setLaunchAtLogin(enable bool) error {
+	file, err := loginAgentFile()
+	if err != nil {
+		return err
+	}
+	if !enable {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	exe, err := serviceExecutable()
+	if err != nil {
+		return err
+	}
+	var escaped bytes.Buffer
+	if err := xml.EscapeText(&escaped, []byte(exe)); err != nil {
+		return err
+	}
+	plist := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + loginAgentLabel + `</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>` + escaped.String() + `</string>
+		<string>service</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>ProcessType</key>
+	<string>Background</string>
+</dict>
+</plist>
+`
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(plist), 0644)
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func loginDesktopFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "autostart", loginName+".desktop"), nil
+}
+
+// setLaunchAtLogin writes an XDG autostart entry, which desktop sessions run at login
+func setLaunchAtLogin(enable bool) error {
+	file, err := loginDesktopFile()
+	if err != nil {
+		return err
+	}
+	if !enable {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	exe, err := serviceExecutable()
+	if err != nil {
+		return err
+	}
+	// inside double quotes of an Exec key these four are escaped with a backslash
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`).Replace(exe)
+	entry := "[Desktop Entry]\n" +
+		"Type=Application\n" +
+		"Name=" + loginName + "\n" +
+		"Exec=\"" + quoted + "\" service\n" +
+		"NoDisplay=true\n" +
+		"X-GNOME-Autostart-enabled=true\n"
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(entry), 0644)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package core
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const loginRunKey = `Software\Microsoft\Windows\CurrentVersion\Run`
+
+// setLaunchAtLogin adds the service to the Run key of the user, the GUI binary starts without a console
+func setLaunchAtLogin(enable bool) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, loginRunKey, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	if !enable {
+		if err := key.DeleteValue(loginName); err != nil && !errors.Is(err, registry.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	exe, err := serviceExecutable()
+	if err != nil {
+		return err
+	}
+	return key.SetStringValue(loginName, `"`+exe+`" service`)
+}
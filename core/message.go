@@ -0,0 +1,176 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Message codes of the errors and remarks core shows to the user. The code goes out next to the
+// text (messageCode in api replies, MessageCode in events) so clients can react without parsing it.
+const (
+	MsgSaveDirectoryUnset   = "save_directory_unset"
+	MsgTaskNotFound         = "task_not_found"
+	MsgTaskNotPaused        = "task_not_paused"
+	MsgTaskNotQueued        = "task_not_queued"
+	MsgDownloadCancelled    = "download_cancelled"
+	MsgStatusCode           = "status_code"
+	MsgDiskSpace            = "disk_space"
+	MsgNoUrl                = "no_url"
+	MsgNoResources          = "no_resources"
+	MsgNoFileSelected       = "no_file_selected"
+	MsgBatchNotFound        = "batch_not_found"
+	MsgPreviewNotFound      = "preview_not_found"
+	MsgResourceNotFound     = "resource_not_found"
+	MsgGroupNotFound        = "group_not_found"
+	MsgSeriesNotFound       = "series_not_found"
+	MsgProfileNameRequired  = "profile_name_required"
+	MsgProfileNotFound      = "profile_not_found"
+	MsgSessionNameRequired  = "session_name_required"
+	MsgSessionNotFound      = "session_not_found"
+	MsgSettingsInvalid      = "settings_invalid"
+	MsgPassphraseRequired   = "passphrase_required"
+	MsgPassphraseWrong      = "passphrase_wrong"
+	MsgUpdateRunning        = "update_running"
+	MsgUpdateNone           = "update_none"
+	MsgUpdateNoPrevious     = "update_no_previous"
+	MsgUpdateChecksumFailed = "update_checksum_failed"
+	MsgUpdateUnsigned       = "update_unsigned"
+	MsgWindowOnly           = "window_only"
+	MsgVaultMode            = "vault_mode"
+	MsgVaultLocked          = "vault_locked"
+	MsgVaultPassphrase      = "vault_passphrase"
+	MsgVaultDisabled        = "vault_disabled"
+	MsgVaultUnknownEntry    = "vault_unknown_entry"
+	MsgKeychainUnavailable  = "keychain_unavailable"
+	MsgProxyUnknown         = "proxy_unknown"
+)
+
+// messageCatalog holds the text of every code per Config.Locale, English is the fallback
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		MsgSaveDirectoryUnset:   "save directory is not set",
+		MsgTaskNotFound:         "task not found",
+		MsgTaskNotPaused:        "task is not paused",
+		MsgTaskNotQueued:        "task not queued",
+		MsgDownloadCancelled:    "download cancelled",
+		MsgStatusCode:           "unexpected status code: %d",
+		MsgDiskSpace:            "not enough disk space at %s: %d MB free, %d MB needed",
+		MsgNoUrl:                "no url found",
+		MsgNoResources:          "no resources selected",
+		MsgNoFileSelected:       "no file selected",
+		MsgBatchNotFound:        "batch not found",
+		MsgPreviewNotFound:      "preview not found",
+		MsgResourceNotFound:     "resource not found",
+		MsgGroupNotFound:        "resource group not found",
+		MsgSeriesNotFound:       "no episode list found in this response",
+		MsgProfileNameRequired:  "profile name is required",
+		MsgProfileNotFound:      "profile not found",
+		MsgSessionNameRequired:  "session name is required",
+		MsgSessionNotFound:      "session not found",
+		MsgSettingsInvalid:      "not a valid settings file",
+		MsgPassphraseRequired:   "the settings file is encrypted, a passphrase is required",
+		MsgPassphraseWrong:      "wrong passphrase",
+		MsgUpdateRunning:        "an update is already running",
+		MsgUpdateNone:           "already up to date",
+		MsgUpdateNoPrevious:     "no previous version to roll back to",
+		MsgUpdateChecksumFailed: "checksum of the download does not match",
+		MsgUpdateUnsigned:       "this build carries no release key, updates can't be verified",
+		MsgWindowOnly:           "%s can only be changed in the window or over the token api",
+		MsgVaultMode:            "unknown vault mode: %s",
+		MsgVaultLocked:          "the vault is locked, unlock it with its passphrase",
+		MsgVaultPassphrase:      "the vault needs a passphrase",
+		MsgVaultDisabled:        "the vault is not set up",
+		MsgVaultUnknownEntry:    "%s is not kept in the vault",
+		MsgKeychainUnavailable:  "the system keychain is not available: %s",
+		MsgProxyUnknown:         "unknown download proxy: %s",
+	},
+	"zh": {
+		MsgSaveDirectoryUnset:   "未设置保存目录",
+		MsgTaskNotFound:         "任务不存在",
+		MsgTaskNotPaused:        "任务未暂停",
+		MsgTaskNotQueued:        "任务不在队列中",
+		MsgDownloadCancelled:    "下载已取消",
+		MsgStatusCode:           "服务器返回异常状态码：%d",
+		MsgDiskSpace:            "%s 磁盘空间不足：剩余 %d MB，需要 %d MB",
+		MsgNoUrl:                "未找到链接",
+		MsgNoResources:          "未选择资源",
+		MsgNoFileSelected:       "未选择文件",
+		MsgBatchNotFound:        "批次不存在",
+		MsgPreviewNotFound:      "预览不存在",
+		MsgResourceNotFound:     "资源不存在",
+		MsgGroupNotFound:        "资源分组不存在",
+		MsgSeriesNotFound:       "该响应中未找到剧集列表",
+		MsgProfileNameRequired:  "请填写配置方案名称",
+		MsgProfileNotFound:      "配置方案不存在",
+		MsgSessionNameRequired:  "请填写会话名称",
+		MsgSessionNotFound:      "会话不存在",
+		MsgSettingsInvalid:      "不是有效的设置文件",
+		MsgPassphraseRequired:   "设置文件已加密，需要输入密码",
+		MsgPassphraseWrong:      "密码错误",
+		MsgUpdateRunning:        "正在更新中",
+		MsgUpdateNone:           "已是最新版本",
+		MsgUpdateNoPrevious:     "没有可回滚的旧版本",
+		MsgUpdateChecksumFailed: "下载文件校验失败",
+		MsgUpdateUnsigned:       "此版本未内置发布公钥，无法校验更新",
+		MsgWindowOnly:           "%s 只能在窗口或带令牌的 API 中修改",
+		MsgVaultMode:            "未知的保险库模式：%s",
+		MsgVaultLocked:          "保险库已锁定，请输入密码解锁",
+		MsgVaultPassphrase:      "保险库需要设置密码",
+		MsgVaultDisabled:        "未启用保险库",
+		MsgVaultUnknownEntry:    "%s 不保存在保险库中",
+		MsgKeychainUnavailable:  "系统钥匙串不可用：%s",
+		MsgProxyUnknown:         "未知的下载代理：%s",
+	},
+}
+
+// localize renders code in the language of Config.Locale
+func localize(code string, args ...interface{}) string {
+	lang := "en"
+	if globalConfig != nil {
+		lang = strings.ToLower(globalConfig.Locale)
+	}
+	text, ok := messageCatalog[lang][code]
+	if !ok {
+		if text, ok = messageCatalog["en"][code]; !ok {
+			text = code
+		}
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// codedError is an error that carries a message code
+type codedError interface {
+	error
+	MessageCode() string
+}
+
+// MessageError is an error from the catalog, its text follows the locale at the time it is shown
+type MessageError struct {
+	Code string
+	Args []interface{}
+}
+
+func messageError(code string, args ...interface{}) *MessageError {
+	return &MessageError{Code: code, Args: args}
+}
+
+func (e *MessageError) Error() string {
+	return localize(e.Code, e.Args...)
+}
+
+func (e *MessageError) MessageCode() string {
+	return e.Code
+}
+
+// errorCode is the message code somewhere in err's chain, empty for errors outside the catalog
+func errorCode(err error) string {
+	var coded codedError
+	if errors.As(err, &coded) {
+		return coded.MessageCode()
+	}
+	return ""
+}
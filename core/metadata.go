@@ -0,0 +1,227 @@
+package core
+
+import (
+	"encoding/json"
+	"net/url"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataFields are the OtherData keys enrichment fills, with the api keys each is read from,
+// the more specific names first
+var metadataFields = []struct {
+	key   string
+	names []string
+}{
+	{"title", []string{"title", "display_title", "share_title"}},
+	{"author", []string{"nickname", "nick_name", "author_name", "screen_name", "uname", "author"}},
+	{"publish_time", []string{"create_time", "createTime", "publish_time", "publishTime", "pubdate", "ctime", "time"}},
+	{"likes", []string{"digg_count", "liked_count", "like_count", "likeCount", "likes"}},
+	{"description", []string{"desc", "description", "caption", "content"}},
+}
+
+// metadataNested are the objects next to the media that carry the author and counters
+var metadataNested = []string{"author", "user", "owner", "statistics", "stats", "stat", "interact_info", "note_card", "basic_info"}
+
+// enrichMetadata fills title, author, publish_time, likes and description of res from the api
+// response that lists it. The objects enclosing the media url are searched, nearest first, so an
+// item of a feed is described by its own fields. What a plugin or the resolver set is kept.
+func enrichMetadata(res *shared.MediaInfo, body string) {
+	u, err := url.Parse(res.Url)
+	if err != nil || len(u.Path) < 8 {
+		return
+	}
+	var chain []map[string]interface{}
+	if !findEnclosing(parsePartialJson(body), u.Path, &chain) {
+		return
+	}
+	if res.OtherData == nil {
+		res.OtherData = map[string]string{}
+	}
+	for _, field := range metadataFields {
+		if res.OtherData[field.key] != "" {
+			continue
+		}
+		if value := metadataValue(chain, field.key, field.names); value != "" {
+			res.OtherData[field.key] = value
+		}
+	}
+	if res.Description == "" {
+		res.Description = res.OtherData["title"]
+		if res.Description == "" {
+			res.Description = res.OtherData["description"]
+		}
+	}
+}
+
+// findEnclosing collects the objects on the way to a string containing needle, innermost first
+func findEnclosing(node interface{}, needle string, chain *[]map[string]interface{}) bool {
+	switch v := node.(type) {
+	case string:
+		return strings.Contains(v, needle)
+	case []interface{}:
+		for _, item := range v {
+			if findEnclosing(item, needle, chain) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if findEnclosing(item, needle, chain) {
+				*chain = append(*chain, v)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func metadataValue(chain []map[string]interface{}, key string, names []string) string {
+	for _, object := range chain {
+		if value := metadataLookup(object, key, names); value != "" {
+			return value
+		}
+		for _, nested := range metadataNested {
+			if child, ok := object[nested].(map[string]interface{}); ok {
+				if value := metadataLookup(child, key, names); value != "" {
+					return value
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func metadataLookup(object map[string]interface{}, key string, names []string) string {
+	for _, name := range names {
+		value, ok := object[name]
+		if !ok {
+			continue
+		}
+		switch key {
+		case "publish_time":
+			if t := metadataTime(value); t > 0 {
+				return strconv.FormatInt(t, 10)
+			}
+		case "likes":
+			if n, ok := metadataNumber(value); ok {
+				return strconv.FormatInt(n, 10)
+			}
+		default:
+			if s, ok := value.(string); ok && strings.TrimSpace(s) != "" {
+				return strings.TrimSpace(s)
+			}
+		}
+	}
+	return ""
+}
+
+// metadataTime reads unix seconds or milliseconds, from a number or a numeric string
+func metadataTime(value interface{}) int64 {
+	n, ok := metadataNumber(value)
+	if !ok {
+		return 0
+	}
+	if n > 1e12 {
+		n /= 1000
+	}
+	// anything before 2005 is not a publish date
+	if n < 1104537600 || n > time.Now().Add(24*time.Hour).Unix() {
+		return 0
+	}
+	return n
+}
+
+func metadataNumber(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, true
+		}
+		if f, err := v.Float64(); err == nil {
+			return int64(f), true
+		}
+	case string:
+		// counters like "1.2万" are left out, only plain numbers are taken
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// parsePartialJson decodes as much of body as is well formed. Preview bodies are cut at
+// previewBodyLimit, the items of a feed before the cut are still whole.
+func parsePartialJson(body string) interface{} {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var root interface{}
+	// stack of open containers, keys holds the pending key of each open object as "k"+key
+	var stack []interface{}
+	var keys []string
+	put := func(value interface{}) {
+		if len(stack) == 0 {
+			root = value
+			return
+		}
+		switch parent := stack[len(stack)-1].(type) {
+		case map[string]interface{}:
+			parent[strings.TrimPrefix(keys[len(keys)-1], "k")] = value
+			keys[len(keys)-1] = ""
+		case *[]interface{}:
+			*parent = append(*parent, value)
+		}
+	}
+	expectKey := func() bool {
+		if len(stack) == 0 {
+			return false
+		}
+		_, isObject := stack[len(stack)-1].(map[string]interface{})
+		return isObject && keys[len(keys)-1] == ""
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case json.Delim:
+			switch t {
+			case '{':
+				object := map[string]interface{}{}
+				put(object)
+				stack = append(stack, object)
+				keys = append(keys, "")
+			case '[':
+				list := &[]interface{}{}
+				stack = append(stack, list)
+				keys = append(keys, "")
+			case '}', ']':
+				top := stack[len(stack)-1]
+				stack, keys = stack[:len(stack)-1], keys[:len(keys)-1]
+				if list, ok := top.(*[]interface{}); ok {
+					put(*list)
+				}
+			}
+		case string:
+			if expectKey() {
+				keys[len(keys)-1] = "k" + t
+			} else {
+				put(t)
+			}
+		default:
+			put(t)
+		}
+	}
+	// arrays are put into their parent when they close, the ones the cut left open still count
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		stack, keys = stack[:len(stack)-1], keys[:len(keys)-1]
+		if list, ok := top.(*[]interface{}); ok {
+			put(*list)
+		}
+	}
+	return root
+}
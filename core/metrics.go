@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics counts what only passes by, gauges are read from their owners at scrape time
+type Metrics struct {
+	mu         sync.Mutex
+	captured   map[string]int64 // by classify
+	downloads  map[string]int64 // by final status
+	attempts   map[string]int64 // by attempt outcome, done or an error kind
+	downloaded int64            // bytes of finished attempts, atomic
+
+	serverMu sync.Mutex
+	server   *http.Server
+	addr     string
+}
+
+func initMetrics() *Metrics {
+	if metricsOnce == nil {
+		metricsOnce = &Metrics{
+			captured:  make(map[string]int64),
+			downloads: make(map[string]int64),
+			attempts:  make(map[string]int64),
+		}
+	}
+	return metricsOnce
+}
+
+func (m *Metrics) count(counters map[string]int64, label string) {
+	m.mu.Lock()
+	counters[label]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) capture(classify string) {
+	m.count(m.captured, classify)
+}
+
+func (m *Metrics) download(status string) {
+	m.count(m.downloads, status)
+}
+
+func (m *Metrics) attempt(bytes float64, kind string) {
+	if kind == "" {
+		kind = "done"
+	}
+	m.count(m.attempts, kind)
+	atomic.AddInt64(&m.downloaded, int64(bytes))
+}
+
+// apply starts, stops or moves the listener to match Config.MetricsEnable and Config.MetricsListen
+func (m *Metrics) apply() {
+	m.serverMu.Lock()
+	defer m.serverMu.Unlock()
+
+	addr := strings.TrimSpace(globalConfig.MetricsListen)
+	if m.server != nil && (!globalConfig.MetricsEnable || addr != m.addr) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		_ = m.server.Shutdown(ctx)
+		cancel()
+		m.server = nil
+	}
+	if !globalConfig.MetricsEnable || m.server != nil {
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		globalLogger.Esg(err, "start metrics server failed")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", m.serve)
+	m.addr = addr
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			globalLogger.Esg(err, "metrics server stopped")
+		}
+	}(m.server)
+	globalLogger.Info().Msgf("metrics listening on %s", addr)
+}
+
+func (m *Metrics) stop() {
+	m.serverMu.Lock()
+	defer m.serverMu.Unlock()
+	if m.server != nil {
+		_ = m.server.Close()
+		m.server = nil
+	}
+}
+
+// serve writes the Prometheus text format
+func (m *Metrics) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	var requests, connects, bytesIn, bytesOut int64
+	for _, item := range trafficOnce.list() {
+		requests += item.Requests
+		connects += item.Connects
+		bytesIn += item.BytesIn
+		bytesOut += item.BytesOut
+	}
+	metric(w, "res_downloader_proxy_requests_total", "counter", "Requests seen by the capture proxy.")
+	sample(w, "res_downloader_proxy_requests_total", "", float64(requests))
+	metric(w, "res_downloader_proxy_connects_total", "counter", "CONNECT tunnels opened through the capture proxy.")
+	sample(w, "res_downloader_proxy_connects_total", "", float64(connects))
+	metric(w, "res_downloader_proxy_bytes_total", "counter", "Bytes through the capture proxy.")
+	sample(w, "res_downloader_proxy_bytes_total", `direction="in"`, float64(bytesIn))
+	sample(w, "res_downloader_proxy_bytes_total", `direction="out"`, float64(bytesOut))
+
+	m.mu.Lock()
+	metric(w, "res_downloader_captured_total", "counter", "Resources captured, by type.")
+	labeled(w, "res_downloader_captured_total", "type", m.captured)
+	metric(w, "res_downloader_downloads_total", "counter", "Finished downloads, by status.")
+	labeled(w, "res_downloader_downloads_total", "status", m.downloads)
+	metric(w, "res_downloader_download_attempts_total", "counter", "Download attempts, by outcome.")
+	labeled(w, "res_downloader_download_attempts_total", "outcome", m.attempts)
+	m.mu.Unlock()
+	metric(w, "res_downloader_downloaded_bytes_total", "counter", "Bytes written by finished download attempts.")
+	sample(w, "res_downloader_downloaded_bytes_total", "", float64(atomic.LoadInt64(&m.downloaded)))
+
+	waiting, parked := 0, 0
+	for _, item := range queueOnce.list() {
+		if item.Paused {
+			parked++
+		} else {
+			waiting++
+		}
+	}
+	metric(w, "res_downloader_queue_items", "gauge", "Downloads in the queue, by state.")
+	sample(w, "res_downloader_queue_items", `state="waiting"`, float64(waiting))
+	sample(w, "res_downloader_queue_items", `state="paused"`, float64(parked))
+	sample(w, "res_downloader_queue_items", `state="running"`, float64(len(queueOnce.runningIds())))
+
+	speed := 0.0
+	historyOnce.running.Range(func(_, value interface{}) bool {
+		attempt := value.(*liveAttempt)
+		attempt.mu.Lock()
+		speed += attempt.speed
+		attempt.mu.Unlock()
+		return true
+	})
+	metric(w, "res_downloader_download_speed_bytes", "gauge", "Combined speed of running downloads in bytes per second.")
+	sample(w, "res_downloader_download_speed_bytes", "", speed)
+}
+
+func metric(w io.Writer, name, kind, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	_, _ = fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+func labeled(w io.Writer, name, label string, counters map[string]int64) {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sample(w, name, fmt.Sprintf("%s=%q", label, key), float64(counters[key]))
+	}
+}
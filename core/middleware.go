@@ -5,6 +5,16 @@ import (
 	"strings"
 )
 
+// tokenRoutes are the legacy routes that take the api token like the /v1 api
+var tokenRoutes = map[string]bool{
+	"/api/events":    true,
+	"/api/logs":      true,
+	"/api/resources": true, // the captures carry their cookies and authorization headers
+	// captured response bodies, account data included
+	"/api/response-previews": true,
+	"/api/response-preview":  true,
+}
+
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if HandleApi(w, r) {
@@ -19,12 +29,17 @@ func HandleApi(w http.ResponseWriter, r *http.Request) bool {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		if r.URL.Path != "/api/preview" {
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return true
 		}
+		// any page can reach the legacy api, what carries captured urls, headers or the log wants the api token
+		if tokenRoutes[r.URL.Path] && !tokenAuthorized(r) {
+			httpServerOnce.error(w, "invalid api token")
+			return true
+		}
 		switch r.URL.Path {
 		case "/api/install":
 			httpServerOnce.install(w, r)
@@ -64,6 +79,158 @@ func HandleApi(w http.ResponseWriter, r *http.Request) bool {
 			httpServerOnce.wxFileDecode(w, r)
 		case "/api/batch-export":
 			httpServerOnce.batchExport(w, r)
+		case "/api/traffic-stats":
+			httpServerOnce.trafficStats(w, r)
+		case "/api/traffic-clear":
+			httpServerOnce.trafficClear(w, r)
+		case "/api/adblock-update":
+			httpServerOnce.adBlockUpdate(w, r)
+		case "/api/cache-stats":
+			httpServerOnce.cacheStats(w, r)
+		case "/api/cache-clear":
+			httpServerOnce.cacheClear(w, r)
+		case "/api/sessions":
+			httpServerOnce.sessions(w, r)
+		case "/api/session-save":
+			httpServerOnce.sessionSave(w, r)
+		case "/api/session-restore":
+			httpServerOnce.sessionRestore(w, r)
+		case "/api/session-delete":
+			httpServerOnce.sessionDelete(w, r)
+		case "/api/vault":
+			httpServerOnce.vault(w, r)
+		case "/api/vault-unlock":
+			httpServerOnce.vaultUnlock(w, r)
+		case "/api/cleanup":
+			httpServerOnce.cleanup(w, r)
+		case "/api/range-status":
+			httpServerOnce.rangeStatus(w, r)
+		case "/api/pinning":
+			httpServerOnce.pinning(w, r)
+		case "/api/pinning-clear":
+			httpServerOnce.pinningClear(w, r)
+		case "/api/danmaku-sessions":
+			httpServerOnce.danmakuSessions(w, r)
+		case "/api/danmaku-export":
+			httpServerOnce.danmakuExport(w, r)
+		case "/api/process-proxy-start":
+			httpServerOnce.processProxyStart(w, r)
+		case "/api/process-proxy-stop":
+			httpServerOnce.processProxyStop(w, r)
+		case "/api/process-proxy-status":
+			httpServerOnce.processProxyStatus(w, r)
+		case "/api/response-previews":
+			httpServerOnce.responsePreviews(w, r)
+		case "/api/response-preview":
+			httpServerOnce.responsePreview(w, r)
+		case "/api/pending-downloads":
+			httpServerOnce.pendingDownloads(w, r)
+		case "/api/resume-downloads":
+			httpServerOnce.resumeDownloads(w, r)
+		case "/api/speed-limit":
+			httpServerOnce.speedLimit(w, r)
+		case "/api/queue":
+			httpServerOnce.queueList(w, r)
+		case "/api/queue-move":
+			httpServerOnce.queueMove(w, r)
+		case "/api/queue-priority":
+			httpServerOnce.queuePriority(w, r)
+		case "/api/queue-pause":
+			httpServerOnce.queuePause(w, r)
+		case "/api/batch-download":
+			httpServerOnce.batchDownload(w, r)
+		case "/api/batch-status":
+			httpServerOnce.batchStatus(w, r)
+		case "/api/aria2-check":
+			httpServerOnce.aria2Check(w, r)
+		case "/api/task-headers":
+			httpServerOnce.taskHeaders(w, r)
+		case "/api/task-proxy":
+			httpServerOnce.taskProxy(w, r)
+		case "/api/webdav-check":
+			httpServerOnce.webdavCheck(w, r)
+		case "/api/history":
+			httpServerOnce.history(w, r)
+		case "/api/history-delete":
+			httpServerOnce.historyDelete(w, r)
+		case "/api/list-export":
+			httpServerOnce.listExport(w, r)
+		case "/api/list-import":
+			httpServerOnce.listImport(w, r)
+		case "/api/add-url":
+			httpServerOnce.addUrl(w, r)
+		case "/api/schedule-status":
+			httpServerOnce.scheduleStatus(w, r)
+		case "/api/pause":
+			httpServerOnce.pause(w, r)
+		case "/api/resume":
+			httpServerOnce.resume(w, r)
+		case "/api/cookies":
+			httpServerOnce.cookies(w, r)
+		case "/api/cookies-clear":
+			httpServerOnce.cookiesClear(w, r)
+		case "/api/events":
+			httpServerOnce.events(w, r)
+		case "/api/analytics":
+			httpServerOnce.analytics(w, r)
+		case "/api/stats":
+			httpServerOnce.stats(w, r)
+		case "/api/stats-export":
+			httpServerOnce.statsExport(w, r)
+		case "/api/task-log":
+			httpServerOnce.taskLog(w, r)
+		case "/api/variants":
+			httpServerOnce.variants(w, r)
+		case "/api/resources":
+			httpServerOnce.resources(w, r)
+		case "/api/plugins":
+			httpServerOnce.plugins(w, r)
+		case "/api/rule-packs":
+			httpServerOnce.rulePacks(w, r)
+		case "/api/rule-packs-update":
+			httpServerOnce.rulePacksUpdate(w, r)
+		case "/api/logs":
+			httpServerOnce.logs(w, r)
+		case "/api/profiles":
+			httpServerOnce.profiles(w, r)
+		case "/api/profile-save":
+			httpServerOnce.profileSave(w, r)
+		case "/api/profile-switch":
+			httpServerOnce.profileSwitch(w, r)
+		case "/api/profile-delete":
+			httpServerOnce.profileDelete(w, r)
+		case "/api/update-check":
+			httpServerOnce.updateCheck(w, r)
+		case "/api/diagnostics":
+			httpServerOnce.diagnostics(w, r)
+		case "/api/resource-search":
+			httpServerOnce.resourceSearch(w, r)
+		case "/api/resource-mark":
+			httpServerOnce.resourceMark(w, r)
+		case "/api/resource-text":
+			httpServerOnce.resourceText(w, r)
+		case "/api/resource-tags":
+			httpServerOnce.resourceTags(w, r)
+		case "/api/resource-group":
+			httpServerOnce.resourceGroup(w, r)
+		case "/api/group-download":
+			httpServerOnce.groupDownload(w, r)
+		case "/api/image-set":
+			httpServerOnce.imageSet(w, r)
+		case "/api/series":
+			httpServerOnce.series(w, r)
+		case "/api/series-download":
+			httpServerOnce.seriesDownload(w, r)
+		case "/api/live-rooms":
+			httpServerOnce.liveRooms(w, r)
+		case "/api/live-check":
+			httpServerOnce.liveCheck(w, r)
+		case "/api/transcript":
+			httpServerOnce.transcript(w, r)
+		case "/api/clip-export":
+			httpServerOnce.clipExport(w, r)
+		case "/api/notes-export":
+			httpServerOnce.notesExport(w, r)
 		case "/api/cert":
 			httpServerOnce.downCert(w, r)
 		}
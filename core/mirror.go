@@ -0,0 +1,104 @@
+package core
+
+import (
+	"errors"
+	"net/url"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+)
+
+// mirrorSet is one piece of media seen on several CDN hosts, the first capture is listed and
+// the others are kept as fallbacks
+type mirrorSet struct {
+	Id   string
+	Urls []string
+}
+
+// mirrorKey identifies the same file on different hosts of one site by registrable domain, path and
+// size, the CDN nodes of a platform share the domain while other platforms never do
+func mirrorKey(res shared.MediaInfo) (string, bool) {
+	if res.Size <= 0 {
+		return "", false
+	}
+	u, err := url.Parse(res.Url)
+	if err != nil || len(u.Path) < 8 {
+		return "", false
+	}
+	return shared.GetTopLevelDomain(u.Host) + "|" + u.Path + "|" + strconv.FormatFloat(res.Size, 'f', 0, 64), true
+}
+
+// addMirror returns true when res is another host for media already listed
+func (r *Resource) addMirror(res shared.MediaInfo) bool {
+	key, ok := mirrorKey(res)
+	if !ok {
+		return false
+	}
+	r.mirrorMux.Lock()
+	defer r.mirrorMux.Unlock()
+
+	set, ok := r.mirrors[key]
+	if !ok {
+		r.mirrors[key] = &mirrorSet{Id: res.Id, Urls: []string{res.Url}}
+		return false
+	}
+	for _, u := range set.Urls {
+		if u == res.Url {
+			return true
+		}
+	}
+	set.Urls = append(set.Urls, res.Url)
+	httpServerOnce.send("resourceMirror", map[string]string{
+		"Id":  set.Id,
+		"Url": res.Url,
+	})
+	return true
+}
+
+func (r *Resource) mirrorUrls(id string) []string {
+	r.mirrorMux.Lock()
+	defer r.mirrorMux.Unlock()
+	for _, set := range r.mirrors {
+		if set.Id == id {
+			return append([]string(nil), set.Urls...)
+		}
+	}
+	return nil
+}
+
+func (r *Resource) clearMirrors() {
+	r.mirrorMux.Lock()
+	r.mirrors = make(map[string]*mirrorSet)
+	r.mirrorMux.Unlock()
+}
+
+// downloadCandidates lists the primary url followed by every known mirror, without duplicates
+func (r *Resource) downloadCandidates(mediaInfo shared.MediaInfo, primary string, extra ...string) []string {
+	candidates := []string{primary}
+	seen := map[string]bool{primary: true}
+	add := func(u string) {
+		if u = strings.TrimSpace(u); u != "" && !seen[u] {
+			seen[u] = true
+			candidates = append(candidates, u)
+		}
+	}
+	for _, u := range extra {
+		add(u)
+	}
+	for _, u := range r.mirrorUrls(mediaInfo.Id) {
+		add(u)
+	}
+	for _, u := range strings.Split(mediaInfo.OtherData["mirrors"], "\n") {
+		add(u)
+	}
+	return candidates
+}
+
+// shouldFailover tells whether another url may succeed where this one failed
+func shouldFailover(err error) bool {
+	var diskErr *DiskSpaceError
+	if errors.As(err, &diskErr) {
+		return false
+	}
+	return classifyDownloadError(err) != ErrorKindCancelled
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UploadPart is a part the service accepted, what completing the upload lists
+type UploadPart struct {
+	Number int    // 1 based
+	ETag   string // what the service answered the part with
+	Size   int64
+}
+
+// MultipartUploader sends a file in parts of PartSize, Parallel at a time, retrying a failed part
+// Retries times with backoff. How a part is sent and what it is identified by is left to Put, so
+// any chunked PUT protocol can use it; starting and completing the upload stay with the caller.
+type MultipartUploader struct {
+	PartSize int64
+	Parallel int
+	Retries  int
+	// Put sends one part and returns its ETag
+	Put func(ctx context.Context, number int, data []byte) (string, error)
+	// Progress is told the bytes accepted so far, from the goroutines sending the parts
+	Progress func(done, total int64)
+}
+
+// Upload sends size bytes of file and returns the parts in order. The first part that fails for
+// good stops the others.
+func (u *MultipartUploader) Upload(ctx context.Context, file io.ReaderAt, size int64) ([]UploadPart, error) {
+	if u.PartSize <= 0 {
+		return nil, errors.New("multipart upload: part size not set")
+	}
+	count := int((size + u.PartSize - 1) / u.PartSize)
+	if count == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	numbers := make(chan int)
+	go func() {
+		defer close(numbers)
+		for number := 1; number <= count; number++ {
+			select {
+			case numbers <- number:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	parts := make([]UploadPart, 0, count)
+	var mu sync.Mutex
+	var firstErr error
+	var done atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < min(max(u.Parallel, 1), count); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, u.PartSize)
+			for number := range numbers {
+				offset := int64(number-1) * u.PartSize
+				data := buf[:min(u.PartSize, size-offset)]
+				part, err := u.sendPart(ctx, file, number, offset, data)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+					return
+				}
+				parts = append(parts, part)
+				mu.Unlock()
+				if u.Progress != nil {
+					u.Progress(done.Add(part.Size), size)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+// sendPart reads one part and puts it, again after a backoff while attempts are left
+func (u *MultipartUploader) sendPart(ctx context.Context, file io.ReaderAt, number int, offset int64, data []byte) (UploadPart, error) {
+	if _, err := file.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+		return UploadPart{}, err
+	}
+	for attempt := 0; ; attempt++ {
+		etag, err := u.Put(ctx, number, data)
+		if err == nil {
+			return UploadPart{Number: number, ETag: etag, Size: int64(len(data))}, nil
+		}
+		if ctx.Err() != nil || attempt >= u.Retries {
+			return UploadPart{}, fmt.Errorf("part %d: %w", number, err)
+		}
+		timer := time.NewTimer(retryDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return UploadPart{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+	"time"
+)
+
+const (
+	// notesParagraphGap starts a new paragraph of the transcript after a pause this long, in seconds
+	notesParagraphGap = 2.0
+	// notesParagraphLength starts one anyway once a paragraph covers this much, so headings stay frequent
+	notesParagraphLength = 60.0
+)
+
+// NotesRequest asks for a Markdown document of each downloaded resource
+type NotesRequest struct {
+	Ids        []string `json:"ids"`
+	Timestamps bool     `json:"timestamps"` // a heading with the start time above each paragraph of the transcript
+}
+
+// exportNotes writes <name>.md next to each download: title, source, capture date, description
+// and the transcript saved next to it, plain Markdown that Notion, Obsidian and the like import.
+// Resources not downloaded yet are skipped.
+func exportNotes(req NotesRequest) ([]string, error) {
+	var files []string
+	var skipped []string
+	for _, id := range req.Ids {
+		mediaInfo, ok := resourceOnce.store.get(id)
+		if !ok || mediaInfo.SavePath == "" || !shared.FileExist(mediaInfo.SavePath) {
+			skipped = append(skipped, id)
+			continue
+		}
+		fileName := strings.TrimSuffix(mediaInfo.SavePath, filepath.Ext(mediaInfo.SavePath)) + ".md"
+		sidecar := sidecarOf(mediaInfo)
+		if shareUrl := mediaInfo.OtherData["share_url"]; shareUrl != "" {
+			sidecar.Url = shareUrl
+		}
+		var cues []Cue
+		if transcript := transcriptFor(mediaInfo.SavePath); transcript != "" {
+			var err error
+			if cues, err = loadTranscript(transcript); err != nil {
+				globalLogger.Warn().Msgf("notes of %s without transcript: %v", id, err)
+			}
+		}
+		if err := os.WriteFile(fileName, []byte(notesMarkdown(sidecar, mediaInfo.SavePath, cues, req.Timestamps)), 0644); err != nil {
+			return files, err
+		}
+		files = append(files, fileName)
+	}
+	if len(files) == 0 {
+		if len(skipped) > 0 {
+			return nil, errors.New("nothing downloaded to export: " + strings.Join(skipped, ", "))
+		}
+		return nil, messageError(MsgNoResources)
+	}
+	return files, nil
+}
+
+func notesMarkdown(sidecar Sidecar, savePath string, cues []Cue, timestamps bool) string {
+	var b strings.Builder
+	b.WriteString("# " + notesLine(sidecar.Title) + "\n\n")
+	if sidecar.Url != "" {
+		fmt.Fprintf(&b, "- **Source:** <%s>\n", sidecar.Url)
+	}
+	if sidecar.Author != "" {
+		fmt.Fprintf(&b, "- **Author:** %s\n", notesLine(sidecar.Author))
+	}
+	if sidecar.Platform != "" {
+		fmt.Fprintf(&b, "- **Platform:** %s\n", notesLine(sidecar.Platform))
+	}
+	if sidecar.Published > 0 {
+		fmt.Fprintf(&b, "- **Published:** %s\n", time.Unix(sidecar.Published, 0).Format("2006-01-02"))
+	}
+	fmt.Fprintf(&b, "- **Captured:** %s\n", time.Unix(sidecar.Captured, 0).Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "- **File:** %s\n", filepath.Base(savePath))
+
+	if description := strings.TrimSpace(sidecar.Description); description != "" && description != sidecar.Title {
+		b.WriteString("\n")
+		for _, line := range strings.Split(description, "\n") {
+			b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+	}
+
+	if len(cues) > 0 {
+		b.WriteString("\n## Transcript\n")
+		for _, paragraph := range notesParagraphs(cues) {
+			b.WriteString("\n")
+			if timestamps {
+				b.WriteString("### " + notesStamp(paragraph[0].Start) + "\n\n")
+			}
+			texts := make([]string, 0, len(paragraph))
+			for _, cue := range paragraph {
+				if text := strings.TrimSpace(cue.Text); text != "" {
+					texts = append(texts, text)
+				}
+			}
+			b.WriteString(strings.Join(texts, " ") + "\n")
+		}
+	}
+	return b.String()
+}
+
+// notesParagraphs groups the cues at pauses, a running monologue is cut every minute or so
+func notesParagraphs(cues []Cue) [][]Cue {
+	var paragraphs [][]Cue
+	for _, cue := range cues {
+		if last := len(paragraphs) - 1; last >= 0 {
+			paragraph := paragraphs[last]
+			if cue.Start-paragraph[len(paragraph)-1].End < notesParagraphGap && cue.Start-paragraph[0].Start < notesParagraphLength {
+				paragraphs[last] = append(paragraph, cue)
+				continue
+			}
+		}
+		paragraphs = append(paragraphs, []Cue{cue})
+	}
+	return paragraphs
+}
+
+// notesLine keeps a value on one line, a newline would end the heading or the list item
+func notesLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func notesStamp(seconds float64) string {
+	s := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	ocrTimeout = time.Minute
+	// ocrMaxFrames bounds the work on a long video, at the default interval its first 20 minutes
+	ocrMaxFrames = 120
+	// ocrSameWords is the share of words two frames may have in common and still be the same slide
+	ocrSameWords = 0.8
+)
+
+// ocrImageExts are what OCR engines read, animated and vector images are left out
+var ocrImageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".bmp": true, ".tif": true, ".tiff": true, ".webp": true}
+
+// ocrSlot lets one download at a time through Config.OcrCommand, a batch of them doesn't start a
+// shell per frame for each at once
+var ocrSlot = make(chan struct{}, 1)
+
+// recognizeText reads the text off a downloaded image, or off frames sampled from a downloaded
+// video, and stores it with the resource where the search finds it. The image or the frames are
+// copied to the work directory first and Config.OcrCommand runs over them in the background, so
+// the post command and uploads, which may remove the download, don't wait for it.
+func (r *Resource) recognizeText(mediaInfo shared.MediaInfo) {
+	if globalConfig.OcrCommand == "" || mediaInfo.SavePath == "" {
+		return
+	}
+	var dir string
+	var frames []string
+	var interval int
+	var err error
+	switch {
+	case mediaInfo.Classify == "image" && ocrImageExts[strings.ToLower(filepath.Ext(mediaInfo.SavePath))]:
+		dir, frames, err = ocrImageCopy(mediaInfo.SavePath)
+	case sidecarClassify[mediaInfo.Classify]:
+		interval = max(globalConfig.OcrFrameSeconds, 1)
+		dir, frames, err = ocrFrames(mediaInfo.SavePath, interval)
+	default:
+		return
+	}
+	if err != nil {
+		globalLogger.Esg(err, "ocr failed: "+mediaInfo.SavePath)
+		return
+	}
+
+	go func() {
+		defer os.RemoveAll(dir)
+		ocrSlot <- struct{}{}
+		defer func() { <-ocrSlot }()
+		text, err := ocrRead(frames, interval)
+		if err != nil {
+			globalLogger.Esg(err, "ocr failed: "+mediaInfo.SavePath)
+			return
+		}
+		r.store.setText(mediaInfo.UrlSign, TextOcr, text)
+	}()
+}
+
+// ocrImage is the text Config.OcrCommand prints for an image, trimmed
+func ocrImage(fileName string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ocrTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, globalConfig.OcrCommand)
+	cmd.Env = append(os.Environ(), "RD_IMAGE="+fileName)
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ocrImageCopy copies an image into a work folder, the command reads it from there
+func ocrImageCopy(fileName string) (string, []string, error) {
+	dir, err := workFolder()
+	if err != nil {
+		return "", nil, err
+	}
+	image := filepath.Join(dir, "image"+strings.ToLower(filepath.Ext(fileName)))
+	if err := copyWorkFile(fileName, image); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return dir, []string{image}, nil
+}
+
+// ocrFrames saves one frame every interval seconds of a video into a work folder, in order
+func ocrFrames(fileName string, interval int) (string, []string, error) {
+	ffmpeg, err := findFfmpeg("")
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := workFolder()
+	if err != nil {
+		return "", nil, err
+	}
+	output, err := backgroundCommand(ffmpeg, "-hide_banner", "-loglevel", "error", "-i", fileName,
+		"-vf", "fps=1/"+strconv.Itoa(interval), "-frames:v", strconv.Itoa(ocrMaxFrames),
+		"-q:v", "2", filepath.Join(dir, "%05d.jpg")).CombinedOutput()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	frames, err := filepath.Glob(filepath.Join(dir, "*.jpg"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+	sort.Strings(frames)
+	return dir, frames, nil
+}
+
+// ocrRead runs the command over the frames. With an interval, a frame showing the same text as
+// the one before, a slide still on screen, is left out and the rest is kept under the time it
+// appeared; a single image is just its text.
+func ocrRead(frames []string, interval int) (string, error) {
+	if interval == 0 {
+		return ocrImage(frames[0])
+	}
+	var b strings.Builder
+	previous := ""
+	for i, frame := range frames {
+		text, err := ocrImage(frame)
+		if err != nil {
+			return "", err
+		}
+		if text == "" || ocrSameText(previous, text) {
+			continue
+		}
+		previous = text
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("[" + notesStamp(float64(i*interval)) + "]\n" + text)
+	}
+	return b.String(), nil
+}
+
+// ocrSameText tells two readings of the same screen apart from a new one, OCR rarely reads a
+// frame twice exactly alike
+func ocrSameText(a, b string) bool {
+	wordsA, wordsB := ocrWords(a), ocrWords(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return len(wordsA) == len(wordsB)
+	}
+	seen := make(map[string]int, len(wordsA))
+	for _, word := range wordsA {
+		seen[word]++
+	}
+	common := 0
+	for _, word := range wordsB {
+		if seen[word] > 0 {
+			seen[word]--
+			common++
+		}
+	}
+	return float64(common) >= ocrSameWords*float64(max(len(wordsA), len(wordsB)))
+}
+
+// ocrWords splits text into lower case words without punctuation, each Chinese character being
+// one as there are no spaces between them
+func ocrWords(text string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, c):
+			flush()
+			words = append(words, string(c))
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			word = append(word, c)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
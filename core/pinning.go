@@ -0,0 +1,114 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pinningMessage = "此应用校验证书，无法解密"
+
+// PinningRecord describes a host whose clients keep aborting the MITM handshake
+type PinningRecord struct {
+	Domain    string `json:"Domain"`
+	Count     int    `json:"Count"`
+	Reason    string `json:"Reason"`
+	LastError string `json:"LastError"`
+	LastSeen  int64  `json:"LastSeen"`
+	Message   string `json:"Message"`
+}
+
+type PinningDetector struct {
+	mu      sync.Mutex
+	records map[string]*PinningRecord
+}
+
+// proxyLogger receives goproxy's warnings, which is the only place a failed client handshake surfaces
+type proxyLogger struct{}
+
+func (l *proxyLogger) Printf(format string, v ...interface{}) {
+	if strings.Contains(format, "Cannot handshake client") && len(v) >= 3 {
+		if err, ok := v[2].(error); ok {
+			pinningOnce.record(fmt.Sprint(v[1]), err)
+		}
+	}
+	globalLogger.Module("proxy").Debug().Msg(strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func initPinning() *PinningDetector {
+	if pinningOnce == nil {
+		pinningOnce = &PinningDetector{
+			records: make(map[string]*PinningRecord),
+		}
+	}
+	return pinningOnce
+}
+
+// classifyHandshakeError tells pinning-like aborts apart from ordinary network noise
+func classifyHandshakeError(err error) string {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "unknown certificate authority"), strings.Contains(msg, "unknown ca"):
+		return "untrusted_ca"
+	case strings.Contains(msg, "bad certificate"), strings.Contains(msg, "certificate unknown"),
+		strings.Contains(msg, "unsupported certificate"), strings.Contains(msg, "access denied"):
+		return "pinning"
+	case strings.Contains(msg, "eof"), strings.Contains(msg, "connection reset"), strings.Contains(msg, "broken pipe"):
+		return "aborted"
+	default:
+		return ""
+	}
+}
+
+func (p *PinningDetector) record(host string, err error) {
+	reason := classifyHandshakeError(err)
+	if reason == "" {
+		return
+	}
+	if h, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		host = h
+	}
+
+	p.mu.Lock()
+	item, ok := p.records[host]
+	if !ok {
+		item = &PinningRecord{Domain: host, Message: pinningMessage}
+		p.records[host] = item
+	}
+	item.Count++
+	item.LastError = err.Error()
+	item.LastSeen = time.Now().Unix()
+	// a definite pinning signal is never downgraded by later generic aborts
+	if item.Reason != "pinning" {
+		item.Reason = reason
+	}
+	record := *item
+	p.mu.Unlock()
+
+	if !ok {
+		httpServerOnce.send("certPinning", record)
+	}
+}
+
+func (p *PinningDetector) list() []PinningRecord {
+	p.mu.Lock()
+	list := make([]PinningRecord, 0, len(p.records))
+	for _, item := range p.records {
+		list = append(list, *item)
+	}
+	p.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen > list[j].LastSeen
+	})
+	return list
+}
+
+func (p *PinningDetector) clear() {
+	p.mu.Lock()
+	p.records = make(map[string]*PinningRecord)
+	p.mu.Unlock()
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+)
+
+// domainPattern routes hosts to a plugin by wildcard or regex instead of the fixed top-level domain.
+// Supported forms: "*.qq.com" (any subdomain), "v*.qq.com" (* inside a label), "/^.+\.qq\.com$/" (regex).
+type domainPattern struct {
+	raw    string
+	suffix string
+	regex  *regexp.Regexp
+	plugin shared.Plugin
+}
+
+var (
+	pluginPatterns    []domainPattern
+	pluginPatternsMux sync.RWMutex
+)
+
+func isDomainPattern(entry string) bool {
+	return strings.Contains(entry, "*") || strings.HasPrefix(entry, "/")
+}
+
+func compileDomainPattern(entry string, plugin shared.Plugin) (domainPattern, error) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	pattern := domainPattern{raw: entry, plugin: plugin}
+	switch {
+	case strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") && len(entry) > 2:
+		re, err := regexp.Compile(entry[1 : len(entry)-1])
+		if err != nil {
+			return pattern, err
+		}
+		pattern.regex = re
+	case strings.HasPrefix(entry, "*.") && !strings.Contains(entry[2:], "*"):
+		pattern.suffix = entry[2:]
+	case strings.Contains(entry, "*"):
+		parts := strings.Split(entry, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		pattern.regex = regexp.MustCompile("^" + strings.Join(parts, "[a-z0-9-]*") + "$")
+	default:
+		return pattern, fmt.Errorf("invalid domain pattern: %s", entry)
+	}
+	return pattern, nil
+}
+
+func (d domainPattern) match(host string) bool {
+	if d.regex != nil {
+		return d.regex.MatchString(host)
+	}
+	return host == d.suffix || strings.HasSuffix(host, "."+d.suffix)
+}
+
+// loadPluginPatterns rebuilds the pattern list from plugin Domains() and Config.PlatformDomains,
+// each config line is "<plugin domain> <pattern>", e.g. "qq.com *.wechat-cdn.cn"
+func loadPluginPatterns(plugins []shared.Plugin, extra string) {
+	var patterns []domainPattern
+	for _, p := range plugins {
+		for _, entry := range p.Domains() {
+			if !isDomainPattern(entry) {
+				continue
+			}
+			pattern, err := compileDomainPattern(entry, p)
+			if err != nil {
+				globalLogger.Esg(err, "compile plugin domain failed")
+				continue
+			}
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(extra))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			globalLogger.Warn().Msgf("invalid platform domain line: %s", line)
+			continue
+		}
+		plugin, ok := pluginRegistry[strings.ToLower(fields[0])]
+		if !ok {
+			globalLogger.Warn().Msgf("unknown platform: %s", fields[0])
+			continue
+		}
+		entry := fields[1]
+		if !isDomainPattern(entry) {
+			entry = "*." + entry
+		}
+		pattern, err := compileDomainPattern(entry, plugin)
+		if err != nil {
+			globalLogger.Esg(err, "compile platform domain failed")
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	pluginPatternsMux.Lock()
+	pluginPatterns = patterns
+	pluginPatternsMux.Unlock()
+}
+
+func matchPluginPattern(host string) shared.Plugin {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+
+	pluginPatternsMux.RLock()
+	defer pluginPatternsMux.RUnlock()
+	for _, pattern := range pluginPatterns {
+		if pattern.match(host) {
+			return pattern.plugin
+		}
+	}
+	return nil
+}
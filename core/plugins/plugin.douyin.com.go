@@ -0,0 +1,108 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/elazarl/goproxy"
+	"net/http"
+	"regexp"
+	"res-downloader/core/shared"
+	"strings"
+)
+
+var douyinRouterDataRegex = regexp.MustCompile(`window\._ROUTER_DATA\s*=\s*(\{.+?\})\s*</script>`)
+
+// DouyinPlugin only resolves pasted share links, captured traffic is left to the default plugin
+type DouyinPlugin struct {
+	bridge *shared.Bridge
+}
+
+func (p *DouyinPlugin) SetBridge(bridge *shared.Bridge) {
+	p.bridge = bridge
+}
+
+func (p *DouyinPlugin) Domains() []string {
+	return []string{"douyin.com", "iesdouyin.com"}
+}
+
+func (p *DouyinPlugin) OnRequest(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	return nil, nil
+}
+
+func (p *DouyinPlugin) OnResponse(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	return nil
+}
+
+type douyinItem struct {
+	Desc   string `json:"desc"`
+	Author struct {
+		Nickname string `json:"nickname"`
+	} `json:"author"`
+	Video struct {
+		PlayAddr struct {
+			UrlList []string `json:"url_list"`
+		} `json:"play_addr"`
+		Cover struct {
+			UrlList []string `json:"url_list"`
+		} `json:"cover"`
+	} `json:"video"`
+	Images []struct {
+		UrlList []string `json:"url_list"`
+	} `json:"images"`
+}
+
+// Resolve reads the item from the iesdouyin share page, videos are returned without watermark
+func (p *DouyinPlugin) Resolve(pageUrl string, body []byte) ([]shared.MediaInfo, error) {
+	matches := douyinRouterDataRegex.FindSubmatch(body)
+	if matches == nil {
+		return nil, errors.New("douyin: share data not found")
+	}
+	var routerData struct {
+		LoaderData map[string]json.RawMessage `json:"loaderData"`
+	}
+	if err := json.Unmarshal(matches[1], &routerData); err != nil {
+		return nil, err
+	}
+
+	var item *douyinItem
+	for _, raw := range routerData.LoaderData {
+		var page struct {
+			VideoInfoRes struct {
+				ItemList []douyinItem `json:"item_list"`
+			} `json:"videoInfoRes"`
+		}
+		if json.Unmarshal(raw, &page) == nil && len(page.VideoInfoRes.ItemList) > 0 {
+			item = &page.VideoInfoRes.ItemList[0]
+			break
+		}
+	}
+	if item == nil {
+		return nil, errors.New("douyin: item not found, it may be private or deleted")
+	}
+
+	headers := map[string][]string{
+		"User-Agent": {shared.MobileUserAgent},
+		"Referer":    {"https://www.douyin.com/"},
+	}
+	cover := ""
+	if len(item.Video.Cover.UrlList) > 0 {
+		cover = item.Video.Cover.UrlList[0]
+	}
+
+	var list []shared.MediaInfo
+	if len(item.Images) > 0 {
+		for _, image := range item.Images {
+			if len(image.UrlList) == 0 {
+				continue
+			}
+			list = append(list, newResolvedMedia("douyin.com", image.UrlList[0], "image", ".jpeg", item.Desc, image.UrlList[0], headers))
+		}
+	} else if len(item.Video.PlayAddr.UrlList) > 0 {
+		videoUrl := strings.Replace(item.Video.PlayAddr.UrlList[0], "/playwm/", "/play/", 1)
+		list = append(list, newResolvedMedia("douyin.com", videoUrl, "video", ".mp4", item.Desc, cover, headers))
+	}
+	for i := range list {
+		list[i].OtherData["author"] = item.Author.Nickname
+	}
+	return list, nil
+}
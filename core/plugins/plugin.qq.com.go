@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"regexp"
 	"res-downloader/core/shared"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -31,7 +32,9 @@ func (p *QqPlugin) Domains() []string {
 
 func (p *QqPlugin) OnRequest(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
 	if strings.Contains(r.Host, "qq.com") && strings.Contains(r.URL.Path, "/res-downloader/wechat") {
-		if p.bridge.GetConfig("WxAction").(bool) && r.URL.Query().Get("type") == "1" {
+		if r.URL.Query().Get("type") == "3" {
+			return p.handleCommentRequest(r, ctx)
+		} else if p.bridge.GetConfig("WxAction").(bool) && r.URL.Query().Get("type") == "1" {
 			return p.handleWechatRequest(r, ctx)
 		} else if !p.bridge.GetConfig("WxAction").(bool) && r.URL.Query().Get("type") == "2" {
 			return p.handleWechatRequest(r, ctx)
@@ -101,6 +104,18 @@ func (p *QqPlugin) OnResponse(resp *http.Response, ctx *goproxy.ProxyCtx) *http.
 									  mode: "no-cors",
 									  body: JSON.stringify(res.data.object.objectDesc),
 									});
+									var media = res.data.object.objectDesc.media?.[0];
+									if (media?.url) {
+										fetch("https://wxapp.tc.qq.com/res-downloader/wechat?type=3", {
+										  method: "POST",
+										  mode: "no-cors",
+										  body: JSON.stringify({
+											url: media.url,
+											description: res.data.object.objectDesc.description,
+											comments: res.data.commentInfo || res.data.object.commentList || [],
+										  }),
+										});
+									}
 								}
 								return res;
 							}async
@@ -151,7 +166,7 @@ func (p *QqPlugin) handleMedia(body []byte) {
 		return
 	}
 
-	urlSign := shared.Md5(rawUrl)
+	urlSign := qqUrlSign(rawUrl)
 	if p.bridge.MediaIsMarked(urlSign) {
 		return
 	}
@@ -220,6 +235,14 @@ func (p *QqPlugin) handleMedia(body []byte) {
 		res.Description = desc
 	}
 
+	if contact, ok := result["contact"].(map[string]interface{}); ok {
+		if nickname, ok := contact["nickname"].(string); ok {
+			res.OtherData["author"] = nickname
+		}
+	} else if nickname, ok := result["nickname"].(string); ok {
+		res.OtherData["author"] = nickname
+	}
+
 	if spec, ok := firstMedia["spec"].([]interface{}); ok {
 		var fileFormats []string
 		for _, item := range spec {
@@ -239,6 +262,69 @@ func (p *QqPlugin) handleMedia(body []byte) {
 	}(res)
 }
 
+func (p *QqPlugin) handleCommentRequest(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return r, p.buildEmptyResponse(r)
+	}
+
+	go p.handleComments(body)
+
+	return r, p.buildEmptyResponse(r)
+}
+
+// handleComments reads what the injected finderGetCommentDetail posts: the media url, the full
+// description and the comments the page loaded, the most liked first
+func (p *QqPlugin) handleComments(body []byte) {
+	var result struct {
+		Url         string                   `json:"url"`
+		Description string                   `json:"description"`
+		Comments    []map[string]interface{} `json:"comments"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.Url == "" {
+		return
+	}
+
+	comments := shared.MediaComments{
+		UrlSign:     qqUrlSign(result.Url),
+		Description: result.Description,
+	}
+	for _, item := range result.Comments {
+		content, _ := item["content"].(string)
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		comment := shared.Comment{Content: content}
+		comment.Author, _ = item["nickname"].(string)
+		comment.Likes = qqNumber(item["likeCount"])
+		comment.Time = qqNumber(item["createtime"])
+		comments.Comments = append(comments.Comments, comment)
+	}
+	sort.SliceStable(comments.Comments, func(i, j int) bool {
+		return comments.Comments[i].Likes > comments.Comments[j].Likes
+	})
+
+	p.bridge.Send("mediaComments", comments)
+}
+
+// qqUrlSign is the sign of a media url as objectDesc has it, before urlToken is appended, which
+// media and its comments are matched by
+func qqUrlSign(rawUrl string) string {
+	return shared.Md5(rawUrl)
+}
+
+// qqNumber reads the counters and times the api sends as numbers or as numeric strings
+func qqNumber(value interface{}) int64 {
+	switch v := value.(type) {
+	case float64:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
 func (p *QqPlugin) buildEmptyResponse(r *http.Request) *http.Response {
 	body := "The content does not exist"
 	resp := &http.Response{
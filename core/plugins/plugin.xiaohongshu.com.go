@@ -0,0 +1,119 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/elazarl/goproxy"
+	"net/http"
+	"regexp"
+	"res-downloader/core/shared"
+)
+
+var (
+	xhsInitialStateRegex = regexp.MustCompile(`window\.__INITIAL_STATE__\s*=\s*(\{.+?\})\s*</script>`)
+	xhsUndefinedRegex    = regexp.MustCompile(`:\s*undefined\b`)
+)
+
+// XhsPlugin only resolves pasted share links, captured traffic is left to the default plugin
+type XhsPlugin struct {
+	bridge *shared.Bridge
+}
+
+func (p *XhsPlugin) SetBridge(bridge *shared.Bridge) {
+	p.bridge = bridge
+}
+
+func (p *XhsPlugin) Domains() []string {
+	return []string{"xiaohongshu.com"}
+}
+
+func (p *XhsPlugin) OnRequest(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	return nil, nil
+}
+
+func (p *XhsPlugin) OnResponse(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	return nil
+}
+
+type xhsNote struct {
+	Title string `json:"title"`
+	Desc  string `json:"desc"`
+	Type  string `json:"type"`
+	User  struct {
+		Nickname string `json:"nickname"`
+	} `json:"user"`
+	ImageList []struct {
+		UrlDefault string `json:"urlDefault"`
+	} `json:"imageList"`
+	Video struct {
+		Media struct {
+			Stream map[string][]struct {
+				MasterUrl string `json:"masterUrl"`
+			} `json:"stream"`
+		} `json:"media"`
+	} `json:"video"`
+}
+
+// Resolve reads the note from the __INITIAL_STATE__ of the explore page
+func (p *XhsPlugin) Resolve(pageUrl string, body []byte) ([]shared.MediaInfo, error) {
+	matches := xhsInitialStateRegex.FindSubmatch(body)
+	if matches == nil {
+		return nil, errors.New("xiaohongshu: note data not found")
+	}
+	// the state is a js literal, undefined is not valid json
+	data := xhsUndefinedRegex.ReplaceAll(matches[1], []byte(":null"))
+	var state struct {
+		Note struct {
+			NoteDetailMap map[string]struct {
+				Note xhsNote `json:"note"`
+			} `json:"noteDetailMap"`
+		} `json:"note"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	var note *xhsNote
+	for _, detail := range state.Note.NoteDetailMap {
+		if detail.Note.Type != "" {
+			note = &detail.Note
+			break
+		}
+	}
+	if note == nil {
+		return nil, errors.New("xiaohongshu: note not found, it may be private or deleted")
+	}
+
+	headers := map[string][]string{
+		"User-Agent": {shared.MobileUserAgent},
+		"Referer":    {"https://www.xiaohongshu.com/"},
+	}
+	title := note.Title
+	if title == "" {
+		title = note.Desc
+	}
+	cover := ""
+	if len(note.ImageList) > 0 {
+		cover = note.ImageList[0].UrlDefault
+	}
+
+	var list []shared.MediaInfo
+	if note.Type == "video" {
+		for _, codec := range []string{"h264", "h265", "av1"} {
+			if streams := note.Video.Media.Stream[codec]; len(streams) > 0 && streams[0].MasterUrl != "" {
+				list = append(list, newResolvedMedia("xiaohongshu.com", streams[0].MasterUrl, "video", ".mp4", title, cover, headers))
+				break
+			}
+		}
+	} else {
+		for _, image := range note.ImageList {
+			if image.UrlDefault != "" {
+				list = append(list, newResolvedMedia("xiaohongshu.com", image.UrlDefault, "image", ".webp", title, image.UrlDefault, headers))
+			}
+		}
+	}
+	for i := range list {
+		list[i].OtherData["author"] = note.User.Nickname
+	}
+	return list, nil
+}
@@ -0,0 +1,33 @@
+package plugins
+
+import (
+	"encoding/json"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"res-downloader/core/shared"
+)
+
+// newResolvedMedia builds the MediaInfo for a resolved share link. Domain is the platform of the share page,
+// not the CDN, so naming and category rules see the same value as for captured resources.
+func newResolvedMedia(domain, rawUrl, classify, suffix, description, cover string, headers map[string][]string) shared.MediaInfo {
+	urlSign := shared.Md5(rawUrl)
+	id, err := gonanoid.New()
+	if err != nil {
+		id = urlSign
+	}
+	res := shared.MediaInfo{
+		Id:          id,
+		Url:         rawUrl,
+		UrlSign:     urlSign,
+		CoverUrl:    cover,
+		Domain:      domain,
+		Classify:    classify,
+		Suffix:      suffix,
+		Status:      shared.DownloadStatusReady,
+		OtherData:   map[string]string{},
+		Description: description,
+	}
+	if data, err := json.Marshal(headers); err == nil {
+		res.OtherData["headers"] = string(data)
+	}
+	return res
+}
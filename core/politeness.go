@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DomainLimit paces what res-downloader asks of one platform, a rule for "*" covers the others
+type DomainLimit struct {
+	Domain     string // matches the host and its subdomains
+	PerMinute  int    // page loads and download starts a minute, 0 leaves them unpaced
+	Concurrent int    // downloads running at once, 0 leaves it to Config.DownNumber
+}
+
+// domainState is the budget of one rule, or of one domain under the "*" rule
+type domainState struct {
+	next    time.Time // the earliest a request may go out
+	running int
+}
+
+// Politeness keeps batch work from looking like abuse to a platform: requests to it are spaced
+// out and only so many of its downloads run at once, as Config.DomainLimits says. Segments and
+// ranges of a running download are not paced, only the requests that start one.
+type Politeness struct {
+	mu     sync.Mutex
+	states map[string]*domainState
+	limits []DomainLimit // Config.DomainLimits, parsed when it changes
+}
+
+func initPoliteness() *Politeness {
+	if politeOnce == nil {
+		politeOnce = &Politeness{states: map[string]*domainState{}}
+		politeOnce.setLimits(globalConfig.DomainLimits)
+	}
+	return politeOnce
+}
+
+// setLimits takes the rules of Config.DomainLimits, called when it changes
+func (p *Politeness) setLimits(text string) {
+	limits := parseDomainLimits(text)
+	p.mu.Lock()
+	p.limits = limits
+	p.mu.Unlock()
+}
+
+// parseDomainLimits reads one rule per line: the domain, requests a minute and optionally how
+// many downloads may run at once, e.g. "douyin.com 20 2"
+func parseDomainLimits(text string) []DomainLimit {
+	var limits []DomainLimit
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		limit := DomainLimit{Domain: strings.ToLower(strings.TrimPrefix(fields[0], "."))}
+		var err error
+		if len(fields) > 1 {
+			limit.PerMinute, err = strconv.Atoi(fields[1])
+		}
+		if err == nil && len(fields) > 2 {
+			limit.Concurrent, err = strconv.Atoi(fields[2])
+		}
+		if err != nil || len(fields) < 2 || len(fields) > 3 {
+			globalLogger.Warn().Msgf("invalid domain limit: %s", scanner.Text())
+			continue
+		}
+		limits = append(limits, limit)
+	}
+	return limits
+}
+
+// limitFor finds the rule of the first of hosts a rule names, the most specific one winning,
+// and the key its budget is kept under
+func (p *Politeness) limitFor(hosts ...string) (DomainLimit, string, bool) {
+	p.mu.Lock()
+	limits := p.limits
+	p.mu.Unlock()
+	var best DomainLimit
+	found := false
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if host == "" {
+			continue
+		}
+		for _, limit := range limits {
+			if limit.Domain != host && !strings.HasSuffix(host, "."+limit.Domain) {
+				continue
+			}
+			if !found || len(limit.Domain) > len(best.Domain) {
+				best, found = limit, true
+			}
+		}
+		if found {
+			return best, best.Domain, true
+		}
+	}
+	for _, limit := range limits {
+		if limit.Domain == "*" {
+			for _, host := range hosts {
+				if host != "" {
+					return limit, strings.ToLower(host), true
+				}
+			}
+		}
+	}
+	return DomainLimit{}, "", false
+}
+
+// hostOf is the host name of rawUrl, empty when it doesn't parse
+func hostOf(rawUrl string) string {
+	if u, err := url.Parse(rawUrl); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+func (p *Politeness) state(key string) *domainState {
+	state, ok := p.states[key]
+	if !ok {
+		state = &domainState{}
+		p.states[key] = state
+	}
+	return state
+}
+
+// wait holds a request to one of hosts until its turn, the first host a rule names decides
+func (p *Politeness) wait(ctx context.Context, hosts ...string) error {
+	limit, key, ok := p.limitFor(hosts...)
+	if !ok || limit.PerMinute <= 0 {
+		return nil
+	}
+	interval := time.Minute / time.Duration(limit.PerMinute)
+	p.mu.Lock()
+	state := p.state(key)
+	now := time.Now()
+	if state.next.Before(now) {
+		state.next = now
+	}
+	delay := state.next.Sub(now)
+	state.next = state.next.Add(interval)
+	p.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// acquire takes a download slot of the domain, false when its rule has none free. The key to
+// release is returned, empty when the domain has no cap.
+func (p *Politeness) acquire(hosts ...string) (string, bool) {
+	limit, key, ok := p.limitFor(hosts...)
+	if !ok || limit.Concurrent <= 0 {
+		return "", true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := p.state(key)
+	if state.running >= limit.Concurrent {
+		return "", false
+	}
+	state.running++
+	return key, true
+}
+
+func (p *Politeness) release(key string) {
+	if key == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state, ok := p.states[key]; ok && state.running > 0 {
+		state.running--
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"os"
+	"res-downloader/core/shared"
+	"strconv"
+	"time"
+)
+
+// afterDownload runs once a file is complete on disk, in the background so the queue moves on
+func (r *Resource) afterDownload(mediaInfo shared.MediaInfo) {
+	go func() {
+		// before the command, it may want the cover or the metadata
+		r.saveSidecars(mediaInfo)
+		// only copies the file aside, reading the text goes on in the background
+		r.recognizeText(mediaInfo)
+		if globalConfig.PostCommand != "" {
+			runPostCommand(mediaInfo)
+		}
+		// uploads last, they may remove the local file
+		uploadDestinations(mediaInfo)
+	}()
+}
+
+// runPostCommand runs Config.PostCommand through the system shell, the resource is passed as RD_* env vars
+func runPostCommand(mediaInfo shared.MediaInfo) {
+	timeout := time.Duration(globalConfig.PostCommandTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, globalConfig.PostCommand)
+	cmd.Env = append(os.Environ(), mediaEnv(mediaInfo)...)
+	output, err := cmd.CombinedOutput()
+
+	result := map[string]interface{}{
+		"Id":     mediaInfo.Id,
+		"Output": string(output),
+		"Error":  "",
+	}
+	if err != nil {
+		globalLogger.Esg(err, "post download command failed: "+string(output))
+		result["Error"] = err.Error()
+	}
+	httpServerOnce.send("postCommand", result)
+}
+
+// mediaEnv describes a resource to a command as RD_* variables
+func mediaEnv(mediaInfo shared.MediaInfo) []string {
+	return []string{
+		"RD_ID=" + mediaInfo.Id,
+		"RD_PATH=" + mediaInfo.SavePath,
+		"RD_URL=" + mediaInfo.Url,
+		"RD_PLATFORM=" + mediaInfo.Domain,
+		"RD_TITLE=" + mediaInfo.Description,
+		"RD_CLASSIFY=" + mediaInfo.Classify,
+		"RD_SUFFIX=" + mediaInfo.Suffix,
+		"RD_SIZE=" + strconv.FormatFloat(mediaInfo.Size, 'f', 0, 64),
+	}
+}
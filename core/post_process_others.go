@@ -0,0 +1,16 @@
+//go:build !windows
+
+package core
+
+import (
+	"context"
+	"os/exec"
+)
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+func backgroundCommand(name string, args ...string) *exec.Cmd {
+	return exec.Command(name, args...)
+}
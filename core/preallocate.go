@@ -0,0 +1,23 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// allocateFile sizes a new part file. With Config.Preallocate the blocks are reserved up front,
+// which keeps the file contiguous and fails right away when the disk can't hold it.
+func allocateFile(file *os.File, size int64) error {
+	if globalConfig.Preallocate {
+		if err := preallocate(file, size); err != nil {
+			if isNoSpace(err) {
+				dir := filepath.Dir(file.Name())
+				free, _ := diskFree(dir)
+				return &DiskSpaceError{Path: dir, Free: free, Need: size}
+			}
+			// not supported by the file system, a sparse file still works
+			globalLogger.Warn().Msgf("preallocate %s failed: %v", file.Name(), err)
+		}
+	}
+	return file.Truncate(size)
+}
@@ -0,0 +1,32 @@
+//go:build darwin
+
+package core
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func preallocate(file *os.File, size int64) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() >= size {
+		return err
+	}
+	store := &unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size - info.Size(),
+	}
+	if err := unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, store); err != nil {
+		// contiguous space may not exist, any allocation still reserves the blocks
+		store.Flags = unix.F_ALLOCATEALL
+		return unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, store)
+	}
+	return nil
+}
+
+func isNoSpace(err error) bool {
+	return errors.Is(err, unix.ENOSPC)
+}
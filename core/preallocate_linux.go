@@ -0,0 +1,18 @@
+//go:build linux
+
+package core
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func preallocate(file *os.File, size int64) error {
+	return unix.Fallocate(int(file.Fd()), 0, 0, size)
+}
+
+func isNoSpace(err error) bool {
+	return errors.Is(err, unix.ENOSPC)
+}
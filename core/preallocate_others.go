@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !windows
+
+package core
+
+import (
+	"errors"
+	"os"
+)
+
+func preallocate(file *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
+
+func isNoSpace(err error) bool {
+	return false
+}
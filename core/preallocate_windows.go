@@ -0,0 +1,23 @@
+//go:build windows
+
+package core
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// preallocate reserves the clusters with FileAllocationInfo. SetFileValidData would also skip
+// zero filling, but it needs SE_MANAGE_VOLUME_NAME and exposes old disk content, so it's not used.
+func preallocate(file *os.File, size int64) error {
+	info := struct{ AllocationSize int64 }{size}
+	return windows.SetFileInformationByHandle(windows.Handle(file.Fd()), windows.FileAllocationInfo,
+		(*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+}
+
+func isNoSpace(err error) bool {
+	return errors.Is(err, windows.ERROR_DISK_FULL) || errors.Is(err, windows.ERROR_HANDLE_DISK_FULL)
+}
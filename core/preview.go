@@ -0,0 +1,211 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/url"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const (
+	previewBodyLimit = 64 * 1024
+	previewMaxItems  = 100
+)
+
+type ResponsePreview struct {
+	Id          string `json:"Id"`
+	Url         string `json:"Url"`
+	Host        string `json:"Host"`
+	Status      int    `json:"Status"`
+	ContentType string `json:"ContentType"`
+	Time        int64  `json:"Time"`
+	Size        int    `json:"Size"`
+	Truncated   bool   `json:"Truncated"`
+	Body        string `json:"Body,omitempty"`
+	method      string
+	header      http.Header // request headers, a catalog's later pages are replayed with them
+}
+
+// PreviewStore keeps truncated bodies of recent text/json responses, newest last
+type PreviewStore struct {
+	mu        sync.Mutex
+	items     []*ResponsePreview
+	announced map[string]bool // api host+path already reported as a series
+}
+
+func initPreview() *PreviewStore {
+	if previewOnce == nil {
+		previewOnce = &PreviewStore{}
+	}
+	return previewOnce
+}
+
+// tap copies the head of a text response while it streams to the client
+func (p *PreviewStore) tap(resp *http.Response) {
+	if !globalConfig.ResponsePreview || resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols || !isTextContent(resp.Header.Get("Content-Type")) {
+		return
+	}
+	resp.Body = &previewBody{ReadCloser: resp.Body, resp: resp}
+}
+
+func (p *PreviewStore) add(resp *http.Response, raw []byte, truncated bool) {
+	body := decodeContent(resp.Header.Get("Content-Encoding"), raw, previewBodyLimit)
+	if body == nil {
+		return
+	}
+	if len(body) > previewBodyLimit {
+		body = body[:previewBodyLimit]
+		truncated = true
+	}
+	id, err := gonanoid.New()
+	if err != nil {
+		id = shared.Md5(resp.Request.URL.String() + time.Now().String())
+	}
+	item := &ResponsePreview{
+		Id:          id,
+		Url:         resp.Request.URL.String(),
+		Host:        resp.Request.Host,
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Time:        time.Now().UnixMilli(),
+		Size:        len(body),
+		Truncated:   truncated,
+		Body:        string(body),
+		method:      resp.Request.Method,
+		header:      resp.Request.Header.Clone(),
+	}
+
+	p.mu.Lock()
+	p.items = append(p.items, item)
+	if len(p.items) > previewMaxItems {
+		p.items = p.items[len(p.items)-previewMaxItems:]
+	}
+	p.mu.Unlock()
+	go p.detectSeries(*item)
+}
+
+// list returns previews without bodies, newest first, optionally filtered by host
+func (p *PreviewStore) list(host string) []ResponsePreview {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make([]ResponsePreview, 0, len(p.items))
+	for i := len(p.items) - 1; i >= 0; i-- {
+		item := *p.items[i]
+		if host != "" && !strings.Contains(item.Host, host) {
+			continue
+		}
+		item.Body = ""
+		list = append(list, item)
+	}
+	return list
+}
+
+func (p *PreviewStore) get(id string) (ResponsePreview, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, item := range p.items {
+		if item.Id == id {
+			return *item, true
+		}
+	}
+	return ResponsePreview{}, false
+}
+
+// related finds the newest api response that mentions the media url, so the UI can show it alongside
+func (p *PreviewStore) related(mediaUrl string) string {
+	u, err := url.Parse(mediaUrl)
+	if err != nil || len(u.Path) < 8 {
+		return ""
+	}
+	needles := []string{u.Path, strings.ReplaceAll(u.Path, "/", `\/`)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := len(p.items) - 1; i >= 0; i-- {
+		for _, needle := range needles {
+			if strings.Contains(p.items[i].Body, needle) {
+				return p.items[i].Id
+			}
+		}
+	}
+	return ""
+}
+
+func (p *PreviewStore) clear() {
+	p.mu.Lock()
+	p.items = nil
+	p.announced = nil
+	p.mu.Unlock()
+}
+
+// decodeContent undoes gzip, deflate or brotli up to limit bytes, nil for encodings it can't read
+func decodeContent(encoding string, raw []byte, limit int64) []byte {
+	var reader io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return raw
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil
+		}
+		reader = gr
+	case "deflate":
+		reader = flate.NewReader(bytes.NewReader(raw))
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(raw))
+	default:
+		return nil
+	}
+	// the raw bytes may be cut short, keep whatever decodes
+	data, _ := io.ReadAll(io.LimitReader(reader, limit+1))
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+type previewBody struct {
+	io.ReadCloser
+	resp      *http.Response
+	buf       bytes.Buffer
+	truncated bool
+	done      bool
+}
+
+func (b *previewBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.truncated {
+		if room := previewBodyLimit - b.buf.Len(); room >= n {
+			b.buf.Write(p[:n])
+		} else {
+			b.buf.Write(p[:room])
+			b.truncated = true
+		}
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *previewBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *previewBody) finish() {
+	if b.done || b.buf.Len() == 0 {
+		return
+	}
+	b.done = true
+	previewOnce.add(b.resp, b.buf.Bytes(), b.truncated)
+}
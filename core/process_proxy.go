@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessProxy redirects the TLS connections of selected executables into the
+// capture engine without touching the system proxy. The packet diversion is OS
+// specific (see process_proxy_windows.go); redirected connections arrive at a
+// transparent listener, which sniffs the SNI and tunnels them through our own
+// proxy with a CONNECT so the normal MITM pipeline applies.
+type ProcessProxy struct {
+	mu       sync.Mutex
+	running  bool
+	listener net.Listener
+	ports    sync.Map // local ports owned by target processes
+	stop     chan struct{}
+}
+
+type ProcessProxyStatus struct {
+	Running   bool   `json:"Running"`
+	Processes string `json:"Processes"`
+	Port      int    `json:"Port"`
+}
+
+var errHelloCaptured = errors.New("client hello captured")
+
+func initProcessProxy() *ProcessProxy {
+	if processProxyOnce == nil {
+		processProxyOnce = &ProcessProxy{}
+	}
+	return processProxyOnce
+}
+
+func (p *ProcessProxy) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running {
+		return nil
+	}
+	if strings.TrimSpace(globalConfig.ProcessNames) == "" {
+		return fmt.Errorf("no process configured")
+	}
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	p.stop = make(chan struct{})
+	if err := p.divert(); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	p.running = true
+	go p.serve(listener)
+	return nil
+}
+
+func (p *ProcessProxy) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return
+	}
+	close(p.stop)
+	_ = p.listener.Close()
+	p.running = false
+	p.ports = sync.Map{}
+}
+
+func (p *ProcessProxy) Status() ProcessProxyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := ProcessProxyStatus{Running: p.running, Processes: globalConfig.ProcessNames}
+	if p.running {
+		status.Port = p.listener.Addr().(*net.TCPAddr).Port
+	}
+	return status
+}
+
+func (p *ProcessProxy) targetNames() map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.FieldsFunc(globalConfig.ProcessNames, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	}) {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func (p *ProcessProxy) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+// handle receives a reflected connection, its remote address is the original server
+func (p *ProcessProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	if _, ok := p.ports.Load(uint16(remote.Port)); !ok {
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	serverName, hello := peekServerName(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	if serverName == "" {
+		serverName = remote.IP.String()
+	}
+	target := net.JoinHostPort(serverName, "443")
+
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", globalConfig.Port), 10*time.Second)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	if _, err := fmt.Fprintf(upstream, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target); err != nil {
+		return
+	}
+	reader := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+	if _, err := upstream.Write(hello); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, reader)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// peekServerName reads the ClientHello, returning the SNI and the bytes consumed so they can be replayed
+func peekServerName(conn net.Conn) (string, []byte) {
+	var buf bytes.Buffer
+	var serverName string
+	_ = tls.Server(helloConn{reader: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloCaptured
+		},
+	}).Handshake()
+	return serverName, buf.Bytes()
+}
+
+// helloConn is a read-only net.Conn used to let crypto/tls parse a ClientHello
+type helloConn struct {
+	reader io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c helloConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c helloConn) Close() error                       { return nil }
+func (c helloConn) LocalAddr() net.Addr                { return nil }
+func (c helloConn) RemoteAddr() net.Addr               { return nil }
+func (c helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c helloConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package core
+
+import "fmt"
+
+func (p *ProcessProxy) divert() error {
+	return fmt.Errorf("process based proxying is only supported on windows")
+}
@@ -0,0 +1,189 @@
+//go:build windows
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// WinDivert 2.x (WinDivert.dll and WinDivert64.sys must sit next to the executable)
+var (
+	winDivertDll       = windows.NewLazyDLL("WinDivert.dll")
+	procWinDivertOpen  = winDivertDll.NewProc("WinDivertOpen")
+	procWinDivertRecv  = winDivertDll.NewProc("WinDivertRecv")
+	procWinDivertSend  = winDivertDll.NewProc("WinDivertSend")
+	procWinDivertClose = winDivertDll.NewProc("WinDivertClose")
+	procWinDivertCalc  = winDivertDll.NewProc("WinDivertHelperCalcChecksums")
+)
+
+const (
+	winDivertLayerNetwork = 0
+	winDivertLayerSocket  = 3
+	winDivertFlagSniff    = 0x0001
+	winDivertFlagRecvOnly = 0x0004
+	winDivertOutbound     = 1 << 17
+	winDivertEventConnect = 4
+)
+
+type winDivertAddress struct {
+	Timestamp int64
+	Flags     uint32
+	Reserved  uint32
+	Data      [64]byte
+}
+
+func (a *winDivertAddress) event() uint32 {
+	return (a.Flags >> 8) & 0xFF
+}
+
+// socketPid/socketLocalPort read WINDIVERT_DATA_SOCKET
+func (a *winDivertAddress) socketPid() uint32 {
+	return binary.LittleEndian.Uint32(a.Data[16:20])
+}
+
+func (a *winDivertAddress) socketLocalPort() uint16 {
+	return binary.LittleEndian.Uint16(a.Data[52:54])
+}
+
+func winDivertOpen(filter string, layer int, flags uint64) (uintptr, error) {
+	if err := winDivertDll.Load(); err != nil {
+		return 0, fmt.Errorf("load WinDivert.dll failed: %w", err)
+	}
+	cFilter, err := windows.BytePtrFromString(filter)
+	if err != nil {
+		return 0, err
+	}
+	handle, _, callErr := procWinDivertOpen.Call(uintptr(unsafe.Pointer(cFilter)), uintptr(layer), 0, uintptr(flags))
+	if windows.Handle(handle) == windows.InvalidHandle {
+		return 0, fmt.Errorf("WinDivertOpen failed: %w", callErr)
+	}
+	return handle, nil
+}
+
+func (p *ProcessProxy) divert() error {
+	socketHandle, err := winDivertOpen("outbound and tcp and remotePort == 443", winDivertLayerSocket, winDivertFlagSniff|winDivertFlagRecvOnly)
+	if err != nil {
+		return err
+	}
+	proxyPort := p.listener.Addr().(*net.TCPAddr).Port
+	filter := fmt.Sprintf("outbound and !loopback and ip and tcp and (tcp.DstPort == 443 or tcp.SrcPort == %d)", proxyPort)
+	networkHandle, err := winDivertOpen(filter, winDivertLayerNetwork, 0)
+	if err != nil {
+		_, _, _ = procWinDivertClose.Call(socketHandle)
+		return err
+	}
+
+	pids := &processPids{}
+	pids.refresh(p.targetNames())
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				_, _, _ = procWinDivertClose.Call(socketHandle)
+				_, _, _ = procWinDivertClose.Call(networkHandle)
+				return
+			case <-ticker.C:
+				pids.refresh(p.targetNames())
+			}
+		}
+	}()
+	go p.watchSockets(socketHandle, pids)
+	go p.reflect(networkHandle, uint16(proxyPort))
+	return nil
+}
+
+// watchSockets remembers the local ports of connections opened by target processes
+func (p *ProcessProxy) watchSockets(handle uintptr, pids *processPids) {
+	var addr winDivertAddress
+	for {
+		ok, _, _ := procWinDivertRecv.Call(handle, 0, 0, 0, uintptr(unsafe.Pointer(&addr)))
+		if ok == 0 {
+			return
+		}
+		if addr.event() == winDivertEventConnect && pids.has(addr.socketPid()) {
+			p.ports.Store(addr.socketLocalPort(), true)
+		}
+	}
+}
+
+// reflect bounces target packets to the transparent listener and rewrites replies back,
+// the same scheme as WinDivert's streamdump sample
+func (p *ProcessProxy) reflect(handle uintptr, proxyPort uint16) {
+	packet := make([]byte, 65535)
+	var addr winDivertAddress
+	var recvLen uint32
+	for {
+		ok, _, _ := procWinDivertRecv.Call(handle, uintptr(unsafe.Pointer(&packet[0])), uintptr(len(packet)), uintptr(unsafe.Pointer(&recvLen)), uintptr(unsafe.Pointer(&addr)))
+		if ok == 0 {
+			return
+		}
+		data := packet[:recvLen]
+		if len(data) >= 20 && data[0]>>4 == 4 {
+			ihl := int(data[0]&0x0F) * 4
+			if len(data) >= ihl+4 {
+				tcp := data[ihl:]
+				srcPort := binary.BigEndian.Uint16(tcp[0:2])
+				dstPort := binary.BigEndian.Uint16(tcp[2:4])
+				_, isTarget := p.ports.Load(srcPort)
+				switch {
+				case dstPort == 443 && isTarget:
+					binary.BigEndian.PutUint16(tcp[2:4], proxyPort)
+					swapIPv4(data)
+					addr.Flags &^= winDivertOutbound
+				case srcPort == proxyPort:
+					binary.BigEndian.PutUint16(tcp[0:2], 443)
+					swapIPv4(data)
+					addr.Flags &^= winDivertOutbound
+				}
+				_, _, _ = procWinDivertCalc.Call(uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&addr)), 0)
+			}
+		}
+		_, _, _ = procWinDivertSend.Call(handle, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), 0, uintptr(unsafe.Pointer(&addr)))
+	}
+}
+
+func swapIPv4(data []byte) {
+	var src [4]byte
+	copy(src[:], data[12:16])
+	copy(data[12:16], data[16:20])
+	copy(data[16:20], src[:])
+}
+
+type processPids struct {
+	pids map[uint32]bool
+	mu   sync.RWMutex
+}
+
+func (s *processPids) refresh(names map[string]bool) {
+	pids := map[uint32]bool{}
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err == nil {
+		defer windows.CloseHandle(snapshot)
+		var entry windows.ProcessEntry32
+		entry.Size = uint32(unsafe.Sizeof(entry))
+		for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+			if names[strings.ToLower(windows.UTF16ToString(entry.ExeFile[:]))] {
+				pids[entry.ProcessID] = true
+			}
+		}
+	}
+	s.mu.Lock()
+	s.pids = pids
+	s.mu.Unlock()
+}
+
+func (s *processPids) has(pid uint32) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pids[pid]
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// profileKeys are the settings a profile carries, everything else is shared by all profiles
+var profileKeys = []string{
+	"SaveDirectory", "FilenameLen", "FilenameTime", "FilenameTemplate", "CategoryDirs", "CategoryRules",
+	"Rule", "PlatformDomains", "QuicDowngrade", "QuicRule",
+	"UpstreamProxy", "OpenProxy", "DownloadProxy", "AutoProxy", "ProcessProxy", "ProcessNames",
+}
+
+// ConfigProfile is a named set of settings, e.g. one for capturing a phone and one for this machine's browser
+type ConfigProfile struct {
+	Name     string                     `json:"Name"`
+	Settings map[string]json.RawMessage `json:"Settings"` // Config fields by name, only profileKeys
+}
+
+// clone copies the config through JSON, as the settings page would send it
+func (c *Config) clone() (Config, error) {
+	var config Config
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(raw, &config)
+	return config, err
+}
+
+// saveProfile stores the current values of profileKeys under name, replacing a profile of that name
+func (c *Config) saveProfile(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return messageError(MsgProfileNameRequired)
+	}
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	var config Config
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	profile := ConfigProfile{Name: name, Settings: map[string]json.RawMessage{}}
+	for _, key := range profileKeys {
+		profile.Settings[key] = fields[key]
+	}
+
+	profiles := make([]ConfigProfile, 0, len(config.Profiles)+1)
+	for _, p := range config.Profiles {
+		if p.Name != name {
+			profiles = append(profiles, p)
+		}
+	}
+	config.Profiles = append(profiles, profile)
+	config.ActiveProfile = name
+	c.setConfig(config)
+	return nil
+}
+
+// switchProfile applies a saved profile and brings the system proxy in line with it
+func (c *Config) switchProfile(name string) error {
+	var profile *ConfigProfile
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			profile = &c.Profiles[i]
+			break
+		}
+	}
+	if profile == nil {
+		return messageError(MsgProfileNotFound)
+	}
+	config, err := c.clone()
+	if err != nil {
+		return err
+	}
+	settings := map[string]json.RawMessage{}
+	for _, key := range profileKeys {
+		if value, ok := profile.Settings[key]; ok {
+			settings[key] = value
+		}
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return err
+	}
+	config.ActiveProfile = name
+	c.setConfig(config)
+
+	if c.AutoProxy {
+		err = appOnce.OpenSystemProxy()
+	} else {
+		err = appOnce.UnsetSystemProxy()
+	}
+	if err != nil {
+		globalLogger.Esg(err, "switch system proxy for profile %s failed", name)
+	}
+	httpServerOnce.send("profileSwitched", map[string]interface{}{
+		"Name":    name,
+		"IsProxy": appOnce.IsProxy,
+	})
+	return nil
+}
+
+func (c *Config) deleteProfile(name string) error {
+	config, err := c.clone()
+	if err != nil {
+		return err
+	}
+	profiles := make([]ConfigProfile, 0, len(config.Profiles))
+	for _, p := range config.Profiles {
+		if p.Name != name {
+			profiles = append(profiles, p)
+		}
+	}
+	if len(profiles) == len(config.Profiles) {
+		return messageError(MsgProfileNotFound)
+	}
+	config.Profiles = profiles
+	if config.ActiveProfile == name {
+		config.ActiveProfile = ""
+	}
+	c.setConfig(config)
+	return nil
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"res-downloader/core/shared"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	progressInterval = 250 * time.Millisecond // at most one progress event per task in this period
+	speedWindow      = 5 * time.Second        // speed is averaged over this much recent history
+)
+
+// ProgressStats is the payload of the downloadStats event
+type ProgressStats struct {
+	Id         string          `json:"Id"`
+	Unit       string          `json:"Unit"` // "bytes", or "segments" for segment lists and playlists
+	Downloaded float64         `json:"Downloaded"`
+	Total      float64         `json:"Total"`
+	Speed      float64         `json:"Speed"` // units per second
+	Eta        int64           `json:"Eta"`   // seconds, -1 while unknown
+	Parts      map[int]float64 `json:"Parts,omitempty"`
+}
+
+type progressSample struct {
+	at   time.Time
+	done float64
+}
+
+// progressTracker turns the raw callbacks of a downloader, which fire for every chunk,
+// into throttled events carrying speed, ETA and the state of each range part
+type progressTracker struct {
+	mu        sync.Mutex
+	mediaInfo shared.MediaInfo
+	stats     ProgressStats
+	samples   []progressSample
+	lastEmit  time.Time
+}
+
+func trackProgress(mediaInfo shared.MediaInfo, unit string) ProgressCallback {
+	t := &progressTracker{
+		mediaInfo: mediaInfo,
+		stats:     ProgressStats{Id: mediaInfo.Id, Unit: unit, Eta: -1},
+	}
+	return t.update
+}
+
+func (t *progressTracker) update(totalDownloaded, totalSize float64, taskID int, taskProgress float64) {
+	t.mu.Lock()
+	now := time.Now()
+	// a mirror switch starts over, old samples would report a negative speed
+	if totalDownloaded < t.stats.Downloaded {
+		t.samples = nil
+		t.stats.Parts = nil
+	}
+	t.stats.Downloaded = totalDownloaded
+	t.stats.Total = totalSize
+	if t.stats.Unit == "bytes" && totalSize > 0 {
+		if t.stats.Parts == nil {
+			t.stats.Parts = make(map[int]float64)
+		}
+		t.stats.Parts[taskID] = taskProgress
+	}
+	t.samples = append(t.samples, progressSample{at: now, done: totalDownloaded})
+	for len(t.samples) > 2 && now.Sub(t.samples[0].at) > speedWindow {
+		t.samples = t.samples[1:]
+	}
+
+	finished := totalSize > 0 && totalDownloaded >= totalSize
+	if !finished && now.Sub(t.lastEmit) < progressInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastEmit = now
+
+	t.stats.Speed = 0
+	if first := t.samples[0]; now.Sub(first.at) > 0 {
+		t.stats.Speed = (totalDownloaded - first.done) / now.Sub(first.at).Seconds()
+	}
+	t.stats.Eta = -1
+	if finished {
+		t.stats.Eta = 0
+	} else if t.stats.Speed > 0 && totalSize > 0 {
+		t.stats.Eta = int64((totalSize - totalDownloaded) / t.stats.Speed)
+	}
+	stats := t.stats
+	if stats.Parts != nil {
+		stats.Parts = make(map[int]float64, len(t.stats.Parts))
+		for id, progress := range t.stats.Parts {
+			stats.Parts[id] = progress
+		}
+	}
+	t.mu.Unlock()
+
+	// live recordings have no total, their size is reported instead
+	percent := shared.FormatSize(totalDownloaded)
+	if totalSize > 0 {
+		percent = strconv.Itoa(int(totalDownloaded*100/totalSize)) + "%"
+	}
+	historyOnce.observe(t.mediaInfo.Id, totalDownloaded, stats.Speed)
+	resourceOnce.progressEventsEmit(t.mediaInfo, percent, shared.DownloadStatusRunning)
+	httpServerOnce.send("downloadStats", stats)
+}
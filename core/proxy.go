@@ -23,13 +23,20 @@ type Proxy struct {
 
 var pluginRegistry = make(map[string]shared.Plugin)
 
+var pluginList []shared.Plugin
+
+// proxyBridge is what plugins, built-in or external, see of core
+var proxyBridge *shared.Bridge
+
 func init() {
 	ps := []shared.Plugin{
 		&plugins.QqPlugin{},
+		&plugins.DouyinPlugin{},
+		&plugins.XhsPlugin{},
 		&plugins.DefaultPlugin{},
 	}
 
-	bridge := &shared.Bridge{
+	proxyBridge = &shared.Bridge{
 		GetVersion: func() string {
 			return appOnce.Version
 		},
@@ -49,16 +56,27 @@ func init() {
 			return globalConfig.getConfig(key)
 		},
 		Send: func(t string, data interface{}) {
+			if res, ok := data.(shared.MediaInfo); ok && t == "newResources" {
+				resourceOnce.capture(res)
+				return
+			}
+			if comments, ok := data.(shared.MediaComments); ok {
+				resourceOnce.attachComments(comments)
+				return
+			}
 			httpServerOnce.send(t, data)
 		},
 	}
 
 	for _, p := range ps {
-		p.SetBridge(bridge)
+		p.SetBridge(proxyBridge)
 		for _, domain := range p.Domains() {
-			pluginRegistry[domain] = p
+			if !isDomainPattern(domain) {
+				pluginRegistry[domain] = p
+			}
 		}
 	}
+	pluginList = ps
 }
 
 func initProxy() *Proxy {
@@ -77,11 +95,17 @@ func (p *Proxy) Startup() {
 	}
 
 	p.Proxy = goproxy.NewProxyHttpServer()
+	p.Proxy.Logger = &proxyLogger{}
 	//p.Proxy.KeepDestinationHeaders = true
 	//p.Proxy.Verbose = false
 	p.setTransport()
+	loadPluginPatterns(pluginList, withRulePacks("platforms", globalConfig.PlatformDomains))
 	//p.Proxy.OnRequest().HandleConnect(goproxy.AlwaysMitm)
 	p.Proxy.OnRequest().HandleConnectFunc(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		trafficOnce.connect(host)
+		if adBlockOnce.blocked(host) {
+			return goproxy.RejectConnect, host
+		}
 		if ruleOnce.shouldMitm(host) {
 			return goproxy.MitmConnect, host
 		}
@@ -109,17 +133,29 @@ func (p *Proxy) setCa() error {
 }
 
 func (p *Proxy) setTransport() {
+	// upstream connections are pooled per host, sized by config
 	transport := &http.Transport{
-		DisableKeepAlives: false,
-		// MaxIdleConnsPerHost: 10,
+		DisableKeepAlives:   false,
+		MaxIdleConns:        globalConfig.PoolMaxIdle,
+		MaxIdleConnsPerHost: globalConfig.PoolMaxPerHost,
 		DialContext: (&net.Dialer{
-			Timeout: 60 * time.Second,
+			Timeout:   60 * time.Second,
+			KeepAlive: 30 * time.Second,
 		}).DialContext,
+		ForceAttemptHTTP2:     true,
 		TLSHandshakeTimeout:   60 * time.Second,
 		ResponseHeaderTimeout: 60 * time.Second,
-		IdleConnTimeout:       30 * time.Second,
+		IdleConnTimeout:       time.Duration(globalConfig.PoolIdleTime) * time.Second,
+	}
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = 90 * time.Second
 	}
 
+	if globalConfig.DnsOverHttps != "" {
+		transport.DialContext = dohOnce.DialContext
+	}
+	transport.DialTLSContext = fingerprintDialTLS(transport.DialContext)
+
 	p.Proxy.ConnectDial = nil
 	p.Proxy.ConnectDialWithReq = nil
 
@@ -130,6 +166,9 @@ func (p *Proxy) setTransport() {
 			p.Proxy.ConnectDial = p.Proxy.NewConnectDialToProxy(globalConfig.UpstreamProxy)
 		}
 	}
+	if p.Proxy.Tr != nil {
+		p.Proxy.Tr.CloseIdleConnections()
+	}
 	p.Proxy.Tr = transport
 }
 
@@ -138,10 +177,21 @@ func (p *Proxy) matchPlugin(host string) shared.Plugin {
 	if plugin, ok := pluginRegistry[domain]; ok {
 		return plugin
 	}
-	return nil
+	return matchPluginPattern(host)
 }
 
 func (p *Proxy) httpRequestEvent(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	trafficOnce.request(r)
+
+	if adBlockOnce.blocked(r.Host) {
+		return r, adBlockOnce.blockedResponse(r)
+	}
+
+	r, scriptResp := scriptOnce.request(r)
+	if scriptResp != nil {
+		return r, scriptResp
+	}
+
 	plugin := p.matchPlugin(r.Host)
 	if plugin != nil {
 		newReq, newResp := plugin.OnRequest(r, ctx)
@@ -161,13 +211,26 @@ func (p *Proxy) httpResponseEvent(resp *http.Response, ctx *goproxy.ProxyCtx) *h
 		return resp
 	}
 
+	p.quicDowngrade(resp)
+	scriptOnce.response(resp)
+	rangeStitchOnce.tee(resp)
+	bodyCacheOnce.tee(resp)
+	danmakuOnce.tap(resp)
+	previewOnce.tap(resp)
+	cookieOnce.tap(resp)
+
 	plugin := p.matchPlugin(resp.Request.Host)
 	if plugin != nil {
-		newResp := plugin.OnResponse(resp, ctx)
-		if newResp != nil {
-			return newResp
+		if newResp := plugin.OnResponse(resp, ctx); newResp != nil {
+			resp = newResp
+			trafficOnce.response(resp)
+			return resp
 		}
 	}
 
-	return pluginRegistry["default"].OnResponse(resp, ctx)
+	resp = pluginRegistry["default"].OnResponse(resp, ctx)
+	if resp != nil && resp.Request != nil {
+		trafficOnce.response(resp)
+	}
+	return resp
 }
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ProxyState is persisted while the system proxy points at us, so a crashed or
+// killed session can be detected on the next start and the previous settings restored.
+type ProxyState struct {
+	Pid      int               `json:"Pid"`
+	Port     string            `json:"Port"`
+	Time     int64             `json:"Time"`
+	Previous map[string]string `json:"Previous"`
+}
+
+func proxyStateFile() string {
+	return filepath.Join(appOnce.UserDir, "proxy.state")
+}
+
+func loadProxyState() *ProxyState {
+	data, err := os.ReadFile(proxyStateFile())
+	if err != nil {
+		return nil
+	}
+	var state ProxyState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	return &state
+}
+
+func saveProxyState(previous map[string]string) {
+	data, err := json.Marshal(ProxyState{
+		Pid:      os.Getpid(),
+		Port:     globalConfig.Port,
+		Time:     time.Now().Unix(),
+		Previous: previous,
+	})
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(proxyStateFile(), data, 0644); err != nil {
+		globalLogger.Esg(err, "save proxy state failed")
+	}
+}
+
+func removeProxyState() {
+	_ = os.Remove(proxyStateFile())
+}
+
+// restoreSystemProxy puts back the settings captured before the proxy was
+// enabled, falling back to simply disabling the proxy.
+func restoreSystemProxy(state *ProxyState) error {
+	if state != nil && len(state.Previous) > 0 {
+		if err := systemOnce.restoreProxy(state.Previous); err == nil {
+			return nil
+		} else {
+			globalLogger.Esg(err, "restore previous proxy failed, unset instead")
+		}
+	}
+	return systemOnce.unsetProxy()
+}
+
+// recoverSystemProxy runs on startup: a leftover state file means the last
+// session exited without unsetting the system proxy.
+func recoverSystemProxy() {
+	state := loadProxyState()
+	if state == nil {
+		return
+	}
+	globalLogger.Warn().Msgf("system proxy left enabled by a previous session (pid %d, port %s), restoring", state.Pid, state.Port)
+	if err := restoreSystemProxy(state); err != nil {
+		globalLogger.Esg(err, "recover system proxy failed")
+		return
+	}
+	removeProxyState()
+}
@@ -0,0 +1,407 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"res-downloader/core/shared"
+	"sync"
+	"time"
+)
+
+// QueueItem is a download waiting for a free slot
+type QueueItem struct {
+	MediaInfo shared.MediaInfo `json:"MediaInfo"`
+	DecodeStr string           `json:"DecodeStr"`
+	Priority  int              `json:"Priority"`
+	Paused    bool             `json:"Paused"`
+}
+
+// ways a running item can be stopped without losing its partial data
+const (
+	stopRequeue = "requeue" // back to the head of the queue
+	stopPause   = "pause"   // parked until resumed
+)
+
+// DownloadQueue starts queued downloads in order, at most Config.DownNumber at a time.
+// Higher priority items are placed ahead, the order can then be changed freely with move.
+type DownloadQueue struct {
+	mu        sync.Mutex
+	items     []*QueueItem
+	running   map[string]bool
+	slots     map[string]string // id -> the Politeness key its download slot is released to
+	cancelled map[string]bool
+	stopping  map[string]string
+	parked    map[string]*QueueItem         // paused by the user
+	halts     map[string]context.CancelFunc // ends the pacing wait or retry delay of a running item
+	paused    bool
+	held      bool // outside the download schedule
+}
+
+func initQueue() *DownloadQueue {
+	if queueOnce == nil {
+		queueOnce = &DownloadQueue{
+			running:   make(map[string]bool),
+			slots:     make(map[string]string),
+			cancelled: make(map[string]bool),
+			stopping:  make(map[string]string),
+			parked:    make(map[string]*QueueItem),
+			halts:     make(map[string]context.CancelFunc),
+		}
+	}
+	return queueOnce
+}
+
+func (q *DownloadQueue) push(mediaInfo shared.MediaInfo, decodeStr string, priority int) {
+	q.mu.Lock()
+	delete(q.parked, mediaInfo.Id)
+	if q.running[mediaInfo.Id] || q.indexOf(mediaInfo.Id) >= 0 {
+		q.mu.Unlock()
+		return
+	}
+	item := &QueueItem{MediaInfo: mediaInfo, DecodeStr: decodeStr, Priority: priority}
+	index := len(q.items)
+	for i, queued := range q.items {
+		if queued.Priority < priority {
+			index = i
+			break
+		}
+	}
+	q.items = append(q.items[:index], append([]*QueueItem{item}, q.items[index:]...)...)
+	q.mu.Unlock()
+
+	resourceOnce.progressEventsEmit(mediaInfo, "waiting", shared.DownloadStatusReady)
+	q.schedule()
+}
+
+// schedule fills free slots from the head of the queue
+func (q *DownloadQueue) schedule() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused || q.held {
+		return
+	}
+	limit := globalConfig.DownNumber
+	if limit <= 0 {
+		limit = 1
+	}
+	for len(q.running) < limit {
+		index := q.nextStartable()
+		if index < 0 {
+			break
+		}
+		item := q.items[index]
+		q.items = append(q.items[:index], q.items[index+1:]...)
+		q.running[item.MediaInfo.Id] = true
+		ctx, cancel := context.WithCancel(context.Background())
+		q.halts[item.MediaInfo.Id] = cancel
+		go q.run(ctx, item)
+	}
+}
+
+// nextStartable is the first queued item whose platform has a download slot free, which it takes.
+// Items of a platform at its Config.DomainLimits cap wait without holding up the others.
+func (q *DownloadQueue) nextStartable() int {
+	for i, item := range q.items {
+		if key, ok := politeOnce.acquire(mediaHosts(item.MediaInfo)...); ok {
+			q.slots[item.MediaInfo.Id] = key
+			return i
+		}
+	}
+	return -1
+}
+
+// mediaHosts are the hosts a rule of Config.DomainLimits may name for a resource, the host of
+// its url first and then the platform it was captured on
+func mediaHosts(mediaInfo shared.MediaInfo) []string {
+	return []string{hostOf(mediaInfo.Url), mediaInfo.Domain}
+}
+
+// run downloads an item, retrying the whole task with backoff while the error is retryable.
+// ctx ends when the item is cancelled or stopped, so neither waits out the pacing or a retry delay.
+func (q *DownloadQueue) run(ctx context.Context, item *QueueItem) {
+	defer CaptureCrash()
+	retries := globalConfig.RetryCount
+	for attempt := 0; ; attempt++ {
+		_ = politeOnce.wait(ctx, mediaHosts(item.MediaInfo)...)
+		if q.takeCancelled(item.MediaInfo.Id) || q.takeStopped(item) {
+			break
+		}
+		historyOnce.attemptStart(item.MediaInfo.Id)
+		err := resourceOnce.download(item.MediaInfo, item.DecodeStr)
+		historyOnce.attemptEnd(item.MediaInfo, attempt, err)
+		resourceOnce.tasks.Delete(item.MediaInfo.Id)
+		if err == nil {
+			break
+		}
+		if q.takeStopped(item) {
+			break
+		}
+		var diskErr *DiskSpaceError
+		if errors.As(err, &diskErr) {
+			q.pauseFor(item, diskErr)
+			break
+		}
+		kind := classifyDownloadError(err)
+		if kind == ErrorKindCancelled {
+			break
+		}
+		if kind == ErrorKindFatal || attempt >= retries {
+			resourceOnce.errorEventsEmit(item.MediaInfo, err, kind)
+			break
+		}
+		delay := retryDelay(attempt + 1)
+		resourceOnce.progressEventsEmit(item.MediaInfo, fmt.Sprintf("retrying in %ds (%d/%d): %v", int(delay.Seconds()), attempt+1, retries, err), shared.DownloadStatusRunning)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if q.takeCancelled(item.MediaInfo.Id) || q.takeStopped(item) {
+			break
+		}
+	}
+	q.takeCancelled(item.MediaInfo.Id)
+
+	q.mu.Lock()
+	delete(q.running, item.MediaInfo.Id)
+	if cancel, ok := q.halts[item.MediaInfo.Id]; ok {
+		cancel()
+		delete(q.halts, item.MediaInfo.Id)
+	}
+	slot := q.slots[item.MediaInfo.Id]
+	delete(q.slots, item.MediaInfo.Id)
+	q.mu.Unlock()
+	politeOnce.release(slot)
+	q.schedule()
+}
+
+// pauseFor stops scheduling and puts the item back at the head until the user resumes
+func (q *DownloadQueue) pauseFor(item *QueueItem, err *DiskSpaceError) {
+	q.mu.Lock()
+	q.paused = true
+	q.items = append([]*QueueItem{item}, q.items...)
+	q.mu.Unlock()
+
+	resourceOnce.progressEventsEmit(item.MediaInfo, err.Error(), shared.DownloadStatusReady)
+	httpServerOnce.send("diskSpaceLow", map[string]interface{}{
+		"Path": err.Path,
+		"Free": err.Free,
+		"Need": err.Need,
+	})
+}
+
+func (q *DownloadQueue) setPaused(paused bool) {
+	q.mu.Lock()
+	q.paused = paused
+	q.mu.Unlock()
+	if !paused {
+		q.schedule()
+	}
+}
+
+// setHeld stops or restarts scheduling for the download schedule, independent of the user's pause
+func (q *DownloadQueue) setHeld(held bool) {
+	q.mu.Lock()
+	q.held = held
+	q.mu.Unlock()
+	if !held {
+		q.schedule()
+	}
+}
+
+// stop ends a running item without dropping its partial data, see stopRequeue and stopPause
+func (q *DownloadQueue) stop(id, mode string) bool {
+	q.mu.Lock()
+	if !q.running[id] {
+		q.mu.Unlock()
+		return false
+	}
+	q.stopping[id] = mode
+	if cancel, ok := q.halts[id]; ok {
+		cancel()
+	}
+	q.mu.Unlock()
+	if d, ok := resourceOnce.tasks.Load(id); ok {
+		d.(Downloader).Pause()
+	}
+	return true
+}
+
+// keepsPartial tells download() not to forget a task that is being stopped rather than cancelled
+func (q *DownloadQueue) keepsPartial(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.stopping[id]
+	return ok
+}
+
+func (q *DownloadQueue) takeStopped(item *QueueItem) bool {
+	q.mu.Lock()
+	mode, ok := q.stopping[item.MediaInfo.Id]
+	if !ok {
+		q.mu.Unlock()
+		return false
+	}
+	delete(q.stopping, item.MediaInfo.Id)
+	if mode == stopPause {
+		q.parked[item.MediaInfo.Id] = item
+	} else {
+		q.items = append([]*QueueItem{item}, q.items...)
+	}
+	q.mu.Unlock()
+
+	if mode == stopPause {
+		resourceOnce.progressEventsEmit(item.MediaInfo, "paused", shared.DownloadStatusPaused)
+	} else {
+		resourceOnce.progressEventsEmit(item.MediaInfo, "waiting", shared.DownloadStatusReady)
+	}
+	return true
+}
+
+// pause parks a queued or running item until resume is called
+func (q *DownloadQueue) pause(id string) error {
+	q.mu.Lock()
+	if index := q.indexOf(id); index >= 0 {
+		item := q.items[index]
+		q.items = append(q.items[:index], q.items[index+1:]...)
+		q.parked[id] = item
+		q.mu.Unlock()
+		resourceOnce.progressEventsEmit(item.MediaInfo, "paused", shared.DownloadStatusPaused)
+		return nil
+	}
+	q.mu.Unlock()
+	if q.stop(id, stopPause) {
+		return nil
+	}
+	return messageError(MsgTaskNotFound)
+}
+
+// resume queues a paused item again with its old priority
+func (q *DownloadQueue) resume(id string) error {
+	q.mu.Lock()
+	item, ok := q.parked[id]
+	q.mu.Unlock()
+	if !ok {
+		return messageError(MsgTaskNotPaused)
+	}
+	q.push(item.MediaInfo, item.DecodeStr, item.Priority)
+	return nil
+}
+
+func (q *DownloadQueue) dropPaused(id string) (*QueueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.parked[id]
+	delete(q.parked, id)
+	return item, ok
+}
+
+func (q *DownloadQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// remove drops a queued item, returns false when it isn't waiting
+func (q *DownloadQueue) remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	index := q.indexOf(id)
+	if index < 0 {
+		return false
+	}
+	q.items = append(q.items[:index], q.items[index+1:]...)
+	return true
+}
+
+// markCancelled stops a running item from being retried, returns false when it isn't running
+func (q *DownloadQueue) markCancelled(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.running[id] {
+		return false
+	}
+	q.cancelled[id] = true
+	if cancel, ok := q.halts[id]; ok {
+		cancel()
+	}
+	return true
+}
+
+func (q *DownloadQueue) takeCancelled(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cancelled := q.cancelled[id]
+	delete(q.cancelled, id)
+	return cancelled
+}
+
+// move puts a queued item at the given position, used for drag-to-reorder
+func (q *DownloadQueue) move(id string, position int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	index := q.indexOf(id)
+	if index < 0 {
+		return messageError(MsgTaskNotQueued)
+	}
+	item := q.items[index]
+	q.items = append(q.items[:index], q.items[index+1:]...)
+	if position < 0 {
+		position = 0
+	}
+	if position > len(q.items) {
+		position = len(q.items)
+	}
+	q.items = append(q.items[:position], append([]*QueueItem{item}, q.items[position:]...)...)
+	return nil
+}
+
+// setPriority changes the priority and re-sorts the item among its peers
+func (q *DownloadQueue) setPriority(id string, priority int) error {
+	q.mu.Lock()
+	index := q.indexOf(id)
+	if index < 0 {
+		q.mu.Unlock()
+		return messageError(MsgTaskNotQueued)
+	}
+	item := q.items[index]
+	q.items = append(q.items[:index], q.items[index+1:]...)
+	q.mu.Unlock()
+
+	q.push(item.MediaInfo, item.DecodeStr, priority)
+	return nil
+}
+
+func (q *DownloadQueue) list() []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := make([]QueueItem, 0, len(q.items)+len(q.parked))
+	for _, item := range q.items {
+		list = append(list, *item)
+	}
+	for _, item := range q.parked {
+		paused := *item
+		paused.Paused = true
+		list = append(list, paused)
+	}
+	return list
+}
+
+func (q *DownloadQueue) runningIds() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]string, 0, len(q.running))
+	for id := range q.running {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (q *DownloadQueue) indexOf(id string) int {
+	for i, item := range q.items {
+		if item.MediaInfo.Id == id {
+			return i
+		}
+	}
+	return -1
+}
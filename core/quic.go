@@ -0,0 +1,45 @@
+package core
+
+import (
+	"net/http"
+	"strings"
+)
+
+// stripAltSvcH3 removes the HTTP/3 (h3, h3-29, quic ...) alternatives advertised in
+// the Alt-Svc header, so clients keep using TCP through the proxy instead of
+// switching to QUIC, which bypasses the HTTP proxy entirely.
+func stripAltSvcH3(header http.Header) {
+	values := header.Values("Alt-Svc")
+	if len(values) == 0 {
+		return
+	}
+
+	var kept []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			protocol := strings.ToLower(strings.TrimSpace(strings.SplitN(item, "=", 2)[0]))
+			if strings.HasPrefix(protocol, "h3") || strings.HasPrefix(protocol, "quic") {
+				continue
+			}
+			kept = append(kept, item)
+		}
+	}
+
+	header.Del("Alt-Svc")
+	if len(kept) > 0 {
+		header.Set("Alt-Svc", strings.Join(kept, ", "))
+	}
+}
+
+func (p *Proxy) quicDowngrade(resp *http.Response) {
+	if !globalConfig.QuicDowngrade || quicRuleOnce == nil {
+		return
+	}
+	if quicRuleOnce.match(resp.Request.Host) {
+		stripAltSvcH3(resp.Header)
+	}
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RangeStitcher tees the bodies of 206 media responses into a sparse file per url
+// and tracks the covered byte ranges, so a resource that players only ever fetch
+// in small Range requests can still be saved once every byte has passed through.
+type RangeStitcher struct {
+	mu    sync.Mutex
+	dir   string
+	files map[string]*rangeFile
+}
+
+type rangeFile struct {
+	mu     sync.Mutex
+	path   string
+	total  int64
+	ranges [][2]int64 // merged, sorted, half-open [start, end)
+	file   *os.File
+}
+
+type RangeStatus struct {
+	Total    int64 `json:"Total"`
+	Covered  int64 `json:"Covered"`
+	Complete bool  `json:"Complete"`
+}
+
+type rangeTeeBody struct {
+	io.ReadCloser
+	rf     *rangeFile
+	offset int64
+}
+
+func (b *rangeTeeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.rf.write(p[:n], b.offset)
+		b.offset += int64(n)
+	}
+	return n, err
+}
+
+func initRangeStitch() *RangeStitcher {
+	if rangeStitchOnce == nil {
+		rangeStitchOnce = &RangeStitcher{
+			dir:   filepath.Join(appOnce.UserDir, "ranges"),
+			files: make(map[string]*rangeFile),
+		}
+		_ = os.RemoveAll(rangeStitchOnce.dir)
+	}
+	return rangeStitchOnce
+}
+
+func (s *RangeStitcher) tee(resp *http.Response) {
+	if !globalConfig.RangeStitch || resp.StatusCode != http.StatusPartialContent || resp.Body == nil {
+		return
+	}
+	classify, _ := globalConfig.typeSuffix(resp.Header.Get("Content-Type"))
+	if classify != "video" && classify != "audio" {
+		return
+	}
+	start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok {
+		return
+	}
+	rf, err := s.get(shared.Md5(resp.Request.URL.String()), total)
+	if err != nil {
+		globalLogger.Esg(err, "open range file failed")
+		return
+	}
+	resp.Body = &rangeTeeBody{ReadCloser: resp.Body, rf: rf, offset: start}
+}
+
+func (s *RangeStitcher) get(sign string, total int64) (*rangeFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rf, ok := s.files[sign]; ok {
+		return rf, nil
+	}
+	if err := shared.CreateDirIfNotExist(s.dir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(s.dir, sign)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	rf := &rangeFile{path: path, total: total, file: file}
+	s.files[sign] = rf
+	return rf, nil
+}
+
+func (s *RangeStitcher) status(sign string) (RangeStatus, bool) {
+	s.mu.Lock()
+	rf, ok := s.files[sign]
+	s.mu.Unlock()
+	if !ok {
+		return RangeStatus{}, false
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	covered := int64(0)
+	for _, r := range rf.ranges {
+		covered += r[1] - r[0]
+	}
+	return RangeStatus{Total: rf.total, Covered: covered, Complete: rf.complete()}, true
+}
+
+// export copies a fully covered stitched file to savePath
+func (s *RangeStitcher) export(sign, savePath string, finalize FinalizeFunc) (string, bool, error) {
+	s.mu.Lock()
+	rf, ok := s.files[sign]
+	s.mu.Unlock()
+	if !ok {
+		return savePath, false, nil
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if !rf.complete() {
+		return savePath, false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(savePath), os.ModePerm); err != nil {
+		return savePath, true, fmt.Errorf("create directory failed: %w", err)
+	}
+	savePath = targetFileName(savePath)
+	partName := savePath + partSuffix
+	dst, err := os.Create(partName)
+	if err != nil {
+		return savePath, true, err
+	}
+	_, err = io.Copy(dst, io.NewSectionReader(rf.file, 0, rf.total))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partName)
+		return savePath, true, err
+	}
+	return savePath, true, commitPart(partName, savePath, finalize)
+}
+
+func (s *RangeStitcher) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rf := range s.files {
+		rf.mu.Lock()
+		_ = rf.file.Close()
+		rf.mu.Unlock()
+	}
+	s.files = make(map[string]*rangeFile)
+	_ = os.RemoveAll(s.dir)
+}
+
+func (rf *rangeFile) write(p []byte, offset int64) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if offset >= rf.total {
+		return
+	}
+	if end := offset + int64(len(p)); end > rf.total {
+		p = p[:rf.total-offset]
+	}
+	if _, err := rf.file.WriteAt(p, offset); err != nil {
+		return
+	}
+	rf.addRange(offset, offset+int64(len(p)))
+}
+
+func (rf *rangeFile) addRange(start, end int64) {
+	rf.ranges = append(rf.ranges, [2]int64{start, end})
+	sort.Slice(rf.ranges, func(i, j int) bool { return rf.ranges[i][0] < rf.ranges[j][0] })
+	merged := rf.ranges[:1]
+	for _, r := range rf.ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	rf.ranges = merged
+}
+
+func (rf *rangeFile) complete() bool {
+	return len(rf.ranges) == 1 && rf.ranges[0][0] == 0 && rf.ranges[0][1] >= rf.total
+}
+
+// parseContentRange parses "bytes start-end/total", total must be known
+func parseContentRange(value string) (int64, int64, bool) {
+	value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "bytes"))
+	rangePart, totalPart, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	startPart, _, ok := strings.Cut(rangePart, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(startPart), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(totalPart), 10, 64)
+	if err != nil || total <= 0 {
+		return 0, 0, false
+	}
+	return start, total, true
+}
@@ -0,0 +1,420 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+	flvMaxTag    = 16 << 20
+)
+
+func isLiveFlv(mediaInfo shared.MediaInfo) bool {
+	return mediaInfo.Classify == "live" && strings.EqualFold(mediaInfo.Suffix, ".flv")
+}
+
+// flvTag is one FLV tag: the 11 byte header, its data and the trailing PreviousTagSize
+type flvTag struct {
+	kind byte
+	ts   uint32
+	raw  []byte
+}
+
+func readFlvTag(r io.Reader) (*flvTag, error) {
+	head := make([]byte, 11)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	size := int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	if size > flvMaxTag {
+		return nil, errors.New("invalid flv tag size")
+	}
+	raw := make([]byte, 11+size+4)
+	copy(raw, head)
+	if _, err := io.ReadFull(r, raw[11:]); err != nil {
+		return nil, err
+	}
+	ts := uint32(head[4])<<16 | uint32(head[5])<<8 | uint32(head[6]) | uint32(head[7])<<24
+	return &flvTag{kind: head[0] & 0x1f, ts: ts, raw: raw}, nil
+}
+
+func (t *flvTag) data() []byte {
+	return t.raw[11 : len(t.raw)-4]
+}
+
+func (t *flvTag) withTimestamp(ts uint32) []byte {
+	t.raw[4], t.raw[5], t.raw[6], t.raw[7] = byte(ts>>16), byte(ts>>8), byte(ts), byte(ts>>24)
+	return t.raw
+}
+
+// isSequenceHeader reports the AVC/HEVC decoder config or the AAC audio config,
+// every part needs them before its first frame
+func (t *flvTag) isSequenceHeader() bool {
+	d := t.data()
+	if len(d) < 2 {
+		return false
+	}
+	switch t.kind {
+	case flvTagVideo:
+		codec := d[0] & 0x0f
+		return (codec == 7 || codec == 12) && d[1] == 0
+	case flvTagAudio:
+		return d[0]>>4 == 10 && d[1] == 0
+	}
+	return false
+}
+
+func (t *flvTag) isKeyframe() bool {
+	d := t.data()
+	return t.kind == flvTagVideo && len(d) > 0 && d[0]>>4 == 1
+}
+
+func (t *flvTag) clone() *flvTag {
+	return &flvTag{kind: t.kind, ts: t.ts, raw: append([]byte(nil), t.raw...)}
+}
+
+// LiveRecorder records an endless HTTP-FLV stream into "<name>_part1.flv", "<name>_part2.flv", ...
+// rolling over at a keyframe once Config.RecordMaxMinutes or Config.RecordMaxSizeMB is reached.
+// Cancel ends the recording and keeps it, Pause keeps the finished parts and resuming starts the next one.
+type LiveRecorder struct {
+	Url              string
+	FileName         string
+	Headers          map[string]string
+	Overrides        map[string]string
+	ProxyMode        string
+	Finalize         FinalizeFunc
+	progressCallback ProgressCallback
+	recorded         int64
+	limiter          *RateLimiter
+	stopped          atomic.Bool
+	ctx              context.Context
+	cancelFunc       context.CancelFunc
+}
+
+// livePart is the file currently being written
+type livePart struct {
+	index   int
+	name    string
+	file    *os.File
+	w       *bufio.Writer
+	startTs uint32
+	size    int64
+}
+
+func NewLiveRecorder(url, filename string, headers map[string]string) *LiveRecorder {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	return &LiveRecorder{
+		Url:        url,
+		FileName:   filename,
+		Headers:    headers,
+		limiter:    NewRateLimiter(0),
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+	}
+}
+
+func (lr *LiveRecorder) SetSpeedLimit(rate int64) {
+	lr.limiter.SetRate(rate)
+}
+
+func (lr *LiveRecorder) Start() error {
+	if err := os.MkdirAll(filepath.Dir(lr.FileName), os.ModePerm); err != nil {
+		return fmt.Errorf("create directory failed: %w", err)
+	}
+	// a resumed recording continues the numbering of its earlier parts
+	if !shared.FileExist(lr.partPath(1)) && !shared.FileExist(lr.partPath(1)+partSuffix) {
+		lr.FileName = targetFileName(lr.FileName)
+	}
+	if _, ok := lr.Headers["User-Agent"]; !ok {
+		lr.Headers["User-Agent"] = defaultUserAgent()
+	}
+	fd := &FileDownloader{Headers: lr.Headers, Overrides: lr.Overrides}
+	proxyURL, err := downloadProxy(lr.ProxyMode, lr.Url)
+	if err != nil {
+		return err
+	}
+	fd.ProxyUrl = proxyURL
+
+	request, err := http.NewRequestWithContext(lr.ctx, "GET", lr.Url, nil)
+	if err != nil {
+		return err
+	}
+	fd.setHeaders(request)
+	resp, err := fd.buildClient().Do(request)
+	if err != nil {
+		return lr.stop(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode}
+	}
+
+	err = lr.record(bufio.NewReaderSize(newLimitedReader(lr.ctx, resp.Body, lr.limiter, speedLimitOnce), 64<<10))
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// the streamer went offline
+		return lr.finish()
+	}
+	return lr.stop(err)
+}
+
+// stop maps the end of a recording to its result: kept on Cancel, resumable on Pause
+func (lr *LiveRecorder) stop(err error) error {
+	if lr.stopped.Load() {
+		return lr.finish()
+	}
+	if lr.ctx.Err() != nil {
+		return messageError(MsgDownloadCancelled)
+	}
+	return err
+}
+
+func (lr *LiveRecorder) record(r *bufio.Reader) error {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(header, []byte("FLV")) {
+		return errors.New("not a flv stream")
+	}
+
+	var meta *flvTag
+	seq := map[byte]*flvTag{}
+	var part *livePart
+	defer func() {
+		if part != nil {
+			lr.closePart(part)
+		}
+	}()
+	for {
+		tag, err := readFlvTag(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case tag.kind == flvTagScript:
+			if meta == nil {
+				meta = tag.clone()
+			}
+			continue
+		case tag.isSequenceHeader():
+			seq[tag.kind] = tag.clone()
+			if part == nil {
+				continue
+			}
+		case tag.kind != flvTagAudio && tag.kind != flvTagVideo:
+			continue
+		}
+
+		// parts start at a keyframe so each one plays on its own, audio only streams roll anywhere
+		if part == nil || (lr.shouldRoll(part, tag) && (tag.isKeyframe() || seq[flvTagVideo] == nil)) {
+			if part != nil {
+				if err := lr.closePart(part); err != nil {
+					part = nil
+					return err
+				}
+			}
+			if part, err = lr.openPart(header, meta, seq, tag.ts); err != nil {
+				return err
+			}
+		}
+		ts := uint32(0)
+		if tag.ts > part.startTs {
+			ts = tag.ts - part.startTs
+		}
+		if err := part.write(tag.withTimestamp(ts)); err != nil {
+			return err
+		}
+		lr.recorded += int64(len(tag.raw))
+		if lr.progressCallback != nil {
+			lr.progressCallback(float64(lr.recorded), -1, part.index, 0)
+		}
+	}
+}
+
+func (lr *LiveRecorder) shouldRoll(part *livePart, tag *flvTag) bool {
+	if minutes := globalConfig.RecordMaxMinutes; minutes > 0 && tag.ts > part.startTs && tag.ts-part.startTs >= uint32(minutes)*60000 {
+		return true
+	}
+	if size := globalConfig.RecordMaxSizeMB; size > 0 && part.size >= int64(size)<<20 {
+		return true
+	}
+	return false
+}
+
+func (lr *LiveRecorder) partPath(index int) string {
+	ext := filepath.Ext(lr.FileName)
+	return strings.TrimSuffix(lr.FileName, ext) + "_part" + strconv.Itoa(index) + ext
+}
+
+// nextIndex is the first unused part number, parts left unfinished by a crash are kept as they are
+func (lr *LiveRecorder) nextIndex() int {
+	index := 1
+	for ; ; index++ {
+		name := lr.partPath(index)
+		if shared.FileExist(name + partSuffix) {
+			_ = commitPart(name+partSuffix, name, nil)
+		}
+		if !shared.FileExist(name) {
+			return index
+		}
+	}
+}
+
+func (lr *LiveRecorder) parts() []string {
+	var parts []string
+	for index := 1; shared.FileExist(lr.partPath(index)); index++ {
+		parts = append(parts, lr.partPath(index))
+	}
+	return parts
+}
+
+// openPart starts a file with the stream header, metadata and codec configs at timestamp 0
+func (lr *LiveRecorder) openPart(header []byte, meta *flvTag, seq map[byte]*flvTag, startTs uint32) (*livePart, error) {
+	index := lr.nextIndex()
+	name := lr.partPath(index)
+	file, err := os.Create(name + partSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("file open failed: %w", err)
+	}
+	part := &livePart{index: index, name: name, file: file, w: bufio.NewWriterSize(file, 256<<10), startTs: startTs}
+	if err := part.write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, tag := range []*flvTag{meta, seq[flvTagVideo], seq[flvTagAudio]} {
+		if tag == nil {
+			continue
+		}
+		if err := part.write(tag.clone().withTimestamp(0)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return part, nil
+}
+
+func (lr *LiveRecorder) closePart(part *livePart) error {
+	err := part.w.Flush()
+	if closeErr := part.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return commitPart(part.name+partSuffix, part.name, lr.Finalize)
+}
+
+func (p *livePart) write(data []byte) error {
+	n, err := p.w.Write(data)
+	p.size += int64(n)
+	return err
+}
+
+// finish settles the recorded parts: a single part takes the plain name,
+// several are joined into it when Config.RecordConcat is on
+func (lr *LiveRecorder) finish() error {
+	parts := lr.parts()
+	switch {
+	case len(parts) == 0:
+		return errors.New("nothing was recorded")
+	case len(parts) == 1:
+		return os.Rename(parts[0], lr.FileName)
+	case !globalConfig.RecordConcat:
+		lr.FileName = parts[0]
+		return nil
+	}
+	partName := lr.FileName + partSuffix
+	if err := concatFlv(parts, partName); err != nil {
+		_ = os.Remove(partName)
+		return fmt.Errorf("concat recording failed: %w", err)
+	}
+	if err := commitPart(partName, lr.FileName, nil); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		_ = os.Remove(part)
+	}
+	return nil
+}
+
+// concatFlv joins parts written by the recorder, dropping the repeated headers
+// and shifting each part's timestamps to follow the previous one
+func concatFlv(parts []string, target string) error {
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriterSize(out, 256<<10)
+
+	offset := uint32(0)
+	for i, name := range parts {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		r := bufio.NewReaderSize(file, 256<<10)
+		header := make([]byte, 13)
+		if _, err := io.ReadFull(r, header); err != nil {
+			file.Close()
+			return err
+		}
+		if i == 0 {
+			if _, err := w.Write(header); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		last, leading := uint32(0), true
+		for {
+			tag, err := readFlvTag(r)
+			if err != nil {
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					break
+				}
+				file.Close()
+				return err
+			}
+			if leading && (tag.kind == flvTagScript || tag.isSequenceHeader()) {
+				if i > 0 {
+					continue
+				}
+			} else {
+				leading = false
+			}
+			if tag.ts > last {
+				last = tag.ts
+			}
+			if _, err := w.Write(tag.withTimestamp(tag.ts + offset)); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		file.Close()
+		offset += last + 1
+	}
+	return w.Flush()
+}
+
+func (lr *LiveRecorder) Pause() {
+	lr.cancelFunc()
+}
+
+func (lr *LiveRecorder) Cancel() {
+	lr.stopped.Store(true)
+	lr.cancelFunc()
+}
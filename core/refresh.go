@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+)
+
+// isExpiredLink matches the answers CDNs give for a signed url that ran out
+func isExpiredLink(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.Code == http.StatusForbidden || statusErr.Code == http.StatusGone || statusErr.Code == http.StatusUnauthorized
+}
+
+// signingParams are the query parameters of a signed url that change with each signature, the
+// others, e.g. encfilekey, may be what tells media on a shared path apart
+var signingParams = map[string]bool{
+	"sign": true, "signature": true, "sig": true, "token": true, "auth_key": true, "policy": true,
+	"expires": true, "expire": true, "x-expires": true, "deadline": true, "e": true, "t": true,
+	"ts": true, "timestamp": true, "key-pair-id": true, "hdnts": true, "hdnea": true,
+}
+
+// signedKey identifies a signed url by its host, path and query without the signature
+func signedKey(rawUrl string) (string, bool) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.RawQuery == "" || len(u.Path) < 8 {
+		return "", false
+	}
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if signingParams[lower] || strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "x-goog-") {
+			query.Del(name)
+		}
+	}
+	// Encode sorts by name
+	return u.Host + u.Path + "?" + query.Encode(), true
+}
+
+// rememberUrl keeps the newest signed url per path, players request the same media again
+// with a fresh signature, e.g. after the page is reloaded
+func (r *Resource) rememberUrl(res shared.MediaInfo) {
+	if key, ok := signedKey(res.Url); ok {
+		r.freshUrls.Store(key, res.Url)
+	}
+}
+
+// refreshUrl looks for a working replacement of an expired url: first a newer capture of the
+// same media, then resolving the share link the resource came from again. Empty when none is found.
+func (r *Resource) refreshUrl(mediaInfo shared.MediaInfo, current string) string {
+	if key, ok := signedKey(current); ok {
+		if fresh, ok := r.freshUrls.Load(key); ok && fresh.(string) != current {
+			return fresh.(string)
+		}
+	}
+
+	shareUrl := mediaInfo.OtherData["share_url"]
+	if shareUrl == "" {
+		return ""
+	}
+	list, err := resolveShare(shareUrl)
+	if err != nil {
+		globalLogger.Warn().Msgf("refresh %s failed: %v", shareUrl, err)
+		return ""
+	}
+	index, _ := strconv.Atoi(mediaInfo.OtherData["resolve_index"])
+	if index < len(list) && list[index].Url != current {
+		return list[index].Url
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formats a captured request can be copied as
+const (
+	RequestCurl   = "curl"
+	RequestWget   = "wget"
+	RequestPython = "python"
+)
+
+// requestCommand reproduces the request a download of the resource sends: the captured headers as
+// Config.UseHeaders lets through, the identity, the cookies of the jar and the overrides set for
+// it. Commands are for a POSIX shell. A playlist is fetched as is, not its segments.
+func (r *Resource) requestCommand(id, format string) (string, error) {
+	mediaInfo, ok := r.store.get(id)
+	if !ok {
+		return "", messageError(MsgResourceNotFound)
+	}
+	headers, err := r.parseHeaders(mediaInfo)
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequest(http.MethodGet, mediaInfo.Url, nil)
+	if err != nil {
+		return "", err
+	}
+	downloader := &FileDownloader{Headers: headers, Overrides: r.headerOverrides(id)}
+	downloader.setHeaders(request)
+	// the client sets these itself
+	request.Header.Del("Host")
+	request.Header.Del("Content-Length")
+	request.Header.Del("Range")
+
+	output := requestFileName(mediaInfo)
+	switch format {
+	case RequestCurl, "":
+		return curlCommand(mediaInfo.Url, request.Header, output), nil
+	case RequestWget:
+		return wgetCommand(mediaInfo.Url, request.Header, output), nil
+	case RequestPython:
+		return pythonSnippet(mediaInfo.Url, request.Header, output), nil
+	}
+	return "", fmt.Errorf("unknown request format %q, expected curl, wget or python", format)
+}
+
+// requestFileName is the title of the resource with its suffix, what the command saves to
+func requestFileName(mediaInfo shared.MediaInfo) string {
+	parts := templateParts(mediaInfo, "{title}")
+	return parts[len(parts)-1] + mediaInfo.Suffix
+}
+
+// headerLines are the headers as "Name: value", sorted so the output is stable
+func headerLines(header http.Header) []string {
+	var lines []string
+	for key, values := range header {
+		for _, value := range values {
+			lines = append(lines, key+": "+value)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func curlCommand(rawUrl string, header http.Header, output string) string {
+	var b strings.Builder
+	b.WriteString("curl -L " + shellQuote(rawUrl))
+	for _, line := range headerLines(header) {
+		b.WriteString(" \\\n  -H " + shellQuote(line))
+	}
+	b.WriteString(" \\\n  -o " + shellQuote(output))
+	return b.String()
+}
+
+func wgetCommand(rawUrl string, header http.Header, output string) string {
+	var b strings.Builder
+	b.WriteString("wget " + shellQuote(rawUrl))
+	for _, line := range headerLines(header) {
+		b.WriteString(" \\\n  --header=" + shellQuote(line))
+	}
+	b.WriteString(" \\\n  -O " + shellQuote(output))
+	return b.String()
+}
+
+func pythonSnippet(rawUrl string, header http.Header, output string) string {
+	var b strings.Builder
+	b.WriteString("import requests\n\n")
+	b.WriteString("headers = {\n")
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// requests sends one value per header, repeated ones are joined as http allows
+		fmt.Fprintf(&b, "    %s: %s,\n", strconv.Quote(key), strconv.Quote(strings.Join(header[key], ", ")))
+	}
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "with requests.get(%s, headers=headers, stream=True, timeout=30) as response:\n", strconv.Quote(rawUrl))
+	b.WriteString("    response.raise_for_status()\n")
+	fmt.Fprintf(&b, "    with open(%s, \"wb\") as file:\n", strconv.Quote(output))
+	b.WriteString("        for chunk in response.iter_content(chunk_size=1 << 16):\n")
+	b.WriteString("            file.write(chunk)\n")
+	return b.String()
+}
+
+// shellQuote wraps value in single quotes, which keep everything literal in a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const resolvePageLimit = 5 << 20
+
+var (
+	shareUrlRegex  = regexp.MustCompile(`https?://[^\s"'<>，。！]+`)
+	metaMediaRegex = regexp.MustCompile(`(?i)<meta[^>]+(?:property|name)=["'](og:video(?::url|:secure_url)?|og:image|twitter:player:stream)["'][^>]*>`)
+	metaContent    = regexp.MustCompile(`(?i)content=["']([^"']+)["']`)
+	videoTagRegex  = regexp.MustCompile(`(?i)<(?:video|source)[^>]+src=["']([^"']+)["']`)
+)
+
+// resolveUrl turns a pasted url or share text into resources, new ones are captured like sniffed ones.
+// The share link is kept with each resource so an expired media url can be resolved again.
+func (r *Resource) resolveUrl(text string) ([]shared.MediaInfo, error) {
+	var list []shared.MediaInfo
+	rawUrl := magnetRegex.FindString(text)
+	if rawUrl != "" {
+		list = append(list, newMagnetMedia(rawUrl))
+	} else if rawUrl = shareUrlRegex.FindString(text); rawUrl == "" {
+		return nil, messageError(MsgNoUrl)
+	} else {
+		var err error
+		if list, err = resolveShare(rawUrl); err != nil {
+			return nil, err
+		}
+	}
+	for i, res := range list {
+		res.OtherData["share_url"] = rawUrl
+		res.OtherData["resolve_index"] = strconv.Itoa(i)
+		if r.mediaIsMarked(res.UrlSign) {
+			continue
+		}
+		r.markMedia(res.UrlSign)
+		r.capture(res)
+	}
+	return list, nil
+}
+
+// resolveShare follows rawUrl through redirects, direct media is taken as is, pages go to the
+// matching plugin's Resolver and then to a generic og:video / <video> scan
+func resolveShare(rawUrl string) ([]shared.MediaInfo, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", shared.MobileUserAgent)
+	request.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	client := &http.Client{Transport: &http.Transport{Proxy: func(req *http.Request) (*url.URL, error) {
+		return downloadProxy(DownloadProxyDefault, req.URL.String())
+	}}, Jar: cookieOnce}
+	if err := politeOnce.wait(ctx, request.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	pageUrl := resp.Request.URL.String()
+
+	var list []shared.MediaInfo
+	if classify, suffix := globalConfig.typeSuffix(resp.Header.Get("Content-Type")); classify != "" {
+		list = append(list, directMedia(pageUrl, classify, suffix, resp))
+	} else {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, resolvePageLimit))
+		if err != nil {
+			return nil, err
+		}
+		if resolver, ok := proxyOnce.matchPlugin(resp.Request.URL.Hostname()).(shared.Resolver); ok {
+			list, err = resolver.Resolve(pageUrl, body)
+			if err != nil {
+				globalLogger.Warn().Msgf("resolve %s failed: %v", pageUrl, err)
+			}
+		}
+		if len(list) == 0 {
+			list = genericResolve(pageUrl, body)
+		}
+		if len(list) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no media found on %s", pageUrl)
+		}
+	}
+
+	return list, nil
+}
+
+func directMedia(rawUrl, classify, suffix string, resp *http.Response) shared.MediaInfo {
+	if suffix == "default" {
+		suffix = path.Ext(resp.Request.URL.Path)
+	}
+	res := newManualMedia(rawUrl, classify, suffix, "")
+	res.Size = float64(resp.ContentLength)
+	res.ContentType = resp.Header.Get("Content-Type")
+	return res
+}
+
+// genericResolve looks for og:video / og:image meta tags and <video>/<source> elements
+func genericResolve(pageUrl string, body []byte) []shared.MediaInfo {
+	base, _ := url.Parse(pageUrl)
+	seen := map[string]bool{}
+	var list []shared.MediaInfo
+	add := func(raw, classify string) {
+		u, err := base.Parse(html.UnescapeString(strings.TrimSpace(raw)))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+		suffix := path.Ext(u.Path)
+		if suffix == "" {
+			suffix = map[string]string{"video": ".mp4", "image": ".jpg"}[classify]
+		}
+		list = append(list, newManualMedia(u.String(), classify, suffix, ""))
+	}
+
+	for _, tag := range metaMediaRegex.FindAllSubmatch(body, -1) {
+		content := metaContent.FindSubmatch(tag[0])
+		if content == nil {
+			continue
+		}
+		classify := "video"
+		if strings.EqualFold(string(tag[1]), "og:image") {
+			classify = "image"
+		}
+		add(string(content[1]), classify)
+	}
+	for _, match := range videoTagRegex.FindAllSubmatch(body, -1) {
+		add(string(match[1]), "video")
+	}
+
+	// a page image only counts when there is no video
+	var videos []shared.MediaInfo
+	for _, res := range list {
+		if res.Classify == "video" {
+			videos = append(videos, res)
+		}
+	}
+	if len(videos) > 0 {
+		return videos
+	}
+	return list
+}
+
+func newManualMedia(rawUrl, classify, suffix, description string) shared.MediaInfo {
+	urlSign := shared.Md5(rawUrl)
+	id, err := gonanoid.New()
+	if err != nil {
+		id = urlSign
+	}
+	return shared.MediaInfo{
+		Id:          id,
+		Url:         rawUrl,
+		UrlSign:     urlSign,
+		Domain:      shared.GetTopLevelDomain(rawUrl),
+		Classify:    classify,
+		Suffix:      suffix,
+		Status:      shared.DownloadStatusReady,
+		OtherData:   map[string]string{},
+		Description: description,
+	}
+}
@@ -3,7 +3,6 @@ package core
 import (
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -11,7 +10,6 @@ import (
 	"path/filepath"
 	"regexp"
 	"res-downloader/core/shared"
-	"strconv"
 	"strings"
 	"sync"
 )
@@ -22,16 +20,46 @@ type WxFileDecodeResult struct {
 }
 
 type Resource struct {
-	mediaMark  sync.Map
-	tasks      sync.Map
-	resType    map[string]bool
-	resTypeMux sync.RWMutex
+	mediaMark     sync.Map
+	tasks         sync.Map
+	resType       map[string]bool
+	resTypeMux    sync.RWMutex
+	segmentGroups map[string]*SegmentGroup
+	segmentMux    sync.Mutex
+	overrides     sync.Map // media id -> map[string]string
+	proxyModes    sync.Map // media id -> download proxy mode
+	audioModes    sync.Map // media id -> true when only the audio is kept
+	mirrors       map[string]*mirrorSet
+	mirrorMux     sync.Mutex
+	variants      map[string]*variantSet
+	variantMux    sync.Mutex
+	freshUrls     sync.Map // signedKey -> newest captured url
+	groups        *Grouper
+	comments      sync.Map // url sign -> pendingComments that came before the media
+	store         *ResourceStore
+}
+
+// Downloader is implemented by every download task kept in Resource.tasks
+type Downloader interface {
+	Start() error
+	Pause()
+	Cancel()
+	SetSpeedLimit(rate int64)
 }
 
 func initResource() *Resource {
 	if resourceOnce == nil {
-		resourceOnce = &Resource{}
+		resourceOnce = &Resource{
+			segmentGroups: make(map[string]*SegmentGroup),
+			mirrors:       make(map[string]*mirrorSet),
+			variants:      make(map[string]*variantSet),
+			groups:        newGrouper(),
+			store:         openResourceStore(filepath.Join(appOnce.UserDir, "resources.db")),
+		}
 		resourceOnce.resType = resourceOnce.buildResType(globalConfig.MimeMap)
+		for _, sign := range resourceOnce.store.signs() {
+			resourceOnce.markMedia(sign)
+		}
 	}
 	return resourceOnce
 }
@@ -80,29 +108,130 @@ func (r *Resource) setResType(n []string) {
 	r.resTypeMux.Unlock()
 }
 
+// capture is the single entry for newly sniffed resources, whichever plugin found them
+func (r *Resource) capture(res shared.MediaInfo) {
+	res, ok := scriptOnce.resource(res)
+	if !ok {
+		return
+	}
+	r.rememberUrl(res)
+	if globalConfig.SegmentMerge {
+		if key, index, ranged, ok := segmentKey(res); ok {
+			if !r.addSegment(key, index, ranged, res) {
+				return
+			}
+			if res.OtherData == nil {
+				res.OtherData = map[string]string{}
+			}
+			res.OtherData["segment_group"] = key
+		}
+	}
+	if res.OtherData["segment_group"] == "" && r.addMirror(res) {
+		return
+	}
+	if r.addVariant(&res) {
+		return
+	}
+	if id := previewOnce.related(res.Url); id != "" {
+		if res.OtherData == nil {
+			res.OtherData = map[string]string{}
+		}
+		res.OtherData["preview_id"] = id
+		if preview, ok := previewOnce.get(id); ok {
+			enrichMetadata(&res, preview.Body)
+		}
+	}
+	r.groups.assign(&res)
+	r.takeComments(&res)
+	metricsOnce.capture(res.Classify)
+	automation := automationFor(&res)
+	r.store.add(res)
+	httpServerOnce.send("newResources", res)
+	fireWebhooks(WebhookCaptured, res, "")
+	automation.run(res)
+}
+
+// capturedList is the latest of what was captured since the last clear, oldest first
+func (r *Resource) capturedList() []shared.MediaInfo {
+	return r.store.recent(capturedLimit)
+}
+
 func (r *Resource) clear() {
 	r.mediaMark.Clear()
+	r.store.clear()
+	r.freshUrls.Clear()
+	r.clearSegments()
+	r.clearMirrors()
+	r.clearVariants()
+	bodyCacheOnce.Clear()
+	rangeStitchOnce.clear()
+	previewOnce.clear()
+	r.groups.clear()
+	r.comments.Clear()
 }
 
 func (r *Resource) delete(sign string) {
 	r.mediaMark.Delete(sign)
+	r.store.delete(sign)
+	bodyCacheOnce.Delete(sign)
+}
+
+// setSpeedLimit overrides the speed of a running task, rate in bytes per second
+func (r *Resource) setSpeedLimit(id string, rate int64) error {
+	if d, ok := r.tasks.Load(id); ok {
+		d.(Downloader).SetSpeedLimit(rate)
+		return nil
+	}
+	return messageError(MsgTaskNotFound)
 }
 
 func (r *Resource) cancel(id string) error {
+	if queueOnce.remove(id) {
+		r.journalRemove(id)
+		return nil
+	}
+	if item, ok := queueOnce.dropPaused(id); ok {
+		r.removePartial(item.MediaInfo)
+		r.journalRemove(id)
+		return nil
+	}
 	if d, ok := r.tasks.Load(id); ok {
-		d.(*FileDownloader).Cancel()
+		d.(Downloader).Cancel()
 		r.tasks.Delete(id) // 可选：取消后清理
+		queueOnce.markCancelled(id)
 		return nil
 	}
-	return errors.New("task not found")
+	// waiting for a retry
+	if queueOnce.markCancelled(id) {
+		r.journalRemove(id)
+		return nil
+	}
+	return messageError(MsgTaskNotFound)
 }
 
-func (r *Resource) download(mediaInfo shared.MediaInfo, decodeStr string) {
+// removePartial deletes what a paused download left behind
+func (r *Resource) removePartial(mediaInfo shared.MediaInfo) {
+	if savePath := r.journalSavePath(mediaInfo); savePath != "" {
+		partName := savePath + partSuffix
+		_ = os.Remove(partName)
+		_ = os.Remove(partName + ".json")
+		_ = os.Remove(partName + ".aria2")
+	}
+}
+
+// download runs one task to completion, tasks are started by the DownloadQueue
+func (r *Resource) download(mediaInfo shared.MediaInfo, decodeStr string) error {
 	if globalConfig.SaveDirectory == "" {
-		return
+		return messageError(MsgSaveDirectoryUnset)
 	}
-	go func(mediaInfo shared.MediaInfo) {
-		rawUrl := mediaInfo.Url
+	// a .torrent without a client configured is simply saved as a file
+	if isTorrent(mediaInfo) && (globalConfig.TorrentClient != "" || isMagnet(mediaInfo.Url)) {
+		return r.handoffTorrent(mediaInfo)
+	}
+	rawUrl := mediaInfo.Url
+	if globalConfig.FilenameTemplate != "" {
+		mediaInfo.SavePath = templateSavePath(mediaInfo, globalConfig.FilenameTemplate)
+	} else {
 		fileName := shared.Md5(rawUrl)
 
 		if v := shared.GetFileNameFromURL(rawUrl); v != "" {
@@ -123,61 +252,242 @@ func (r *Resource) download(mediaInfo shared.MediaInfo, decodeStr string) {
 		}
 
 		if globalConfig.FilenameTime {
-			mediaInfo.SavePath = filepath.Join(globalConfig.SaveDirectory, fileName+"_"+shared.GetCurrentDateTimeFormatted())
+			mediaInfo.SavePath = filepath.Join(saveDirectory(mediaInfo), fileName+"_"+shared.GetCurrentDateTimeFormatted())
 		} else {
-			mediaInfo.SavePath = filepath.Join(globalConfig.SaveDirectory, fileName)
+			mediaInfo.SavePath = filepath.Join(saveDirectory(mediaInfo), fileName)
 		}
 
 		if !strings.HasSuffix(mediaInfo.SavePath, mediaInfo.Suffix) {
 			mediaInfo.SavePath = mediaInfo.SavePath + mediaInfo.Suffix
 		}
+	}
 
-		if strings.Contains(rawUrl, "qq.com") {
-			if globalConfig.Quality == 1 &&
-				strings.Contains(rawUrl, "encfilekey=") &&
-				strings.Contains(rawUrl, "token=") {
-				parseUrl, err := url.Parse(rawUrl)
-				queryParams := parseUrl.Query()
-				if err == nil && queryParams.Has("encfilekey") && queryParams.Has("token") {
-					rawUrl = parseUrl.Scheme + "://" + parseUrl.Host + "/" + parseUrl.Path +
-						"?encfilekey=" + queryParams.Get("encfilekey") +
-						"&token=" + queryParams.Get("token")
-				}
-			} else if globalConfig.Quality > 1 && mediaInfo.OtherData["wx_file_formats"] != "" {
-				format := strings.Split(mediaInfo.OtherData["wx_file_formats"], "#")
-				qualityMap := []string{
-					format[0],
-					format[len(format)/2],
-					format[len(format)-1],
-				}
-				rawUrl += "&X-snsvideoflag=" + qualityMap[globalConfig.Quality-2]
+	if savePath := r.journalSavePath(mediaInfo); savePath != "" {
+		mediaInfo.SavePath = savePath
+	} else if savePath, remark := resolveExistingName(mediaInfo.SavePath); remark != "" {
+		mediaInfo.SavePath = savePath
+		r.progressEventsEmit(mediaInfo, remark, shared.DownloadStatusDone)
+		historyOnce.record(mediaInfo, shared.DownloadStatusDone, remark)
+		return nil
+	} else {
+		mediaInfo.SavePath = savePath
+	}
+	r.journalAdd(mediaInfo, decodeStr)
+
+	var fallbacks []string
+	if strings.Contains(rawUrl, "qq.com") {
+		if globalConfig.Quality == 1 &&
+			strings.Contains(rawUrl, "encfilekey=") &&
+			strings.Contains(rawUrl, "token=") {
+			parseUrl, err := url.Parse(rawUrl)
+			queryParams := parseUrl.Query()
+			if err == nil && queryParams.Has("encfilekey") && queryParams.Has("token") {
+				rawUrl = parseUrl.Scheme + "://" + parseUrl.Host + "/" + parseUrl.Path +
+					"?encfilekey=" + queryParams.Get("encfilekey") +
+					"&token=" + queryParams.Get("token")
+			}
+		} else if globalConfig.Quality > 1 && mediaInfo.OtherData["wx_file_formats"] != "" {
+			format := strings.Split(mediaInfo.OtherData["wx_file_formats"], "#")
+			qualityMap := []string{
+				format[0],
+				format[len(format)/2],
+				format[len(format)-1],
+			}
+			baseUrl := rawUrl
+			rawUrl += "&X-snsvideoflag=" + qualityMap[globalConfig.Quality-2]
+			// the other qualities are fallbacks
+			for _, f := range format {
+				fallbacks = append(fallbacks, baseUrl+"&X-snsvideoflag="+f)
 			}
 		}
+	}
 
-		headers, _ := r.parseHeaders(mediaInfo)
+	// a quality picked in the list wins over Config.VariantPreference, playlists choose their stream later
+	if !isHlsPlaylist(mediaInfo) {
+		if picked := mediaInfo.OtherData["variant"]; picked != "" {
+			rawUrl = picked
+		} else if variants := r.variantsOf(mediaInfo.Id); len(variants) > 1 {
+			rawUrl = pickVariant(variants, globalConfig.VariantPreference).Url
+		}
+	}
+
+	headers, _ := r.parseHeaders(mediaInfo)
 
-		downloader := NewFileDownloader(rawUrl, mediaInfo.SavePath, globalConfig.TaskNumber, headers)
-		downloader.progressCallback = func(totalDownloaded, totalSize float64, taskID int, taskProgress float64) {
-			r.progressEventsEmit(mediaInfo, strconv.Itoa(int(totalDownloaded*100/totalSize))+"%", shared.DownloadStatusRunning)
+	// an HLS audio rendition is already sound only
+	var audioTrack bool
+	// the sha256 the download was verified with, hashed once for the duplicate check and the history
+	var checksum string
+	// wx files are decrypted while still a part file, a failure keeps the file as before
+	var decodeErr error
+	finalize := func(partName string) error {
+		if decodeStr != "" {
+			r.progressEventsEmit(mediaInfo, "decrypting in progress", shared.DownloadStatusRunning)
+			decodeErr = r.decodeWxFile(partName, decodeStr)
+		} else if mediaInfo.OtherData["plugin_decrypt"] != "" {
+			r.progressEventsEmit(mediaInfo, "decrypting in progress", shared.DownloadStatusRunning)
+			decodeErr = pluginDecrypt(partName, mediaInfo)
 		}
+		return nil
+	}
+
+	savePath, stitched, err := rangeStitchOnce.export(mediaInfo.UrlSign, mediaInfo.SavePath, finalize)
+	if !stitched && rawUrl == mediaInfo.Url {
+		savePath, stitched, err = bodyCacheOnce.export(mediaInfo.UrlSign, mediaInfo.SavePath, finalize)
+	}
+	if stitched {
+		mediaInfo.SavePath = savePath
+	} else if urls, offsets := r.segmentUrls(mediaInfo.OtherData["segment_group"]); urls != nil {
+		downloader := NewSegmentDownloader(urls, mediaInfo.SavePath, headers)
+		downloader.Offsets = offsets
+		downloader.Overrides = r.headerOverrides(mediaInfo.Id)
+		downloader.ProxyMode = r.proxyMode(mediaInfo.Id)
+		downloader.progressCallback = trackProgress(mediaInfo, "segments")
+		downloader.Finalize = finalize
+		r.tasks.Store(mediaInfo.Id, downloader)
+		err = downloader.Start()
+		mediaInfo.SavePath = downloader.FileName
+	} else if isHlsPlaylist(mediaInfo) {
+		savePath := strings.TrimSuffix(mediaInfo.SavePath, mediaInfo.Suffix) + ".ts"
+		downloader := NewPlaylistDownloader(rawUrl, savePath, headers)
+		downloader.Variant = mediaInfo.OtherData["variant"]
+		downloader.AudioOnly = r.audioOnly(mediaInfo)
+		downloader.Overrides = r.headerOverrides(mediaInfo.Id)
+		downloader.ProxyMode = r.proxyMode(mediaInfo.Id)
+		downloader.progressCallback = trackProgress(mediaInfo, "segments")
+		downloader.Finalize = finalize
+		r.tasks.Store(mediaInfo.Id, downloader)
+		err = downloader.Start()
+		mediaInfo.SavePath = downloader.FileName
+		audioTrack = downloader.audioRendition
+	} else if isLiveFlv(mediaInfo) {
+		downloader := NewLiveRecorder(rawUrl, mediaInfo.SavePath, headers)
+		downloader.Overrides = r.headerOverrides(mediaInfo.Id)
+		downloader.ProxyMode = r.proxyMode(mediaInfo.Id)
+		downloader.progressCallback = trackProgress(mediaInfo, "bytes")
+		downloader.Finalize = finalize
 		r.tasks.Store(mediaInfo.Id, downloader)
-		err := downloader.Start()
+		err = downloader.Start()
 		mediaInfo.SavePath = downloader.FileName
-		if err != nil {
-			if !strings.Contains(err.Error(), "cancelled") {
-				r.progressEventsEmit(mediaInfo, err.Error())
+	} else if globalConfig.Aria2Enable {
+		downloader := NewAria2Downloader(rawUrl, mediaInfo.SavePath, headers)
+		downloader.Overrides = r.headerOverrides(mediaInfo.Id)
+		downloader.ProxyMode = r.proxyMode(mediaInfo.Id)
+		downloader.progressCallback = trackProgress(mediaInfo, "bytes")
+		downloader.Finalize = finalize
+		r.tasks.Store(mediaInfo.Id, downloader)
+		err = downloader.Start()
+		mediaInfo.SavePath = downloader.FileName
+	} else {
+		candidates := r.downloadCandidates(mediaInfo, rawUrl, fallbacks...)
+		refreshed := false
+		for i := 0; i < len(candidates); i++ {
+			candidate := candidates[i]
+			downloader := NewFileDownloader(candidate, mediaInfo.SavePath, globalConfig.TaskNumber, headers)
+			downloader.Overrides = r.headerOverrides(mediaInfo.Id)
+			downloader.ProxyMode = r.proxyMode(mediaInfo.Id)
+			downloader.progressCallback = trackProgress(mediaInfo, "bytes")
+			downloader.Finalize = finalize
+			r.tasks.Store(mediaInfo.Id, downloader)
+			err = downloader.Start()
+			if err == nil && downloader.Sha256 != "" {
+				httpServerOnce.send("downloadVerified", map[string]interface{}{
+					"Id":       mediaInfo.Id,
+					"SavePath": downloader.FileName,
+					"Sha256":   downloader.Sha256,
+				})
+			}
+			if err != nil && !refreshed && isExpiredLink(err) {
+				if fresh := r.refreshUrl(mediaInfo, candidate); fresh != "" {
+					refreshed = true
+					r.progressEventsEmit(mediaInfo, "link expired, retrying with a refreshed url", shared.DownloadStatusRunning)
+					candidates[i] = fresh
+					i--
+					continue
+				}
 			}
-			return
+			if err == nil || i == len(candidates)-1 || !shouldFailover(err) {
+				mediaInfo.SavePath = downloader.FileName
+				checksum = downloader.Sha256
+				break
+			}
+			globalLogger.Warn().Msgf("download from %s failed, trying mirror %d/%d: %v", candidate, i+1, len(candidates)-1, err)
+			r.progressEventsEmit(mediaInfo, fmt.Sprintf("switching to mirror %d/%d", i+1, len(candidates)-1), shared.DownloadStatusRunning)
 		}
-		if decodeStr != "" {
-			r.progressEventsEmit(mediaInfo, "decrypting in progress", shared.DownloadStatusRunning)
-			if err := r.decodeWxFile(mediaInfo.SavePath, decodeStr); err != nil {
-				r.progressEventsEmit(mediaInfo, "decryption error: "+err.Error())
-				return
+	}
+	if err != nil {
+		if classifyDownloadError(err) == ErrorKindCancelled && !queueOnce.keepsPartial(mediaInfo.Id) {
+			r.journalRemove(mediaInfo.Id)
+		}
+		return err
+	}
+	r.journalRemove(mediaInfo.Id)
+	if decodeErr != nil {
+		r.progressEventsEmit(mediaInfo, "decryption error: "+decodeErr.Error())
+		return nil
+	}
+	message := "complete"
+	if r.audioOnly(mediaInfo) {
+		if savePath, err := extractAudio(mediaInfo.SavePath); err != nil {
+			globalLogger.Esg(err, "extract audio failed: "+mediaInfo.SavePath)
+			if !audioTrack {
+				message = "audio extraction failed, the video was kept: " + err.Error()
 			}
+		} else {
+			mediaInfo.SavePath = savePath
+			mediaInfo.Suffix = audioSuffix
+			checksum = ""
 		}
-		r.progressEventsEmit(mediaInfo, "complete", shared.DownloadStatusDone)
-	}(mediaInfo)
+	}
+	if decodeStr != "" || mediaInfo.OtherData["plugin_decrypt"] != "" {
+		// the file was verified before it was decrypted
+		checksum = ""
+	}
+	savePath, checksum, duplicate := dedupeContent(mediaInfo.SavePath, checksum)
+	mediaInfo.SavePath = savePath
+	if duplicate != nil {
+		duplicate.Id = mediaInfo.Id
+		message = duplicate.message()
+		httpServerOnce.send("duplicateContent", duplicate)
+	}
+	r.progressEventsEmit(mediaInfo, message, shared.DownloadStatusDone)
+	historyOnce.recordChecksum(mediaInfo, shared.DownloadStatusDone, message, checksum)
+	if duplicate != nil && duplicate.Action == ContentDelete {
+		// nothing new on disk to process
+		return nil
+	}
+	r.afterDownload(mediaInfo)
+	return nil
+}
+
+// setHeaderOverrides replaces the headers/cookies/UA a task will use instead of the captured ones
+func (r *Resource) setHeaderOverrides(id string, headers map[string]string) {
+	if len(headers) == 0 {
+		r.overrides.Delete(id)
+		return
+	}
+	r.overrides.Store(id, headers)
+}
+
+func (r *Resource) headerOverrides(id string) map[string]string {
+	if v, ok := r.overrides.Load(id); ok {
+		return v.(map[string]string)
+	}
+	return nil
+}
+
+func (r *Resource) setProxyMode(id, mode string) {
+	if mode == DownloadProxyDefault {
+		r.proxyModes.Delete(id)
+		return
+	}
+	r.proxyModes.Store(id, mode)
+}
+
+func (r *Resource) proxyMode(id string) string {
+	if v, ok := r.proxyModes.Load(id); ok {
+		return v.(string)
+	}
+	return DownloadProxyDefault
 }
 
 func (r *Resource) parseHeaders(mediaInfo shared.MediaInfo) (map[string]string, error) {
@@ -207,23 +517,42 @@ func (r *Resource) wxFileDecode(mediaInfo shared.MediaInfo, fileName, decodeStr
 	defer sourceFile.Close()
 	mediaInfo.SavePath = strings.ReplaceAll(fileName, ".mp4", "_decrypt.mp4")
 
-	destinationFile, err := os.Create(mediaInfo.SavePath)
+	partName := mediaInfo.SavePath + partSuffix
+	destinationFile, err := os.Create(partName)
 	if err != nil {
 		return "", err
 	}
-	defer destinationFile.Close()
-
 	_, err = io.Copy(destinationFile, sourceFile)
-	if err != nil {
-		return "", err
+	if closeErr := destinationFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = commitPart(partName, mediaInfo.SavePath, func(partName string) error {
+			return r.decodeWxFile(partName, decodeStr)
+		})
 	}
-	err = r.decodeWxFile(mediaInfo.SavePath, decodeStr)
 	if err != nil {
+		_ = os.Remove(partName)
 		return "", err
 	}
 	return mediaInfo.SavePath, nil
 }
 
+// errorEventsEmit reports a failed download together with its retry classification
+func (r *Resource) errorEventsEmit(mediaInfo shared.MediaInfo, err error, kind string) {
+	batchOnce.update(mediaInfo.Id, shared.DownloadStatusError, err.Error())
+	historyOnce.record(mediaInfo, shared.DownloadStatusError, err.Error())
+	r.store.setStatus(mediaInfo.Id, shared.DownloadStatusError, mediaInfo.SavePath)
+	httpServerOnce.send("downloadProgress", map[string]interface{}{
+		"Id":          mediaInfo.Id,
+		"Status":      shared.DownloadStatusError,
+		"SavePath":    mediaInfo.SavePath,
+		"Message":     err.Error(),
+		"ErrorKind":   kind,
+		"MessageCode": errorCode(err),
+	})
+}
+
 func (r *Resource) progressEventsEmit(mediaInfo shared.MediaInfo, args ...string) {
 	Status := shared.DownloadStatusError
 	Message := "ok"
@@ -235,6 +564,11 @@ func (r *Resource) progressEventsEmit(mediaInfo shared.MediaInfo, args ...string
 		Status = args[1]
 	}
 
+	// the store first, a batch that finishes here reads the saved paths
+	if Status == shared.DownloadStatusDone || Status == shared.DownloadStatusError {
+		r.store.setStatus(mediaInfo.Id, Status, mediaInfo.SavePath)
+	}
+	batchOnce.update(mediaInfo.Id, Status, Message)
 	httpServerOnce.send("downloadProgress", map[string]interface{}{
 		"Id":       mediaInfo.Id,
 		"Status":   Status,
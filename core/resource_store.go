@@ -0,0 +1,616 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"res-downloader/core/shared"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// resourceStoreLimit bounds the stored resources, the oldest go first
+	resourceStoreLimit = 50000
+	// capturedLimit bounds the list handed out in one piece, e.g. to /api/resources
+	capturedLimit = 5000
+	// noteLimit bounds a note on a resource, in characters
+	noteLimit = 500
+	// resourceColumns is what scan reads, the curation columns go into OtherData
+	resourceColumns = "data, starred, tags, note"
+)
+
+// ResourceQuery selects stored resources, empty fields match everything
+type ResourceQuery struct {
+	Keyword  string  `json:"keyword"`  // full text over title, url and the text recognized in the resource
+	Title    string  `json:"title"`    // substring of the title
+	Url      string  `json:"url"`      // substring of the url
+	Platform string  `json:"platform"` // substring of the domain
+	Type     string  `json:"type"`     // classify, e.g. video
+	Status   string  `json:"status"`
+	MinSize  float64 `json:"minSize"` // bytes
+	MaxSize  float64 `json:"maxSize"` // bytes, 0 for no limit
+	Start    int64   `json:"start"`   // unix seconds, inclusive
+	End      int64   `json:"end"`     // unix seconds, exclusive
+	Starred  bool    `json:"starred"` // starred ones only
+	Tag      string  `json:"tag"`
+	Group    string  `json:"group"` // OtherData group, see Grouper
+	Page     int     `json:"page"`
+	PageSize int     `json:"pageSize"`
+}
+
+// ResourceMark is a change to the curation of a resource, nil fields stay as they are
+type ResourceMark struct {
+	Starred *bool     `json:"starred"`
+	Tags    *[]string `json:"tags"`
+	Note    *string   `json:"note"`
+}
+
+// ResourceTag is a tag in use and how many resources carry it
+type ResourceTag struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ResourceStore keeps captured resources in UserDir/resources.db so the list survives restarts.
+// The full MediaInfo is kept as JSON, the columns next to it are what lists are filtered by.
+type ResourceStore struct {
+	db      *sql.DB
+	inserts atomic.Int64
+}
+
+func openResourceStore(path string) *ResourceStore {
+	store := &ResourceStore{}
+	db, err := store.open(path)
+	if err != nil {
+		// keep capturing for this session rather than lose the list
+		globalLogger.Esg(err, "open resource store failed, resources are kept in memory")
+		if db, err = store.open(":memory:"); err != nil {
+			globalLogger.Esg(err, "open in-memory resource store failed")
+			return store
+		}
+	}
+	store.db = db
+	return store
+}
+
+func (s *ResourceStore) open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes writers, and is the whole database when in memory
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS resources (
+		id TEXT PRIMARY KEY,
+		url_sign TEXT NOT NULL UNIQUE,
+		url TEXT NOT NULL,
+		domain TEXT NOT NULL DEFAULT '',
+		classify TEXT NOT NULL DEFAULT '',
+		suffix TEXT NOT NULL DEFAULT '',
+		size REAL NOT NULL DEFAULT 0,
+		description TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT '',
+		save_path TEXT NOT NULL DEFAULT '',
+		data TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_resources_url ON resources(url);
+	CREATE INDEX IF NOT EXISTS idx_resources_classify ON resources(classify);
+	CREATE INDEX IF NOT EXISTS idx_resources_domain ON resources(domain);
+	CREATE INDEX IF NOT EXISTS idx_resources_created ON resources(created_at);`)
+	if err == nil {
+		err = s.migrate(db)
+	}
+	if err == nil {
+		err = s.openSearch(db)
+	}
+	if err == nil {
+		err = s.openSessions(db)
+	}
+	if err == nil {
+		err = s.openText(db)
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate adds the columns later versions brought to a database made by an earlier one
+func (s *ResourceStore) migrate(db *sql.DB) error {
+	rows, err := db.Query(`PRAGMA table_info(resources)`)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, kind string
+		var value sql.NullString
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &value, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		have[name] = true
+	}
+	rows.Close()
+	for _, column := range []struct{ name, definition string }{
+		{"starred", "INTEGER NOT NULL DEFAULT 0"},
+		{"tags", "TEXT NOT NULL DEFAULT ''"},
+		{"note", "TEXT NOT NULL DEFAULT ''"},
+		{"group_id", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		if have[column.name] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE resources ADD COLUMN " + column.name + " " + column.definition); err != nil {
+			return err
+		}
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_resources_starred ON resources(starred);
+	CREATE INDEX IF NOT EXISTS idx_resources_group ON resources(group_id)`)
+	return err
+}
+
+// openSearch sets up the full text index of titles and urls. The trigram tokenizer matches any
+// part of a word, which is what Chinese titles without spaces need.
+func (s *ResourceStore) openSearch(db *sql.DB) error {
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'resources_fts'`).Scan(&exists); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS resources_fts USING fts5(
+		description, url, content='resources', content_rowid='rowid', tokenize='trigram'
+	);
+	CREATE TRIGGER IF NOT EXISTS resources_fts_insert AFTER INSERT ON resources BEGIN
+		INSERT INTO resources_fts(rowid, description, url) VALUES (new.rowid, new.description, new.url);
+	END;
+	CREATE TRIGGER IF NOT EXISTS resources_fts_delete AFTER DELETE ON resources BEGIN
+		INSERT INTO resources_fts(resources_fts, rowid, description, url) VALUES ('delete', old.rowid, old.description, old.url);
+	END;
+	CREATE TRIGGER IF NOT EXISTS resources_fts_update AFTER UPDATE OF description, url ON resources BEGIN
+		INSERT INTO resources_fts(resources_fts, rowid, description, url) VALUES ('delete', old.rowid, old.description, old.url);
+		INSERT INTO resources_fts(rowid, description, url) VALUES (new.rowid, new.description, new.url);
+	END;`)
+	if err == nil && exists == 0 {
+		// resources stored before the index existed
+		_, err = db.Exec(`INSERT INTO resources_fts(resources_fts) VALUES ('rebuild')`)
+	}
+	return err
+}
+
+// add stores a captured resource, one captured again replaces the earlier entry of its url
+// and keeps the star, tags and note it had
+func (s *ResourceStore) add(res shared.MediaInfo) {
+	s.insert(res, time.Now().UnixMilli())
+}
+
+func (s *ResourceStore) insert(res shared.MediaInfo, createdAt int64) {
+	if s.db == nil {
+		return
+	}
+	data, err := json.Marshal(withoutCuration(res))
+	if err != nil {
+		return
+	}
+	_, err = s.db.Exec(`INSERT INTO resources (id, url_sign, url, domain, classify, suffix, size, description, status, save_path, group_id, data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url_sign) DO UPDATE SET id = excluded.id, url = excluded.url, domain = excluded.domain,
+			classify = excluded.classify, suffix = excluded.suffix, size = excluded.size, description = excluded.description,
+			status = excluded.status, save_path = excluded.save_path, group_id = excluded.group_id, data = excluded.data,
+			created_at = excluded.created_at`,
+		res.Id, res.UrlSign, res.Url, res.Domain, res.Classify, res.Suffix, res.Size, res.Description,
+		res.Status, res.SavePath, res.OtherData["group"], string(data), createdAt)
+	if err != nil {
+		globalLogger.Esg(err, "store resource failed")
+		return
+	}
+	if s.inserts.Add(1)%500 == 0 {
+		s.prune()
+	}
+}
+
+// withoutCuration drops the star, tags and note from OtherData, they live in their own columns
+// and scan puts them back
+func withoutCuration(res shared.MediaInfo) shared.MediaInfo {
+	if res.OtherData["starred"] == "" && res.OtherData["tags"] == "" && res.OtherData["note"] == "" {
+		return res
+	}
+	otherData := make(map[string]string, len(res.OtherData))
+	for key, value := range res.OtherData {
+		if key != "starred" && key != "tags" && key != "note" {
+			otherData[key] = value
+		}
+	}
+	res.OtherData = otherData
+	return res
+}
+
+func (s *ResourceStore) prune() {
+	_, err := s.db.Exec(`DELETE FROM resources WHERE created_at < (
+		SELECT created_at FROM resources ORDER BY created_at DESC LIMIT 1 OFFSET ?)`, resourceStoreLimit-1)
+	if err != nil {
+		globalLogger.Esg(err, "prune resources failed")
+	}
+}
+
+// expire removes what was captured before the cutoff, starred, tagged or noted resources are kept
+func (s *ResourceStore) expire(before time.Time) int {
+	if s.db == nil {
+		return 0
+	}
+	result, err := s.db.Exec(`DELETE FROM resources WHERE created_at < ? AND starred = 0 AND tags = '' AND note = ''`, before.UnixMilli())
+	if err != nil {
+		globalLogger.Esg(err, "expire resources failed")
+		return 0
+	}
+	n, _ := result.RowsAffected()
+	return int(n)
+}
+
+// vacuum compacts the database file and returns how many bytes it shrank by
+func (s *ResourceStore) vacuum() int64 {
+	if s.db == nil {
+		return 0
+	}
+	before := s.size()
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		globalLogger.Esg(err, "vacuum resources failed")
+		return 0
+	}
+	if shrunk := before - s.size(); shrunk > 0 {
+		return shrunk
+	}
+	return 0
+}
+
+func (s *ResourceStore) size() int64 {
+	var pages, pageSize int64
+	_ = s.db.QueryRow(`PRAGMA page_count`).Scan(&pages)
+	_ = s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize)
+	return pages * pageSize
+}
+
+// setStatus keeps the outcome of a download with its resource
+func (s *ResourceStore) setStatus(id, status, savePath string) {
+	if s.db == nil {
+		return
+	}
+	_, err := s.db.Exec(`UPDATE resources SET status = ?, save_path = ?,
+		data = json_set(data, '$.Status', ?, '$.SavePath', ?) WHERE id = ?`, status, savePath, status, savePath, id)
+	if err != nil {
+		globalLogger.Esg(err, "update resource status failed")
+	}
+}
+
+func (s *ResourceStore) scan(query string, args ...interface{}) []shared.MediaInfo {
+	list := make([]shared.MediaInfo, 0)
+	if s.db == nil {
+		return list
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		globalLogger.Esg(err, "read resources failed")
+		return list
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data, tags, note string
+		var starred bool
+		var res shared.MediaInfo
+		if rows.Scan(&data, &starred, &tags, &note) != nil || json.Unmarshal([]byte(data), &res) != nil {
+			continue
+		}
+		if starred || tags != "" || note != "" {
+			if res.OtherData == nil {
+				res.OtherData = map[string]string{}
+			}
+			if starred {
+				res.OtherData["starred"] = "1"
+			}
+			if tags != "" {
+				res.OtherData["tags"] = strings.Trim(tags, ",")
+			}
+			if note != "" {
+				res.OtherData["note"] = note
+			}
+		}
+		list = append(list, res)
+	}
+	return list
+}
+
+// recent is the latest limit resources, oldest first
+func (s *ResourceStore) recent(limit int) []shared.MediaInfo {
+	return s.scan(`SELECT `+resourceColumns+` FROM (SELECT `+resourceColumns+`, created_at FROM resources ORDER BY created_at DESC LIMIT ?) ORDER BY created_at`, limit)
+}
+
+// search returns one page of the matching resources, newest first, and how many match in all
+func (s *ResourceStore) search(q ResourceQuery) ([]shared.MediaInfo, int, error) {
+	if s.db == nil {
+		return make([]shared.MediaInfo, 0), 0, nil
+	}
+	var where []string
+	var args []interface{}
+	if keyword := strings.TrimSpace(q.Keyword); keyword != "" {
+		// trigrams need three characters, shorter words are looked up plainly
+		// the text recognized in a resource matches as well
+		if utf8.RuneCountInString(keyword) >= 3 {
+			phrase := `"` + strings.ReplaceAll(keyword, `"`, `""`) + `"`
+			where = append(where, `(rowid IN (SELECT rowid FROM resources_fts WHERE resources_fts MATCH ?)
+				OR url_sign IN (SELECT url_sign FROM resource_text WHERE rowid IN (SELECT rowid FROM resource_text_fts WHERE resource_text_fts MATCH ?)))`)
+			args = append(args, phrase, phrase)
+		} else {
+			where = append(where, "(description LIKE ? OR url LIKE ? OR url_sign IN (SELECT url_sign FROM resource_text WHERE text LIKE ?))")
+			args = append(args, "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%")
+		}
+	}
+	if q.Title != "" {
+		where = append(where, "description LIKE ?")
+		args = append(args, "%"+q.Title+"%")
+	}
+	if q.Url != "" {
+		where = append(where, "url LIKE ?")
+		args = append(args, "%"+q.Url+"%")
+	}
+	if q.Platform != "" {
+		where = append(where, "domain LIKE ?")
+		args = append(args, "%"+q.Platform+"%")
+	}
+	if q.Type != "" {
+		where = append(where, "classify = ?")
+		args = append(args, q.Type)
+	}
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, q.Status)
+	}
+	if q.Starred {
+		where = append(where, "starred = 1")
+	}
+	if tag := normalizeTag(q.Tag); tag != "" {
+		where = append(where, "tags LIKE ?")
+		args = append(args, "%,"+tag+",%")
+	}
+	if q.Group != "" {
+		where = append(where, "group_id = ?")
+		args = append(args, q.Group)
+	}
+	if q.MinSize > 0 {
+		where = append(where, "size >= ?")
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		where = append(where, "size <= ?")
+		args = append(args, q.MaxSize)
+	}
+	if q.Start > 0 {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Start*1000)
+	}
+	if q.End > 0 {
+		where = append(where, "created_at < ?")
+		args = append(args, q.End*1000)
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM resources"+clause, args...).Scan(&total); err != nil {
+		return make([]shared.MediaInfo, 0), 0, err
+	}
+	if q.PageSize <= 0 || q.PageSize > capturedLimit {
+		q.PageSize = 50
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	list := s.scan("SELECT "+resourceColumns+" FROM resources"+clause+" ORDER BY created_at DESC LIMIT ? OFFSET ?",
+		append(args, q.PageSize, (q.Page-1)*q.PageSize)...)
+	return list, total, nil
+}
+
+func (s *ResourceStore) get(id string) (shared.MediaInfo, bool) {
+	list := s.scan(`SELECT `+resourceColumns+` FROM resources WHERE id = ?`, id)
+	if len(list) == 0 {
+		return shared.MediaInfo{}, false
+	}
+	return list[0], true
+}
+
+// annotate merges values into the OtherData of the resource of sign, false when it is not stored
+func (s *ResourceStore) annotate(sign string, values map[string]string) (shared.MediaInfo, bool) {
+	list := s.scan(`SELECT `+resourceColumns+` FROM resources WHERE url_sign = ?`, sign)
+	if len(list) == 0 {
+		return shared.MediaInfo{}, false
+	}
+	res := list[0]
+	if res.OtherData == nil {
+		res.OtherData = map[string]string{}
+	}
+	for key, value := range values {
+		res.OtherData[key] = value
+	}
+	data, err := json.Marshal(withoutCuration(res))
+	if err != nil {
+		return res, false
+	}
+	if _, err := s.db.Exec(`UPDATE resources SET data = ? WHERE url_sign = ?`, string(data), sign); err != nil {
+		globalLogger.Esg(err, "update resource failed")
+		return res, false
+	}
+	return res, true
+}
+
+// capturedAt is when a resource was captured, or captured again
+func (s *ResourceStore) capturedAt(id string) (time.Time, bool) {
+	if s.db == nil {
+		return time.Time{}, false
+	}
+	var createdAt int64
+	if s.db.QueryRow(`SELECT created_at FROM resources WHERE id = ?`, id).Scan(&createdAt) != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(createdAt), true
+}
+
+// grouped lists the resources of a group, oldest first
+func (s *ResourceStore) grouped(id string) []shared.MediaInfo {
+	return s.scan(`SELECT `+resourceColumns+` FROM resources WHERE group_id = ? ORDER BY created_at`, id)
+}
+
+// latest is the newest resource of a type
+func (s *ResourceStore) latest(classify string) (shared.MediaInfo, bool) {
+	list := s.scan(`SELECT `+resourceColumns+` FROM resources WHERE classify = ? ORDER BY created_at DESC LIMIT 1`, classify)
+	if len(list) == 0 {
+		return shared.MediaInfo{}, false
+	}
+	return list[0], true
+}
+
+// mark stars, tags or notes a resource and returns it as changed
+func (s *ResourceStore) mark(id string, m ResourceMark) (shared.MediaInfo, error) {
+	if s.db == nil {
+		return shared.MediaInfo{}, messageError(MsgResourceNotFound)
+	}
+	var set []string
+	var args []interface{}
+	if m.Starred != nil {
+		set = append(set, "starred = ?")
+		args = append(args, *m.Starred)
+	}
+	if m.Tags != nil {
+		set = append(set, "tags = ?")
+		args = append(args, joinTags(*m.Tags))
+	}
+	if m.Note != nil {
+		note := strings.TrimSpace(*m.Note)
+		if utf8.RuneCountInString(note) > noteLimit {
+			note = string([]rune(note)[:noteLimit])
+		}
+		set = append(set, "note = ?")
+		args = append(args, note)
+	}
+	if len(set) > 0 {
+		result, err := s.db.Exec("UPDATE resources SET "+strings.Join(set, ", ")+" WHERE id = ?", append(args, id)...)
+		if err != nil {
+			return shared.MediaInfo{}, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return shared.MediaInfo{}, messageError(MsgResourceNotFound)
+		}
+	}
+	res, ok := s.get(id)
+	if !ok {
+		return shared.MediaInfo{}, messageError(MsgResourceNotFound)
+	}
+	return res, nil
+}
+
+// tags lists the tags in use, the most used first
+func (s *ResourceStore) tags() []ResourceTag {
+	list := make([]ResourceTag, 0)
+	if s.db == nil {
+		return list
+	}
+	rows, err := s.db.Query(`SELECT tags FROM resources WHERE tags != ''`)
+	if err != nil {
+		return list
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var tags string
+		if rows.Scan(&tags) != nil {
+			continue
+		}
+		for _, tag := range strings.Split(strings.Trim(tags, ","), ",") {
+			counts[tag]++
+		}
+	}
+	for tag, count := range counts {
+		list = append(list, ResourceTag{Tag: tag, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Tag < list[j].Tag
+	})
+	return list
+}
+
+// normalizeTag trims a tag, commas separate tags in the column so they are dropped
+func normalizeTag(tag string) string {
+	return strings.TrimSpace(strings.ReplaceAll(tag, ",", " "))
+}
+
+// joinTags stores tags as ",a,b," so one is found with LIKE '%,a,%'
+func joinTags(tags []string) string {
+	var list []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		list = append(list, tag)
+	}
+	if len(list) == 0 {
+		return ""
+	}
+	return "," + strings.Join(list, ",") + ","
+}
+
+func (s *ResourceStore) count() int {
+	n := 0
+	if s.db != nil {
+		_ = s.db.QueryRow(`SELECT COUNT(*) FROM resources`).Scan(&n)
+	}
+	return n
+}
+
+// signs lists the url signs of everything stored, so a restart doesn't capture them again
+func (s *ResourceStore) signs() []string {
+	var list []string
+	if s.db == nil {
+		return list
+	}
+	rows, err := s.db.Query(`SELECT url_sign FROM resources`)
+	if err != nil {
+		return list
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sign string
+		if rows.Scan(&sign) == nil {
+			list = append(list, sign)
+		}
+	}
+	return list
+}
+
+func (s *ResourceStore) delete(sign string) {
+	if s.db != nil {
+		_, _ = s.db.Exec(`DELETE FROM resources WHERE url_sign = ?`, sign)
+	}
+}
+
+func (s *ResourceStore) clear() {
+	if s.db != nil {
+		_, _ = s.db.Exec(`DELETE FROM resources`)
+	}
+}
+
+func (s *ResourceStore) close() {
+	if s.db != nil {
+		_ = s.db.Close()
+	}
+}
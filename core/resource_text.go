@@ -0,0 +1,77 @@
+package core
+
+import (
+	"database/sql"
+)
+
+// text recognized in a resource, keyed by url sign so it survives the resource being captured again
+const (
+	TextOcr = "ocr"
+)
+
+// openText sets up the table of text found in resources and its full text index. Removing a
+// resource removes its text.
+func (s *ResourceStore) openText(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS resource_text (
+		url_sign TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		text TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (url_sign, kind)
+	);
+	CREATE VIRTUAL TABLE IF NOT EXISTS resource_text_fts USING fts5(
+		text, content='resource_text', content_rowid='rowid', tokenize='trigram'
+	);
+	CREATE TRIGGER IF NOT EXISTS resource_text_fts_insert AFTER INSERT ON resource_text BEGIN
+		INSERT INTO resource_text_fts(rowid, text) VALUES (new.rowid, new.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS resource_text_fts_delete AFTER DELETE ON resource_text BEGIN
+		INSERT INTO resource_text_fts(resource_text_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS resource_text_fts_update AFTER UPDATE OF text ON resource_text BEGIN
+		INSERT INTO resource_text_fts(resource_text_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+		INSERT INTO resource_text_fts(rowid, text) VALUES (new.rowid, new.text);
+	END;
+	CREATE TRIGGER IF NOT EXISTS resource_text_cleanup AFTER DELETE ON resources BEGIN
+		DELETE FROM resource_text WHERE url_sign = old.url_sign;
+	END;`)
+	return err
+}
+
+// setText keeps the text of kind found in the resource of sign, empty text removes it
+func (s *ResourceStore) setText(sign, kind, text string) {
+	if s.db == nil {
+		return
+	}
+	var err error
+	if text == "" {
+		_, err = s.db.Exec(`DELETE FROM resource_text WHERE url_sign = ? AND kind = ?`, sign, kind)
+	} else {
+		_, err = s.db.Exec(`INSERT INTO resource_text (url_sign, kind, text, updated_at) VALUES (?, ?, ?, strftime('%s', 'now'))
+			ON CONFLICT(url_sign, kind) DO UPDATE SET text = excluded.text, updated_at = excluded.updated_at`, sign, kind, text)
+	}
+	if err != nil {
+		globalLogger.Esg(err, "store resource text failed")
+	}
+}
+
+// texts is the text found in the resource of id, by kind
+func (s *ResourceStore) texts(id string) map[string]string {
+	texts := map[string]string{}
+	if s.db == nil {
+		return texts
+	}
+	rows, err := s.db.Query(`SELECT t.kind, t.text FROM resource_text t JOIN resources r ON r.url_sign = t.url_sign WHERE r.id = ?`, id)
+	if err != nil {
+		globalLogger.Esg(err, "read resource text failed")
+		return texts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, text string
+		if rows.Scan(&kind, &text) == nil {
+			texts[kind] = text
+		}
+	}
+	return texts
+}
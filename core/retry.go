@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ErrorKindRetryable = "retryable"
+	ErrorKindFatal     = "fatal"
+	ErrorKindCancelled = "cancelled"
+
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// StatusError is returned for an unexpected http status from the download server
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return localize(MsgStatusCode, e.Code)
+}
+
+func (e *StatusError) MessageCode() string {
+	return MsgStatusCode
+}
+
+// classifyDownloadError tells whether retrying can help: timeouts, resets and 5xx can,
+// an expired or forbidden url (401/403/404/410) and local failures can't
+func classifyDownloadError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.Canceled) || errorCode(err) == MsgDownloadCancelled || strings.Contains(err.Error(), "cancelled") {
+		return ErrorKindCancelled
+	}
+
+	var integrityErr *IntegrityError
+	if errors.As(err, &integrityErr) {
+		if globalConfig.VerifyRedownload {
+			return ErrorKindRetryable
+		}
+		return ErrorKindFatal
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.Code == http.StatusRequestTimeout, statusErr.Code == http.StatusTooManyRequests, statusErr.Code >= 500:
+			return ErrorKindRetryable
+		default:
+			return ErrorKindFatal
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorKindRetryable
+	}
+	return ErrorKindFatal
+}
+
+// retryDelay is exponential backoff with jitter, attempt starts at 0
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << uint(attempt)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
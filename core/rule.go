@@ -23,7 +23,7 @@ type RuleSet struct {
 func initRule() *RuleSet {
 	if ruleOnce == nil {
 		ruleOnce = &RuleSet{}
-		err := ruleOnce.Load(globalConfig.Rule)
+		err := ruleOnce.Load(withRulePacks("mitm", globalConfig.Rule))
 		if err != nil {
 			globalLogger.Esg(err, "init rule failed")
 			return nil
@@ -32,6 +32,18 @@ func initRule() *RuleSet {
 	return ruleOnce
 }
 
+func initQuicRule() *RuleSet {
+	if quicRuleOnce == nil {
+		quicRuleOnce = &RuleSet{}
+		err := quicRuleOnce.Load(withRulePacks("quic", globalConfig.QuicRule))
+		if err != nil {
+			globalLogger.Esg(err, "init quic rule failed")
+			return nil
+		}
+	}
+	return quicRuleOnce
+}
+
 func (r *RuleSet) Load(rs string) error {
 	reader := strings.NewReader(rs)
 	scanner := bufio.NewScanner(reader)
@@ -87,9 +99,14 @@ func (r *RuleSet) Load(rs string) error {
 }
 
 // shouldMitm: 根据当前规则集判断是否对 host 做 MITM
-// host 可能带端口（example.com:443），函数会只匹配 hostname 部分
 // 返回 true => MITM（解密），false => 透传
 func (r *RuleSet) shouldMitm(host string) bool {
+	return r.match(host)
+}
+
+// match: 判断 host 是否命中当前规则集
+// host 可能带端口（example.com:443），函数会只匹配 hostname 部分
+func (r *RuleSet) match(host string) bool {
 	h := host
 	if strings.HasPrefix(h, "[") {
 		if hostSplitIdx := strings.LastIndex(h, "]"); hostSplitIdx != -1 {
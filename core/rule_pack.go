@@ -0,0 +1,295 @@
+package core
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rulePackLimit bounds a downloaded pack, they are lists of domains
+const rulePackLimit = 4 * 1024 * 1024
+
+// RulePack carries platform rules maintained outside of releases. It is published as
+// {"payload": base64(pack json), "signature": base64(ed25519 signature of the payload bytes)}
+// and only accepted when the signature matches Config.RulePackKey.
+type RulePack struct {
+	Name      string   `json:"name"`
+	Version   int      `json:"version"`
+	Mitm      []string `json:"mitm"`      // lines as in Config.Rule
+	Quic      []string `json:"quic"`      // lines as in Config.QuicRule
+	Platforms []string `json:"platforms"` // lines as in Config.PlatformDomains
+	AdBlock   []string `json:"adblock"`   // lines as in Config.AdBlockRule
+}
+
+type rulePackEnvelope struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type RulePackStatus struct {
+	Url     string `json:"Url"`
+	Name    string `json:"Name"`
+	Version int    `json:"Version"`
+	Updated int64  `json:"Updated"` // unix seconds of the last successful fetch
+	Error   string `json:"Error"`
+}
+
+// RulePacks keeps the subscribed packs. Their rules come before the user's own,
+// so a line in the settings always has the last word.
+type RulePacks struct {
+	mu       sync.RWMutex
+	dir      string
+	packs    map[string]*RulePack
+	status   map[string]*RulePackStatus
+	updating sync.Mutex
+}
+
+func initRulePacks() *RulePacks {
+	if rulePacksOnce == nil {
+		rulePacksOnce = &RulePacks{
+			dir:    filepath.Join(appOnce.UserDir, "rulepacks"),
+			packs:  make(map[string]*RulePack),
+			status: make(map[string]*RulePackStatus),
+		}
+		// packs fetched before are verified again, the key may have changed since
+		for _, u := range rulePackUrls() {
+			data, err := os.ReadFile(rulePacksOnce.file(u))
+			if err != nil {
+				continue
+			}
+			pack, err := verifyRulePack(data)
+			if err != nil {
+				globalLogger.Esg(err, "load rule pack %s failed", u)
+				continue
+			}
+			rulePacksOnce.packs[u] = pack
+			rulePacksOnce.status[u] = &RulePackStatus{Url: u, Name: pack.Name, Version: pack.Version}
+		}
+		go func() {
+			time.Sleep(time.Minute)
+			for {
+				rulePacksOnce.updateDue()
+				time.Sleep(10 * time.Minute)
+			}
+		}()
+	}
+	return rulePacksOnce
+}
+
+func rulePackUrls() []string {
+	var urls []string
+	scanner := bufio.NewScanner(strings.NewReader(globalConfig.RulePackUrls))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls
+}
+
+func (p *RulePacks) file(u string) string {
+	return filepath.Join(p.dir, shared.Md5(u)+".json")
+}
+
+// withRulePacks puts the subscribed lines of one kind in front of the user's
+func withRulePacks(kind, user string) string {
+	if rulePacksOnce == nil {
+		return user
+	}
+	rulePacksOnce.mu.RLock()
+	defer rulePacksOnce.mu.RUnlock()
+	var lines []string
+	for _, u := range rulePackUrls() {
+		pack, ok := rulePacksOnce.packs[u]
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "mitm":
+			lines = append(lines, pack.Mitm...)
+		case "quic":
+			lines = append(lines, pack.Quic...)
+		case "platforms":
+			lines = append(lines, pack.Platforms...)
+		case "adblock":
+			lines = append(lines, pack.AdBlock...)
+		}
+	}
+	if len(lines) == 0 {
+		return user
+	}
+	return strings.Join(lines, "\n") + "\n" + user
+}
+
+func verifyRulePack(data []byte) (*RulePack, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(globalConfig.RulePackKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("rule pack key is missing or invalid")
+	}
+	var envelope rulePackEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return nil, errors.New("rule pack signature does not match")
+	}
+	var pack RulePack
+	if err := json.Unmarshal(payload, &pack); err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+// updateDue fetches the packs not refreshed within Config.RulePackHours
+func (p *RulePacks) updateDue() {
+	hours := globalConfig.RulePackHours
+	if hours <= 0 {
+		hours = 24
+	}
+	due := time.Now().Add(-time.Duration(hours) * time.Hour).Unix()
+	p.mu.RLock()
+	var urls []string
+	for _, u := range rulePackUrls() {
+		if status, ok := p.status[u]; !ok || status.Updated < due {
+			urls = append(urls, u)
+		}
+	}
+	p.mu.RUnlock()
+	if len(urls) > 0 {
+		p.update(urls)
+	}
+}
+
+// update fetches the given packs, all subscribed ones when urls is nil, and reloads the rules if any changed.
+// A pack that fails to fetch or verify keeps its previous version.
+func (p *RulePacks) update(urls []string) {
+	p.updating.Lock()
+	defer p.updating.Unlock()
+	if urls == nil {
+		urls = rulePackUrls()
+	}
+
+	changed := false
+	for _, u := range urls {
+		pack, data, err := fetchRulePack(u)
+
+		p.mu.Lock()
+		status, ok := p.status[u]
+		if !ok {
+			status = &RulePackStatus{Url: u}
+			p.status[u] = status
+		}
+		if err != nil {
+			status.Error = err.Error()
+			p.mu.Unlock()
+			globalLogger.Esg(err, "update rule pack %s failed", u)
+			continue
+		}
+		if old, ok := p.packs[u]; !ok || old.Version != pack.Version {
+			changed = true
+		}
+		p.packs[u] = pack
+		status.Name, status.Version, status.Error = pack.Name, pack.Version, ""
+		status.Updated = time.Now().Unix()
+		p.mu.Unlock()
+
+		if err := shared.CreateDirIfNotExist(p.dir); err == nil {
+			_ = os.WriteFile(p.file(u), data, 0644)
+		}
+	}
+	if changed {
+		p.apply()
+	}
+}
+
+// prune forgets packs that are no longer subscribed
+func (p *RulePacks) prune() {
+	subscribed := map[string]bool{}
+	for _, u := range rulePackUrls() {
+		subscribed[u] = true
+	}
+	p.mu.Lock()
+	removed := false
+	for u := range p.status {
+		if subscribed[u] {
+			continue
+		}
+		delete(p.status, u)
+		if _, ok := p.packs[u]; ok {
+			delete(p.packs, u)
+			removed = true
+		}
+		_ = os.Remove(p.file(u))
+	}
+	p.mu.Unlock()
+	if removed {
+		p.apply()
+	}
+}
+
+// apply reloads every rule set the packs feed into
+func (p *RulePacks) apply() {
+	if err := ruleOnce.Load(withRulePacks("mitm", globalConfig.Rule)); err != nil {
+		globalLogger.Esg(err, "set rule failed")
+	}
+	if err := quicRuleOnce.Load(withRulePacks("quic", globalConfig.QuicRule)); err != nil {
+		globalLogger.Esg(err, "set quic rule failed")
+	}
+	loadPluginPatterns(pluginList, withRulePacks("platforms", globalConfig.PlatformDomains))
+	adBlockOnce.Reload()
+}
+
+func (p *RulePacks) list() []RulePackStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var list []RulePackStatus
+	for _, u := range rulePackUrls() {
+		if status, ok := p.status[u]; ok {
+			list = append(list, *status)
+		} else {
+			list = append(list, RulePackStatus{Url: u})
+		}
+	}
+	return list
+}
+
+func fetchRulePack(u string) (*RulePack, []byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, &StatusError{Code: resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, rulePackLimit))
+	if err != nil {
+		return nil, nil, err
+	}
+	pack, err := verifyRulePack(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pack, data, nil
+}
@@ -0,0 +1,311 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	s3MultipartThreshold = 64 << 20
+	s3PartSize           = 16 << 20
+	s3PartParallel       = 4
+	s3PartRetries        = 3
+)
+
+// S3Destination uploads to S3 compatible storage (AWS S3, OSS, COS, MinIO) with SigV4
+type S3Destination struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	prefix    string
+	client    *http.Client
+}
+
+func newS3Destination() (*S3Destination, error) {
+	endpoint, err := url.Parse(globalConfig.S3Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", globalConfig.S3Endpoint)
+	}
+	region := globalConfig.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Destination{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    globalConfig.S3Bucket,
+		accessKey: globalConfig.S3AccessKey,
+		secretKey: globalConfig.S3SecretKey,
+		pathStyle: globalConfig.S3PathStyle,
+		prefix:    globalConfig.S3Prefix,
+		client:    &http.Client{},
+	}, nil
+}
+
+func (d *S3Destination) Name() string {
+	return "s3"
+}
+
+// objectKey renders the prefix template ({platform}, {classify}, {date}, {year}, {month}, {day}) in front of the path
+func (d *S3Destination) objectKey(mediaInfo shared.MediaInfo, remotePath string) string {
+	now := time.Now()
+	prefix := strings.NewReplacer(
+		"{platform}", sanitizeFileName(mediaInfo.Domain),
+		"{classify}", sanitizeFileName(mediaInfo.Classify),
+		"{date}", now.Format("20060102"),
+		"{year}", now.Format("2006"),
+		"{month}", now.Format("01"),
+		"{day}", now.Format("02"),
+	).Replace(d.prefix)
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return remotePath
+	}
+	return prefix + "/" + remotePath
+}
+
+func (d *S3Destination) Upload(ctx context.Context, mediaInfo shared.MediaInfo, remotePath string) error {
+	key := d.objectKey(mediaInfo, remotePath)
+	file, err := os.Open(mediaInfo.SavePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() < s3MultipartThreshold {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		resp, err := d.request(ctx, http.MethodPut, key, nil, data)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	} else {
+		percent := int64(-1)
+		progress := func(done, total int64) {
+			// parts finish on several goroutines, only a higher figure is reported
+			p := done * 100 / total
+			for previous := atomic.LoadInt64(&percent); p > previous; previous = atomic.LoadInt64(&percent) {
+				if atomic.CompareAndSwapInt64(&percent, previous, p) {
+					uploadEventsEmit(mediaInfo, d.Name(), shared.DownloadStatusRunning, fmt.Sprintf("uploading %d%%", p))
+					return
+				}
+			}
+		}
+		if err := d.multipartUpload(ctx, key, file, info.Size(), progress); err != nil {
+			return err
+		}
+	}
+
+	resp, err := d.request(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return fmt.Errorf("s3 verify failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.ContentLength != info.Size() {
+		return fmt.Errorf("s3 verify failed: remote size %d, local %d", resp.ContentLength, info.Size())
+	}
+	return nil
+}
+
+func (d *S3Destination) multipartUpload(ctx context.Context, key string, file *os.File, size int64, progress func(done, total int64)) error {
+	resp, err := d.request(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiate struct {
+		UploadId string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiate)
+	resp.Body.Close()
+	if err != nil || initiate.UploadId == "" {
+		return fmt.Errorf("s3 create multipart upload failed: %v", err)
+	}
+
+	uploader := &MultipartUploader{
+		PartSize: s3PartSize,
+		Parallel: s3PartParallel,
+		Retries:  s3PartRetries,
+		Put: func(ctx context.Context, number int, data []byte) (string, error) {
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiate.UploadId}}
+			resp, err := d.request(ctx, http.MethodPut, key, query, data)
+			if err != nil {
+				return "", err
+			}
+			resp.Body.Close()
+			return resp.Header.Get("ETag"), nil
+		},
+		Progress: progress,
+	}
+	uploaded, err := uploader.Upload(ctx, file, size)
+	if err != nil {
+		d.abort(key, initiate.UploadId)
+		return fmt.Errorf("s3 upload %w", err)
+	}
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	parts := make([]completedPart, 0, len(uploaded))
+	for _, part := range uploaded {
+		parts = append(parts, completedPart{PartNumber: part.Number, ETag: part.ETag})
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err = d.request(ctx, http.MethodPost, key, url.Values{"uploadId": {initiate.UploadId}}, body)
+	if err != nil {
+		d.abort(key, initiate.UploadId)
+		return fmt.Errorf("s3 complete multipart upload failed: %w", err)
+	}
+	// errors can come back in a 200 body
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if bytes.Contains(data, []byte("<Error>")) {
+		d.abort(key, initiate.UploadId)
+		return fmt.Errorf("s3 complete multipart upload failed: %s", data)
+	}
+	return nil
+}
+
+func (d *S3Destination) abort(key, uploadId string) {
+	resp, err := d.request(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {uploadId}}, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+// request sends a SigV4 signed request, non 2xx answers are returned as errors
+func (d *S3Destination) request(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	host := d.endpoint.Host
+	canonicalPath := "/" + s3EscapePath(key)
+	if d.pathStyle {
+		canonicalPath = "/" + d.bucket + canonicalPath
+	} else {
+		host = d.bucket + "." + host
+	}
+	rawQuery := s3CanonicalQuery(query)
+	target := d.endpoint.Scheme + "://" + host + canonicalPath
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = int64(len(body))
+	d.sign(request, host, canonicalPath, rawQuery, body)
+
+	resp, err := d.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, key, resp.Status, data)
+	}
+	return resp, nil
+}
+
+func (d *S3Destination) sign(request *http.Request, host, canonicalPath, rawQuery string, body []byte) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	request.Host = host
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalPath,
+		rawQuery,
+		"host:" + host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + d.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSha256([]byte("AWS4"+d.secretKey), day)
+	signingKey = hmacSha256(signingKey, d.region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.accessKey, scope, signedHeaders, signature))
+}
+
+func s3EscapePath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// s3Escape is RFC 3986 encoding as SigV4 expects, unlike url.QueryEscape spaces become %20
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, s3Escape(key)+"="+s3Escape(query.Get(key)))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
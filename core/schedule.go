@@ -0,0 +1,197 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduleWindow is a daily time window downloads may run in, Limit is the speed in KB/s while it is active
+type ScheduleWindow struct {
+	Start int    `json:"Start"` // minutes since midnight
+	End   int    `json:"End"`
+	Limit int64  `json:"Limit"` // -1 keeps the bandwidth profile or Config.SpeedLimit, 0 is unlimited
+	Raw   string `json:"Raw"`
+}
+
+func (w ScheduleWindow) contains(minute int) bool {
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	// crosses midnight, e.g. 22:00-06:00
+	return minute >= w.Start || minute < w.End
+}
+
+// Scheduler holds the queue outside Config.DownloadSchedule and applies the speed of the active window,
+// or else of the active Config.BandwidthProfiles line
+type Scheduler struct {
+	mu      sync.Mutex
+	active  *ScheduleWindow
+	profile *ScheduleWindow
+	inside  bool
+}
+
+func initSchedule() *Scheduler {
+	if scheduleOnce == nil {
+		// the queue starts released, so a start outside every window counts as leaving one and holds it
+		scheduleOnce = &Scheduler{inside: true}
+		scheduleOnce.apply()
+		go func() {
+			for range time.Tick(30 * time.Second) {
+				scheduleOnce.apply()
+			}
+		}()
+	}
+	return scheduleOnce
+}
+
+// parseSchedule reads one window per line: "02:00-08:00" or "02:00-08:00 512" with a limit,
+// see parseRate for the accepted limits
+func parseSchedule(text string) []ScheduleWindow {
+	var windows []ScheduleWindow
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			globalLogger.Warn().Msgf("invalid schedule window: %s", line)
+			continue
+		}
+		window := ScheduleWindow{Limit: -1, Raw: line}
+		var err error
+		if window.Start, err = parseClock(start); err == nil {
+			window.End, err = parseClock(end)
+		}
+		if err == nil && len(fields) > 1 {
+			window.Limit, err = parseRate(strings.Join(fields[1:], ""))
+		}
+		if err != nil {
+			globalLogger.Warn().Msgf("invalid schedule window %s: %v", line, err)
+			continue
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// parseRate reads a speed in KB/s: "512", "512KB", "1.5MB/s", or "unlimited" for 0
+func parseRate(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.ToUpper(value), "/S")
+	if value == "UNLIMITED" {
+		return 0, nil
+	}
+	unit := 1.0
+	if strings.HasSuffix(value, "MB") || strings.HasSuffix(value, "M") {
+		unit = 1024
+	}
+	number, err := strconv.ParseFloat(strings.TrimRight(value, "KMB"), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid speed %q", value)
+	}
+	return int64(number * unit), nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// apply re-evaluates the schedule, called periodically and when the config changes
+func (s *Scheduler) apply() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	minute := now.Hour()*60 + now.Minute()
+	rate, profileChanged := s.profileRate(minute)
+	if !globalConfig.ScheduleEnable {
+		s.active, s.inside = nil, true
+		speedLimitOnce.SetRateIfChanged(rate)
+		queueOnce.setHeld(false)
+		if profileChanged {
+			httpServerOnce.send("scheduleChanged", s.snapshot())
+		}
+		return
+	}
+
+	var active *ScheduleWindow
+	for _, window := range parseSchedule(globalConfig.DownloadSchedule) {
+		if window.contains(minute) {
+			active = &window
+			break
+		}
+	}
+	s.active = active
+	if active != nil && active.Limit >= 0 {
+		rate = active.Limit * 1024
+	}
+	speedLimitOnce.SetRateIfChanged(rate)
+
+	inside := active != nil
+	if inside == s.inside {
+		if profileChanged {
+			httpServerOnce.send("scheduleChanged", s.snapshot())
+		}
+		return
+	}
+	s.inside = inside
+	if inside {
+		globalLogger.Info().Msgf("download window %s started", active.Raw)
+		queueOnce.setHeld(false)
+	} else {
+		globalLogger.Info().Msg("download window ended, holding the queue")
+		queueOnce.setHeld(true)
+		for _, id := range queueOnce.runningIds() {
+			queueOnce.stop(id, stopRequeue)
+		}
+	}
+	httpServerOnce.send("scheduleChanged", s.snapshot())
+}
+
+// profileRate is the global speed in bytes per second from the first bandwidth profile containing minute,
+// Config.SpeedLimit outside all of them. Callers hold mu.
+func (s *Scheduler) profileRate(minute int) (int64, bool) {
+	var profile *ScheduleWindow
+	for _, window := range parseSchedule(globalConfig.BandwidthProfiles) {
+		if window.Limit >= 0 && window.contains(minute) {
+			profile = &window
+			break
+		}
+	}
+	previous := s.profile
+	s.profile = profile
+	changed := (previous == nil) != (profile == nil) || previous != nil && previous.Raw != profile.Raw
+	if changed && profile != nil {
+		globalLogger.Info().Msgf("bandwidth profile %s applied", profile.Raw)
+	}
+	if profile == nil {
+		return int64(globalConfig.SpeedLimit) * 1024, changed
+	}
+	return profile.Limit * 1024, changed
+}
+
+func (s *Scheduler) status() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+func (s *Scheduler) snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"Enabled": globalConfig.ScheduleEnable,
+		"Inside":  s.inside,
+		"Window":  s.active,
+		"Profile": s.profile,
+		"Rate":    speedLimitOnce.Rate(),
+	}
+}
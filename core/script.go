@@ -0,0 +1,295 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"res-downloader/core/shared"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/elazarl/goproxy"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const (
+	// scriptBodyLimit is the largest text body handed to onResponse
+	scriptBodyLimit = 2 * 1024 * 1024
+	// scriptTimeout interrupts a hook that runs longer, the proxy waits on every call
+	scriptTimeout = 2 * time.Second
+)
+
+// ScriptEngine runs user JS hooks on intercepted traffic. A script may define:
+//
+//	onRequest(req)   -> {url, headers, block}   rewrite or block a request
+//	onResponse(res)  -> void                    inspect API bodies, call emit() to add resources
+//	onResource(item) -> false | {fields...}     drop or amend a captured resource (e.g. tags)
+//
+// and may call log(...) and emit({url, classify, suffix, description, coverUrl, size, headers}).
+type ScriptEngine struct {
+	mu         sync.Mutex
+	vm         *goja.Runtime
+	onRequest  goja.Callable
+	onResponse goja.Callable
+	onResource goja.Callable
+}
+
+func initScript() *ScriptEngine {
+	if scriptOnce == nil {
+		scriptOnce = &ScriptEngine{}
+		if err := scriptOnce.Load(globalConfig.Script); err != nil {
+			globalLogger.Esg(err, "init script failed")
+		}
+	}
+	return scriptOnce
+}
+
+func (s *ScriptEngine) Load(source string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.vm, s.onRequest, s.onResponse, s.onResource = nil, nil, nil, nil
+	if strings.TrimSpace(source) == "" {
+		return nil
+	}
+
+	vm := goja.New()
+	_ = vm.Set("log", func(args ...interface{}) {
+		globalLogger.Info().Msgf("[script] %s", fmt.Sprint(args...))
+	})
+	_ = vm.Set("emit", func(item map[string]interface{}) {
+		s.emit(item)
+	})
+
+	timer := time.AfterFunc(scriptTimeout, func() {
+		vm.Interrupt("script timeout")
+	})
+	_, err := vm.RunString(source)
+	timer.Stop()
+	if err != nil {
+		return err
+	}
+
+	s.onRequest, _ = goja.AssertFunction(vm.Get("onRequest"))
+	s.onResponse, _ = goja.AssertFunction(vm.Get("onResponse"))
+	s.onResource, _ = goja.AssertFunction(vm.Get("onResource"))
+	s.vm = vm
+	return nil
+}
+
+// call runs a hook, callers hold mu. A hook running past scriptTimeout is interrupted and counts
+// as failed, so a loop in a script can't hang the proxy.
+func (s *ScriptEngine) call(fn goja.Callable, arg interface{}) (map[string]interface{}, bool) {
+	vm := s.vm
+	timer := time.AfterFunc(scriptTimeout, func() {
+		vm.Interrupt("script timeout")
+	})
+	value, err := fn(goja.Undefined(), vm.ToValue(arg))
+	timer.Stop()
+	vm.ClearInterrupt()
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			globalLogger.Esg(err, fmt.Sprintf("script hook failed: interrupted after %s", scriptTimeout))
+		} else {
+			globalLogger.Esg(err, "script hook failed")
+		}
+		return nil, true
+	}
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return nil, true
+	}
+	if b, ok := value.Export().(bool); ok {
+		return nil, b
+	}
+	result, _ := value.Export().(map[string]interface{})
+	return result, true
+}
+
+func (s *ScriptEngine) request(r *http.Request) (*http.Request, *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.onRequest == nil {
+		return r, nil
+	}
+
+	result, _ := s.call(s.onRequest, map[string]interface{}{
+		"url":     r.URL.String(),
+		"method":  r.Method,
+		"host":    r.Host,
+		"headers": flattenHeader(r.Header),
+	})
+	if result == nil {
+		return r, nil
+	}
+
+	if block, _ := result["block"].(bool); block {
+		return r, goproxy.NewResponse(r, goproxy.ContentTypeText, http.StatusForbidden, "blocked by script")
+	}
+	if rawUrl, ok := result["url"].(string); ok && rawUrl != "" && rawUrl != r.URL.String() {
+		if u, err := url.Parse(rawUrl); err == nil {
+			r.URL = u
+			r.Host = u.Host
+		}
+	}
+	if headers, ok := result["headers"].(map[string]interface{}); ok {
+		for k, v := range headers {
+			r.Header.Set(k, fmt.Sprint(v))
+		}
+	}
+	return r, nil
+}
+
+func (s *ScriptEngine) response(resp *http.Response) {
+	s.mu.Lock()
+	hooked := s.onResponse != nil
+	s.mu.Unlock()
+	if !hooked || streamingResponse(resp) {
+		return
+	}
+
+	// the body is read before the lock is taken, a slow one doesn't hold up other responses
+	body := ""
+	contentType := resp.Header.Get("Content-Type")
+	if isTextContent(contentType) && resp.ContentLength <= scriptBodyLimit && resp.Body != nil {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, scriptBodyLimit+1))
+		if err == nil && len(data) <= scriptBodyLimit {
+			body = string(data)
+		}
+		// a chunked body longer than the limit keeps streaming after what was read
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.onResponse == nil {
+		return
+	}
+	s.call(s.onResponse, map[string]interface{}{
+		"url":         resp.Request.URL.String(),
+		"status":      resp.StatusCode,
+		"contentType": contentType,
+		"headers":     flattenHeader(resp.Header),
+		"body":        body,
+	})
+}
+
+// resource lets onResource amend a captured resource, returns false when the script drops it
+func (s *ScriptEngine) resource(res shared.MediaInfo) (shared.MediaInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.onResource == nil {
+		return res, true
+	}
+
+	var item map[string]interface{}
+	raw, _ := json.Marshal(res)
+	_ = json.Unmarshal(raw, &item)
+
+	result, keep := s.call(s.onResource, item)
+	if !keep {
+		return res, false
+	}
+	if result != nil {
+		for k, v := range result {
+			item[k] = v
+		}
+		raw, _ = json.Marshal(item)
+		var amended shared.MediaInfo
+		if err := json.Unmarshal(raw, &amended); err == nil {
+			res = amended
+		}
+	}
+	return res, true
+}
+
+func (s *ScriptEngine) emit(item map[string]interface{}) {
+	rawUrl, _ := item["url"].(string)
+	if rawUrl == "" {
+		return
+	}
+	urlSign := shared.Md5(rawUrl)
+	if resourceOnce.mediaIsMarked(urlSign) {
+		return
+	}
+	id, err := gonanoid.New()
+	if err != nil {
+		id = urlSign
+	}
+
+	res := shared.MediaInfo{
+		Id:        id,
+		Url:       rawUrl,
+		UrlSign:   urlSign,
+		Domain:    shared.GetTopLevelDomain(rawUrl),
+		Classify:  "video",
+		Suffix:    ".mp4",
+		Status:    shared.DownloadStatusReady,
+		OtherData: map[string]string{},
+	}
+	if v, ok := item["classify"].(string); ok && v != "" {
+		res.Classify = v
+	}
+	if v, ok := item["suffix"].(string); ok && v != "" {
+		res.Suffix = v
+	}
+	if v, ok := item["description"].(string); ok {
+		res.Description = v
+	}
+	if v, ok := item["coverUrl"].(string); ok {
+		res.CoverUrl = v
+	}
+	switch v := item["size"].(type) {
+	case int64:
+		res.Size = float64(v)
+	case float64:
+		res.Size = v
+	}
+	if headers, ok := item["headers"].(map[string]interface{}); ok {
+		h := map[string][]string{}
+		for k, v := range headers {
+			h[k] = []string{fmt.Sprint(v)}
+		}
+		if data, err := json.Marshal(h); err == nil {
+			res.OtherData["headers"] = string(data)
+		}
+	}
+
+	resourceOnce.markMedia(urlSign)
+	go resourceOnce.capture(res)
+}
+
+func flattenHeader(header http.Header) map[string]string {
+	m := make(map[string]string, len(header))
+	for k := range header {
+		m[k] = header.Get(k)
+	}
+	return m
+}
+
+// streamingResponse is a body that never ends on its own, server-sent events or an upgraded
+// connection, reading it to the end would stall the page
+func streamingResponse(resp *http.Response) bool {
+	if upgradedResponse(resp) {
+		return true
+	}
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	return strings.HasPrefix(contentType, "text/event-stream")
+}
+
+func isTextContent(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "json") ||
+		strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "xml")
+}
@@ -0,0 +1,369 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SegmentGroup collects the many small requests a player makes for one piece of
+// media (numbered .ts/.m4s chunks or ?range= slices) so they are listed and
+// downloaded as a single resource.
+type SegmentGroup struct {
+	Key      string
+	First    shared.MediaInfo
+	Ranged   bool // segments are keyed by their byte offset and may overlap
+	Segments map[int64]string
+}
+
+var (
+	segmentNumberRegex = regexp.MustCompile(`^(.*?)(\d+)(\.[A-Za-z0-9]+)?$`)
+	segmentSuffixes    = map[string]bool{".ts": true, ".m4s": true}
+	segmentClassifies  = map[string]bool{"video": true, "audio": true, "live": true, "stream": true}
+	segmentRangeParams = []string{"range", "bytestart", "byteend", "byterange"}
+)
+
+// segmentKey normalizes a segment url into its group key and position inside the group,
+// ranged reports that the position is a byte offset taken from a ?range= style parameter
+func segmentKey(res shared.MediaInfo) (key string, index int64, ranged bool, ok bool) {
+	if !segmentClassifies[res.Classify] {
+		return "", 0, false, false
+	}
+	u, err := url.Parse(res.Url)
+	if err != nil {
+		return "", 0, false, false
+	}
+
+	query := u.Query()
+	for _, param := range segmentRangeParams {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		start, err := strconv.ParseInt(strings.SplitN(value, "-", 2)[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		for _, p := range segmentRangeParams {
+			query.Del(p)
+		}
+		u.RawQuery = query.Encode()
+		return u.String(), start, true, true
+	}
+
+	dir, base := path.Split(u.Path)
+	matches := segmentNumberRegex.FindStringSubmatch(base)
+	if matches == nil || !segmentSuffixes[strings.ToLower(matches[3])] {
+		return "", 0, false, false
+	}
+	index, err = strconv.ParseInt(matches[2], 10, 64)
+	if err != nil {
+		return "", 0, false, false
+	}
+	u.Path = dir + matches[1] + "{n}" + matches[3]
+	return u.String(), index, false, true
+}
+
+// addSegment records a segment, returns true when it opens a new group and should be listed
+func (r *Resource) addSegment(key string, index int64, ranged bool, res shared.MediaInfo) bool {
+	r.segmentMux.Lock()
+	defer r.segmentMux.Unlock()
+
+	if group, ok := r.segmentGroups[key]; ok {
+		group.Segments[index] = res.Url
+		return false
+	}
+	r.segmentGroups[key] = &SegmentGroup{
+		Key:      key,
+		First:    res,
+		Ranged:   ranged,
+		Segments: map[int64]string{index: res.Url},
+	}
+	return true
+}
+
+// segmentUrls returns the ordered segment urls of a group, nil when it never grew past one request.
+// offsets holds the byte offset of each url for ranged groups and is nil otherwise.
+func (r *Resource) segmentUrls(key string) (urls []string, offsets []int64) {
+	r.segmentMux.Lock()
+	defer r.segmentMux.Unlock()
+
+	group, ok := r.segmentGroups[key]
+	if !ok || len(group.Segments) < 2 {
+		return nil, nil
+	}
+	indexes := make([]int64, 0, len(group.Segments))
+	for index := range group.Segments {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	urls = make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		urls = append(urls, group.Segments[index])
+	}
+	if group.Ranged {
+		offsets = indexes
+	}
+	return urls, offsets
+}
+
+func (r *Resource) clearSegments() {
+	r.segmentMux.Lock()
+	r.segmentGroups = make(map[string]*SegmentGroup)
+	r.segmentMux.Unlock()
+}
+
+// SegmentDownloader fetches segments with a bounded worker pool and appends them into one file in order.
+// With Playlist set the segment urls (and AES-128 keys) are read from that m3u8 first.
+type SegmentDownloader struct {
+	Urls             []string
+	Offsets          []int64 // byte offset of each url when they are ?range= slices, overlaps are trimmed
+	Playlist         string
+	Variant          string // stream of a master playlist picked by the user
+	AudioOnly        bool   // a master playlist is followed to its separate audio rendition when it has one
+	FileName         string
+	Headers          map[string]string
+	Overrides        map[string]string
+	ProxyMode        string
+	progressCallback ProgressCallback
+	Finalize         FinalizeFunc
+	crypts           []*segmentCrypt
+	audioRendition   bool // the audio playlist was downloaded, there is no video to strip
+	limiter          *RateLimiter
+	ctx              context.Context
+	cancelFunc       context.CancelFunc
+}
+
+type segmentResult struct {
+	index int
+	data  []byte
+	err   error
+}
+
+func NewSegmentDownloader(urls []string, filename string, headers map[string]string) *SegmentDownloader {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	return &SegmentDownloader{
+		Urls:       urls,
+		FileName:   filename,
+		Headers:    headers,
+		limiter:    NewRateLimiter(0),
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+	}
+}
+
+func NewPlaylistDownloader(playlist, filename string, headers map[string]string) *SegmentDownloader {
+	sd := NewSegmentDownloader(nil, filename, headers)
+	sd.Playlist = playlist
+	return sd
+}
+
+func (sd *SegmentDownloader) SetSpeedLimit(rate int64) {
+	sd.limiter.SetRate(rate)
+}
+
+func (sd *SegmentDownloader) Start() error {
+	if err := os.MkdirAll(filepath.Dir(sd.FileName), os.ModePerm); err != nil {
+		return fmt.Errorf("create directory failed: %w", err)
+	}
+	if _, ok := sd.Headers["User-Agent"]; !ok {
+		sd.Headers["User-Agent"] = defaultUserAgent()
+	}
+	fd := &FileDownloader{Headers: sd.Headers, Overrides: sd.Overrides}
+	proxyTarget := sd.Playlist
+	if proxyTarget == "" && len(sd.Urls) > 0 {
+		proxyTarget = sd.Urls[0]
+	}
+	proxyURL, err := downloadProxy(sd.ProxyMode, proxyTarget)
+	if err != nil {
+		return err
+	}
+	fd.ProxyUrl = proxyURL
+	client := fd.buildClient()
+
+	if sd.Playlist != "" {
+		urls, crypts, err := sd.loadPlaylist(client, fd, sd.Playlist)
+		if err != nil {
+			return err
+		}
+		sd.Urls, sd.crypts = urls, crypts
+	}
+	if len(sd.Urls) == 0 {
+		return fmt.Errorf("no segments to download")
+	}
+
+	sd.FileName = targetFileName(sd.FileName)
+	partName := sd.FileName + partSuffix
+	file, err := os.Create(partName)
+	if err != nil {
+		return fmt.Errorf("file open failed: %w", err)
+	}
+	// segments are not resumable, a failed or cancelled part is dropped
+	if err = sd.assemble(file, client, fd); err != nil {
+		file.Close()
+		_ = os.Remove(partName)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		_ = os.Remove(partName)
+		return err
+	}
+	return commitPart(partName, sd.FileName, sd.Finalize)
+}
+
+// assemble fetches all segments in parallel and writes them to file in playlist order
+func (sd *SegmentDownloader) assemble(file *os.File, client *http.Client, fd *FileDownloader) error {
+
+	workers := min(max(globalConfig.TaskNumber, 1), 16, len(sd.Urls))
+	ctx, cancel := context.WithCancel(sd.ctx)
+	defer cancel()
+
+	// window keeps at most 2*workers segments in memory while an earlier one is still loading
+	window := make(chan struct{}, workers*2)
+	jobs := make(chan int)
+	results := make(chan segmentResult, workers)
+	go func() {
+		defer close(jobs)
+		for i := range sd.Urls {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				data, err := sd.fetchWithRetry(ctx, client, fd, i)
+				select {
+				case results <- segmentResult{index: i, data: data, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	done := map[int][]byte{}
+	var end int64
+	if sd.Offsets != nil {
+		end = sd.Offsets[0]
+	}
+	for next := 0; next < len(sd.Urls); {
+		var result segmentResult
+		select {
+		case result = <-results:
+		case <-sd.ctx.Done():
+			return messageError(MsgDownloadCancelled)
+		}
+		if result.err != nil {
+			if sd.ctx.Err() != nil {
+				return messageError(MsgDownloadCancelled)
+			}
+			return fmt.Errorf("segment %d failed: %w", result.index, result.err)
+		}
+		done[result.index] = result.data
+		for data, ok := done[next]; ok; data, ok = done[next] {
+			if sd.Offsets != nil {
+				data = trimOverlap(data, sd.Offsets[next], &end)
+			}
+			if _, err := file.Write(data); err != nil {
+				return err
+			}
+			delete(done, next)
+			<-window
+			next++
+			if sd.progressCallback != nil {
+				sd.progressCallback(float64(next), float64(len(sd.Urls)), next-1, 100)
+			}
+		}
+	}
+	return nil
+}
+
+// trimOverlap drops the head of a ranged slice that was already written, end is the offset
+// just past the written bytes and is moved forward
+func trimOverlap(data []byte, offset int64, end *int64) []byte {
+	if skip := *end - offset; skip > 0 {
+		if skip >= int64(len(data)) {
+			return nil
+		}
+		data = data[skip:]
+		offset += skip
+	}
+	*end = max(*end, offset+int64(len(data)))
+	return data
+}
+
+// fetchWithRetry loads one segment, retrying it alone when the error is retryable
+func (sd *SegmentDownloader) fetchWithRetry(ctx context.Context, client *http.Client, fd *FileDownloader, index int) ([]byte, error) {
+	var lastErr error
+	for retries := 0; retries < MaxRetries; retries++ {
+		data, err := sd.fetch(ctx, client, fd, sd.Urls[index])
+		if err == nil {
+			if sd.crypts != nil && sd.crypts[index] != nil {
+				return decryptSegment(data, sd.crypts[index])
+			}
+			return data, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || classifyDownloadError(err) != ErrorKindRetryable {
+			break
+		}
+		select {
+		case <-time.After(retryDelay(retries)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, lastErr
+}
+
+func (sd *SegmentDownloader) fetch(ctx context.Context, client *http.Client, fd *FileDownloader, segmentUrl string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", segmentUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	fd.setHeaders(request)
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	return io.ReadAll(newLimitedReader(ctx, resp.Body, sd.limiter, speedLimitOnce))
+}
+
+// Pause stops the download, segments are fetched again on resume so nothing is kept
+func (sd *SegmentDownloader) Pause() {
+	sd.Cancel()
+}
+
+func (sd *SegmentDownloader) Cancel() {
+	if sd.cancelFunc != nil {
+		sd.cancelFunc()
+	}
+	if sd.FileName != "" {
+		_ = os.Remove(sd.FileName + partSuffix)
+	}
+}
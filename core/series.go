@@ -0,0 +1,464 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// seriesPageLimit bounds how many pages of a catalog are replayed
+	seriesPageLimit = 20
+	seriesBodyLimit = 4 << 20
+	// seriesResolvers is how many episode pages are resolved at once
+	seriesResolvers = 4
+)
+
+// seriesHints in an api path or list key tell a catalog from an ordinary feed
+var seriesHints = []string{"episode", "playlist", "mix", "collection", "season", "series", "chapter", "lesson", "course", "catalog", "album", "合集", "目录"}
+
+var (
+	seriesUrlKeys   = []string{"play_url", "playUrl", "video_url", "videoUrl", "play_addr", "playAddr", "url_list", "src", "url", "share_url", "shareUrl", "page_url", "link", "href"}
+	seriesTitleKeys = []string{"title", "name", "episode_title", "display_title", "share_title", "desc", "caption"}
+	seriesCoverKeys = []string{"cover", "cover_url", "coverUrl", "thumbnail", "pic", "image", "poster"}
+	seriesNameKeys  = []string{"mix_name", "collection_name", "series_name", "season_title", "course_name", "album_name", "title", "name"}
+	seriesMoreKeys  = []string{"has_more", "hasMore", "has_next", "hasNext"}
+	seriesCursors   = []string{"cursor", "next_cursor", "max_cursor", "pcursor", "next_offset", "offset"}
+	seriesPageKeys  = []string{"page", "pn", "page_num", "pageNum", "page_no", "pageNo", "p"}
+	seriesMediaExts = map[string]string{".mp4": "video", ".m3u8": "m3u8", ".flv": "video", ".mov": "video", ".webm": "video", ".mp3": "audio", ".m4a": "audio"}
+)
+
+// Episode is one entry of a playlist, course catalog or collection
+type Episode struct {
+	Index int    `json:"Index"` // 1 based, in catalog order
+	Title string `json:"Title"`
+	Url   string `json:"Url"` // the media, or the page it plays on
+	Cover string `json:"Cover"`
+}
+
+// Series is what a catalog api response lists, with the later pages replayed
+type Series struct {
+	PreviewId string    `json:"PreviewId"`
+	Title     string    `json:"Title"`
+	Source    string    `json:"Source"` // the api url the catalog came from
+	Pages     int       `json:"Pages"`
+	Total     int       `json:"Total"`
+	Episodes  []Episode `json:"Episodes,omitempty"`
+}
+
+// detectSeries announces a captured api response that looks like a catalog as "seriesDetected",
+// once per api path. Only the first page is looked at, the episodes are listed on request.
+func (p *PreviewStore) detectSeries(item ResponsePreview) {
+	if !strings.Contains(item.ContentType, "json") {
+		return
+	}
+	u, err := url.Parse(item.Url)
+	if err != nil {
+		return
+	}
+	key, episodes, title := seriesEpisodes(parsePartialJson(item.Body))
+	if len(episodes) < 2 || !(seriesHinted(key) || seriesHinted(u.Path)) {
+		return
+	}
+	p.mu.Lock()
+	if p.announced == nil {
+		p.announced = map[string]bool{}
+	}
+	seen := p.announced[u.Host+u.Path]
+	p.announced[u.Host+u.Path] = true
+	p.mu.Unlock()
+	if seen {
+		return
+	}
+	if title == "" {
+		title = u.Host
+	}
+	httpServerOnce.send("seriesDetected", Series{PreviewId: item.Id, Title: title, Source: item.Url, Pages: 1, Total: len(episodes)})
+}
+
+func seriesHinted(s string) bool {
+	s = strings.ToLower(s)
+	for _, hint := range seriesHints {
+		if strings.Contains(s, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSeries lists every episode of the catalog a captured api response belongs to, the
+// following pages are fetched by replaying the request with the next cursor or page number
+func resolveSeries(previewId string) (Series, error) {
+	item, ok := previewOnce.get(previewId)
+	if !ok {
+		return Series{}, messageError(MsgSeriesNotFound)
+	}
+	series := Series{PreviewId: previewId, Source: item.Url}
+	seen := map[string]bool{}
+	add := func(episodes []Episode) int {
+		added := 0
+		for _, episode := range episodes {
+			if seen[episode.Url] {
+				continue
+			}
+			seen[episode.Url] = true
+			episode.Index = len(series.Episodes) + 1
+			series.Episodes = append(series.Episodes, episode)
+			added++
+		}
+		return added
+	}
+
+	root := parsePartialJson(item.Body)
+	_, episodes, title := seriesEpisodes(root)
+	if len(episodes) == 0 {
+		return Series{}, messageError(MsgSeriesNotFound)
+	}
+	series.Title = title
+	add(episodes)
+	series.Pages = 1
+
+	pageUrl := item.Url
+	for series.Pages < seriesPageLimit && item.method == http.MethodGet {
+		next := seriesNextPage(pageUrl, root)
+		if next == "" {
+			break
+		}
+		body, err := replayPreview(next, item.header)
+		if err != nil {
+			globalLogger.Warn().Msgf("replay %s failed: %v", next, err)
+			break
+		}
+		root = parsePartialJson(string(body))
+		_, episodes, _ = seriesEpisodes(root)
+		// a page that brings nothing new means the paging was guessed wrong
+		if add(episodes) == 0 {
+			break
+		}
+		pageUrl = next
+		series.Pages++
+	}
+	if series.Title == "" {
+		if u, err := url.Parse(item.Url); err == nil {
+			series.Title = u.Host
+		}
+	}
+	series.Total = len(series.Episodes)
+	return series, nil
+}
+
+// seriesEpisodes finds the longest list of objects that each carry a url, the key it is under
+// and the name of the object holding it
+func seriesEpisodes(root interface{}) (string, []Episode, string) {
+	var bestKey, bestTitle string
+	var best []Episode
+	var walk func(node interface{}, depth int)
+	walk = func(node interface{}, depth int) {
+		if depth > 8 {
+			return
+		}
+		switch v := node.(type) {
+		case []interface{}:
+			for _, item := range v {
+				walk(item, depth+1)
+			}
+		case map[string]interface{}:
+			for key, child := range v {
+				if list, ok := child.([]interface{}); ok && len(list) >= 2 {
+					var episodes []Episode
+					for _, entry := range list {
+						if object, ok := entry.(map[string]interface{}); ok {
+							if episode, ok := seriesEpisode(object); ok {
+								episodes = append(episodes, episode)
+							}
+						}
+					}
+					if len(episodes) > len(best) || (len(episodes) == len(best) && len(best) > 0 && seriesHinted(key) && !seriesHinted(bestKey)) {
+						bestKey, best = key, episodes
+						bestTitle = seriesName(v)
+					}
+				}
+				walk(child, depth+1)
+			}
+		}
+	}
+	walk(root, 0)
+	if bestTitle == "" {
+		if object, ok := root.(map[string]interface{}); ok {
+			bestTitle = seriesName(object)
+		}
+	}
+	return bestKey, best, bestTitle
+}
+
+// seriesName is the name of a catalog, from the object holding the list or an info object next to it
+func seriesName(object map[string]interface{}) string {
+	if name := seriesText(object, seriesNameKeys); name != "" {
+		return name
+	}
+	// only the explicit names, a plain title of a nested object is rarely the catalog's
+	explicit := seriesNameKeys[:len(seriesNameKeys)-2]
+	for _, child := range object {
+		if nested, ok := child.(map[string]interface{}); ok {
+			if name := seriesText(nested, explicit); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func seriesEpisode(object map[string]interface{}) (Episode, bool) {
+	episode := Episode{
+		Url:   seriesLink(object, seriesUrlKeys, 0),
+		Title: seriesText(object, seriesTitleKeys),
+		Cover: seriesLink(object, seriesCoverKeys, 0),
+	}
+	return episode, episode.Url != ""
+}
+
+// seriesLink is the first http url under one of keys, the video or media object of an entry
+// is looked into as well
+func seriesLink(object map[string]interface{}, keys []string, depth int) string {
+	for _, key := range keys {
+		if link := firstLink(object[key], 0); link != "" {
+			return link
+		}
+	}
+	if depth < 2 {
+		for _, nested := range []string{"video", "media", "item", "aweme", "content"} {
+			if child, ok := object[nested].(map[string]interface{}); ok {
+				if link := seriesLink(child, keys, depth+1); link != "" {
+					return link
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// firstLink digs a url out of a value, e.g. play_addr: {url_list: [...]}
+func firstLink(node interface{}, depth int) string {
+	if depth > 3 {
+		return ""
+	}
+	switch v := node.(type) {
+	case string:
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+			return v
+		}
+	case []interface{}:
+		for _, item := range v {
+			if link := firstLink(item, depth+1); link != "" {
+				return link
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"url_list", "urlList", "url", "urls", "src"} {
+			if link := firstLink(v[key], depth+1); link != "" {
+				return link
+			}
+		}
+	}
+	return ""
+}
+
+func seriesText(object map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		if s, ok := object[key].(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// seriesNextPage is the url of the following page: the cursor the response hands out, or the
+// page number counted up. Empty when the response says there is no more or paging is not recognized.
+func seriesNextPage(pageUrl string, root interface{}) string {
+	object, ok := root.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	// the paging fields sit at the top or in a data wrapper
+	scopes := []map[string]interface{}{object}
+	for _, child := range object {
+		if nested, ok := child.(map[string]interface{}); ok {
+			scopes = append(scopes, nested)
+		}
+	}
+	more, known := false, false
+	cursor := ""
+	for _, scope := range scopes {
+		for _, key := range seriesMoreKeys {
+			if value, ok := scope[key]; ok {
+				known = true
+				switch v := value.(type) {
+				case bool:
+					more = more || v
+				case json.Number:
+					more = more || v.String() != "0"
+				}
+			}
+		}
+		if cursor == "" {
+			for _, key := range seriesCursors {
+				switch v := scope[key].(type) {
+				case json.Number:
+					cursor = v.String()
+				case string:
+					cursor = v
+				}
+				if cursor != "" {
+					break
+				}
+			}
+		}
+	}
+	if known && !more {
+		return ""
+	}
+	u, err := url.Parse(pageUrl)
+	if err != nil {
+		return ""
+	}
+	query := u.Query()
+	if cursor != "" {
+		for _, key := range seriesCursors {
+			if query.Has(key) {
+				if query.Get(key) == cursor {
+					return ""
+				}
+				query.Set(key, cursor)
+				u.RawQuery = query.Encode()
+				return u.String()
+			}
+		}
+	}
+	for _, key := range seriesPageKeys {
+		if page, err := strconv.Atoi(query.Get(key)); err == nil {
+			query.Set(key, strconv.Itoa(page+1))
+			u.RawQuery = query.Encode()
+			return u.String()
+		}
+	}
+	return ""
+}
+
+// replayPreview requests a page of a captured api again, with the captured headers and the cookie jar
+func replayPreview(rawUrl string, header http.Header) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		// the transport negotiates and undoes compression itself
+		if strings.EqualFold(name, "Accept-Encoding") || strings.EqualFold(name, "Cookie") {
+			continue
+		}
+		request.Header[name] = values
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: func(req *http.Request) (*url.URL, error) {
+		return downloadProxy(DownloadProxyDefault, req.URL.String())
+	}}, Jar: cookieOnce}
+	if err := politeOnce.wait(ctx, request.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, seriesBodyLimit))
+}
+
+// downloadSeries captures every episode of a catalog and queues them as one batch. Episode pages
+// are resolved like pasted share links, the titles are numbered so the files sort in catalog order.
+func (r *Resource) downloadSeries(previewId string, priority int) (DownloadBatch, error) {
+	if globalConfig.SaveDirectory == "" {
+		return DownloadBatch{}, messageError(MsgSaveDirectoryUnset)
+	}
+	series, err := resolveSeries(previewId)
+	if err != nil {
+		return DownloadBatch{}, err
+	}
+	width := max(len(strconv.Itoa(len(series.Episodes))), 2)
+
+	resolved := make([][]shared.MediaInfo, len(series.Episodes))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, seriesResolvers)
+	for i, episode := range series.Episodes {
+		wg.Add(1)
+		go func(i int, episode Episode) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			u, err := url.Parse(episode.Url)
+			if err != nil {
+				return
+			}
+			if classify, ok := seriesMediaExts[strings.ToLower(path.Ext(u.Path))]; ok {
+				resolved[i] = []shared.MediaInfo{newManualMedia(episode.Url, classify, path.Ext(u.Path), "")}
+				return
+			}
+			list, err := resolveShare(episode.Url)
+			if err != nil {
+				globalLogger.Warn().Msgf("resolve episode %s failed: %v", episode.Url, err)
+				return
+			}
+			resolved[i] = list
+		}(i, episode)
+	}
+	wg.Wait()
+
+	var items []QueueItem
+	for i, episode := range series.Episodes {
+		if len(resolved[i]) == 0 {
+			continue
+		}
+		// an episode page may also carry images, the first video is the episode
+		res := resolved[i][0]
+		for _, candidate := range resolved[i] {
+			if candidate.Classify != "image" {
+				res = candidate
+				break
+			}
+		}
+		title := episode.Title
+		if title == "" {
+			title = res.Description
+		}
+		res.Description = fmt.Sprintf("%0*d_%s", width, episode.Index, title)
+		if res.CoverUrl == "" {
+			res.CoverUrl = episode.Cover
+		}
+		res.OtherData["episode"] = strconv.Itoa(episode.Index)
+		res.OtherData["series"] = series.Title
+		if res.Url != episode.Url {
+			res.OtherData["share_url"] = episode.Url
+		}
+		if !r.mediaIsMarked(res.UrlSign) {
+			r.markMedia(res.UrlSign)
+			r.capture(res)
+		}
+		items = append(items, QueueItem{MediaInfo: res})
+	}
+	if len(items) == 0 {
+		return DownloadBatch{}, errors.New("no episode could be resolved")
+	}
+	return batchOnce.start(items, priority), nil
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// loginName is what the login item is registered as
+	loginName = "res-downloader"
+	// serviceStopWait bounds how long the window waits for a running service to let go of its port
+	serviceStopWait = 10 * time.Second
+)
+
+var (
+	// serviceStarted is when "res-downloader service" started, zero while the window runs
+	serviceStarted time.Time
+	serviceStop    = make(chan struct{}, 1)
+)
+
+// ServiceStatus is what the api tells about the running capture
+type ServiceStatus struct {
+	Service       bool  `json:"Service"`       // running in the background without the window
+	Proxy         bool  `json:"Proxy"`         // the system proxy points at the capture
+	LaunchAtLogin bool  `json:"LaunchAtLogin"` // the service starts when the user logs in
+	Started       int64 `json:"Started"`       // unix seconds, 0 for the window
+	Resources     int   `json:"Resources"`
+	Queued        int   `json:"Queued"`
+	Running       int   `json:"Running"`
+}
+
+func serviceStatus() ServiceStatus {
+	status := ServiceStatus{
+		Service:       !serviceStarted.IsZero(),
+		Proxy:         appOnce.IsProxy,
+		LaunchAtLogin: globalConfig.LaunchAtLogin,
+		Resources:     resourceOnce.store.count(),
+		Queued:        len(queueOnce.list()),
+		Running:       len(queueOnce.runningIds()),
+	}
+	if status.Service {
+		status.Started = serviceStarted.Unix()
+	}
+	return status
+}
+
+// cliService runs the capture in the background until it is stopped over the api or by the
+// system: the proxy and its plugins, the queue, live watching, automation and the api, as the
+// saved settings have them. Config.AutoProxy points the system proxy at it.
+func cliService(assets embed.FS, wjs string, args []string) error {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	app := GetApp(assets, wjs)
+	if err := app.startServices(); err != nil {
+		return err
+	}
+	defer app.OnExit()
+	serviceStarted = time.Now()
+	if !globalConfig.ApiEnable {
+		globalLogger.Warn().Msg("the api is off, the service can only be stopped by the system")
+	}
+	if globalConfig.AutoProxy {
+		if !app.isInstall() {
+			globalLogger.Warn().Msg("the certificate is not installed yet, https resources will not be captured")
+		}
+		if err := app.OpenSystemProxy(); err != nil {
+			globalLogger.Esg(err, "open system proxy failed")
+		}
+	}
+	resourceOnce.requeueOnStartup()
+	globalLogger.Info().Msgf("service started, proxy on %s:%s", globalConfig.Host, globalConfig.Port)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	select {
+	case <-stop:
+	case <-serviceStop:
+	}
+	globalLogger.Info().Msg("service stopped")
+	return nil
+}
+
+// stopService ends the service, or closes the window when that is what runs
+func stopService() {
+	if serviceStarted.IsZero() {
+		if appOnce.ctx != nil {
+			runtime.Quit(appOnce.ctx)
+		}
+		return
+	}
+	select {
+	case serviceStop <- struct{}{}:
+	default:
+	}
+}
+
+// stopRunningService stops a "res-downloader service", started at login or by hand, before the
+// window listens on the same ports and opens the same database. It is found through the api the
+// two share in config.json, a service running with the api off is left alone.
+func stopRunningService() {
+	if !globalConfig.ApiEnable || globalConfig.ApiToken == "" {
+		return
+	}
+	base := "http://" + loopbackAddr(globalConfig.ApiListen)
+	var status ServiceStatus
+	if err := serviceCall(http.MethodGet, base+"/v1/service", &status); err != nil || !status.Service {
+		return
+	}
+	globalLogger.Info().Msg("a service is running, stopping it for the window")
+	if err := serviceCall(http.MethodPost, base+"/v1/service/stop", nil); err != nil {
+		globalLogger.Esg(err, "stop service failed")
+		return
+	}
+	// the service has let go of everything once its proxy port is free
+	addr := net.JoinHostPort(globalConfig.Host, globalConfig.Port)
+	for deadline := time.Now().Add(serviceStopWait); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		if listener, err := net.Listen("tcp", addr); err == nil {
+			_ = listener.Close()
+			return
+		}
+	}
+	globalLogger.Warn().Msg("the service did not stop in time")
+}
+
+// serviceCall sends a request to the api of a running service, decoding the answer into out
+func serviceCall(method, url string, out interface{}) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+globalConfig.ApiToken)
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// loopbackAddr is addr with a wildcard host, e.g. ":8900" or "0.0.0.0:8900", dialed on loopback
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// setSystemProxy points the system proxy at the capture or restores it, the window follows
+func setSystemProxy(enable bool) error {
+	var err error
+	if enable {
+		err = appOnce.OpenSystemProxy()
+	} else {
+		err = appOnce.UnsetSystemProxy()
+	}
+	httpServerOnce.send("systemProxy", map[string]bool{"IsProxy": appOnce.IsProxy})
+	return err
+}
+
+// serviceExecutable is the binary the login item starts, links resolved so an update in place keeps working
+func serviceExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return filepath.Abs(exe)
+}
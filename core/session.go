@@ -0,0 +1,198 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"res-downloader/core/shared"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Session is a saved capture session: the resources with their stars, tags and notes, the downloads
+// that had not finished, the captured types and the list filters of the window
+type Session struct {
+	Name      string             `json:"Name"`
+	Time      int64              `json:"Time"` // unix seconds of the save
+	Resources []shared.MediaInfo `json:"Resources"`
+	Pending   []PendingDownload  `json:"Pending"`
+	Types     []string           `json:"Types"`
+	Filters   json.RawMessage    `json:"Filters"` // kept as the window sent it
+}
+
+// SessionSummary is a saved session without its content, for listing
+type SessionSummary struct {
+	Name      string `json:"Name"`
+	Time      int64  `json:"Time"`
+	Resources int    `json:"Resources"`
+	Pending   int    `json:"Pending"`
+}
+
+// openSessions sets up the saved sessions, they live next to the resources in the same database
+func (s *ResourceStore) openSessions(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		name TEXT PRIMARY KEY,
+		resources INTEGER NOT NULL DEFAULT 0,
+		pending INTEGER NOT NULL DEFAULT 0,
+		data TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	)`)
+	return err
+}
+
+func (s *ResourceStore) saveSession(session Session) error {
+	if s.db == nil {
+		return errors.New("resource store is not open")
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO sessions (name, resources, pending, data, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET resources = excluded.resources, pending = excluded.pending,
+			data = excluded.data, created_at = excluded.created_at`,
+		session.Name, len(session.Resources), len(session.Pending), string(data), session.Time)
+	return err
+}
+
+func (s *ResourceStore) session(name string) (Session, error) {
+	var session Session
+	if s.db == nil {
+		return session, messageError(MsgSessionNotFound)
+	}
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM sessions WHERE name = ?`, name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, messageError(MsgSessionNotFound)
+	}
+	if err != nil {
+		return session, err
+	}
+	return session, json.Unmarshal([]byte(data), &session)
+}
+
+// sessions lists the saved sessions, the latest first
+func (s *ResourceStore) sessions() []SessionSummary {
+	list := make([]SessionSummary, 0)
+	if s.db == nil {
+		return list
+	}
+	rows, err := s.db.Query(`SELECT name, created_at, resources, pending FROM sessions ORDER BY created_at DESC`)
+	if err != nil {
+		globalLogger.Esg(err, "read sessions failed")
+		return list
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item SessionSummary
+		if rows.Scan(&item.Name, &item.Time, &item.Resources, &item.Pending) == nil {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (s *ResourceStore) deleteSession(name string) error {
+	if s.db == nil {
+		return messageError(MsgSessionNotFound)
+	}
+	result, err := s.db.Exec(`DELETE FROM sessions WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return messageError(MsgSessionNotFound)
+	}
+	return nil
+}
+
+// saveSession keeps what is captured now under name, a session of that name is replaced
+func (r *Resource) saveSession(name string, filters json.RawMessage) (SessionSummary, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return SessionSummary{}, messageError(MsgSessionNameRequired)
+	}
+	session := Session{
+		Name:      name,
+		Time:      time.Now().Unix(),
+		Resources: r.capturedList(),
+		Pending:   r.unfinished(),
+		Types:     r.selectedTypes(),
+		Filters:   filters,
+	}
+	if err := r.store.saveSession(session); err != nil {
+		return SessionSummary{}, err
+	}
+	return SessionSummary{Name: name, Time: session.Time, Resources: len(session.Resources), Pending: len(session.Pending)}, nil
+}
+
+// restoreSession replaces the captured list with a saved session. Its unfinished downloads go into the
+// download journal, from where /api/resume-downloads picks them up.
+func (r *Resource) restoreSession(name string) (Session, error) {
+	session, err := r.store.session(name)
+	if err != nil {
+		return session, err
+	}
+	r.clear()
+	// the saved order is kept, one millisecond apart and ending now
+	createdAt := time.Now().UnixMilli() - int64(len(session.Resources))
+	for i, res := range session.Resources {
+		if res.Url == "" {
+			continue
+		}
+		r.store.insert(res, createdAt+int64(i))
+		if mark := curationOf(res); mark.Starred != nil || mark.Tags != nil || mark.Note != nil {
+			_, _ = r.store.mark(res.Id, mark)
+		}
+		if res.UrlSign != "" {
+			r.markMedia(res.UrlSign)
+		}
+	}
+	for _, item := range session.Pending {
+		if item.MediaInfo.Url == "" {
+			continue
+		}
+		if _, running := r.tasks.Load(item.MediaInfo.Id); running {
+			continue
+		}
+		r.journalAdd(item.MediaInfo, item.DecodeStr)
+	}
+	if len(session.Types) > 0 {
+		r.setResType(session.Types)
+	}
+	httpServerOnce.send("sessionRestored", SessionSummary{Name: session.Name, Time: session.Time,
+		Resources: len(session.Resources), Pending: len(session.Pending)})
+	return session, nil
+}
+
+// selectedTypes are the resource types being captured, as /api/set-type takes them
+func (r *Resource) selectedTypes() []string {
+	r.resTypeMux.RLock()
+	defer r.resTypeMux.RUnlock()
+	var list []string
+	for key, on := range r.resType {
+		if on {
+			list = append(list, key)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// curationOf reads the star, tags and note scan put into OtherData
+func curationOf(res shared.MediaInfo) ResourceMark {
+	var mark ResourceMark
+	if res.OtherData["starred"] == "1" {
+		starred := true
+		mark.Starred = &starred
+	}
+	if tags := res.OtherData["tags"]; tags != "" {
+		list := strings.Split(tags, ",")
+		mark.Tags = &list
+	}
+	if note := res.OtherData["note"]; note != "" {
+		mark.Note = &note
+	}
+	return mark
+}
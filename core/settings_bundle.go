@@ -0,0 +1,164 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	bundleIterations = 200000
+	// bundleMaxIterations caps the count an imported bundle asks for, so a crafted file can't hang the import
+	bundleMaxIterations = 10 * bundleIterations
+)
+
+// settingsBundle is the file settings are moved between machines with. Without a passphrase
+// Data is the payload itself, with one it is the AES-GCM sealed payload under a PBKDF2 key.
+type settingsBundle struct {
+	App        string          `json:"app"`
+	Version    string          `json:"version"`
+	Created    int64           `json:"created"`
+	Encrypted  bool            `json:"encrypted"`
+	Salt       []byte          `json:"salt,omitempty"`
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Iterations int             `json:"iterations,omitempty"`
+	Data       json.RawMessage `json:"data"`
+}
+
+type bundlePayload struct {
+	Config  json.RawMessage `json:"config"`  // every setting, rules, templates and credentials included
+	Cookies json.RawMessage `json:"cookies"` // the download cookie jar
+}
+
+// exportSettings writes the bundle to path, sealed when passphrase isn't empty
+func exportSettings(path, passphrase string) error {
+	config, err := json.Marshal(globalConfig)
+	if err != nil {
+		return err
+	}
+	cookieOnce.mu.Lock()
+	cookies, err := json.Marshal(cookieOnce.jars)
+	cookieOnce.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(bundlePayload{Config: config, Cookies: cookies})
+	if err != nil {
+		return err
+	}
+
+	bundle := settingsBundle{App: appOnce.AppName, Version: appOnce.Version, Created: time.Now().Unix(), Data: payload}
+	if passphrase != "" {
+		bundle.Encrypted = true
+		bundle.Iterations = bundleIterations
+		bundle.Salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, bundle.Salt); err != nil {
+			return err
+		}
+		gcm, err := bundleCipher(passphrase, bundle.Salt, bundle.Iterations)
+		if err != nil {
+			return err
+		}
+		bundle.Nonce = make([]byte, gcm.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, bundle.Nonce); err != nil {
+			return err
+		}
+		sealed, err := json.Marshal(gcm.Seal(nil, bundle.Nonce, payload, nil))
+		if err != nil {
+			return err
+		}
+		bundle.Data = sealed
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// importSettings applies a bundle over the current settings. A save directory that doesn't
+// exist on this machine is not taken over.
+func importSettings(path, passphrase string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var bundle settingsBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return messageError(MsgSettingsInvalid)
+	}
+	payload := []byte(bundle.Data)
+	if bundle.Encrypted {
+		if passphrase == "" {
+			return messageError(MsgPassphraseRequired)
+		}
+		var sealed []byte
+		if err := json.Unmarshal(bundle.Data, &sealed); err != nil {
+			return err
+		}
+		gcm, err := bundleCipher(passphrase, bundle.Salt, bundle.Iterations)
+		if err != nil {
+			return err
+		}
+		if len(bundle.Nonce) != gcm.NonceSize() {
+			return messageError(MsgSettingsInvalid)
+		}
+		if payload, err = gcm.Open(nil, bundle.Nonce, sealed, nil); err != nil {
+			return messageError(MsgPassphraseWrong)
+		}
+	}
+	var content bundlePayload
+	if err := json.Unmarshal(payload, &content); err != nil {
+		return err
+	}
+
+	config, err := globalConfig.clone()
+	if err != nil {
+		return err
+	}
+	saveDirectory := config.SaveDirectory
+	if err := json.Unmarshal(content.Config, &config); err != nil {
+		return err
+	}
+	if info, err := os.Stat(config.SaveDirectory); err != nil || !info.IsDir() {
+		config.SaveDirectory = saveDirectory
+	}
+	globalConfig.setConfig(config)
+
+	if len(content.Cookies) > 0 {
+		jars := map[string]map[string]*jarCookie{}
+		if err := json.Unmarshal(content.Cookies, &jars); err != nil {
+			return err
+		}
+		cookieOnce.mu.Lock()
+		for platform, jar := range jars {
+			for key, cookie := range jar {
+				if cookieOnce.jars[platform] == nil {
+					cookieOnce.jars[platform] = map[string]*jarCookie{}
+				}
+				cookieOnce.jars[platform][key] = cookie
+			}
+		}
+		cookieOnce.mu.Unlock()
+		cookieOnce.save()
+	}
+	return nil
+}
+
+func bundleCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if iterations <= 0 || iterations > bundleMaxIterations || len(salt) == 0 {
+		return nil, messageError(MsgSettingsInvalid)
+	}
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -15,4 +15,28 @@ type MediaInfo struct {
 	Description string
 	ContentType string
 	OtherData   map[string]string
+	Variants    []Variant `json:",omitempty"`
+}
+
+// Variant is one quality of a resource that was captured in several
+type Variant struct {
+	Url       string
+	Label     string
+	Height    int   // 0 when unknown
+	Bandwidth int64 // as the source states it, only compared between variants of one resource
+}
+
+// MediaComments is the description and top comments a platform gave for media it serves,
+// a plugin sends it once it has seen them and they are kept with the resource of UrlSign
+type MediaComments struct {
+	UrlSign     string
+	Description string
+	Comments    []Comment
+}
+
+type Comment struct {
+	Author  string
+	Content string
+	Likes   int64
+	Time    int64 // unix seconds, 0 when unknown
 }
@@ -6,4 +6,8 @@ const (
 	DownloadStatusError   string = "error"
 	DownloadStatusDone    string = "done"
 	DownloadStatusHandle  string = "handle"
+	DownloadStatusPaused  string = "paused"
 )
+
+// MobileUserAgent is sent when fetching share pages, most platforms serve the light mobile page with embedded data to it
+const MobileUserAgent = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
@@ -21,3 +21,9 @@ type Plugin interface {
 	OnRequest(*http.Request, *goproxy.ProxyCtx) (*http.Request, *http.Response)
 	OnResponse(*http.Response, *goproxy.ProxyCtx) *http.Response
 }
+
+// Resolver is implemented by plugins that can turn a share page into media without the proxy,
+// pageUrl is the url after redirects and body the page html
+type Resolver interface {
+	Resolve(pageUrl string, body []byte) ([]MediaInfo, error)
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"os"
+	"path/filepath"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sidecarClassify are the types that get a cover and a metadata file, media libraries only list videos
+var sidecarClassify = map[string]bool{"video": true, "m3u8": true, "live": true}
+
+// Sidecar is the .json written next to a downloaded video
+type Sidecar struct {
+	Id          string `json:"Id"`
+	Title       string `json:"Title"`
+	Author      string `json:"Author"`
+	Description string `json:"Description"`
+	Url         string `json:"Url"`
+	Platform    string `json:"Platform"`
+	Cover       string `json:"Cover"`
+	Captured    int64  `json:"Captured"`  // unix seconds
+	Published   int64  `json:"Published"` // unix seconds, 0 when unknown
+	Likes       int64  `json:"Likes"`
+}
+
+// sidecarNfo is the Kodi movie .nfo Jellyfin and Emby read
+type sidecarNfo struct {
+	XMLName   xml.Name `xml:"movie"`
+	Title     string   `xml:"title"`
+	Plot      string   `xml:"plot,omitempty"`
+	Director  string   `xml:"director,omitempty"`
+	Studio    string   `xml:"studio,omitempty"`
+	Premiered string   `xml:"premiered,omitempty"`
+	DateAdded string   `xml:"dateadded,omitempty"`
+	Thumb     string   `xml:"thumb,omitempty"`
+	UniqueId  struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"uniqueid"`
+	Url string `xml:"url,omitempty"` // not read by media servers, kept for reference
+}
+
+// saveSidecars writes the cover, the metadata file and the comments of a downloaded video next
+// to it, as Config.SaveCover, Config.SidecarFormat and Config.SaveComments ask. Failures are logged, the download stays done.
+func (r *Resource) saveSidecars(mediaInfo shared.MediaInfo) {
+	if !sidecarClassify[mediaInfo.Classify] || mediaInfo.SavePath == "" {
+		return
+	}
+	// comments and metadata may have come after the window got the resource
+	if stored, ok := r.store.get(mediaInfo.Id); ok {
+		otherData := make(map[string]string, len(stored.OtherData)+len(mediaInfo.OtherData))
+		for key, value := range stored.OtherData {
+			otherData[key] = value
+		}
+		for key, value := range mediaInfo.OtherData {
+			if value != "" {
+				otherData[key] = value
+			}
+		}
+		mediaInfo.OtherData = otherData
+	}
+	base := strings.TrimSuffix(mediaInfo.SavePath, filepath.Ext(mediaInfo.SavePath))
+	if globalConfig.SaveCover && mediaInfo.CoverUrl != "" {
+		if err := r.saveCover(mediaInfo, base); err != nil {
+			globalLogger.Esg(err, "save cover failed: "+mediaInfo.CoverUrl)
+		}
+	}
+	var err error
+	switch strings.ToLower(globalConfig.SidecarFormat) {
+	case "json":
+		err = writeSidecarJson(base+".json", sidecarOf(mediaInfo))
+	case "nfo":
+		err = writeSidecarNfo(base+".nfo", sidecarOf(mediaInfo))
+	}
+	if err != nil {
+		globalLogger.Esg(err, "write metadata sidecar failed")
+	}
+	if globalConfig.SaveComments {
+		if _, err := writeCommentsText(base+".txt", mediaInfo); err != nil {
+			globalLogger.Esg(err, "write comments failed")
+		}
+	}
+}
+
+// saveCover downloads the cover as <name>-poster.<ext>, with the headers and proxy of the video
+func (r *Resource) saveCover(mediaInfo shared.MediaInfo, base string) error {
+	ext := ".jpg"
+	if u, err := url.Parse(mediaInfo.CoverUrl); err == nil {
+		switch e := strings.ToLower(filepath.Ext(u.Path)); e {
+		case ".jpg", ".jpeg", ".png", ".webp", ".gif":
+			ext = e
+		}
+	}
+	coverPath := base + "-poster" + ext
+	if _, err := os.Stat(coverPath); err == nil {
+		return nil
+	}
+	headers, err := r.parseHeaders(mediaInfo)
+	if err != nil {
+		return err
+	}
+	downloader := NewFileDownloader(mediaInfo.CoverUrl, coverPath, 1, headers)
+	downloader.Overrides = r.headerOverrides(mediaInfo.Id)
+	downloader.ProxyMode = r.proxyMode(mediaInfo.Id)
+	return downloader.Start()
+}
+
+func sidecarOf(mediaInfo shared.MediaInfo) Sidecar {
+	title := mediaInfo.OtherData["title"]
+	if title == "" {
+		title = mediaInfo.Description
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(mediaInfo.SavePath), filepath.Ext(mediaInfo.SavePath))
+	}
+	published, _ := strconv.ParseInt(mediaInfo.OtherData["publish_time"], 10, 64)
+	likes, _ := strconv.ParseInt(mediaInfo.OtherData["likes"], 10, 64)
+	platform := mediaInfo.Domain
+	if name, ok := platformNames[platform]; ok {
+		platform = name
+	}
+	captured := time.Now().Unix()
+	if at, ok := resourceOnce.store.capturedAt(mediaInfo.Id); ok {
+		captured = at.Unix()
+	}
+	return Sidecar{
+		Id:          mediaInfo.Id,
+		Title:       title,
+		Author:      mediaInfo.OtherData["author"],
+		Description: mediaInfo.OtherData["description"],
+		Url:         mediaInfo.Url,
+		Platform:    platform,
+		Cover:       mediaInfo.CoverUrl,
+		Captured:    captured,
+		Published:   published,
+		Likes:       likes,
+	}
+}
+
+func writeSidecarJson(fileName string, sidecar Sidecar) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	// urls stay readable, & is not escaped
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(sidecar); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+func writeSidecarNfo(fileName string, sidecar Sidecar) error {
+	nfo := sidecarNfo{
+		Title:     sidecar.Title,
+		Plot:      sidecar.Description,
+		Director:  sidecar.Author,
+		Studio:    sidecar.Platform,
+		DateAdded: time.Unix(sidecar.Captured, 0).Format("2006-01-02 15:04:05"),
+		Thumb:     sidecar.Cover,
+		Url:       sidecar.Url,
+	}
+	if sidecar.Published > 0 {
+		nfo.Premiered = time.Unix(sidecar.Published, 0).Format("2006-01-02")
+	}
+	nfo.UniqueId.Type = "res-downloader"
+	nfo.UniqueId.Value = sidecar.Id
+	data, err := xml.MarshalIndent(nfo, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
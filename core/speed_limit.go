@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket in bytes per second, a rate of 0 means unlimited
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func initSpeedLimit() *RateLimiter {
+	if speedLimitOnce == nil {
+		speedLimitOnce = NewRateLimiter(int64(globalConfig.SpeedLimit) * 1024)
+	}
+	return speedLimitOnce
+}
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, last: time.Now()}
+}
+
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	l.rate = rate
+	l.tokens = 0
+	l.last = time.Now()
+	l.mu.Unlock()
+}
+
+// SetRateIfChanged avoids resetting the bucket when a periodic caller sets the same rate again
+func (l *RateLimiter) SetRateIfChanged(rate int64) {
+	if l.Rate() != rate {
+		l.SetRate(rate)
+	}
+}
+
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait takes n bytes from the bucket, sleeping off any debt
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	burst := float64(l.rate)
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limitedReader throttles reads through the task limiter and the global one
+type limitedReader struct {
+	reader   io.Reader
+	ctx      context.Context
+	limiters []*RateLimiter
+}
+
+func newLimitedReader(ctx context.Context, reader io.Reader, limiters ...*RateLimiter) io.Reader {
+	return &limitedReader{reader: reader, ctx: ctx, limiters: limiters}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		for _, limiter := range r.limiters {
+			if limiter == nil {
+				continue
+			}
+			if waitErr := limiter.Wait(r.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
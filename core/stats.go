@@ -0,0 +1,195 @@
+package core
+
+import (
+	"encoding/csv"
+	"io"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// statsTopDomains is how many domains Stats.TopDomains ranks by default
+const statsTopDomains = 10
+
+// StatsQuery picks the time range of the dashboard, zero values mean everything
+type StatsQuery struct {
+	Start int64 `json:"start"` // unix seconds, inclusive
+	End   int64 `json:"end"`   // unix seconds, exclusive
+	Top   int   `json:"top"`   // domains to rank
+}
+
+// DailyStats is one platform on one day, Day is local time as 2006-01-02
+type DailyStats struct {
+	Day       string `json:"Day"`
+	Platform  string `json:"Platform"`
+	Captures  int    `json:"Captures"`
+	Downloads int    `json:"Downloads"`
+	Failed    int    `json:"Failed"`
+	Bytes     int64  `json:"Bytes"` // of the finished downloads
+}
+
+type DomainStats struct {
+	Domain    string `json:"Domain"`
+	Captures  int    `json:"Captures"`
+	Downloads int    `json:"Downloads"`
+	Bytes     int64  `json:"Bytes"`
+}
+
+// Stats is what the dashboard draws: captures come from the resource store, downloads from the history
+type Stats struct {
+	Captures   int           `json:"Captures"`
+	Downloads  int           `json:"Downloads"`
+	Failed     int           `json:"Failed"`
+	Bytes      int64         `json:"Bytes"`
+	Daily      []DailyStats  `json:"Daily"` // by day, then platform
+	TopDomains []DomainStats `json:"TopDomains"`
+}
+
+type statsKey struct {
+	day, platform string
+}
+
+// stats aggregates the stored captures and the download history over q's range
+func stats(q StatsQuery) (Stats, error) {
+	daily := map[statsKey]*DailyStats{}
+	domains := map[string]*DomainStats{}
+	entry := func(day, platform string) *DailyStats {
+		key := statsKey{day, platform}
+		if daily[key] == nil {
+			daily[key] = &DailyStats{Day: day, Platform: platform}
+		}
+		if domains[platform] == nil {
+			domains[platform] = &DomainStats{Domain: platform}
+		}
+		return daily[key]
+	}
+
+	captures, err := resourceOnce.store.captureCounts(q.Start, q.End)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, item := range captures {
+		entry(item.Day, item.Platform).Captures += item.Captures
+		domains[item.Platform].Captures += item.Captures
+	}
+	downloads, err := historyOnce.downloadCounts(q.Start, q.End)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, item := range downloads {
+		day := entry(item.Day, item.Platform)
+		day.Downloads += item.Downloads
+		day.Failed += item.Failed
+		day.Bytes += item.Bytes
+		domains[item.Platform].Downloads += item.Downloads
+		domains[item.Platform].Bytes += item.Bytes
+	}
+
+	result := Stats{Daily: make([]DailyStats, 0, len(daily)), TopDomains: make([]DomainStats, 0, len(domains))}
+	for _, item := range daily {
+		result.Captures += item.Captures
+		result.Downloads += item.Downloads
+		result.Failed += item.Failed
+		result.Bytes += item.Bytes
+		result.Daily = append(result.Daily, *item)
+	}
+	sort.Slice(result.Daily, func(i, j int) bool {
+		if result.Daily[i].Day != result.Daily[j].Day {
+			return result.Daily[i].Day < result.Daily[j].Day
+		}
+		return result.Daily[i].Platform < result.Daily[j].Platform
+	})
+	for _, item := range domains {
+		result.TopDomains = append(result.TopDomains, *item)
+	}
+	sort.Slice(result.TopDomains, func(i, j int) bool {
+		a, b := result.TopDomains[i], result.TopDomains[j]
+		if a.Captures+a.Downloads != b.Captures+b.Downloads {
+			return a.Captures+a.Downloads > b.Captures+b.Downloads
+		}
+		return a.Domain < b.Domain
+	})
+	top := q.Top
+	if top <= 0 {
+		top = statsTopDomains
+	}
+	if len(result.TopDomains) > top {
+		result.TopDomains = result.TopDomains[:top]
+	}
+	return result, nil
+}
+
+// writeStatsCsv writes the daily rows, the totals and rankings add up from them
+func writeStatsCsv(w io.Writer, result Stats) error {
+	// BOM so Excel detects utf-8
+	if _, err := io.WriteString(w, "\xEF\xBB\xBF"); err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"Day", "Platform", "Captures", "Downloads", "Failed", "Bytes"})
+	for _, item := range result.Daily {
+		_ = out.Write([]string{item.Day, item.Platform, strconv.Itoa(item.Captures), strconv.Itoa(item.Downloads),
+			strconv.Itoa(item.Failed), strconv.FormatInt(item.Bytes, 10)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// statsRange turns the query range into the bounds of a created_at column in the given unit
+func statsRange(start, end int64, scale int64) (int64, int64) {
+	if end <= 0 {
+		end = time.Now().Unix() + 1
+	}
+	return start * scale, end * scale
+}
+
+// captureCounts counts the stored captures per local day and domain
+func (s *ResourceStore) captureCounts(start, end int64) ([]DailyStats, error) {
+	list := make([]DailyStats, 0)
+	if s.db == nil {
+		return list, nil
+	}
+	from, to := statsRange(start, end, 1000)
+	rows, err := s.db.Query(`SELECT strftime('%Y-%m-%d', created_at / 1000, 'unixepoch', 'localtime') AS day, domain, COUNT(*)
+		FROM resources WHERE created_at >= ? AND created_at < ? GROUP BY day, domain`, from, to)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item DailyStats
+		if err := rows.Scan(&item.Day, &item.Platform, &item.Captures); err != nil {
+			return list, err
+		}
+		list = append(list, item)
+	}
+	return list, rows.Err()
+}
+
+// downloadCounts counts the finished and failed downloads per local day and platform
+func (h *History) downloadCounts(start, end int64) ([]DailyStats, error) {
+	list := make([]DailyStats, 0)
+	if h.db == nil {
+		return list, nil
+	}
+	from, to := statsRange(start, end, 1)
+	rows, err := h.db.Query(`SELECT strftime('%Y-%m-%d', created_at, 'unixepoch', 'localtime') AS day, platform,
+			COUNT(CASE WHEN status = ? THEN 1 END),
+			COUNT(CASE WHEN status = ? THEN 1 END),
+			COALESCE(SUM(CASE WHEN status = ? THEN size END), 0)
+		FROM downloads WHERE created_at >= ? AND created_at < ? GROUP BY day, platform`,
+		shared.DownloadStatusDone, shared.DownloadStatusError, shared.DownloadStatusDone, from, to)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item DailyStats
+		if err := rows.Scan(&item.Day, &item.Platform, &item.Downloads, &item.Failed, &item.Bytes); err != nil {
+			return list, err
+		}
+		list = append(list, item)
+	}
+	return list, rows.Err()
+}
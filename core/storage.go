@@ -1,14 +1,18 @@
 package core
 
 import (
+	"crypto/sha256"
 	"os"
 	"path"
 	"res-downloader/core/shared"
+	"sync"
 )
 
 type Storage struct {
 	fileName string
 	def      []byte
+	mu       sync.Mutex
+	sum      [sha256.Size]byte // of what was last loaded or stored, to tell edits by others apart
 }
 
 func NewStorage(filename string, def []byte) *Storage {
@@ -24,18 +28,45 @@ func (l *Storage) Load() ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		l.remember(l.def)
 		return l.def, nil
 	}
 	d, err := os.ReadFile(l.fileName)
 	if err != nil {
 		return nil, err
 	}
+	l.remember(d)
 	return d, err
 }
 
 func (l *Storage) Store(data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	if err := os.WriteFile(l.fileName, data, 0644); err != nil {
 		return err
 	}
+	l.sum = sha256.Sum256(data)
 	return nil
 }
+
+func (l *Storage) remember(data []byte) {
+	l.mu.Lock()
+	l.sum = sha256.Sum256(data)
+	l.mu.Unlock()
+}
+
+// Changed returns the file when something other than Store wrote it since
+func (l *Storage) Changed() ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d, err := os.ReadFile(l.fileName)
+	if err != nil || len(d) == 0 {
+		return nil, false
+	}
+	sum := sha256.Sum256(d)
+	if sum == l.sum {
+		return nil, false
+	}
+	l.sum = sum
+	return d, true
+}
@@ -116,6 +116,65 @@ func (s *SystemSetup) unsetProxy() error {
 	return fmt.Errorf("failed to unset proxy for any active network service, errs:%s", errs)
 }
 
+func (s *SystemSetup) proxySnapshot() map[string]string {
+	services, err := s.getNetworkServices()
+	if err != nil {
+		return nil
+	}
+
+	snapshot := map[string]string{}
+	for _, serviceName := range services {
+		for _, kind := range []string{"webproxy", "securewebproxy"} {
+			output, err := s.runCommand([]string{"networksetup", "-get" + kind, serviceName})
+			if err != nil {
+				continue
+			}
+			var enabled, server, port string
+			for _, line := range strings.Split(string(output), "\n") {
+				k, v, ok := strings.Cut(line, ":")
+				if !ok {
+					continue
+				}
+				switch strings.TrimSpace(k) {
+				case "Enabled":
+					enabled = strings.TrimSpace(v)
+				case "Server":
+					server = strings.TrimSpace(v)
+				case "Port":
+					port = strings.TrimSpace(v)
+				}
+			}
+			snapshot[serviceName+"|"+kind] = enabled + "|" + server + "|" + port
+		}
+	}
+	return snapshot
+}
+
+func (s *SystemSetup) restoreProxy(snapshot map[string]string) error {
+	var errs strings.Builder
+	for key, value := range snapshot {
+		serviceName, kind, _ := strings.Cut(key, "|")
+		parts := strings.SplitN(value, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		var cmd []string
+		if parts[0] == "Yes" && parts[1] != "" && !(parts[1] == "127.0.0.1" && parts[2] == globalConfig.Port) {
+			cmd = []string{"networksetup", "-set" + kind, serviceName, parts[1], parts[2]}
+		} else {
+			cmd = []string{"networksetup", "-set" + kind + "state", serviceName, "off"}
+		}
+		if output, err := s.runCommand(cmd); err != nil {
+			errs.WriteString(fmt.Sprintf("cmd: %v\noutput: %s\nerr: %s\n", cmd, output, err))
+		}
+	}
+	if errs.Len() > 0 {
+		return fmt.Errorf("failed to restore proxy, errs:%s", errs.String())
+	}
+	return nil
+}
+
 func (s *SystemSetup) installCert() (string, error) {
 	_, err := s.initCert()
 	if err != nil {
@@ -76,6 +76,58 @@ func (s *SystemSetup) unsetProxy() error {
 	return nil
 }
 
+var gnomeProxyKeys = [][]string{
+	{"org.gnome.system.proxy", "mode"},
+	{"org.gnome.system.proxy.http", "host"},
+	{"org.gnome.system.proxy.http", "port"},
+	{"org.gnome.system.proxy.https", "host"},
+	{"org.gnome.system.proxy.https", "port"},
+}
+
+func (s *SystemSetup) proxySnapshot() map[string]string {
+	snapshot := map[string]string{}
+	for _, key := range gnomeProxyKeys {
+		output, err := s.runCommand([]string{"gsettings", "get", key[0], key[1]}, false)
+		if err != nil {
+			continue
+		}
+		snapshot[key[0]+" "+key[1]] = strings.TrimSpace(string(output))
+	}
+	return snapshot
+}
+
+func (s *SystemSetup) restoreProxy(snapshot map[string]string) error {
+	var errs strings.Builder
+	// a snapshot taken while our own proxy was set must not point the system back at it
+	pointsAtSelf := false
+	for _, scheme := range []string{"http", "https"} {
+		prefix := "org.gnome.system.proxy." + scheme + " "
+		if strings.Trim(snapshot[prefix+"host"], "'") == "127.0.0.1" && snapshot[prefix+"port"] == globalConfig.Port {
+			pointsAtSelf = true
+		}
+	}
+	// restore host/port first so the mode switch takes effect with the right values
+	for i := len(gnomeProxyKeys) - 1; i >= 0; i-- {
+		key := gnomeProxyKeys[i]
+		value, ok := snapshot[key[0]+" "+key[1]]
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, "'")
+		if pointsAtSelf && key[1] == "mode" {
+			value = "none"
+		}
+		cmd := []string{"gsettings", "set", key[0], key[1], value}
+		if output, err := s.runCommand(cmd, false); err != nil {
+			errs.WriteString(fmt.Sprintf("cmd: %v\noutput: %s\nerr: %s\n", cmd, output, err))
+		}
+	}
+	if errs.Len() > 0 {
+		return fmt.Errorf("failed to restore proxy:\n%s", errs.String())
+	}
+	return nil
+}
+
 func (s *SystemSetup) installCert() (string, error) {
 	_, err := s.initCert()
 	if err != nil {
@@ -8,11 +8,14 @@ import (
 	"errors"
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/registry"
+	"strconv"
 	"unsafe"
 )
 
+const internetSettingsKey = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
 func (s *SystemSetup) setProxy() error {
-	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.SET_VALUE)
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
 	if err != nil {
 		return err
 	}
@@ -31,7 +34,7 @@ func (s *SystemSetup) setProxy() error {
 }
 
 func (s *SystemSetup) unsetProxy() error {
-	key, err := registry.OpenKey(registry.CURRENT_USER, `Software\Microsoft\Windows\CurrentVersion\Internet Settings`, registry.SET_VALUE)
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
 	if err != nil {
 		return err
 	}
@@ -43,6 +46,44 @@ func (s *SystemSetup) unsetProxy() error {
 	return nil
 }
 
+func (s *SystemSetup) proxySnapshot() map[string]string {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.QUERY_VALUE)
+	if err != nil {
+		return nil
+	}
+	defer key.Close()
+
+	snapshot := map[string]string{}
+	if server, _, err := key.GetStringValue("ProxyServer"); err == nil {
+		snapshot["ProxyServer"] = server
+	}
+	if enable, _, err := key.GetIntegerValue("ProxyEnable"); err == nil {
+		snapshot["ProxyEnable"] = strconv.FormatUint(enable, 10)
+	}
+	return snapshot
+}
+
+func (s *SystemSetup) restoreProxy(snapshot map[string]string) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	server, hasServer := snapshot["ProxyServer"]
+	if hasServer {
+		if err := key.SetStringValue("ProxyServer", server); err != nil {
+			return err
+		}
+	}
+
+	enable := uint32(0)
+	if snapshot["ProxyEnable"] == "1" && hasServer && server != "127.0.0.1:"+globalConfig.Port {
+		enable = 1
+	}
+	return key.SetDWordValue("ProxyEnable", enable)
+}
+
 func (s *SystemSetup) installCert() (string, error) {
 	certData, err := s.initCert()
 	if err != nil {
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const telegramApi = "https://api.telegram.org/bot"
+
+// TelegramBot long polls a bot for commands and pushes download results to Config.TelegramChatId.
+// Other chats only get told their id, so a leaked bot name can't drive the queue.
+type TelegramBot struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+type telegramUpdate struct {
+	UpdateId int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			Id int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+func initTelegram() *TelegramBot {
+	if telegramOnce == nil {
+		telegramOnce = &TelegramBot{}
+	}
+	return telegramOnce
+}
+
+// apply restarts polling with the current token, or stops it when the bot is disabled
+func (t *TelegramBot) apply() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	if !globalConfig.TelegramEnable || globalConfig.TelegramToken == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	go t.poll(ctx, globalConfig.TelegramToken)
+}
+
+func (t *TelegramBot) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
+
+func (t *TelegramBot) client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return downloadProxy(globalConfig.TelegramProxy, r.URL.String())
+			},
+		},
+	}
+}
+
+// call runs one bot api method and decodes its result into out when given
+func (t *TelegramBot) call(ctx context.Context, client *http.Client, token, method string, params url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramApi+token+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return telegramError(err, method)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return telegramError(err, method)
+	}
+	defer resp.Body.Close()
+	var data struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	if !data.Ok {
+		return fmt.Errorf("telegram %s: %s", method, data.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(data.Result, out)
+	}
+	return nil
+}
+
+// telegramError keeps the bot token, part of every request url, out of errors that end up in the log
+func telegramError(err error, method string) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = telegramApi + "***/" + method
+	}
+	return err
+}
+
+func (t *TelegramBot) poll(ctx context.Context, token string) {
+	client := t.client(time.Minute)
+	var offset int64
+	for ctx.Err() == nil {
+		var updates []telegramUpdate
+		err := t.call(ctx, client, token, "getUpdates", url.Values{
+			"offset":          {strconv.FormatInt(offset, 10)},
+			"timeout":         {"50"},
+			"allowed_updates": {`["message"]`},
+		}, &updates)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			globalLogger.Esg(err, "telegram polling failed")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(30 * time.Second):
+			}
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateId + 1
+			if update.Message == nil || !strings.HasPrefix(update.Message.Text, "/") {
+				continue
+			}
+			chatId := strconv.FormatInt(update.Message.Chat.Id, 10)
+			reply := t.command(chatId, update.Message.Text)
+			if err := t.call(ctx, client, token, "sendMessage", url.Values{"chat_id": {chatId}, "text": {reply}}, nil); err != nil {
+				globalLogger.Esg(err, "telegram reply failed")
+			}
+		}
+	}
+}
+
+// command runs a "/name args" message and returns the reply
+func (t *TelegramBot) command(chatId, text string) string {
+	if chatId != strings.TrimSpace(globalConfig.TelegramChatId) {
+		return "This chat is not allowed. Set the Telegram chat id to " + chatId + " in the settings to control res-downloader from here."
+	}
+	name, _, _ := strings.Cut(strings.Fields(text)[0], "@")
+	switch name {
+	case "/status":
+		items := queueOnce.list()
+		paused := "running"
+		if queueOnce.isPaused() {
+			paused = "paused"
+		}
+		return fmt.Sprintf("Queue is %s: %d running, %d waiting.", paused, len(queueOnce.runningIds()), len(items))
+	case "/pause":
+		queueOnce.setPaused(true)
+		return "Queue paused, running downloads finish."
+	case "/resume":
+		queueOnce.setPaused(false)
+		return "Queue resumed."
+	case "/latest":
+		return t.downloadLatest()
+	case "/transcript":
+		return "Transcripts are not available, res-downloader has no speech recognition."
+	default:
+		return "Commands:\n/status - queue state\n/pause - pause the queue\n/resume - resume the queue\n/latest - download the latest captured video\n/transcript - fetch a transcript"
+	}
+}
+
+func (t *TelegramBot) downloadLatest() string {
+	if globalConfig.SaveDirectory == "" {
+		return "The save directory is not set."
+	}
+	res, ok := resourceOnce.store.latest("video")
+	if !ok {
+		return "No video has been captured yet."
+	}
+	queueOnce.push(res, "", 0)
+	title := res.Description
+	if title == "" {
+		title = res.Url
+	}
+	return "Queued: " + title
+}
+
+// notify tells the configured chat how a download ended
+func (t *TelegramBot) notify(mediaInfo shared.MediaInfo, status, message string) {
+	title := mediaInfo.Description
+	if title == "" {
+		title = mediaInfo.Url
+	}
+	if status == shared.DownloadStatusDone {
+		text := "Download finished\n" + title + "\n" + mediaInfo.SavePath
+		if info, err := os.Stat(mediaInfo.SavePath); err == nil {
+			text += "\n" + shared.FormatSize(float64(info.Size()))
+		}
+		t.message(text)
+	} else {
+		t.message("Download failed\n" + title + "\n" + message)
+	}
+}
+
+// message sends text to the configured chat in the background
+func (t *TelegramBot) message(text string) {
+	token, chatId := globalConfig.TelegramToken, strings.TrimSpace(globalConfig.TelegramChatId)
+	if !globalConfig.TelegramEnable || token == "" || chatId == "" {
+		return
+	}
+	go func() {
+		err := t.call(context.Background(), t.client(15*time.Second), token, "sendMessage", url.Values{"chat_id": {chatId}, "text": {text}}, nil)
+		if err != nil {
+			globalLogger.Esg(err, "telegram notification failed")
+		}
+	}()
+}
@@ -0,0 +1,263 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"res-downloader/core/shared"
+	"strings"
+	"time"
+)
+
+const (
+	TorrentClientQbittorrent  = "qbittorrent"
+	TorrentClientTransmission = "transmission"
+
+	torrentFileLimit = 10 << 20
+)
+
+var magnetRegex = regexp.MustCompile(`(?i)magnet:\?[^\s"'<>]+`)
+
+func isMagnet(rawUrl string) bool {
+	return strings.HasPrefix(strings.ToLower(rawUrl), "magnet:")
+}
+
+func isTorrent(mediaInfo shared.MediaInfo) bool {
+	return mediaInfo.Classify == "torrent" || isMagnet(mediaInfo.Url)
+}
+
+// newMagnetMedia lists a pasted magnet link, its dn parameter becomes the description
+func newMagnetMedia(magnet string) shared.MediaInfo {
+	name := ""
+	if _, query, ok := strings.Cut(magnet, "?"); ok {
+		if values, err := url.ParseQuery(query); err == nil {
+			name = values.Get("dn")
+		}
+	}
+	return newManualMedia(magnet, "torrent", ".torrent", name)
+}
+
+// handoffTorrent gives a magnet or .torrent to the configured BitTorrent client instead of downloading it,
+// the client saves into the directory the resource would have gone to
+func (r *Resource) handoffTorrent(mediaInfo shared.MediaInfo) error {
+	client := strings.ToLower(globalConfig.TorrentClient)
+	if client == "" {
+		return errors.New("no torrent client configured")
+	}
+	var metainfo []byte
+	if !isMagnet(mediaInfo.Url) {
+		var err error
+		if metainfo, err = r.fetchTorrent(mediaInfo); err != nil {
+			return err
+		}
+	}
+
+	dir := saveDirectory(mediaInfo)
+	var err error
+	switch client {
+	case TorrentClientQbittorrent:
+		err = qbittorrentAdd(mediaInfo.Url, metainfo, dir)
+	case TorrentClientTransmission:
+		err = transmissionAdd(mediaInfo.Url, metainfo, dir)
+	default:
+		err = fmt.Errorf("unsupported torrent client: %s", globalConfig.TorrentClient)
+	}
+	if err != nil {
+		return fmt.Errorf("torrent handoff failed: %w", err)
+	}
+	message := "handed off to " + client
+	r.progressEventsEmit(mediaInfo, message, shared.DownloadStatusDone)
+	historyOnce.record(mediaInfo, shared.DownloadStatusDone, message)
+	return nil
+}
+
+// fetchTorrent loads a captured .torrent with the headers it was requested with,
+// trackers often hand them out to logged in users only
+func (r *Resource) fetchTorrent(mediaInfo shared.MediaInfo) ([]byte, error) {
+	headers, _ := r.parseHeaders(mediaInfo)
+	fd := &FileDownloader{Headers: headers, Overrides: r.headerOverrides(mediaInfo.Id)}
+	proxyURL, err := downloadProxy(r.proxyMode(mediaInfo.Id), mediaInfo.Url)
+	if err != nil {
+		return nil, err
+	}
+	fd.ProxyUrl = proxyURL
+	request, err := http.NewRequest(http.MethodGet, mediaInfo.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	fd.setHeaders(request)
+	resp, err := fd.buildClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, torrentFileLimit))
+	if err != nil {
+		return nil, err
+	}
+	// a torrent is a bencoded dictionary
+	if !bytes.HasPrefix(data, []byte("d")) {
+		return nil, errors.New("not a torrent file")
+	}
+	return data, nil
+}
+
+// torrentHttpClient talks to the client directly, never through the system proxy we may have set
+func torrentHttpClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{
+		Transport: &http.Transport{},
+		Jar:       jar,
+		Timeout:   30 * time.Second,
+	}
+}
+
+func torrentBaseUrl(fallback string) string {
+	base := strings.TrimRight(strings.TrimSpace(globalConfig.TorrentUrl), "/")
+	if base == "" {
+		return fallback
+	}
+	return base
+}
+
+// qbittorrentAdd uses the qBittorrent WebUI API v2, without a user the WebUI must allow localhost
+func qbittorrentAdd(magnet string, metainfo []byte, dir string) error {
+	base := torrentBaseUrl("http://127.0.0.1:8080")
+	client := torrentHttpClient()
+	if globalConfig.TorrentUser != "" {
+		form := url.Values{"username": {globalConfig.TorrentUser}, "password": {globalConfig.TorrentPassword}}
+		request, err := http.NewRequest(http.MethodPost, base+"/api/v2/auth/login", strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Referer", base)
+		body, err := torrentDo(client, request)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(body)) != "Ok." {
+			return errors.New("qbittorrent login failed")
+		}
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if metainfo != nil {
+		part, err := writer.CreateFormFile("torrents", "resource.torrent")
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(metainfo); err != nil {
+			return err
+		}
+	} else {
+		_ = writer.WriteField("urls", magnet)
+	}
+	_ = writer.WriteField("savepath", dir)
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, base+"/api/v2/torrents/add", &buf)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Set("Referer", base)
+	body, err := torrentDo(client, request)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) == "Fails." {
+		return errors.New("qbittorrent rejected the torrent")
+	}
+	return nil
+}
+
+// transmissionAdd uses Transmission's RPC, answering its 409 session id handshake
+func transmissionAdd(magnet string, metainfo []byte, dir string) error {
+	endpoint := torrentBaseUrl("http://127.0.0.1:9091/transmission/rpc")
+	if u, err := url.Parse(endpoint); err == nil && (u.Path == "" || u.Path == "/") {
+		endpoint = strings.TrimRight(endpoint, "/") + "/transmission/rpc"
+	}
+	arguments := map[string]interface{}{"download-dir": dir}
+	if metainfo != nil {
+		arguments["metainfo"] = base64.StdEncoding.EncodeToString(metainfo)
+	} else {
+		arguments["filename"] = magnet
+	}
+	payload, err := json.Marshal(map[string]interface{}{"method": "torrent-add", "arguments": arguments})
+	if err != nil {
+		return err
+	}
+
+	client := torrentHttpClient()
+	sessionId := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if sessionId != "" {
+			request.Header.Set("X-Transmission-Session-Id", sessionId)
+		}
+		if globalConfig.TorrentUser != "" {
+			request.SetBasicAuth(globalConfig.TorrentUser, globalConfig.TorrentPassword)
+		}
+		resp, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusConflict {
+			sessionId = resp.Header.Get("X-Transmission-Session-Id")
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return &StatusError{Code: resp.StatusCode}
+		}
+		var result struct {
+			Result string `json:"result"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return err
+		}
+		if result.Result != "success" {
+			return fmt.Errorf("transmission: %s", result.Result)
+		}
+		return nil
+	}
+	return errors.New("transmission session handshake failed")
+}
+
+func torrentDo(client *http.Client, request *http.Request) ([]byte, error) {
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	return body, nil
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"res-downloader/core/shared"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type DomainTraffic struct {
+	Domain   string `json:"Domain"`
+	Requests int64  `json:"Requests"`
+	Connects int64  `json:"Connects"`
+	BytesIn  int64  `json:"BytesIn"`
+	BytesOut int64  `json:"BytesOut"`
+	LastSeen int64  `json:"LastSeen"`
+}
+
+type TrafficStats struct {
+	mu      sync.RWMutex
+	domains map[string]*DomainTraffic
+}
+
+type countingBody struct {
+	io.ReadCloser
+	traffic *DomainTraffic
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&c.traffic.BytesIn, int64(n))
+	}
+	return n, err
+}
+
+func initTraffic() *TrafficStats {
+	if trafficOnce == nil {
+		trafficOnce = &TrafficStats{
+			domains: make(map[string]*DomainTraffic),
+		}
+	}
+	return trafficOnce
+}
+
+func (t *TrafficStats) get(host string) *DomainTraffic {
+	domain := shared.GetTopLevelDomain(host)
+
+	t.mu.RLock()
+	item, ok := t.domains[domain]
+	t.mu.RUnlock()
+	if ok {
+		atomic.StoreInt64(&item.LastSeen, time.Now().Unix())
+		return item
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if item, ok = t.domains[domain]; !ok {
+		item = &DomainTraffic{Domain: domain}
+		t.domains[domain] = item
+	}
+	atomic.StoreInt64(&item.LastSeen, time.Now().Unix())
+	return item
+}
+
+func (t *TrafficStats) connect(host string) {
+	atomic.AddInt64(&t.get(host).Connects, 1)
+}
+
+func (t *TrafficStats) request(r *http.Request) {
+	item := t.get(r.Host)
+	atomic.AddInt64(&item.Requests, 1)
+	if r.ContentLength > 0 {
+		atomic.AddInt64(&item.BytesOut, r.ContentLength)
+	}
+}
+
+func (t *TrafficStats) response(resp *http.Response) {
+	if resp.Body == nil || resp.Body == http.NoBody || upgradedResponse(resp) {
+		return
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, traffic: t.get(resp.Request.Host)}
+}
+
+// upgradedResponse is a websocket or other switched protocol, whose body is the connection the
+// proxy writes to as well; wrapping it hides the writer and breaks the tunnel
+func upgradedResponse(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return true
+	}
+	_, ok := resp.Body.(io.ReadWriteCloser)
+	return ok
+}
+
+func (t *TrafficStats) list() []DomainTraffic {
+	t.mu.RLock()
+	list := make([]DomainTraffic, 0, len(t.domains))
+	for _, item := range t.domains {
+		list = append(list, DomainTraffic{
+			Domain:   item.Domain,
+			Requests: atomic.LoadInt64(&item.Requests),
+			Connects: atomic.LoadInt64(&item.Connects),
+			BytesIn:  atomic.LoadInt64(&item.BytesIn),
+			BytesOut: atomic.LoadInt64(&item.BytesOut),
+			LastSeen: atomic.LoadInt64(&item.LastSeen),
+		})
+	}
+	t.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].BytesIn+list[i].BytesOut > list[j].BytesIn+list[j].BytesOut
+	})
+	return list
+}
+
+func (t *TrafficStats) clear() {
+	t.mu.Lock()
+	t.domains = make(map[string]*DomainTraffic)
+	t.mu.Unlock()
+}
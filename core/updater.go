@@ -0,0 +1,421 @@
+package core
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Update channels
+const (
+	UpdateChannelStable = "stable"
+	UpdateChannelBeta   = "beta" // pre-releases too
+)
+
+// checksum files looked for in a release, and the signature of the first one found
+var updateChecksumNames = []string{"SHA256SUMS", "SHA256SUMS.txt", "checksums.txt"}
+
+// updatePublicKey is the base64 ed25519 key release checksums are signed with. Release builds
+// set it with -ldflags "-X res-downloader/core.updatePublicKey=<key>", a build without one
+// refuses to install updates rather than trust whatever a release lists.
+var updatePublicKey = ""
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	Url  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+type release struct {
+	Tag        string         `json:"tag_name"`
+	Name       string         `json:"name"`
+	Body       string         `json:"body"`
+	Prerelease bool           `json:"prerelease"`
+	Draft      bool           `json:"draft"`
+	Assets     []releaseAsset `json:"assets"`
+}
+
+type UpdateInfo struct {
+	Current   string `json:"Current"`
+	Latest    string `json:"Latest"`
+	Channel   string `json:"Channel"`
+	Available bool   `json:"Available"`
+	Asset     string `json:"Asset"` // empty when the release has no build this platform can swap in
+	Notes     string `json:"Notes"`
+}
+
+// Updater replaces the running executable with a release build. The previous one is kept
+// next to it as <name>.old so a bad update can be rolled back.
+type Updater struct {
+	mu      sync.Mutex
+	running bool
+}
+
+func initUpdater() *Updater {
+	if updaterOnce == nil {
+		updaterOnce = &Updater{}
+	}
+	return updaterOnce
+}
+
+func (u *Updater) client() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Minute,
+		Transport: &http.Transport{
+			Proxy: func(r *http.Request) (*url.URL, error) {
+				return downloadProxy(DownloadProxyDefault, r.URL.String())
+			},
+		},
+	}
+}
+
+// latest finds the newest release of the configured channel
+func (u *Updater) latest() (*release, error) {
+	repo := strings.Trim(globalConfig.UpdateRepo, "/ ")
+	if repo == "" {
+		return nil, errors.New("update repository is not set")
+	}
+	resp, err := u.client().Get("https://api.github.com/repos/" + repo + "/releases?per_page=20")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	var releases []release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, err
+	}
+	var newest *release
+	for i := range releases {
+		r := &releases[i]
+		if r.Draft || r.Prerelease && globalConfig.UpdateChannel != UpdateChannelBeta {
+			continue
+		}
+		if newest == nil || compareVersions(r.Tag, newest.Tag) > 0 {
+			newest = r
+		}
+	}
+	if newest == nil {
+		return nil, errors.New("no release found")
+	}
+	return newest, nil
+}
+
+func (u *Updater) check() (UpdateInfo, error) {
+	info := UpdateInfo{Current: appOnce.Version, Channel: globalConfig.UpdateChannel}
+	r, err := u.latest()
+	if err != nil {
+		return info, err
+	}
+	info.Latest = strings.TrimPrefix(r.Tag, "v")
+	info.Notes = r.Body
+	info.Available = compareVersions(r.Tag, appOnce.Version) > 0
+	if asset := platformAsset(r.Assets); asset != nil {
+		info.Asset = asset.Name
+	}
+	return info, nil
+}
+
+// install downloads, verifies and swaps in the latest build, progress goes out as updateStatus events
+func (u *Updater) install() error {
+	u.mu.Lock()
+	if u.running {
+		u.mu.Unlock()
+		return messageError(MsgUpdateRunning)
+	}
+	u.running = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.running = false
+		u.mu.Unlock()
+	}()
+
+	r, err := u.latest()
+	if err != nil {
+		return err
+	}
+	if compareVersions(r.Tag, appOnce.Version) <= 0 {
+		return messageError(MsgUpdateNone)
+	}
+	asset := platformAsset(r.Assets)
+	if asset == nil {
+		return fmt.Errorf("release %s has no build for %s/%s", r.Tag, runtime.GOOS, runtime.GOARCH)
+	}
+	sums, err := u.checksums(r.Assets)
+	if err != nil {
+		return err
+	}
+	want, ok := sums[asset.Name]
+	if !ok {
+		return fmt.Errorf("%s is not listed in the checksums", asset.Name)
+	}
+
+	exe, err := executablePath()
+	if err != nil {
+		return err
+	}
+	u.status("downloading", asset.Name)
+	next := exe + ".new"
+	sum, err := u.fetch(asset.Url, next)
+	if err != nil {
+		_ = os.Remove(next)
+		return err
+	}
+	u.status("verifying", asset.Name)
+	if !strings.EqualFold(sum, want) {
+		_ = os.Remove(next)
+		return messageError(MsgUpdateChecksumFailed)
+	}
+	if err := swapExecutable(exe, next); err != nil {
+		return err
+	}
+	u.status("installed", strings.TrimPrefix(r.Tag, "v"))
+	return nil
+}
+
+// checksums reads the release's checksum file once its signature checks out against updatePublicKey
+func (u *Updater) checksums(assets []releaseAsset) (map[string]string, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(updatePublicKey))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, messageError(MsgUpdateUnsigned)
+	}
+	var list, signature *releaseAsset
+	for _, name := range updateChecksumNames {
+		for i := range assets {
+			if assets[i].Name == name {
+				list = &assets[i]
+			}
+			if assets[i].Name == name+".sig" {
+				signature = &assets[i]
+			}
+		}
+		if list != nil {
+			break
+		}
+	}
+	if list == nil {
+		return nil, errors.New("the release has no checksum file")
+	}
+	data, err := u.get(list.Url)
+	if err != nil {
+		return nil, err
+	}
+	if signature == nil {
+		return nil, errors.New("the release checksums are not signed")
+	}
+	raw, err := u.get(signature.Url)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		sig = raw
+	}
+	if !ed25519.Verify(publicKey, data, sig) {
+		return nil, errors.New("checksum signature does not match")
+	}
+
+	// "<hex>  <name>" per line, as sha256sum writes them
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+		}
+	}
+	return sums, nil
+}
+
+func (u *Updater) get(rawUrl string) ([]byte, error) {
+	resp, err := u.client().Get(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+}
+
+// fetch saves rawUrl to path and returns its sha256
+func (u *Updater) fetch(rawUrl, path string) (string, error) {
+	resp, err := u.client().Get(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Code: resp.StatusCode}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// rollback puts the executable kept by the last update back
+func (u *Updater) rollback() error {
+	exe, err := executablePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(exe + ".old"); err != nil {
+		return messageError(MsgUpdateNoPrevious)
+	}
+	return swapExecutable(exe, exe+".old")
+}
+
+// installInBackground runs install without holding up the caller, progress and failure go out as updateStatus events
+func (u *Updater) installInBackground() {
+	go func() {
+		if err := u.install(); err != nil {
+			globalLogger.Esg(err, "update failed")
+			u.status("error", err.Error())
+		}
+	}()
+}
+
+func (u *Updater) status(status, message string) {
+	httpServerOnce.send("updateStatus", map[string]string{
+		"Status":  status,
+		"Message": message,
+	})
+}
+
+// swapExecutable moves exe to exe.old and next into its place, undoing the first move if the second fails.
+// A running executable may be renamed on every platform, it is only replaced on disk.
+func swapExecutable(exe, next string) error {
+	old := exe + ".old"
+	backup := exe + ".swap"
+	_ = os.Remove(backup)
+	if err := os.Rename(exe, backup); err != nil {
+		return err
+	}
+	if err := os.Rename(next, exe); err != nil {
+		if rollbackErr := os.Rename(backup, exe); rollbackErr != nil {
+			globalLogger.Esg(rollbackErr, "restore executable failed")
+		}
+		return err
+	}
+	_ = os.Chmod(exe, 0755)
+	_ = os.Remove(old)
+	if err := os.Rename(backup, old); err != nil {
+		globalLogger.Esg(err, "keep previous executable failed")
+	}
+	return nil
+}
+
+// executablePath is the file to replace, the AppImage itself rather than its mounted binary
+func executablePath() (string, error) {
+	if image := os.Getenv("APPIMAGE"); image != "" {
+		return image, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+// platformAsset picks the build for this system. Installers and packages are skipped,
+// only a file that is the executable itself can be swapped in.
+func platformAsset(assets []releaseAsset) *releaseAsset {
+	systems := map[string][]string{
+		"windows": {"windows", "win"},
+		"darwin":  {"darwin", "macos", "mac"},
+		"linux":   {"linux"},
+	}[runtime.GOOS]
+	arches := map[string][]string{
+		"amd64": {"amd64", "x64", "x86_64"},
+		"arm64": {"arm64", "aarch64"},
+	}[runtime.GOARCH]
+	if runtime.GOOS == "darwin" {
+		arches = append(arches, "universal")
+	}
+	for i := range assets {
+		name := strings.ToLower(assets[i].Name)
+		if strings.Contains(name, "installer") || strings.Contains(name, "setup") {
+			continue
+		}
+		switch filepath.Ext(name) {
+		case ".dmg", ".pkg", ".deb", ".rpm", ".msi", ".zip", ".gz", ".xz", ".zst", ".txt", ".sig":
+			continue
+		}
+		if containsAny(name, systems) && containsAny(name, arches) {
+			return &assets[i]
+		}
+	}
+	return nil
+}
+
+func containsAny(s string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions compares dotted versions such as v3.1.3 and 3.2.0-beta.1, a pre-release sorts before its release
+func compareVersions(a, b string) int {
+	a, aPre, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(a), "v"), "-")
+	b, bPre, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(b), "v"), "-")
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x > y {
+				return 1
+			}
+			return -1
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	case aPre > bPre:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -4,7 +4,13 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
+// DialogErr shows message in a dialog, headless runs have no window and only log it: the
+// runtime would end the process over the missing context
 func DialogErr(message string) {
+	if appOnce.ctx == nil {
+		globalLogger.Error().Msg(message)
+		return
+	}
 	_, _ = runtime.MessageDialog(appOnce.ctx, runtime.MessageDialogOptions{
 		Type:          runtime.ErrorDialog,
 		Title:         "Error",
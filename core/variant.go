@@ -0,0 +1,220 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"regexp"
+	"res-downloader/core/shared"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	VariantHighest = "highest"
+	VariantLowest  = "lowest"
+)
+
+// variantParams are the query keys platforms carry the quality in, the rest of the url names the content
+var variantParams = map[string]bool{
+	"br":         true,
+	"bitrate":    true,
+	"ratio":      true,
+	"quality":    true,
+	"qn":         true,
+	"definition": true,
+	"resolution": true,
+}
+
+var heightRegex = regexp.MustCompile(`^(\d{3,4})(?:[pP]\d*)?$`)
+
+// variantSet is one resource captured in several qualities, only the first capture is listed
+type variantSet struct {
+	Id       string
+	Variants []shared.Variant
+}
+
+func parseHeight(value string) int {
+	if m := heightRegex.FindStringSubmatch(strings.TrimSpace(value)); m != nil {
+		height, _ := strconv.Atoi(m[1])
+		return height
+	}
+	return 0
+}
+
+// variantKey strips the quality parameters from the url, urls that only differ in them are one resource
+func variantKey(res shared.MediaInfo) (string, shared.Variant, bool) {
+	variant := shared.Variant{Url: res.Url}
+	u, err := url.Parse(res.Url)
+	if err != nil {
+		return "", variant, false
+	}
+	query := u.Query()
+	var labels []string
+	for key, values := range query {
+		lower := strings.ToLower(key)
+		if !variantParams[lower] {
+			continue
+		}
+		value := values[0]
+		labels = append(labels, value)
+		query.Del(key)
+		if lower == "br" || lower == "bitrate" {
+			variant.Bandwidth, _ = strconv.ParseInt(value, 10, 64)
+		} else if height := parseHeight(value); height > 0 {
+			variant.Height = height
+		}
+	}
+	if len(labels) == 0 {
+		return "", variant, false
+	}
+	sort.Strings(labels)
+	variant.Label = strings.Join(labels, " ")
+	return u.Host + u.Path + "?" + query.Encode(), variant, true
+}
+
+// addVariant returns true when res is another quality of a listed resource.
+// The first capture gets its variant list, WeChat videos list their encoded formats.
+func (r *Resource) addVariant(res *shared.MediaInfo) bool {
+	if formats := res.OtherData["wx_file_formats"]; formats != "" && len(res.Variants) == 0 {
+		for _, format := range strings.Split(formats, "#") {
+			if format != "" {
+				res.Variants = append(res.Variants, shared.Variant{Url: res.Url + "&X-snsvideoflag=" + format, Label: format})
+			}
+		}
+		return false
+	}
+	key, variant, ok := variantKey(*res)
+	if !ok {
+		return false
+	}
+	r.variantMux.Lock()
+	defer r.variantMux.Unlock()
+
+	set, ok := r.variants[key]
+	if !ok {
+		r.variants[key] = &variantSet{Id: res.Id, Variants: []shared.Variant{variant}}
+		res.Variants = []shared.Variant{variant}
+		return false
+	}
+	for _, v := range set.Variants {
+		if v.Url == res.Url {
+			return true
+		}
+	}
+	set.Variants = append(set.Variants, variant)
+	httpServerOnce.send("resourceVariants", map[string]interface{}{
+		"Id":       set.Id,
+		"Variants": set.Variants,
+	})
+	return true
+}
+
+// variantsOf lists the qualities grouped under a listed resource
+func (r *Resource) variantsOf(id string) []shared.Variant {
+	r.variantMux.Lock()
+	defer r.variantMux.Unlock()
+	for _, set := range r.variants {
+		if set.Id == id {
+			return append([]shared.Variant(nil), set.Variants...)
+		}
+	}
+	return nil
+}
+
+func (r *Resource) clearVariants() {
+	r.variantMux.Lock()
+	r.variants = make(map[string]*variantSet)
+	r.variantMux.Unlock()
+}
+
+// listVariants is what the quality picker offers, a master playlist is loaded for its streams
+func (r *Resource) listVariants(mediaInfo shared.MediaInfo) ([]shared.Variant, error) {
+	if isHlsPlaylist(mediaInfo) {
+		headers, _ := r.parseHeaders(mediaInfo)
+		sd := NewPlaylistDownloader(mediaInfo.Url, "", headers)
+		fd := &FileDownloader{Headers: headers, Overrides: r.headerOverrides(mediaInfo.Id)}
+		proxyURL, err := downloadProxy(r.proxyMode(mediaInfo.Id), mediaInfo.Url)
+		if err != nil {
+			return nil, err
+		}
+		fd.ProxyUrl = proxyURL
+		body, err := sd.get(fd.buildClient(), fd, mediaInfo.Url, hlsPlaylistLimit)
+		if err != nil {
+			return nil, err
+		}
+		base, err := url.Parse(mediaInfo.Url)
+		if err != nil {
+			return nil, err
+		}
+		return hlsVariants(base, body), nil
+	}
+	if variants := r.variantsOf(mediaInfo.Id); len(variants) > 0 {
+		return variants, nil
+	}
+	return mediaInfo.Variants, nil
+}
+
+// pickVariant applies a preference: "highest", "lowest" or a height such as "720",
+// which takes the best variant not above it. Heights only rank when every variant has one.
+func pickVariant(variants []shared.Variant, preference string) shared.Variant {
+	sorted := append([]shared.Variant(nil), variants...)
+	byHeight := true
+	for _, variant := range sorted {
+		byHeight = byHeight && variant.Height > 0
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if byHeight && sorted[i].Height != sorted[j].Height {
+			return sorted[i].Height < sorted[j].Height
+		}
+		return sorted[i].Bandwidth < sorted[j].Bandwidth
+	})
+	preference = strings.ToLower(strings.TrimSpace(preference))
+	switch preference {
+	case VariantLowest:
+		return sorted[0]
+	case "", VariantHighest:
+		return sorted[len(sorted)-1]
+	}
+	target := parseHeight(preference)
+	if target <= 0 || !byHeight {
+		return sorted[len(sorted)-1]
+	}
+	best := sorted[0]
+	for _, variant := range sorted {
+		if variant.Height <= target {
+			best = variant
+		}
+	}
+	return best
+}
+
+// hlsVariants lists the streams of a master playlist, nil for a media playlist
+func hlsVariants(base *url.URL, body []byte) []shared.Variant {
+	var variants []shared.Variant
+	var pending *shared.Variant
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := hlsAttributes(line)
+			pending = &shared.Variant{Label: attrs["RESOLUTION"]}
+			pending.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			if _, height, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+				pending.Height, _ = strconv.Atoi(height)
+			}
+			if pending.Label == "" {
+				pending.Label = strconv.FormatInt(pending.Bandwidth/1000, 10) + "kbps"
+			}
+		case line != "" && !strings.HasPrefix(line, "#") && pending != nil:
+			if u, err := base.Parse(line); err == nil {
+				pending.Url = u.String()
+				variants = append(variants, *pending)
+			}
+			pending = nil
+		}
+	}
+	return variants
+}
@@ -0,0 +1,446 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	VaultModeKeychain   = "keychain"   // the key is kept by the system keychain
+	VaultModePassphrase = "passphrase" // the key is derived from a passphrase asked for at startup
+
+	// vaultPassphraseEnv unlocks a passphrase vault without asking, for headless runs
+	vaultPassphraseEnv = "RES_DOWNLOADER_VAULT_PASSPHRASE"
+)
+
+// vaultKeys are the config fields kept in the vault once it is set up, config.json holds them empty
+var vaultKeys = []string{
+	"UpstreamProxy", "ProxyList", "WebdavPassword", "S3AccessKey", "S3SecretKey",
+	"Aria2Secret", "TorrentPassword", "TelegramToken", "Webhooks",
+}
+
+// vaultFile is UserDir/vault.json, Data is the AES-GCM sealed entries
+type vaultFile struct {
+	Mode       string `json:"mode"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Data       []byte `json:"data"`
+}
+
+type vaultEntry struct {
+	Value   json.RawMessage `json:"value"` // the config field as JSON
+	Updated int64           `json:"updated"`
+}
+
+// VaultStatus is what the settings page shows, the values never leave the vault
+type VaultStatus struct {
+	Mode     string           `json:"Mode"` // empty while credentials are kept in config.json
+	Locked   bool             `json:"Locked"`
+	Keychain bool             `json:"Keychain"` // a system keychain is available
+	Entries  []VaultEntryInfo `json:"Entries"`
+}
+
+type VaultEntryInfo struct {
+	Name    string `json:"Name"`
+	Set     bool   `json:"Set"`
+	Updated int64  `json:"Updated"` // unix seconds
+}
+
+// Vault keeps credentials out of config.json. Once set up the fields in vaultKeys and the cookie jar
+// are sealed under a key held by the system keychain or derived from a passphrase.
+type Vault struct {
+	mu      sync.Mutex
+	file    vaultFile
+	key     []byte // nil while locked
+	entries map[string]vaultEntry
+}
+
+func initVault() *Vault {
+	if vaultOnce == nil {
+		vaultOnce = &Vault{entries: map[string]vaultEntry{}}
+		if err := vaultOnce.load(); err != nil {
+			globalLogger.Esg(err, "open vault failed, credentials stay empty until it is unlocked")
+		}
+	}
+	return vaultOnce
+}
+
+func (v *Vault) path() string {
+	return filepath.Join(appOnce.UserDir, "vault.json")
+}
+
+// load reads vault.json and opens it when the key is at hand, the credentials go straight into
+// globalConfig as nothing that uses them runs yet
+func (v *Vault) load() error {
+	data, err := os.ReadFile(v.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &v.file); err != nil {
+		return err
+	}
+	var key []byte
+	switch v.file.Mode {
+	case VaultModeKeychain:
+		if key, err = keychainGet(); err != nil {
+			return err
+		}
+	case VaultModePassphrase:
+		passphrase := os.Getenv(vaultPassphraseEnv)
+		if passphrase == "" {
+			return nil
+		}
+		key = pbkdf2.Key([]byte(passphrase), v.file.Salt, v.file.Iterations, 32, sha256.New)
+	default:
+		return messageError(MsgSettingsInvalid)
+	}
+	if err := v.open(key); err != nil {
+		return err
+	}
+	return json.Unmarshal(v.values(), globalConfig)
+}
+
+// open unseals the entries with key, a wrong key leaves the vault locked
+func (v *Vault) open(key []byte) error {
+	plain, err := unsealWith(key, v.file.Data)
+	if err != nil {
+		return messageError(MsgPassphraseWrong)
+	}
+	entries := map[string]vaultEntry{}
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return err
+	}
+	v.key, v.entries = key, entries
+	return nil
+}
+
+// save seals the entries into vault.json, callers hold mu
+func (v *Vault) save() error {
+	plain, err := json.Marshal(v.entries)
+	if err != nil {
+		return err
+	}
+	if v.file.Data, err = sealWith(v.key, plain); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(v.path(), data, 0600)
+}
+
+// values are the entries as a partial config
+func (v *Vault) values() []byte {
+	fields := map[string]json.RawMessage{}
+	for name, entry := range v.entries {
+		fields[name] = entry.Value
+	}
+	data, _ := json.Marshal(fields)
+	return data
+}
+
+func (v *Vault) enabled() bool {
+	if v == nil {
+		return false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.file.Mode != ""
+}
+
+// strip is what config.json gets: with the vault set up the credentials are moved into it and left
+// empty in the file. A locked vault keeps what it has, nothing typed meanwhile can be saved.
+func (v *Vault) strip(c *Config) ([]byte, error) {
+	raw, err := json.Marshal(c)
+	if err != nil || v == nil {
+		return raw, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.file.Mode == "" {
+		return raw, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	blank := map[string]json.RawMessage{}
+	changed := false
+	for _, name := range vaultKeys {
+		value := fields[name]
+		if bytes.HasPrefix(value, []byte("[")) {
+			blank[name] = json.RawMessage("[]")
+		} else {
+			blank[name] = json.RawMessage(`""`)
+		}
+		if v.key == nil {
+			if !emptyJson(value) {
+				globalLogger.Warn().Msgf("the vault is locked, %s is not saved", name)
+			}
+			continue
+		}
+		if emptyJson(value) {
+			if _, ok := v.entries[name]; ok {
+				delete(v.entries, name)
+				changed = true
+			}
+		} else if !bytes.Equal(v.entries[name].Value, value) {
+			v.entries[name] = vaultEntry{Value: value, Updated: time.Now().Unix()}
+			changed = true
+		}
+	}
+	if changed {
+		if err := v.save(); err != nil {
+			return nil, err
+		}
+	}
+	config, err := c.clone()
+	if err != nil {
+		return nil, err
+	}
+	data, _ := json.Marshal(blank)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return json.Marshal(config)
+}
+
+func emptyJson(value json.RawMessage) bool {
+	switch string(value) {
+	case "", `""`, "null", "[]":
+		return true
+	}
+	return false
+}
+
+func (v *Vault) status() VaultStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	status := VaultStatus{
+		Mode:     v.file.Mode,
+		Locked:   v.file.Mode != "" && v.key == nil,
+		Keychain: keychainAvailable(),
+		Entries:  make([]VaultEntryInfo, 0, len(vaultKeys)),
+	}
+	for _, name := range vaultKeys {
+		entry, ok := v.entries[name]
+		status.Entries = append(status.Entries, VaultEntryInfo{Name: name, Set: ok, Updated: entry.Updated})
+	}
+	return status
+}
+
+// unlock opens a passphrase vault and applies its credentials, the cookie jar is read again
+func (v *Vault) unlock(passphrase string) error {
+	v.mu.Lock()
+	if v.file.Mode != VaultModePassphrase || v.key != nil {
+		v.mu.Unlock()
+		return nil
+	}
+	if passphrase == "" {
+		v.mu.Unlock()
+		return messageError(MsgVaultPassphrase)
+	}
+	err := v.open(pbkdf2.Key([]byte(passphrase), v.file.Salt, v.file.Iterations, 32, sha256.New))
+	values := v.values()
+	v.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := v.apply(values); err != nil {
+		return err
+	}
+	cookieOnce.load()
+	return nil
+}
+
+// apply sets credentials through setConfig, so what depends on them follows
+func (v *Vault) apply(values []byte) error {
+	config, err := globalConfig.clone()
+	if err != nil {
+		return err
+	}
+	if values != nil {
+		if err := json.Unmarshal(values, &config); err != nil {
+			return err
+		}
+	}
+	globalConfig.setConfig(config)
+	return nil
+}
+
+// setup moves the credentials into a new vault, or rekeys the open one for another mode or passphrase
+func (v *Vault) setup(mode, passphrase string) error {
+	var key []byte
+	file := vaultFile{Mode: mode}
+	switch mode {
+	case VaultModeKeychain:
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+	case VaultModePassphrase:
+		if passphrase == "" {
+			return messageError(MsgVaultPassphrase)
+		}
+		file.Salt = make([]byte, 16)
+		if _, err := rand.Read(file.Salt); err != nil {
+			return err
+		}
+		file.Iterations = bundleIterations
+		key = pbkdf2.Key([]byte(passphrase), file.Salt, file.Iterations, 32, sha256.New)
+	default:
+		return messageError(MsgVaultMode, mode)
+	}
+
+	v.mu.Lock()
+	if v.file.Mode != "" && v.key == nil {
+		v.mu.Unlock()
+		return messageError(MsgVaultLocked)
+	}
+	if mode == VaultModeKeychain {
+		if err := keychainSet(key); err != nil {
+			v.mu.Unlock()
+			return messageError(MsgKeychainUnavailable, err.Error())
+		}
+	} else if v.file.Mode == VaultModeKeychain {
+		_ = keychainDelete()
+	}
+	v.file, v.key = file, key
+	err := v.save()
+	v.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// config.json is written again without the credentials, strip takes them into the vault
+	if err := v.apply(nil); err != nil {
+		return err
+	}
+	cookieOnce.save()
+	return nil
+}
+
+// disable puts the credentials back into config.json and removes the vault
+func (v *Vault) disable() error {
+	v.mu.Lock()
+	if v.file.Mode == "" {
+		v.mu.Unlock()
+		return nil
+	}
+	if v.key == nil {
+		v.mu.Unlock()
+		return messageError(MsgVaultLocked)
+	}
+	if v.file.Mode == VaultModeKeychain {
+		_ = keychainDelete()
+	}
+	v.file, v.key, v.entries = vaultFile{}, nil, map[string]vaultEntry{}
+	err := os.Remove(v.path())
+	v.mu.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := v.apply(nil); err != nil {
+		return err
+	}
+	cookieOnce.save()
+	return nil
+}
+
+// set changes one credential, value is the text of a string field and JSON for Webhooks.
+// An empty value removes it.
+func (v *Vault) set(name, value string) error {
+	v.mu.Lock()
+	mode, locked := v.file.Mode, v.key == nil
+	v.mu.Unlock()
+	if mode == "" {
+		return messageError(MsgVaultDisabled)
+	}
+	if locked {
+		return messageError(MsgVaultLocked)
+	}
+	known := false
+	for _, key := range vaultKeys {
+		known = known || key == name
+	}
+	if !known {
+		return messageError(MsgVaultUnknownEntry, name)
+	}
+	raw := json.RawMessage(value)
+	if name == "Webhooks" {
+		if value == "" {
+			raw = json.RawMessage("[]")
+		} else if !json.Valid(raw) {
+			return messageError(MsgSettingsInvalid)
+		}
+	} else {
+		raw, _ = json.Marshal(value)
+	}
+	values, _ := json.Marshal(map[string]json.RawMessage{name: raw})
+	return v.apply(values)
+}
+
+// seal encrypts with the vault key, for the cookie jar
+func (v *Vault) seal(plain []byte) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return nil, messageError(MsgVaultLocked)
+	}
+	return sealWith(v.key, plain)
+}
+
+func (v *Vault) unseal(sealed []byte) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.key == nil {
+		return nil, messageError(MsgVaultLocked)
+	}
+	return unsealWith(v.key, sealed)
+}
+
+// sealWith is AES-GCM with the nonce in front
+func sealWith(key, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func unsealWith(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
@@ -0,0 +1,56 @@
+//go:build darwin
+
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	keychainService = "res-downloader"
+	keychainAccount = "vault"
+)
+
+// the login keychain through the security tool, the key is stored hex encoded
+
+func keychainAvailable() bool {
+	_, err := exec.LookPath("security")
+	return err == nil
+}
+
+func keychainGet() ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w").Output()
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// keychainSet hands the command to "security -i" on stdin, on the command line the key would
+// show up in ps
+func keychainSet(key []byte) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -l \"res-downloader vault\" -w %s\n",
+		keychainService, keychainAccount, hex.EncodeToString(key)))
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	// interactive mode exits 0 whatever the command did, reading the key back is the check
+	stored, err := keychainGet()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored, key) {
+		return errors.New("the keychain did not take the key")
+	}
+	return nil
+}
+
+func keychainDelete() error {
+	return exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", keychainAccount).Run()
+}
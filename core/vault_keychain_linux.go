@@ -0,0 +1,39 @@
+//go:build linux
+
+package core
+
+import (
+	"encoding/hex"
+	"os/exec"
+	"strings"
+)
+
+const (
+	keychainService = "res-downloader"
+	keychainAccount = "vault"
+)
+
+// the Secret Service (GNOME Keyring, KWallet) through secret-tool, the key is stored hex encoded
+
+func keychainAvailable() bool {
+	_, err := exec.LookPath("secret-tool")
+	return err == nil
+}
+
+func keychainGet() ([]byte, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount).Output()
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func keychainSet(key []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label=res-downloader vault", "service", keychainService, "account", keychainAccount)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(key))
+	return cmd.Run()
+}
+
+func keychainDelete() error {
+	return exec.Command("secret-tool", "clear", "service", keychainService, "account", keychainAccount).Run()
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !windows
+
+package core
+
+import "errors"
+
+func keychainAvailable() bool {
+	return false
+}
+
+func keychainGet() ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func keychainSet(key []byte) error {
+	return errors.ErrUnsupported
+}
+
+func keychainDelete() error {
+	return errors.ErrUnsupported
+}
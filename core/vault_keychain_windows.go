@@ -0,0 +1,60 @@
+//go:build windows
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// the key is protected with DPAPI for the current user and kept in UserDir/vault.key
+
+func keychainFile() string {
+	return filepath.Join(appOnce.UserDir, "vault.key")
+}
+
+func keychainAvailable() bool {
+	return true
+}
+
+func keychainGet() ([]byte, error) {
+	data, err := os.ReadFile(keychainFile())
+	if err != nil {
+		return nil, err
+	}
+	return dpapi(data, false)
+}
+
+func keychainSet(key []byte) error {
+	data, err := dpapi(key, true)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(keychainFile(), data, 0600)
+}
+
+func keychainDelete() error {
+	return os.Remove(keychainFile())
+}
+
+func dpapi(data []byte, protect bool) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, windows.ERROR_INVALID_DATA
+	}
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	var err error
+	if protect {
+		err = windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	} else {
+		err = windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte{}, unsafe.Slice(out.Data, out.Size)...), nil
+}
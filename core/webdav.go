@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"res-downloader/core/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebdavDestination uploads to a WebDAV share such as Synology or Nextcloud
+type WebdavDestination struct {
+	base     string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func newWebdavDestination() *WebdavDestination {
+	return &WebdavDestination{
+		base:     strings.TrimRight(globalConfig.WebdavUrl, "/"),
+		user:     globalConfig.WebdavUser,
+		password: globalConfig.WebdavPassword,
+		client:   &http.Client{Timeout: 0},
+	}
+}
+
+func (d *WebdavDestination) Name() string {
+	return "webdav"
+}
+
+func (d *WebdavDestination) remoteUrl(remotePath string) string {
+	parts := strings.Split(strings.Trim(remotePath, "/"), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return d.base + "/" + strings.Join(parts, "/")
+}
+
+func (d *WebdavDestination) do(ctx context.Context, method, target string, body io.Reader, size int64, header map[string]string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		request.ContentLength = size
+	}
+	if d.user != "" {
+		request.SetBasicAuth(d.user, d.password)
+	}
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	return d.client.Do(request)
+}
+
+func (d *WebdavDestination) Upload(ctx context.Context, mediaInfo shared.MediaInfo, remotePath string) error {
+	if err := d.mkdirs(ctx, path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	file, err := os.Open(mediaInfo.SavePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.do(ctx, http.MethodPut, d.remoteUrl(remotePath), file, info.Size(), nil)
+	if err != nil {
+		return fmt.Errorf("webdav put failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webdav put failed: %s", resp.Status)
+	}
+
+	remoteSize, err := d.size(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("webdav verify failed: %w", err)
+	}
+	if remoteSize != info.Size() {
+		return fmt.Errorf("webdav verify failed: remote size %d, local %d", remoteSize, info.Size())
+	}
+	return nil
+}
+
+// mkdirs creates every collection on the way, existing ones answer 405
+func (d *WebdavDestination) mkdirs(ctx context.Context, dir string) error {
+	dir = strings.Trim(dir, "/.")
+	if dir == "" {
+		return nil
+	}
+	current := ""
+	for _, part := range strings.Split(dir, "/") {
+		current += "/" + part
+		resp, err := d.do(ctx, "MKCOL", d.remoteUrl(current)+"/", nil, -1, nil)
+		if err != nil {
+			return fmt.Errorf("webdav mkcol failed: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("webdav mkcol %s failed: %s", current, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (d *WebdavDestination) size(ctx context.Context, remotePath string) (int64, error) {
+	body := `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/></d:prop></d:propfind>`
+	resp, err := d.do(ctx, "PROPFIND", d.remoteUrl(remotePath), strings.NewReader(body), int64(len(body)), map[string]string{
+		"Depth":        "0",
+		"Content-Type": "application/xml",
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("propfind: %s", resp.Status)
+	}
+
+	var result struct {
+		Responses []struct {
+			Length string `xml:"propstat>prop>getcontentlength"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	for _, r := range result.Responses {
+		if r.Length != "" {
+			return strconv.ParseInt(strings.TrimSpace(r.Length), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no content length in propfind response")
+}
+
+// webdavCheck verifies the configured share is reachable with the credentials
+func webdavCheck() error {
+	d := newWebdavDestination()
+	d.client.Timeout = 15 * time.Second
+	resp, err := d.do(context.Background(), "PROPFIND", d.base+"/", nil, -1, map[string]string{"Depth": "0"})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("webdav: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"res-downloader/core/shared"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	WebhookCaptured = "captured"
+	WebhookDone     = "done"
+	WebhookError    = "error"
+
+	webhookAttempts = 3
+)
+
+// Webhook posts to Url when one of its Events happens to a resource that passes the filter.
+// Template is a text/template of the body with .Event, .Message, .Time and .Resource,
+// the event as JSON is sent when it is empty.
+type Webhook struct {
+	Url      string `json:"Url"`
+	Events   string `json:"Events"` // comma separated, captured, done and error
+	Template string `json:"Template"`
+	resourceFilter
+}
+
+type webhookEvent struct {
+	Event    string           `json:"Event"`
+	Message  string           `json:"Message"`
+	Time     int64            `json:"Time"`
+	Resource shared.MediaInfo `json:"Resource"`
+}
+
+var webhookFuncs = template.FuncMap{
+	// json writes a value as a JSON literal, for strings inside a JSON template
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func (w Webhook) wants(event string) bool {
+	for _, e := range strings.Split(w.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// fireWebhooks sends event to every matching Config.Webhooks entry in the background
+func fireWebhooks(event string, mediaInfo shared.MediaInfo, message string) {
+	if len(globalConfig.Webhooks) == 0 {
+		return
+	}
+	data := webhookEvent{Event: event, Message: message, Time: time.Now().Unix(), Resource: webhookResource(mediaInfo)}
+	for _, hook := range globalConfig.Webhooks {
+		if hook.Url == "" || !hook.wants(event) {
+			continue
+		}
+		if err := hook.compile(); err != nil {
+			globalLogger.Esg(err, "webhook %s has an invalid filter", hook.Url)
+			continue
+		}
+		if !hook.matches(mediaInfo) {
+			continue
+		}
+		go hook.send(data)
+	}
+}
+
+// webhookResource is mediaInfo without the request headers it was captured with, their cookies
+// and authorization are not for a third party
+func webhookResource(mediaInfo shared.MediaInfo) shared.MediaInfo {
+	if _, ok := mediaInfo.OtherData["headers"]; !ok {
+		return mediaInfo
+	}
+	otherData := make(map[string]string, len(mediaInfo.OtherData))
+	for key, value := range mediaInfo.OtherData {
+		if key != "headers" {
+			otherData[key] = value
+		}
+	}
+	mediaInfo.OtherData = otherData
+	return mediaInfo
+}
+
+func (w Webhook) send(data webhookEvent) {
+	body, contentType, err := w.render(data)
+	if err != nil {
+		globalLogger.Esg(err, "render webhook %s failed", w.Url)
+		return
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		resp, err := client.Post(w.Url, contentType, bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = &StatusError{Code: resp.StatusCode}
+		}
+		if attempt == webhookAttempts {
+			globalLogger.Esg(err, "webhook %s failed", w.Url)
+			return
+		}
+		time.Sleep(time.Duration(attempt) * 5 * time.Second)
+	}
+}
+
+func (w Webhook) render(data webhookEvent) ([]byte, string, error) {
+	if strings.TrimSpace(w.Template) == "" {
+		body, err := json.Marshal(data)
+		return body, "application/json", err
+	}
+	tpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(w.Template)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return nil, "", err
+	}
+	contentType := "text/plain; charset=utf-8"
+	if json.Valid(buf.Bytes()) {
+		contentType = "application/json"
+	}
+	return buf.Bytes(), contentType, nil
+}
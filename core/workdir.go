@@ -0,0 +1,117 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Intermediate files (ffmpeg output before it is moved into place, frames read by OCR) are
+// written to the work directory instead of next to the download, so a read-only or synced
+// download folder never sees them. Everything there is named with workPrefix, which is all a
+// sweep removes when the directory is shared, e.g. /tmp.
+const workPrefix = "rd-work-"
+
+// workSweepAge keeps the startup sweep off what another running instance is still writing
+const workSweepAge = time.Hour
+
+// workDirectory is Config.WorkDirectory, or the work folder in the user directory
+func workDirectory() string {
+	if globalConfig.WorkDirectory != "" {
+		return globalConfig.WorkDirectory
+	}
+	return filepath.Join(appOnce.UserDir, "work")
+}
+
+// workFile reserves a unique file name ending in suffix in the work directory, the caller
+// removes it once done with it
+func workFile(suffix string) (string, error) {
+	dir := workDirectory()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("create work directory failed: %w", err)
+	}
+	file, err := os.CreateTemp(dir, workPrefix+"*"+suffix)
+	if err != nil {
+		return "", fmt.Errorf("create work file failed: %w", err)
+	}
+	name := file.Name()
+	_ = file.Close()
+	return name, nil
+}
+
+// workFolder is a unique empty folder in the work directory, the caller removes it with its content
+func workFolder() (string, error) {
+	dir := workDirectory()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("create work directory failed: %w", err)
+	}
+	folder, err := os.MkdirTemp(dir, workPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("create work folder failed: %w", err)
+	}
+	return folder, nil
+}
+
+// moveWorkFile puts a finished work file at target. When it can't be renamed there, as across
+// drives, it is copied to a part file next to target first, so target only ever appears complete.
+func moveWorkFile(name, target string) error {
+	// CreateTemp made it owner-only, a download is readable like any other file
+	if err := os.Chmod(name, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(name, target); err == nil {
+		return nil
+	}
+	part := target + partSuffix
+	if err := copyWorkFile(name, part); err != nil {
+		_ = os.Remove(part)
+		return err
+	}
+	if err := os.Rename(part, target); err != nil {
+		_ = os.Remove(part)
+		return err
+	}
+	_ = os.Remove(name)
+	return nil
+}
+
+func copyWorkFile(name, target string) error {
+	source, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, source); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// sweepWorkDirectory removes what a session that crashed or was killed left in the work directory
+func sweepWorkDirectory() {
+	dir := workDirectory()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), workPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < workSweepAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			globalLogger.Warn().Msgf("remove work file %s failed: %v", entry.Name(), err)
+		}
+	}
+}
@@ -79,6 +79,149 @@ export default {
             }
         })
     },
+    searchResources(data: object) {
+        return request({
+            url: 'api/resource-search',
+            method: 'post',
+            data: data
+        })
+    },
+    sessions() {
+        return request({
+            url: 'api/sessions',
+            method: 'post'
+        })
+    },
+    saveSession(data: object) {
+        return request({
+            url: 'api/session-save',
+            method: 'post',
+            data: data
+        })
+    },
+    restoreSession(data: object) {
+        return request({
+            url: 'api/session-restore',
+            method: 'post',
+            data: data
+        })
+    },
+    deleteSession(data: object) {
+        return request({
+            url: 'api/session-delete',
+            method: 'post',
+            data: data
+        })
+    },
+    vault() {
+        return request({
+            url: 'api/vault',
+            method: 'post'
+        })
+    },
+    vaultUnlock(data: object) {
+        return request({
+            url: 'api/vault-unlock',
+            method: 'post',
+            data: data
+        })
+    },
+    stats(data: object) {
+        return request({
+            url: 'api/stats',
+            method: 'post',
+            data: data
+        })
+    },
+    statsExport(data: object) {
+        return request({
+            url: 'api/stats-export',
+            method: 'post',
+            data: data
+        })
+    },
+    cleanup(data: object) {
+        return request({
+            url: 'api/cleanup',
+            method: 'post',
+            data: data
+        })
+    },
+    markResource(data: object) {
+        return request({
+            url: 'api/resource-mark',
+            method: 'post',
+            data: data
+        })
+    },
+    resourceGroup(data: object) {
+        return request({
+            url: 'api/resource-group',
+            method: 'post',
+            data: data
+        })
+    },
+    groupDownload(data: object) {
+        return request({
+            url: 'api/group-download',
+            method: 'post',
+            data: data
+        })
+    },
+    imageSet(data: object) {
+        return request({
+            url: 'api/image-set',
+            method: 'post',
+            data: data
+        })
+    },
+    series(data: object) {
+        return request({
+            url: 'api/series',
+            method: 'post',
+            data: data
+        })
+    },
+    seriesDownload(data: object) {
+        return request({
+            url: 'api/series-download',
+            method: 'post',
+            data: data
+        })
+    },
+    liveRooms() {
+        return request({
+            url: 'api/live-rooms',
+            method: 'post'
+        })
+    },
+    liveCheck() {
+        return request({
+            url: 'api/live-check',
+            method: 'post'
+        })
+    },
+    transcript(data: object) {
+        return request({
+            url: 'api/transcript',
+            method: 'post',
+            data: data
+        })
+    },
+    clipExport(data: object) {
+        return request({
+            url: 'api/clip-export',
+            method: 'post',
+            data: data
+        })
+    },
+    notesExport(data: object) {
+        return request({
+            url: 'api/notes-export',
+            method: 'post',
+            data: data
+        })
+    },
     clear() {
         return request({
             url: 'api/clear',
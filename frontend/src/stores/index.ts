@@ -21,12 +21,19 @@ export const useIndexStore = defineStore("index-store", () => {
         Port: "8899",
         Quality: 0,
         SaveDirectory: "",
+        WorkDirectory: "",
         UpstreamProxy: "",
         FilenameLen: 0,
         FilenameTime: false,
         OpenProxy: false,
         DownloadProxy: false,
         AutoProxy: false,
+        LaunchAtLogin: false,
+        ApiEnable: false,
+        ApiListen: "",
+        ApiToken: "",
+        GrpcEnable: false,
+        GrpcListen: "",
         WxAction: false,
         TaskNumber: 8,
         DownNumber: 3,
@@ -66,7 +73,13 @@ export const useIndexStore = defineStore("index-store", () => {
 
     const setConfig = (formValue: Object) => {
         globalConfig.value = Object.assign({}, globalConfig.value, formValue)
-        appApi.setConfig(globalConfig.value)
+        // the binding, unlike the http api, may change window only settings
+        bind.SetConfig(globalConfig.value).then((res: core.ResponseData) => {
+            // enabling the api makes up its token
+            if (res.code === 1) {
+                globalConfig.value.ApiToken = res.data.ApiToken
+            }
+        })
     }
 
     const openProxy = async () => {
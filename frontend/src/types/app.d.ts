@@ -18,12 +18,19 @@ export namespace appType {
         Port: string
         Quality: number
         SaveDirectory: string
+        WorkDirectory: string
         FilenameLen: number
         FilenameTime: boolean
         UpstreamProxy: string
         OpenProxy: boolean
         DownloadProxy: boolean
         AutoProxy: boolean
+        LaunchAtLogin: boolean
+        ApiEnable: boolean
+        ApiListen: string
+        ApiToken: string
+        GrpcEnable: boolean
+        GrpcListen: string
         WxAction: boolean
         TaskNumber: number
         DownNumber: number
@@ -51,11 +58,92 @@ export namespace appType {
         OtherData: { [key: string]: string }
     }
 
+    interface SessionSummary {
+        Name: string
+        Time: number
+        Resources: number
+        Pending: number
+    }
+
+    interface DuplicateContent {
+        Id: string
+        Path: string
+        Of: string
+        Similar: boolean
+        Action: string
+    }
+
+    interface ImageSet {
+        Group: string
+        Path: string
+        Images: number
+        Missing: number
+        Error: string
+    }
+
+    interface Episode {
+        Index: number
+        Title: string
+        Url: string
+        Cover: string
+    }
+
+    interface Series {
+        PreviewId: string
+        Title: string
+        Source: string
+        Pages: number
+        Total: number
+        Episodes?: Episode[]
+    }
+
+    interface LiveRoom {
+        Url: string
+        Name: string
+        Live: boolean
+        Recording: string
+        Checked: number
+        Error: string
+    }
+
+    interface Cue {
+        Index: number
+        Start: number
+        End: number
+        Text: string
+    }
+
+    interface DailyStats {
+        Day: string
+        Platform: string
+        Captures: number
+        Downloads: number
+        Failed: number
+        Bytes: number
+    }
+
+    interface Stats {
+        Captures: number
+        Downloads: number
+        Failed: number
+        Bytes: number
+        Daily: DailyStats[]
+        TopDomains: { Domain: string, Captures: number, Downloads: number, Bytes: number }[]
+    }
+
+    interface VaultStatus {
+        Mode: string
+        Locked: boolean
+        Keychain: boolean
+        Entries: { Name: string, Set: boolean, Updated: number }[]
+    }
+
     interface DownloadProgress {
         Id: string
         SavePath: string
         Status: string
         Message: string
+        MessageCode?: string
     }
 
     interface Message {
@@ -71,6 +159,7 @@ export namespace appType {
     interface Res<T = any> {
         code: number;
         message: string;
+        messageCode?: string;
         data: T;  // T will be the specific type of your data
     }
 }
\ No newline at end of file
@@ -6,4 +6,24 @@ export function AppInfo():Promise<core.ResponseData>;
 
 export function Config():Promise<core.ResponseData>;
 
+export function CopyRequest(arg1:string,arg2:string):Promise<core.ResponseData>;
+
+export function ResetApiToken():Promise<core.ResponseData>;
+
 export function ResetApp():Promise<void>;
+
+export function Resources():Promise<core.ResponseData>;
+
+export function SetConfig(arg1:Record<string, any>):Promise<core.ResponseData>;
+
+export function SettingsExport(arg1:string):Promise<core.ResponseData>;
+
+export function SettingsImport(arg1:string):Promise<core.ResponseData>;
+
+export function UpdateInstall():Promise<core.ResponseData>;
+
+export function VaultDisable():Promise<core.ResponseData>;
+
+export function VaultSet(arg1:string,arg2:string):Promise<core.ResponseData>;
+
+export function VaultSetup(arg1:string,arg2:string):Promise<core.ResponseData>;
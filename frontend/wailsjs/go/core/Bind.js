@@ -10,6 +10,46 @@ export function Config() {
   return window['go']['core']['Bind']['Config']();
 }
 
+export function CopyRequest(arg1,arg2) {
+  return window['go']['core']['Bind']['CopyRequest'](arg1,arg2);
+}
+
+export function ResetApiToken() {
+  return window['go']['core']['Bind']['ResetApiToken']();
+}
+
 export function ResetApp() {
   return window['go']['core']['Bind']['ResetApp']();
 }
+
+export function Resources() {
+  return window['go']['core']['Bind']['Resources']();
+}
+
+export function SetConfig(arg1) {
+  return window['go']['core']['Bind']['SetConfig'](arg1);
+}
+
+export function SettingsExport(arg1) {
+  return window['go']['core']['Bind']['SettingsExport'](arg1);
+}
+
+export function SettingsImport(arg1) {
+  return window['go']['core']['Bind']['SettingsImport'](arg1);
+}
+
+export function UpdateInstall() {
+  return window['go']['core']['Bind']['UpdateInstall']();
+}
+
+export function VaultDisable() {
+  return window['go']['core']['Bind']['VaultDisable']();
+}
+
+export function VaultSet(arg1,arg2) {
+  return window['go']['core']['Bind']['VaultSet'](arg1,arg2);
+}
+
+export function VaultSetup(arg1,arg2) {
+  return window['go']['core']['Bind']['VaultSetup'](arg1,arg2);
+}
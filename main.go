@@ -9,6 +9,7 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/options/mac"
 	"github.com/wailsapp/wails/v2/pkg/options/windows"
 	"log"
+	"os"
 	"res-downloader/core"
 	"runtime"
 
@@ -27,6 +28,11 @@ var icon []byte
 var wailsJson string
 
 func main() {
+	defer core.CaptureCrash()
+	if len(os.Args) > 1 && core.IsCliCommand(os.Args[1]) {
+		os.Exit(core.RunCli(assets, wailsJson, os.Args[1:]))
+	}
+
 	// Create an instance of the app structure
 	app := core.GetApp(assets, wailsJson)
 	bind := core.NewBind()
@@ -66,6 +72,9 @@ func main() {
 			fmt.Println("lockfile:", app.LockFile)
 			app.Startup(ctx)
 		},
+		OnDomReady: func(ctx context.Context) {
+			app.DomReady(ctx)
+		},
 		OnShutdown: func(ctx context.Context) {
 			app.OnExit()
 		},
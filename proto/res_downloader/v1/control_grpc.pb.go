@@ -0,0 +1,592 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: res_downloader/v1/control.proto
+
+// Control api of res-downloader, the typed counterpart of the REST api in core/openapi.json.
+// Messages follow the JSON the REST api returns, field names are the Go ones in snake case.
+
+package controlv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Control_GetCaptureState_FullMethodName = "/res_downloader.v1.Control/GetCaptureState"
+	Control_SetSystemProxy_FullMethodName  = "/res_downloader.v1.Control/SetSystemProxy"
+	Control_ClearResources_FullMethodName  = "/res_downloader.v1.Control/ClearResources"
+	Control_ListResources_FullMethodName   = "/res_downloader.v1.Control/ListResources"
+	Control_WatchResources_FullMethodName  = "/res_downloader.v1.Control/WatchResources"
+	Control_ListDownloads_FullMethodName   = "/res_downloader.v1.Control/ListDownloads"
+	Control_EnqueueDownload_FullMethodName = "/res_downloader.v1.Control/EnqueueDownload"
+	Control_CancelDownload_FullMethodName  = "/res_downloader.v1.Control/CancelDownload"
+	Control_PauseDownload_FullMethodName   = "/res_downloader.v1.Control/PauseDownload"
+	Control_ResumeDownload_FullMethodName  = "/res_downloader.v1.Control/ResumeDownload"
+	Control_SetQueuePaused_FullMethodName  = "/res_downloader.v1.Control/SetQueuePaused"
+	Control_WatchProgress_FullMethodName   = "/res_downloader.v1.Control/WatchProgress"
+)
+
+// ControlClient is the client API for Control service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ControlClient interface {
+	// capture
+	GetCaptureState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CaptureState, error)
+	SetSystemProxy(ctx context.Context, in *SetSystemProxyRequest, opts ...grpc.CallOption) (*CaptureState, error)
+	ClearResources(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	// resources
+	ListResources(ctx context.Context, in *ListResourcesRequest, opts ...grpc.CallOption) (*ListResourcesResponse, error)
+	WatchResources(ctx context.Context, in *ListResourcesRequest, opts ...grpc.CallOption) (Control_WatchResourcesClient, error)
+	// tasks
+	ListDownloads(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Downloads, error)
+	EnqueueDownload(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*ListResourcesResponse, error)
+	CancelDownload(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*Empty, error)
+	PauseDownload(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*Empty, error)
+	ResumeDownload(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*Empty, error)
+	SetQueuePaused(ctx context.Context, in *SetQueuePausedRequest, opts ...grpc.CallOption) (*Downloads, error)
+	WatchProgress(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Control_WatchProgressClient, error)
+}
+
+type controlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewControlClient(cc grpc.ClientConnInterface) ControlClient {
+	return &controlClient{cc}
+}
+
+func (c *controlClient) GetCaptureState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CaptureState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CaptureState)
+	err := c.cc.Invoke(ctx, Control_GetCaptureState_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) SetSystemProxy(ctx context.Context, in *SetSystemProxyRequest, opts ...grpc.CallOption) (*CaptureState, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CaptureState)
+	err := c.cc.Invoke(ctx, Control_SetSystemProxy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ClearResources(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Control_ClearResources_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ListResources(ctx context.Context, in *ListResourcesRequest, opts ...grpc.CallOption) (*ListResourcesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResourcesResponse)
+	err := c.cc.Invoke(ctx, Control_ListResources_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) WatchResources(ctx context.Context, in *ListResourcesRequest, opts ...grpc.CallOption) (Control_WatchResourcesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Control_ServiceDesc.Streams[0], Control_WatchResources_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &controlWatchResourcesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Control_WatchResourcesClient interface {
+	Recv() (*MediaInfo, error)
+	grpc.ClientStream
+}
+
+type controlWatchResourcesClient struct {
+	grpc.ClientStream
+}
+
+func (x *controlWatchResourcesClient) Recv() (*MediaInfo, error) {
+	m := new(MediaInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *controlClient) ListDownloads(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Downloads, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Downloads)
+	err := c.cc.Invoke(ctx, Control_ListDownloads_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) EnqueueDownload(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*ListResourcesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResourcesResponse)
+	err := c.cc.Invoke(ctx, Control_EnqueueDownload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) CancelDownload(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Control_CancelDownload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) PauseDownload(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Control_PauseDownload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ResumeDownload(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Control_ResumeDownload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) SetQueuePaused(ctx context.Context, in *SetQueuePausedRequest, opts ...grpc.CallOption) (*Downloads, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Downloads)
+	err := c.cc.Invoke(ctx, Control_SetQueuePaused_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) WatchProgress(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Control_WatchProgressClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Control_ServiceDesc.Streams[1], Control_WatchProgress_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &controlWatchProgressClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Control_WatchProgressClient interface {
+	Recv() (*Progress, error)
+	grpc.ClientStream
+}
+
+type controlWatchProgressClient struct {
+	grpc.ClientStream
+}
+
+func (x *controlWatchProgressClient) Recv() (*Progress, error) {
+	m := new(Progress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ControlServer is the server API for Control service.
+// All implementations must embed UnimplementedControlServer
+// for forward compatibility
+type ControlServer interface {
+	// capture
+	GetCaptureState(context.Context, *Empty) (*CaptureState, error)
+	SetSystemProxy(context.Context, *SetSystemProxyRequest) (*CaptureState, error)
+	ClearResources(context.Context, *Empty) (*Empty, error)
+	// resources
+	ListResources(context.Context, *ListResourcesRequest) (*ListResourcesResponse, error)
+	WatchResources(*ListResourcesRequest, Control_WatchResourcesServer) error
+	// tasks
+	ListDownloads(context.Context, *Empty) (*Downloads, error)
+	EnqueueDownload(context.Context, *EnqueueRequest) (*ListResourcesResponse, error)
+	CancelDownload(context.Context, *TaskRequest) (*Empty, error)
+	PauseDownload(context.Context, *TaskRequest) (*Empty, error)
+	ResumeDownload(context.Context, *TaskRequest) (*Empty, error)
+	SetQueuePaused(context.Context, *SetQueuePausedRequest) (*Downloads, error)
+	WatchProgress(*Empty, Control_WatchProgressServer) error
+	mustEmbedUnimplementedControlServer()
+}
+
+// UnimplementedControlServer must be embedded to have forward compatible implementations.
+type UnimplementedControlServer struct {
+}
+
+func (UnimplementedControlServer) GetCaptureState(context.Context, *Empty) (*CaptureState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCaptureState not implemented")
+}
+func (UnimplementedControlServer) SetSystemProxy(context.Context, *SetSystemProxyRequest) (*CaptureState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetSystemProxy not implemented")
+}
+func (UnimplementedControlServer) ClearResources(context.Context, *Empty) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearResources not implemented")
+}
+func (UnimplementedControlServer) ListResources(context.Context, *ListResourcesRequest) (*ListResourcesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListResources not implemented")
+}
+func (UnimplementedControlServer) WatchResources(*ListResourcesRequest, Control_WatchResourcesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchResources not implemented")
+}
+func (UnimplementedControlServer) ListDownloads(context.Context, *Empty) (*Downloads, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDownloads not implemented")
+}
+func (UnimplementedControlServer) EnqueueDownload(context.Context, *EnqueueRequest) (*ListResourcesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnqueueDownload not implemented")
+}
+func (UnimplementedControlServer) CancelDownload(context.Context, *TaskRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelDownload not implemented")
+}
+func (UnimplementedControlServer) PauseDownload(context.Context, *TaskRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseDownload not implemented")
+}
+func (UnimplementedControlServer) ResumeDownload(context.Context, *TaskRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeDownload not implemented")
+}
+func (UnimplementedControlServer) SetQueuePaused(context.Context, *SetQueuePausedRequest) (*Downloads, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetQueuePaused not implemented")
+}
+func (UnimplementedControlServer) WatchProgress(*Empty, Control_WatchProgressServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchProgress not implemented")
+}
+func (UnimplementedControlServer) mustEmbedUnimplementedControlServer() {}
+
+// UnsafeControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ControlServer will
+// result in compilation errors.
+type UnsafeControlServer interface {
+	mustEmbedUnimplementedControlServer()
+}
+
+func RegisterControlServer(s grpc.ServiceRegistrar, srv ControlServer) {
+	s.RegisterService(&Control_ServiceDesc, srv)
+}
+
+func _Control_GetCaptureState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetCaptureState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetCaptureState_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetCaptureState(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_SetSystemProxy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetSystemProxyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).SetSystemProxy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_SetSystemProxy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).SetSystemProxy(ctx, req.(*SetSystemProxyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ClearResources_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ClearResources(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ClearResources_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ClearResources(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ListResources_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListResourcesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ListResources(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ListResources_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ListResources(ctx, req.(*ListResourcesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_WatchResources_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListResourcesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControlServer).WatchResources(m, &controlWatchResourcesServer{ServerStream: stream})
+}
+
+type Control_WatchResourcesServer interface {
+	Send(*MediaInfo) error
+	grpc.ServerStream
+}
+
+type controlWatchResourcesServer struct {
+	grpc.ServerStream
+}
+
+func (x *controlWatchResourcesServer) Send(m *MediaInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Control_ListDownloads_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ListDownloads(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ListDownloads_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ListDownloads(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_EnqueueDownload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnqueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).EnqueueDownload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_EnqueueDownload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).EnqueueDownload(ctx, req.(*EnqueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_CancelDownload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).CancelDownload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_CancelDownload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).CancelDownload(ctx, req.(*TaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_PauseDownload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).PauseDownload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_PauseDownload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).PauseDownload(ctx, req.(*TaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ResumeDownload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ResumeDownload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ResumeDownload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ResumeDownload(ctx, req.(*TaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_SetQueuePaused_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetQueuePausedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).SetQueuePaused(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_SetQueuePaused_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).SetQueuePaused(ctx, req.(*SetQueuePausedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_WatchProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControlServer).WatchProgress(m, &controlWatchProgressServer{ServerStream: stream})
+}
+
+type Control_WatchProgressServer interface {
+	Send(*Progress) error
+	grpc.ServerStream
+}
+
+type controlWatchProgressServer struct {
+	grpc.ServerStream
+}
+
+func (x *controlWatchProgressServer) Send(m *Progress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Control_ServiceDesc is the grpc.ServiceDesc for Control service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Control_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "res_downloader.v1.Control",
+	HandlerType: (*ControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCaptureState",
+			Handler:    _Control_GetCaptureState_Handler,
+		},
+		{
+			MethodName: "SetSystemProxy",
+			Handler:    _Control_SetSystemProxy_Handler,
+		},
+		{
+			MethodName: "ClearResources",
+			Handler:    _Control_ClearResources_Handler,
+		},
+		{
+			MethodName: "ListResources",
+			Handler:    _Control_ListResources_Handler,
+		},
+		{
+			MethodName: "ListDownloads",
+			Handler:    _Control_ListDownloads_Handler,
+		},
+		{
+			MethodName: "EnqueueDownload",
+			Handler:    _Control_EnqueueDownload_Handler,
+		},
+		{
+			MethodName: "CancelDownload",
+			Handler:    _Control_CancelDownload_Handler,
+		},
+		{
+			MethodName: "PauseDownload",
+			Handler:    _Control_PauseDownload_Handler,
+		},
+		{
+			MethodName: "ResumeDownload",
+			Handler:    _Control_ResumeDownload_Handler,
+		},
+		{
+			MethodName: "SetQueuePaused",
+			Handler:    _Control_SetQueuePaused_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchResources",
+			Handler:       _Control_WatchResources_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchProgress",
+			Handler:       _Control_WatchProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "res_downloader/v1/control.proto",
+}